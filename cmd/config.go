@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+	"github.com/spf13/cobra"
+)
+
+var (
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Work with MicroK8s launch configuration files",
+	}
+
+	configValidateCmd = &cobra.Command{
+		Use:          "validate <file>",
+		Short:        "Check a launch configuration file for errors, use '-' to read from stdin",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := readConfigFile(args[0])
+			if err != nil {
+				return err
+			}
+
+			report := k8sinit.CheckConfiguration(b, k8sinit.ParseOptions{SourceName: args[0]})
+			fmt.Fprintln(cmd.OutOrStdout(), report)
+			if !report.OK() {
+				return fmt.Errorf("configuration file %q is not valid", args[0])
+			}
+			return nil
+		},
+	}
+
+	configRenderFormat        = k8sinit.RenderFormatYAML
+	configRenderRedactSecrets bool
+	configRenderCmd           = &cobra.Command{
+		Use:          "render <files...>",
+		Short:        "Print the effective configuration after merging all launch configuration files in order",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var parts k8sinit.MultiPartConfiguration
+			for _, name := range args {
+				b, err := readConfigFile(name)
+				if err != nil {
+					return err
+				}
+				c, err := k8sinit.ParseMultiPartConfigurationWithOptions(b, k8sinit.ParseOptions{SourceName: name})
+				if err != nil {
+					return fmt.Errorf("failed to parse config file %q: %w", name, err)
+				}
+				parts.Parts = append(parts.Parts, c.Parts...)
+			}
+
+			b, err := k8sinit.RenderConfigurationWithOptions(parts, configRenderFormat, k8sinit.MarshalOptions{RedactSecrets: configRenderRedactSecrets})
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(b)
+			return err
+		},
+	}
+)
+
+// readConfigFile reads a configuration file, or stdin if name is "-".
+func readConfigFile(name string) ([]byte, error) {
+	if name == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config from stdin: %w", err)
+		}
+		return b, nil
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %q: %w", name, err)
+	}
+	return b, nil
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+
+	configRenderCmd.Flags().StringVar(&configRenderFormat, "format", configRenderFormat, "output format, one of 'yaml' or 'json'")
+	configRenderCmd.Flags().BoolVar(&configRenderRedactSecrets, "redact-secrets", configRenderRedactSecrets, "mask tokens, keys, passwords and other secret values in the output")
+	configCmd.AddCommand(configRenderCmd)
+
+	rootCmd.AddCommand(configCmd)
+}
@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"fmt"
-	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
@@ -11,8 +14,24 @@ import (
 )
 
 var (
-	initInputFile string
-	initPreInit   bool
+	initInputFile  string
+	initConfigDir  string
+	initPreInit    bool
+	initDryRun     bool
+	initForce      bool
+	initIncludeDir string
+	initTimeout    time.Duration
+	initLockFile   string
+	initLockWait   time.Duration
+	initFlagCheck  string
+	initStateFile  string
+	initPreflight  bool
+	initArgFileDir []string
+	initStrict     bool
+	initRetries    int
+	initBackoff    time.Duration
+	initSkipAddons bool
+	initOnlyAddons bool
 
 	initCmd = &cobra.Command{
 		Use:    "init",
@@ -25,31 +44,50 @@ var (
 			)
 			l := k8sinit.NewLauncher(s, initPreInit)
 
-			var (
-				b   []byte
-				err error
-			)
-			switch initInputFile {
-			case "":
+			switch {
+			case initInputFile == "" && initConfigDir == "":
 				return fmt.Errorf("no config file specified")
-			case "-":
-				b, err = io.ReadAll(os.Stdin)
-				if err != nil {
-					return fmt.Errorf("failed to read config from stdin: %w", err)
+			case initInputFile != "" && initConfigDir != "":
+				return fmt.Errorf("--config-file and --config-dir cannot be used together")
+			}
+
+			parseOpts := k8sinit.ParseOptions{Strict: initStrict}
+			if initIncludeDir != "" {
+				parseOpts.IncludeFS = os.DirFS(initIncludeDir)
+			}
+			if s.HasClusteredLock() {
+				parseOpts.Validation.NodeRole = k8sinit.NodeRoleWorker
+			}
+			var c k8sinit.MultiPartConfiguration
+			if initConfigDir != "" {
+				var err error
+				if c, err = k8sinit.ParseConfigurationDirWithOptions(os.DirFS(initConfigDir), ".", parseOpts); err != nil {
+					return fmt.Errorf("failed to parse config directory: %w", err)
 				}
-			default:
-				b, err = os.ReadFile(initInputFile)
+			} else {
+				b, err := readConfigFile(initInputFile)
 				if err != nil {
-					return fmt.Errorf("failed to read config file %q: %w", initInputFile, err)
+					return err
+				}
+				parseOpts.SourceName = initInputFile
+				if initInputFile == "-" {
+					parseOpts.SourceName = "stdin"
+				}
+				if c, err = k8sinit.ParseMultiPartConfigurationWithOptions(b, parseOpts); err != nil {
+					return fmt.Errorf("failed to parse config file: %w", err)
 				}
 			}
 
-			c, err := k8sinit.ParseMultiPartConfiguration(b)
-			if err != nil {
-				return fmt.Errorf("failed to parse config file: %w", err)
+			applyOpts := k8sinit.ApplyOptions{DryRun: initDryRun, Force: initForce, Timeout: initTimeout, LockTimeout: initLockWait, UnknownFlags: k8sinit.UnknownFlagPolicy(initFlagCheck), StatePath: initStateFile, Preflight: initPreflight, ArgFileDirs: initArgFileDir, MaxRetries: initRetries, Backoff: initBackoff, SkipAddons: initSkipAddons, OnlyAddons: initOnlyAddons}
+			if initLockFile != "" {
+				applyOpts.Locker = k8sinit.NewFileApplyLocker(initLockFile)
+			} else if snapData := os.Getenv("SNAP_DATA"); snapData != "" {
+				applyOpts.Locker = k8sinit.NewFileApplyLocker(filepath.Join(snapData, "var", "lock", "launch-configuration.lock"))
 			}
 
-			if err := l.Apply(cmd.Context(), c); err != nil {
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+			if _, err := l.ApplyWithOptions(ctx, c, applyOpts); err != nil {
 				return fmt.Errorf("failed to apply configuration: %w", err)
 			}
 			return nil
@@ -59,7 +97,27 @@ var (
 
 func init() {
 	initCmd.Flags().StringVarP(&initInputFile, "config-file", "c", initInputFile, "configuration file to read, or '-' to read from stdin")
+	initCmd.Flags().StringVar(&initConfigDir, "config-dir", initConfigDir, "directory of configuration files (*.yaml, *.yml) to read in lexical order, instead of --config-file")
 	initCmd.Flags().BoolVarP(&initPreInit, "pre-init", "p", initPreInit, "apply pre-init configuration, do not restart services or manage addons")
 
+	initCmd.Flags().BoolVar(&initStrict, "strict", initStrict, "reject unknown fields and duplicate keys in every part of the configuration, instead of ignoring them with a warning")
+	initCmd.Flags().StringVar(&initIncludeDir, "include-dir", initIncludeDir, "directory that included config files are read from, includes are disabled if not set")
+
+	initCmd.Flags().BoolVar(&initDryRun, "dry-run", initDryRun, "print the actions needed to apply the configuration, without performing them")
+	initCmd.Flags().BoolVar(&initForce, "force", initForce, "apply the configuration even if it is unchanged since it was last applied")
+	initCmd.Flags().DurationVar(&initTimeout, "timeout", initTimeout, "maximum duration of applying the configuration, no timeout if 0")
+	initCmd.Flags().IntVar(&initRetries, "retries", initRetries, "number of times to retry enabling addons and restarting services if the command fails, not retried if 0")
+	initCmd.Flags().DurationVar(&initBackoff, "retry-backoff", initBackoff, "delay before the first retry, doubled for each further retry, defaults to 1s")
+	initCmd.Flags().BoolVar(&initSkipAddons, "skip-addons", initSkipAddons, "apply the configuration without addons and addon repositories, to apply them later with --only-addons")
+	initCmd.Flags().BoolVar(&initOnlyAddons, "only-addons", initOnlyAddons, "only apply the addons and addon repositories of the configuration")
+
+	initCmd.Flags().StringVar(&initLockFile, "lock-file", initLockFile, "file locked while applying the configuration, defaults to $SNAP_DATA/var/lock/launch-configuration.lock")
+	initCmd.Flags().DurationVar(&initLockWait, "lock-timeout", initLockWait, "maximum duration to wait for another apply to finish, defaults to 5m")
+
+	initCmd.Flags().StringVar(&initStateFile, "state-file", initStateFile, "file to store the last applied configuration in, only sections changed since then are applied if set")
+	initCmd.Flags().StringSliceVar(&initArgFileDir, "arg-file-dir", initArgFileDir, "directory that extra argument values of the form 'file:/path' may refer to, can be repeated; file references are rejected if not set")
+	initCmd.Flags().BoolVar(&initPreflight, "preflight", initPreflight, "check free disk space and that the targeted services are running before applying, abort if a check fails unless --force is set")
+	initCmd.Flags().StringVar(&initFlagCheck, "unknown-flags", initFlagCheck, "check extra arguments against the flags of the installed Kubernetes binaries, one of 'warn' or 'error'; not checked if not set")
+
 	rootCmd.AddCommand(initCmd)
 }
@@ -3,9 +3,11 @@ module github.com/canonical/microk8s-cluster-agent
 go 1.19
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/fsnotify/fsnotify v1.5.4
 	github.com/onsi/gomega v1.26.0
 	github.com/prometheus/client_golang v1.12.1
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
 	github.com/spf13/cobra v1.3.0
 	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/api v0.26.1
@@ -121,10 +121,12 @@ func (a *API) Join(ctx context.Context, request JoinRequest) (*JoinResponse, err
 		return nil, fmt.Errorf("joining this MicroK8s cluster requires x509 authentication. update MicroK8s to version 1.28 or newer and retry the join operation")
 	}
 
-	response.KubeletArgs, err = a.Snap.ReadServiceArguments("kubelet")
+	kubeletArgs, err := a.Snap.ReadServiceArguments("kubelet")
 	if err != nil {
 		return nil, fmt.Errorf("failed to read arguments of kubelet service: %w", err)
 	}
+	// the manual arguments of this node are not sent to the joining node
+	response.KubeletArgs = snaputil.StripManualArguments(kubeletArgs)
 	if hostname != request.HostName {
 		response.KubeletArgs = fmt.Sprintf("%s\n--hostname-override=%s", response.KubeletArgs, hostname)
 	}
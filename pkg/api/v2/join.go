@@ -177,7 +177,7 @@ func (a *API) Join(ctx context.Context, req JoinRequest) (*JoinResponse, int, er
 		APIServerPort:              snaputil.GetServiceArgument(a.Snap, "kube-apiserver", "--secure-port"),
 		APIServerAuthorizationMode: snaputil.GetServiceArgument(a.Snap, "kube-apiserver", "--authorization-mode"),
 		HostNameOverride:           remoteIP,
-		KubeletArgs:                kubeletArgs,
+		KubeletArgs:                snaputil.StripManualArguments(kubeletArgs),
 		ClusterCIDR:                snaputil.GetServiceArgument(a.Snap, "kube-proxy", "--cluster-cidr"),
 	}
 
@@ -10,6 +10,7 @@ import (
 
 	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
 	utiltest "github.com/canonical/microk8s-cluster-agent/pkg/util/test"
 	. "github.com/onsi/gomega"
 )
@@ -152,6 +153,27 @@ Role: 0
 		g.Expect(s.CreateNoCertsReissueLockCalledWith).To(HaveLen(1))
 		g.Expect(s.AddCertificateRequestTokenCalledWith).To(ConsistOf("worker-token-kubelet", "worker-token-proxy"))
 	})
+
+	t.Run("ManualKubeletArguments", func(t *testing.T) {
+		g := NewWithT(t)
+
+		// Reset
+		s.CNIYaml = cni
+		s.ServiceArguments["kubelet"] = snaputil.ManagedArgsHeader + "\n--max-pods=110\n" + snaputil.ManualArgsBegin + "\n--node-ip=10.10.10.10\n" + snaputil.ManualArgsEnd + "\n"
+		defer func() { s.ServiceArguments["kubelet"] = "kubelet arguments\n" }()
+
+		resp, _, err := apiv2.Join(context.Background(), v2.JoinRequest{
+			ClusterToken:     "worker-token",
+			RemoteHostName:   "test-worker",
+			RemoteAddress:    "10.10.10.12:31451",
+			WorkerOnly:       true,
+			HostPort:         "10.10.10.10:25000",
+			ClusterAgentPort: "25000",
+		})
+		g.Expect(err).To(BeNil())
+		g.Expect(resp).NotTo(BeNil())
+		g.Expect(resp.KubeletArgs).To(Equal("--max-pods=110\n"))
+	})
 }
 
 // TestJoinFirstNode tests responses when joining a control plane node on a new cluster.
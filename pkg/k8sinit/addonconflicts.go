@@ -0,0 +1,57 @@
+package k8sinit
+
+import (
+	"fmt"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// AddonArgConflict is an argument of a service that is managed by an addon. Setting the argument in the extra arguments
+// of the service while also enabling the addon leaves the service in an inconsistent state, since both change it.
+type AddonArgConflict struct {
+	// Addon is the name of the addon, without its repository, e.g. "dns".
+	Addon string
+	// ConfigFile is the arguments file of the service, e.g. "kubelet".
+	ConfigFile string
+	// Arg is the argument managed by the addon, e.g. "--cluster-dns".
+	Arg string
+}
+
+// DefaultAddonArgConflicts are the known arguments of services that are managed by MicroK8s addons.
+var DefaultAddonArgConflicts = []AddonArgConflict{
+	{Addon: "dns", ConfigFile: "kubelet", Arg: "--cluster-dns"},
+	{Addon: "dns", ConfigFile: "kubelet", Arg: "--cluster-domain"},
+	{Addon: "rbac", ConfigFile: "kube-apiserver", Arg: "--authorization-mode"},
+}
+
+// AddonConflictWarnings returns a warning for each extra argument of the configuration that is managed by an addon that
+// the configuration also enables, e.g. extraKubeletArgs[--cluster-dns] along with the dns addon. conflicts are the known
+// conflicts; if nil, DefaultAddonArgConflicts is used.
+func (c *Configuration) AddonConflictWarnings(conflicts []AddonArgConflict) []string {
+	if conflicts == nil {
+		conflicts = DefaultAddonArgConflicts
+	}
+
+	enabled := make(map[string]struct{}, len(c.Addons))
+	for _, addon := range c.Addons {
+		if !addon.Disable {
+			enabled[addonName(addon)] = struct{}{}
+		}
+	}
+
+	var warnings []string
+	for _, field := range c.serviceArgsFields() {
+		for _, key := range util.SortedKeys(*field.args) {
+			arg := argFlag(normalizeArgKey(key))
+			for _, conflict := range conflicts {
+				if conflict.ConfigFile != field.configFile || conflict.Arg != arg {
+					continue
+				}
+				if _, ok := enabled[conflict.Addon]; ok {
+					warnings = append(warnings, fmt.Sprintf("%s[%s] is managed by the %q addon, which is also enabled by the configuration", field.name, key, conflict.Addon))
+				}
+			}
+		}
+	}
+	return warnings
+}
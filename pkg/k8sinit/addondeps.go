@@ -0,0 +1,101 @@
+package k8sinit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultAddonDependencies are the known dependencies between MicroK8s addons, by addon name. For example, the ingress
+// addon depends on the dns addon.
+var DefaultAddonDependencies = map[string][]string{
+	"cert-manager":  {"dns"},
+	"dashboard":     {"metrics-server"},
+	"ingress":       {"dns"},
+	"observability": {"dns", "hostpath-storage"},
+	"registry":      {"hostpath-storage"},
+}
+
+// addonName returns the name of the addon without its repository, e.g. "istio" for "community/istio".
+func addonName(a AddonConfiguration) string {
+	if _, name, ok := strings.Cut(a.Name, "/"); ok {
+		return name
+	}
+	return a.Name
+}
+
+// addonDependencies returns the dependencies between addons, using opts.AddonDependencies if set.
+func (s *launcherScope) addonDependencies() map[string][]string {
+	if s.opts.AddonDependencies != nil {
+		return s.opts.AddonDependencies
+	}
+	return DefaultAddonDependencies
+}
+
+// dependsOnAny returns true if the addon depends on any of the addons in group.
+func dependsOnAny(addon AddonConfiguration, group []AddonConfiguration, deps map[string][]string) bool {
+	for _, dep := range deps[addonName(addon)] {
+		for _, other := range group {
+			if addonName(other) == dep {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sortAddonDependencies reorders the enabled addons of a list sorted by sortAddons, so that each addon is enabled after
+// the addons it depends on. Otherwise, the order is kept. Dependencies that are not enabled by the configuration are
+// ignored. An error is returned if the enabled addons have circular dependencies.
+func sortAddonDependencies(addons []AddonConfiguration, deps map[string][]string) ([]AddonConfiguration, error) {
+	sorted := make([]AddonConfiguration, 0, len(addons))
+	var enabled []AddonConfiguration
+	for _, addon := range addons {
+		if addon.Disable {
+			sorted = append(sorted, addon)
+		} else {
+			enabled = append(enabled, addon)
+		}
+	}
+
+	remaining := enabled
+	for len(remaining) > 0 {
+		next := -1
+		for idx, addon := range remaining {
+			if !dependsOnAny(addon, remaining, deps) {
+				next = idx
+				break
+			}
+		}
+		if next == -1 {
+			return nil, fmt.Errorf("addons have circular dependencies: %s", addonDependencyCycle(remaining, deps))
+		}
+		sorted = append(sorted, remaining[next])
+		remaining = append(remaining[:next:next], remaining[next+1:]...)
+	}
+	return sorted, nil
+}
+
+// addonDependencyCycle returns a dependency cycle between addons, e.g. "a -> b -> a". Each of the addons must depend on
+// at least one other addon of the list.
+func addonDependencyCycle(addons []AddonConfiguration, deps map[string][]string) string {
+	names := make(map[string]struct{}, len(addons))
+	for _, addon := range addons {
+		names[addonName(addon)] = struct{}{}
+	}
+
+	var path []string
+	visited := make(map[string]int)
+	for name := addonName(addons[0]); ; {
+		if idx, ok := visited[name]; ok {
+			return strings.Join(append(path[idx:], name), " -> ")
+		}
+		visited[name] = len(path)
+		path = append(path, name)
+		for _, dep := range deps[name] {
+			if _, ok := names[dep]; ok {
+				name = dep
+				break
+			}
+		}
+	}
+}
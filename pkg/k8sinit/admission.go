@@ -0,0 +1,127 @@
+package k8sinit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+const (
+	// enableAdmissionPluginsArg is the kube-apiserver argument that enabled admission plugins are merged into.
+	enableAdmissionPluginsArg = "--enable-admission-plugins"
+	// disableAdmissionPluginsArg is the kube-apiserver argument that disabled admission plugins are merged into.
+	disableAdmissionPluginsArg = "--disable-admission-plugins"
+)
+
+// parsePluginList parses a comma separated list of admission plugins, e.g. "NodeRestriction,PodSecurity". Empty entries
+// are ignored.
+func parsePluginList(value string) []string {
+	var plugins []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			plugins = append(plugins, entry)
+		}
+	}
+	return plugins
+}
+
+// mergePluginList adds plugins to an existing list of admission plugins, and removes the plugins in remove from it.
+// Plugins of the existing list keep their order, and added plugins are appended in the order they are listed.
+func mergePluginList(existing string, add []string, remove []string) []string {
+	removed := make(map[string]struct{}, len(remove))
+	for _, plugin := range remove {
+		removed[plugin] = struct{}{}
+	}
+	seen := make(map[string]struct{})
+	var merged []string
+	for _, plugin := range append(parsePluginList(existing), add...) {
+		if _, ok := removed[plugin]; ok {
+			continue
+		}
+		if _, ok := seen[plugin]; ok {
+			continue
+		}
+		seen[plugin] = struct{}{}
+		merged = append(merged, plugin)
+	}
+	return merged
+}
+
+// withAdmissionPlugins returns the configuration with the admission plugins merged into the "--enable-admission-plugins"
+// and "--disable-admission-plugins" arguments of kube-apiserver. existing returns the current value of a kube-apiserver
+// argument. Enabling a plugin removes it from the disabled plugins, and vice versa. An argument that ends up empty is
+// removed. The configuration is returned as-is if it does not set any admission plugins, otherwise a copy is returned.
+func (c *Configuration) withAdmissionPlugins(existing func(arg string) string) *Configuration {
+	if !c.hasAdmissionPlugins() {
+		return c
+	}
+
+	c = c.Clone()
+	if c.ExtraKubeAPIServerArgs == nil {
+		c.ExtraKubeAPIServerArgs = make(ExtraArgs)
+	}
+	for _, arg := range []struct {
+		name   string
+		add    []string
+		remove []string
+	}{
+		{name: enableAdmissionPluginsArg, add: c.AdmissionPlugins.Enable, remove: c.AdmissionPlugins.Disable},
+		{name: disableAdmissionPluginsArg, add: c.AdmissionPlugins.Disable, remove: c.AdmissionPlugins.Enable},
+	} {
+		current := existing(arg.name)
+		merged := mergePluginList(current, arg.add, arg.remove)
+		if len(merged) == 0 {
+			if current != "" {
+				c.ExtraKubeAPIServerArgs[arg.name] = nil
+			}
+			continue
+		}
+		value := strings.Join(merged, ",")
+		c.ExtraKubeAPIServerArgs[arg.name] = &value
+	}
+	return c
+}
+
+// validateAdmissionPlugins checks that admission plugin names are valid, that no plugin is both enabled and disabled,
+// and that the admission plugin arguments of kube-apiserver are not also set in ExtraKubeAPIServerArgs.
+func validateAdmissionPlugins(c *Configuration) []error {
+	if !c.hasAdmissionPlugins() {
+		return nil
+	}
+
+	var errs []error
+	enabled := make(map[string]struct{}, len(c.AdmissionPlugins.Enable))
+	for idx, plugin := range c.AdmissionPlugins.Enable {
+		if plugin == "" || strings.ContainsAny(plugin, ", ") {
+			errs = append(errs, fmt.Errorf("admissionPlugins.enable[%d] %q is not a valid admission plugin name", idx, plugin))
+		}
+		enabled[plugin] = struct{}{}
+	}
+	for idx, plugin := range c.AdmissionPlugins.Disable {
+		if plugin == "" || strings.ContainsAny(plugin, ", ") {
+			errs = append(errs, fmt.Errorf("admissionPlugins.disable[%d] %q is not a valid admission plugin name", idx, plugin))
+		}
+		if _, ok := enabled[plugin]; ok {
+			errs = append(errs, fmt.Errorf("admissionPlugins.disable[%d] %q cannot be both enabled and disabled", idx, plugin))
+		}
+	}
+	for _, key := range util.SortedKeys(c.ExtraKubeAPIServerArgs) {
+		if arg := argFlag(normalizeArgKey(key)); arg == enableAdmissionPluginsArg || arg == disableAdmissionPluginsArg {
+			errs = append(errs, fmt.Errorf("admissionPlugins cannot be used together with extraKubeAPIServerArgs[%s], since both set the %q argument", key, arg))
+		}
+	}
+	return errs
+}
+
+// hasAdmissionPlugins returns true if the configuration enables or disables any admission plugins.
+func (c *Configuration) hasAdmissionPlugins() bool {
+	return len(c.AdmissionPlugins.Enable) > 0 || len(c.AdmissionPlugins.Disable) > 0
+}
+
+// mergeAdmissionPlugins merges the admission plugins of a later configuration part into merged. A plugin enabled or
+// disabled by the later part overrides whether it was enabled or disabled by earlier parts.
+func mergeAdmissionPlugins(merged *AdmissionPluginsConfiguration, part AdmissionPluginsConfiguration) {
+	merged.Enable = mergePluginList(strings.Join(merged.Enable, ","), part.Enable, part.Disable)
+	merged.Disable = mergePluginList(strings.Join(merged.Disable, ","), part.Disable, part.Enable)
+}
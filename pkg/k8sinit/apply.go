@@ -3,8 +3,12 @@ package k8sinit
 import (
 	"context"
 	"fmt"
-	"strings"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
 	"github.com/canonical/microk8s-cluster-agent/pkg/util"
 )
@@ -12,184 +16,886 @@ import (
 type launcherScope struct {
 	launcher *Launcher
 
+	// mu protects the fields below that are updated while enabling addons in parallel.
+	mu sync.Mutex
+
+	// snap is used to apply the configuration. In dry-run mode, this does not perform any changes.
+	snap snap.Snap
+	// opts are the options used to apply the configuration.
+	opts ApplyOptions
+	// logger is used to report progress and warnings.
+	logger Logger
+	// result is the list of actions performed while applying the configuration.
+	result *ApplyResult
+
+	// restartPolicy controls which services are restarted after the configuration is applied.
+	restartPolicy RestartPolicy
+	// mustRestartServices are services whose configuration was changed.
 	mustRestartServices map[string]struct{}
+	// affectedServices are services whose configuration was set, even if it was not changed.
+	affectedServices map[string]struct{}
+
+	// extraSANs is the list of SANs accumulated from all configuration parts applied so far.
+	extraSANs []string
+
+	// addonErrors are failures of addons with the "continue" failure policy.
+	addonErrors []error
+
+	// nodeInfo is information about the local node, retrieved when the first templated addon argument is resolved.
+	nodeInfo *NodeInfo
+
+	// appliedArgs are the arguments set by launch configurations for each arguments file, loaded on first use.
+	appliedArgs map[string]map[string]struct{}
+	// appliedArgsChanged is true if appliedArgs must be written back.
+	appliedArgsChanged bool
+
+	// retryPatterns are the compiled opts.RetryPatterns.
+	retryPatterns []*regexp.Regexp
+
+	// appliedSections are the sections that resulted in actions (see trackActions).
+	appliedSections map[Section]struct{}
 }
 
 // Apply applies a multi-part configuration to the local MicroK8s node.
 func (l *Launcher) Apply(ctx context.Context, c MultiPartConfiguration) error {
+	_, err := l.ApplyWithOptions(ctx, c, ApplyOptions{})
+	return err
+}
+
+// ApplyWithOptions applies a multi-part configuration to the local MicroK8s node.
+// ApplyWithOptions returns the list of actions that were performed (or would be performed, in dry-run mode).
+// Applying a configuration identical to the last applied configuration is a no-op, unless opts.Force is set.
+// If opts.StatePath is set, only the sections that changed since the last applied configuration are applied.
+// If opts.SkipAddons or opts.OnlyAddons is set, the configuration is applied without its addons, or only its addons.
+// If applying the configuration fails, all changed arguments files and the CNI manifest are restored (best-effort).
+// Addons and joining a cluster cannot be rolled back.
+// If opts.Preflight is set, the free disk space and the targeted services of the local node are checked before any changes are made.
+// Pre-apply hooks are run before any changes are made, and post-apply hooks after the configuration was applied successfully.
+// If ctx is cancelled (or opts.Timeout expires), no further actions are started, and a CanceledError is returned.
+// Only one configuration is applied at a time (see ApplyLocker). If another apply does not finish within opts.LockTimeout,
+// ErrApplyInProgress is returned. Dry-runs do not wait for the lock.
+func (l *Launcher) ApplyWithOptions(ctx context.Context, c MultiPartConfiguration, opts ApplyOptions) (*ApplyResult, error) {
+	if opts.DryRun {
+		return l.applyWithOptions(ctx, c, opts)
+	}
+
+	start := time.Now()
+	result, err := l.lockAndApply(ctx, c, opts)
+	metricsOrDefault(opts.Metrics).ConfigurationApplied(time.Since(start), err)
+	return result, err
+}
+
+// lockAndApply applies a multi-part configuration while holding the apply lock.
+func (l *Launcher) lockAndApply(ctx context.Context, c MultiPartConfiguration, opts ApplyOptions) (*ApplyResult, error) {
+	unlock, err := lockApply(ctx, opts)
+	if err != nil {
+		return &ApplyResult{}, err
+	}
+	defer unlock()
+	return l.applyWithOptions(ctx, c, opts)
+}
+
+// newScope returns the scope of applying a configuration with opts. In dry-run mode, the scope does not perform any
+// changes. Otherwise, the returned rollbackSnap records the changes so that they can be rolled back.
+func (l *Launcher) newScope(opts ApplyOptions) (*launcherScope, *rollbackSnap) {
 	s := &launcherScope{
 		launcher:            l,
+		snap:                l.snap,
+		opts:                opts,
+		logger:              loggerOrDefault(opts.Logger),
+		result:              &ApplyResult{},
+		restartPolicy:       RestartPolicyOnChange,
 		mustRestartServices: make(map[string]struct{}),
+		affectedServices:    make(map[string]struct{}),
+		appliedSections:     make(map[Section]struct{}),
+	}
+	if opts.DryRun {
+		s.snap = newDryRunSnap(l.snap)
+		return s, nil
+	}
+	rollback := newRollbackSnap(l.snap)
+	s.snap = rollback
+	return s, rollback
+}
+
+// applyWithOptions applies a multi-part configuration to the local MicroK8s node (see ApplyWithOptions).
+func (l *Launcher) applyWithOptions(ctx context.Context, c MultiPartConfiguration, opts ApplyOptions) (*ApplyResult, error) {
+	s, rollback := l.newScope(opts)
+	return l.applyInScope(ctx, s, rollback, c, opts)
+}
+
+// applyInScope applies a multi-part configuration in the scope returned by newScope.
+func (l *Launcher) applyInScope(ctx context.Context, s *launcherScope, rollback *rollbackSnap, c MultiPartConfiguration, opts ApplyOptions) (*ApplyResult, error) {
+	if opts.SkipAddons && opts.OnlyAddons {
+		return s.result, fmt.Errorf("SkipAddons and OnlyAddons cannot be used together")
+	}
+
+	present, hasRoles := presentSections(c), c.hasRoles()
+	c, err := s.partsForRole(c)
+	if err != nil {
+		return s.result, err
+	}
+	selected := present
+	if hasRoles {
+		selected = presentSections(c)
+	}
+	if s.retryPatterns, err = compileRetryPatterns(opts.RetryPatterns); err != nil {
+		return s.result, err
 	}
+
+	argFiles, err := s.argFileDigests(c)
+	if err != nil {
+		return s.result, err
+	}
+	hash, err := l.configurationHash(c, opts, argFiles)
+	if err != nil {
+		s.logger.Warnf("failed to compute configuration hash, it will be applied unconditionally: %v", err)
+	} else if !opts.Force && s.isApplied(hash) {
+		s.logger.Infof("Configuration is unchanged since it was last applied, nothing to do")
+		s.summarizeSections(present, selected)
+		return s.result, nil
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	if err := s.checkUnknownFlags(ctx, c); err != nil {
+		return s.result, err
+	}
+	if err := s.preflight(ctx, c); err != nil {
+		return s.result, err
+	}
+
+	hooks, err := mergedHooks(c)
+	if err != nil {
+		return s.result, err
+	}
+	// pre-apply hooks run before any changes are made, so there is nothing to roll back if they fail
+	since := s.actionCount()
+	if err := s.runHooks(ctx, HookPhasePreApply, hooks, hooks.PreApply); err != nil {
+		return s.result, err
+	}
+	s.trackActions(since, SectionHooks)
+
+	applied, merged := c, s.mergedForState(c)
+	if merged != nil && !opts.Force {
+		if last, lastArgFiles := s.loadAppliedState(opts.StatePath); last != nil {
+			// files that extra arguments refer to are compared by digest, so that changed files are applied again
+			changed := changedSections(withArgFileDigests(last, lastArgFiles), withArgFileDigests(merged, argFiles))
+			s.logger.Infof("Applying the sections changed since the last applied configuration: %v", changed)
+			applied = incrementalParts(c, changed)
+		}
+	}
+	// the hash and state are only recorded if the whole configuration was applied
+	staged := opts.SkipAddons || opts.OnlyAddons
+	if opts.SkipAddons {
+		s.result.DeferredAddons = deferredAddons(applied)
+	}
+	applied = s.stagedParts(applied)
+
+	if err := s.apply(ctx, applied); err != nil {
+		return s.result, s.rollback(ctx, rollback, err)
+	}
+	if len(s.addonErrors) > 0 {
+		s.summarizeSections(present, selected)
+		return s.result, &AddonsError{Errors: s.addonErrors}
+	}
+	if err := s.writeAppliedArgs(); err != nil {
+		s.logger.Warnf("failed to record applied arguments: %v", err)
+	}
+	if hash != "" && !staged {
+		if err := s.snap.WriteServiceArguments(configurationHashFile, []byte(hash+"\n")); err != nil {
+			s.logger.Warnf("failed to write configuration hash: %v", err)
+		}
+	}
+	if merged != nil && !opts.DryRun && !staged {
+		if err := s.writeAppliedState(opts.StatePath, merged, argFiles); err != nil {
+			s.logger.Warnf("failed to record the applied configuration, the next apply will apply the full configuration: %v", err)
+		}
+	}
+	since = s.actionCount()
+	err = s.runHooks(ctx, HookPhasePostApply, hooks, hooks.PostApply)
+	s.trackActions(since, SectionHooks)
+	s.summarizeSections(present, selected)
+	return s.result, err
+}
+
+// rollback rolls back the changes recorded by the rollbackSnap after applying a configuration failed with err, and
+// returns the error to report. rollback is nil in dry-run mode, where there are no changes to roll back.
+func (s *launcherScope) rollback(ctx context.Context, rollback *rollbackSnap, err error) error {
+	rollbackCtx := ctx
+	if ctx.Err() != nil {
+		err = &CanceledError{Actions: append([]Action(nil), s.result.Actions...), Err: err}
+		// changes are still rolled back after cancellation, so that services are not left with a partial configuration
+		rollbackCtx = context.Background()
+	}
+	if rollback == nil {
+		return err
+	}
+	s.logger.Warnf("failed to apply configuration, rolling back changes: %v", err)
+	rollback.rolledBack = true
+	if rollbackErr := rollback.rollback(rollbackCtx, s.logger); rollbackErr != nil {
+		return &RollbackError{Err: err, RollbackErr: rollbackErr}
+	}
+	return err
+}
+
+// apply applies all configuration parts, then restarts services.
+func (s *launcherScope) apply(ctx context.Context, c MultiPartConfiguration) error {
 	for idx, part := range c.Parts {
 		if err := s.applyPart(ctx, part); err != nil {
 			return fmt.Errorf("failed to apply config part %d: %w", idx, err)
 		}
 	}
 	if !s.launcher.preInit {
-		for svc := range s.mustRestartServices {
-			if err := s.launcher.snap.RestartService(ctx, svc); err != nil {
+		for _, svc := range s.servicesToRestart() {
+			action := Action{Kind: ActionRestartService, Target: svc}
+			if err := s.record(ctx, action, func() error {
+				return s.retry(ctx, action, func() error { return s.snap.RestartService(ctx, svc) })
+			}); err != nil {
 				return fmt.Errorf("failed to restart service %s to apply configuration: %w", svc, err)
 			}
+			if !s.opts.DryRun {
+				metricsOrDefault(s.opts.Metrics).ServiceRestarted(svc)
+			}
 		}
 	}
 	return nil
 }
 
+// markServices marks services as affected by the configuration, and as changed if changed is true.
+func (s *launcherScope) markServices(changed bool, services ...string) {
+	for _, service := range services {
+		s.affectedServices[service] = struct{}{}
+		if changed {
+			s.mustRestartServices[service] = struct{}{}
+		}
+	}
+}
+
+// servicesToRestart returns the sorted list of services to restart, according to the restart policy.
+func (s *launcherScope) servicesToRestart() []string {
+	var restart map[string]struct{}
+	switch s.restartPolicy {
+	case RestartPolicyAlways:
+		restart = s.affectedServices
+	case RestartPolicyNever:
+		if len(s.mustRestartServices) > 0 {
+			s.logger.Warnf("not restarting services %v due to restart policy %q, restart them to apply the configuration", util.SortedKeys(s.mustRestartServices), s.restartPolicy)
+		}
+		return nil
+	default:
+		restart = s.mustRestartServices
+	}
+	return util.SortedKeys(restart)
+}
+
+// record performs an action, and adds it to the apply result along with its duration and error (if any).
+// If ctx is done, the action is not performed and the context error is returned.
+func (s *launcherScope) record(ctx context.Context, action Action, perform func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := perform()
+	s.recordResult(action, start, err)
+	return err
+}
+
+// recordResult adds an action that was started at start to the apply result.
+func (s *launcherScope) recordResult(action Action, start time.Time, err error) {
+	action.Duration = time.Since(start)
+	if err != nil {
+		action.Error = err.Error()
+	}
+	if s.opts.DryRun {
+		s.logger.Infof("[dry-run] %s", redactAction(action, secretPatternsOrDefault(s.opts.SecretPatterns)))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.result.Actions = append(s.result.Actions, action)
+}
+
+// withDerivedArgs returns the configuration with the sections that are applied as service arguments translated to extra
+// arguments. The configuration is returned as-is if it does not set any of them, otherwise a copy is returned.
+func (s *launcherScope) withDerivedArgs(c *Configuration) *Configuration {
+	// node labels and taints are applied as kubelet arguments, the audit policy, encryption and OIDC configuration as
+	// config files and kube-apiserver arguments, and the kubelet config as a config file and a kubelet argument
+	c = c.withNodeArgs().withAuditPolicy().withEncryptionArgs().withOIDCArgs().withKubeletConfigArgs()
+	// feature gates are merged into the current "--feature-gates" argument of each component
+	c = c.withFeatureGates(func(configFile string) string {
+		return snaputil.GetServiceArgument(s.snap, configFile, featureGatesArg)
+	})
+	// admission plugins are merged into the current admission plugin arguments of kube-apiserver
+	return c.withAdmissionPlugins(func(arg string) string {
+		return snaputil.GetServiceArgument(s.snap, "kube-apiserver", arg)
+	})
+}
+
 // applyPart applies a MicroK8s launch configuration to the local MicroK8s node.
+// Sections that are not present in the configuration (see sectionPresent) are skipped, and their files are not read or written.
 func (s *launcherScope) applyPart(ctx context.Context, c *Configuration) error {
 	if c == nil {
 		return nil
 	}
+	part := c
+	c = s.withDerivedArgs(c)
+	// secret values are read from files only now, so that they are not part of the configuration
+	c, err := s.withArgFiles(c)
+	if err != nil {
+		return err
+	}
+
+	if c.RestartServices != "" {
+		s.restartPolicy = c.RestartServices
+		s.appliedSections[SectionRestartServices] = struct{}{}
+	}
 
 	if !s.launcher.preInit {
-		if err := s.reconcileAddonRepositories(ctx, c.AddonRepositories); err != nil {
-			return fmt.Errorf("failed to reconcile addon repositories: %w", err)
+		if c.sectionPresent(sectionAddonRepositories) {
+			since := s.actionCount()
+			if err := s.reconcileAddonRepositories(ctx, c.AddonRepositories); err != nil {
+				return fmt.Errorf("failed to reconcile addon repositories: %w", err)
+			}
+			s.trackActions(since, SectionAddonRepositories)
 		}
-		if err := s.reconcileAddons(ctx, c.Addons); err != nil {
-			return fmt.Errorf("failed to reconcile addons: %w", err)
+		if c.sectionPresent(sectionAddons) {
+			since := s.actionCount()
+			if err := s.reconcileAddons(ctx, c.Addons); err != nil {
+				return fmt.Errorf("failed to reconcile addons: %w", err)
+			}
+			s.trackActions(since, SectionAddons)
 		}
 	}
 
-	if v := c.PersistentClusterToken; v != "" {
-		if err := s.launcher.snap.AddPersistentClusterToken(v); err != nil {
+	if c.sectionPresent(sectionPersistentClusterToken) {
+		v := c.PersistentClusterToken
+		if err := s.record(ctx, Action{Kind: ActionAddPersistentClusterToken}, func() error { return s.snap.AddPersistentClusterToken(v) }); err != nil {
 			return fmt.Errorf("failed to configure persistent token: %w", err)
 		}
+		s.appliedSections[SectionPersistentClusterToken] = struct{}{}
 	}
 
-	for file, contents := range c.ExtraConfigFiles {
-		if strings.Contains("/", file) {
-			return fmt.Errorf("file name %q must not contain any slashes (possible path-traversal prevented)", file)
-		}
-		if err := s.launcher.snap.WriteServiceArguments(file, []byte(contents)); err != nil {
-			return fmt.Errorf("failed to create extra config file %q: %w", file, err)
-		}
-	}
-
-	for _, item := range []struct {
-		configFile      string
-		restartServices []string
-		args            map[string]*string
-	}{
-		{configFile: "kube-apiserver", restartServices: []string{"kubelite"}, args: c.ExtraKubeAPIServerArgs},
-		{configFile: "kubelet", restartServices: []string{"kubelite"}, args: c.ExtraKubeletArgs},
-		{configFile: "kube-proxy", restartServices: []string{"kubelite"}, args: c.ExtraKubeProxyArgs},
-		{configFile: "kube-controller-manager", restartServices: []string{"kubelite"}, args: c.ExtraKubeControllerManagerArgs},
-		{configFile: "kube-scheduler", restartServices: []string{"kubelite"}, args: c.ExtraKubeSchedulerArgs},
-		{configFile: "kubelite-env", restartServices: []string{"kubelite"}, args: c.ExtraKubeliteEnv},
-		{configFile: "containerd", restartServices: []string{"containerd"}, args: c.ExtraContainerdArgs},
-		{configFile: "containerd-env", restartServices: []string{"containerd"}, args: c.ExtraContainerdEnv},
-		{configFile: "k8s-dqlite", restartServices: []string{"k8s-dqlite"}, args: c.ExtraDqliteArgs},
-		{configFile: "k8s-dqlite-env", restartServices: []string{"k8s-dqlite"}, args: c.ExtraDqliteEnv},
-		{configFile: "cluster-agent", restartServices: []string{"cluster-agent"}, args: c.ExtraMicroK8sClusterAgentArgs},
-		{configFile: "cluster-agent-env", restartServices: []string{"cluster-agent"}, args: c.ExtraMicroK8sClusterAgentEnv},
-		{configFile: "apiserver-proxy", restartServices: []string{"apiserver-proxy"}, args: c.ExtraMicroK8sAPIServerProxyArgs},
-		{configFile: "apiserver-proxy-env", restartServices: []string{"apiserver-proxy"}, args: c.ExtraMicroK8sAPIServerProxyEnv},
-		{configFile: "etcd", restartServices: []string{"etcd"}, args: c.ExtraEtcdArgs},
-		{configFile: "etcd-env", restartServices: []string{"etcd"}, args: c.ExtraEtcdEnv},
-		{configFile: "flanneld", restartServices: []string{"flanneld"}, args: c.ExtraFlanneldArgs},
-		{configFile: "flanneld-env", restartServices: []string{"flanneld"}, args: c.ExtraFlanneldEnv},
-		{configFile: "cni-env", args: c.ExtraCNIEnv},
-		{configFile: "fips-env", restartServices: []string{"kubelite", "k8s-dqlite", "cluster-agent"}, args: c.ExtraFIPSEnv},
-	} {
-		if changed, err := s.reconcileServiceArgs(ctx, item.configFile, item.args); err != nil {
-			return fmt.Errorf("failed to reconcile config file %q: %w", item.configFile, err)
-		} else if changed {
-			for _, service := range item.restartServices {
-				s.mustRestartServices[service] = struct{}{}
+	if c.sectionPresent(sectionExtraConfigFiles) {
+		since := s.actionCount()
+		for _, file := range util.SortedKeys(c.ExtraConfigFiles) {
+			contents := c.ExtraConfigFiles[file]
+			if err := checkConfigFileName(file); err != nil {
+				return err
+			}
+			if err := s.record(ctx, Action{Kind: ActionWriteConfigFile, Target: file}, func() error { return s.snap.WriteServiceArguments(file, []byte(contents)) }); err != nil {
+				return fmt.Errorf("failed to create extra config file %q: %w", file, err)
 			}
 		}
+		s.trackActions(since, SectionExtraConfigFiles)
 	}
 
-	if err := s.reconcileExtraSANs(c.ExtraSANs); err != nil {
-		return fmt.Errorf("failed to configure SANs for apiserver: %w", err)
+	if c.sectionPresent(sectionEncryption) {
+		since := s.actionCount()
+		changed, err := s.reconcileEncryptionConfig(ctx, c.Encryption)
+		if err != nil {
+			return fmt.Errorf("failed to reconcile encryption configuration: %w", err)
+		}
+		s.markServices(changed, "kubelite")
+		s.trackActions(since, SectionEncryption)
 	}
 
-	if err := s.reconcileContainerdRegistryConfigs(c.ContainerdRegistryConfigs); err != nil {
-		return fmt.Errorf("failed to reconcile containerd registry configs: %w", err)
+	if c.sectionPresent(sectionOIDCCA) {
+		since := s.actionCount()
+		changed, err := s.reconcileOIDCCA(ctx, c.OIDC.CA)
+		if err != nil {
+			return fmt.Errorf("failed to reconcile OIDC CA certificate: %w", err)
+		}
+		s.markServices(changed, "kubelite")
+		s.trackActions(since, SectionOIDC)
 	}
 
-	if !s.launcher.preInit {
-		if j := c.Join; j.URL != "" {
-			if err := s.launcher.snap.JoinCluster(ctx, j.URL, j.Worker); err != nil {
-				return fmt.Errorf("failed to join cluster: %w", err)
+	if c.sectionPresent(sectionKubeletConfig) {
+		since := s.actionCount()
+		changed, err := s.reconcileKubeletConfig(ctx, *c.KubeletConfig)
+		if err != nil {
+			return fmt.Errorf("failed to reconcile kubelet config: %w", err)
+		}
+		s.markServices(changed, "kubelite")
+		s.trackActions(since, SectionKubeletConfig)
+	}
+
+	if c.sectionPresent(sectionServiceArgs) {
+		// arguments are reset first, then set
+		resetArgs := s.resetArgs(c)
+		for _, field := range c.serviceArgsFields() {
+			args := *field.args
+			if keys, ok := resetArgs[field.configFile]; ok || hasResetArgs(args) {
+				args = withResetArgs(args, keys)
+				delete(resetArgs, field.configFile)
+			}
+			since := s.actionCount()
+			if changed, err := s.reconcileServiceArgs(ctx, field.configFile, args); err != nil {
+				return fmt.Errorf("failed to reconcile config file %q: %w", field.configFile, err)
+			} else if len(args) > 0 {
+				s.markServices(changed, field.restartServices...)
+				s.trackAppliedArgs(field.configFile, args)
 			}
+			s.trackActions(since, part.argsSections(field)...)
+		}
+	}
+
+	if c.sectionPresent(sectionContainerRuntime) {
+		since := s.actionCount()
+		changed, err := s.reconcileContainerRuntime(ctx, c.ContainerRuntime)
+		if err != nil {
+			return fmt.Errorf("failed to reconcile container runtime: %w", err)
+		}
+		s.markServices(changed, containerRuntimes[c.ContainerRuntime].restartServices...)
+		s.trackActions(since, SectionContainerRuntime)
+	}
+
+	if c.sectionPresent(sectionExtraSANs) {
+		since := s.actionCount()
+		if err := s.reconcileExtraSANs(ctx, c.ExtraSANs); err != nil {
+			return fmt.Errorf("failed to configure SANs for apiserver: %w", err)
+		}
+		s.trackActions(since, SectionExtraSANs)
+	}
+
+	if c.sectionPresent(sectionContainerdConfig) {
+		since := s.actionCount()
+		changed, err := s.reconcileContainerdConfig(ctx, c.Containerd.ConfigToml)
+		if err != nil {
+			return fmt.Errorf("failed to reconcile containerd config: %w", err)
+		}
+		s.markServices(changed, "containerd")
+		s.trackActions(since, SectionContainerd)
+	}
+
+	if c.sectionPresent(sectionContainerdRegistryConfigs) {
+		since := s.actionCount()
+		if err := s.reconcileContainerdRegistryConfigs(ctx, c.ContainerdRegistryConfigs); err != nil {
+			return fmt.Errorf("failed to reconcile containerd registry configs: %w", err)
+		}
+		s.trackActions(since, SectionContainerdRegistryConfigs)
+	}
+
+	if c.sectionPresent(sectionRegistryMirrors) {
+		since := s.actionCount()
+		changed, err := s.reconcileRegistryMirrors(ctx, c.RegistryMirrors)
+		if err != nil {
+			return fmt.Errorf("failed to reconcile registry mirrors: %w", err)
+		}
+		s.markServices(changed, "containerd")
+		s.trackActions(since, SectionRegistryMirrors)
+	}
+
+	if c.sectionPresent(sectionCNI) {
+		since := s.actionCount()
+		if err := s.reconcileCNI(ctx, c.CNI); err != nil {
+			return fmt.Errorf("failed to reconcile cni: %w", err)
+		}
+		s.trackActions(since, SectionCNI)
+	}
+
+	if !s.launcher.preInit && c.sectionPresent(sectionJoin) {
+		j := c.Join
+		if err := s.record(ctx, Action{Kind: ActionJoinCluster, Target: j.URL}, func() error { return s.snap.JoinCluster(ctx, j.URL, j.Worker) }); err != nil {
+			return fmt.Errorf("failed to join cluster: %w", err)
 		}
+		s.appliedSections[SectionJoin] = struct{}{}
 	}
 
 	return nil
 }
 
+// reconcileAddons enables and disables addons.
+// Unless strict list order is requested, all addons are disabled first, then addons are enabled by ascending priority.
+// If parallel workers are configured, addons with the same priority are enabled in parallel.
 func (s *launcherScope) reconcileAddons(ctx context.Context, addons []AddonConfiguration) error {
-	for _, addon := range addons {
-		if addon.Disable {
-			if err := s.launcher.snap.DisableAddon(ctx, addon.Name, addon.Arguments...); err != nil {
-				return fmt.Errorf("failed to disable addon %q: %w", addon.Name, err)
+	if s.opts.PreserveAddonOrder {
+		for _, addon := range addons {
+			if err := s.reconcileAddonWithPolicy(ctx, addon); err != nil {
+				return err
 			}
-		} else if err := s.launcher.snap.EnableAddon(ctx, addon.Name, addon.Arguments...); err != nil {
-			return fmt.Errorf("failed to enable addon %q: %w", addon.Name, err)
+		}
+		return nil
+	}
+	deps := s.addonDependencies()
+	sorted, err := sortAddonDependencies(sortAddons(addons), deps)
+	if err != nil {
+		return err
+	}
+	for _, group := range groupAddons(sorted, deps) {
+		if err := s.reconcileAddonGroup(ctx, group); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// reconcileAddonGroup enables a group of addons that do not depend on each other, using up to opts.AddonWorkers in parallel.
+// After an addon fails with the "abort" failure policy, no more addons of the group are started. All failures are
+// aggregated in an AddonsError.
+func (s *launcherScope) reconcileAddonGroup(ctx context.Context, group []AddonConfiguration) error {
+	workers := s.opts.AddonWorkers
+	if workers <= 1 || len(group) == 1 {
+		for _, addon := range group {
+			if err := s.reconcileAddonWithPolicy(ctx, addon); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		errs    = make([]error, len(group))
+		aborted bool
+		sem     = make(chan struct{}, workers)
+	)
+	for idx, addon := range group {
+		sem <- struct{}{}
+		mu.Lock()
+		stop := aborted || ctx.Err() != nil
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(idx int, addon AddonConfiguration) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := s.reconcileAddonWithPolicy(ctx, addon); err != nil {
+				mu.Lock()
+				errs[idx] = err
+				aborted = true
+				mu.Unlock()
+			}
+		}(idx, addon)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	default:
+		return &AddonsError{Errors: failed}
+	}
+}
+
+// reconcileAddonWithPolicy enables or disables a single addon. Failures of addons with the "continue" failure policy
+// are logged and recorded, and nil is returned. Cancelling ctx always stops applying the configuration.
+func (s *launcherScope) reconcileAddonWithPolicy(ctx context.Context, addon AddonConfiguration) error {
+	err := s.reconcileAddon(ctx, addon)
+	if err == nil || addon.FailurePolicy != AddonFailurePolicyContinue || ctx.Err() != nil {
+		return err
+	}
+	s.logger.Warnf("%v (continuing due to failure policy)", err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addonErrors = append(s.addonErrors, err)
+	return nil
+}
+
+// groupAddons splits a list of addons sorted by sortAddonDependencies into groups that can be reconciled in parallel.
+// Each disabled addon is a group of its own, so that addons are disabled in list order. Enabled addons are grouped by
+// priority, and an addon is never in the same group as an addon it depends on.
+func groupAddons(addons []AddonConfiguration, deps map[string][]string) [][]AddonConfiguration {
+	var groups [][]AddonConfiguration
+	for idx, addon := range addons {
+		if addon.Disable || idx == 0 || addons[idx-1].Disable || addons[idx-1].Priority != addon.Priority || dependsOnAny(addon, groups[len(groups)-1], deps) {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], addon)
+	}
+	return groups
+}
+
+// reconcileAddon enables or disables a single addon, respecting its timeout.
+func (s *launcherScope) reconcileAddon(ctx context.Context, addon AddonConfiguration) error {
+	if addon.Timeout != "" {
+		timeout, err := time.ParseDuration(addon.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout %q for addon %q: %w", addon.Timeout, addon.Name, err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	name := addon.QualifiedName()
+	args, err := s.resolveAddonArguments(addon.Arguments)
+	if err != nil {
+		return fmt.Errorf("failed to resolve arguments of addon %q: %w", name, err)
+	}
+	addon.Arguments = args
+	if addon.Disable {
+		action := Action{Kind: ActionDisableAddon, Target: name, Arguments: addon.Arguments}
+		if err := s.record(ctx, action, func() error {
+			return s.retry(ctx, action, func() error { return s.snap.DisableAddon(ctx, name, addon.Arguments...) })
+		}); err != nil {
+			return fmt.Errorf("failed to disable addon %q: %w", name, err)
+		}
+		return nil
+	}
+	action := Action{Kind: ActionEnableAddon, Target: name, Arguments: addon.Arguments}
+	if err := s.record(ctx, action, func() error {
+		return s.retry(ctx, action, func() error { return s.snap.EnableAddon(ctx, name, addon.Arguments...) })
+	}); err != nil {
+		return fmt.Errorf("failed to enable addon %q: %w", name, err)
+	}
+	return nil
+}
+
+// resolveAddonArguments resolves the template variables of addon arguments from the local node info.
+func (s *launcherScope) resolveAddonArguments(args []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var resolved []string
+	for idx, arg := range args {
+		if !isAddonArgumentTemplate(arg) {
+			continue
+		}
+		if s.nodeInfo == nil {
+			getNodeInfo := s.opts.NodeInfo
+			if getNodeInfo == nil {
+				getNodeInfo = func() (NodeInfo, error) { return localNodeInfo(s.launcher.snap) }
+			}
+			info, err := getNodeInfo()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get node info: %w", err)
+			}
+			s.nodeInfo = &info
+		}
+		if resolved == nil {
+			resolved = append([]string(nil), args...)
+		}
+		value, err := resolveAddonArgument(arg, *s.nodeInfo)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %w", arg, err)
+		}
+		resolved[idx] = value
+	}
+	if resolved == nil {
+		return args, nil
+	}
+	return resolved, nil
+}
+
+// sortAddons returns the list of addons with all disabled addons first (in list order), then all enabled addons sorted by ascending priority.
+func sortAddons(addons []AddonConfiguration) []AddonConfiguration {
+	sorted := make([]AddonConfiguration, 0, len(addons))
+	for _, addon := range addons {
+		if addon.Disable {
+			sorted = append(sorted, addon)
+		}
+	}
+	numDisabled := len(sorted)
+	for _, addon := range addons {
+		if !addon.Disable {
+			sorted = append(sorted, addon)
+		}
+	}
+	enabled := sorted[numDisabled:]
+	sort.SliceStable(enabled, func(i, j int) bool { return enabled[i].Priority < enabled[j].Priority })
+	return sorted
+}
+
 func (s *launcherScope) reconcileServiceArgs(ctx context.Context, configFile string, args map[string]*string) (bool, error) {
 	if len(args) == 0 {
 		return false, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	updateArgs := map[string]string{}
 	deleteArgs := []string{}
+	var addLines, removeLines []string
 
-	for key, valptr := range args {
-		if valptr == nil {
+	// a null value removes the argument, an empty value sets "--flag=" with an empty value
+	// arguments of the list form are whole lines, and are added after the arguments of the map form (see ExtraArgs)
+	for _, key := range util.SortedKeys(args) {
+		valptr := args[key]
+		switch {
+		case isVerbatimArg(key) && valptr == nil:
+			removeLines = append(removeLines, key)
+		case isVerbatimArg(key):
+			addLines = append(addLines, key)
+		case valptr == nil:
 			deleteArgs = append(deleteArgs, key)
-		} else {
+		default:
 			updateArgs[key] = *valptr
 		}
 	}
 
-	changed, err := snaputil.UpdateServiceArguments(s.launcher.snap, configFile, []map[string]string{updateArgs}, deleteArgs)
+	start := time.Now()
+	var changed bool
+	if len(updateArgs) > 0 || len(deleteArgs) > 0 {
+		var err error
+		if changed, err = snaputil.UpdateManagedServiceArguments(s.snap, configFile, []map[string]string{updateArgs}, deleteArgs); err != nil {
+			s.recordResult(Action{Kind: ActionWriteServiceArguments, Target: configFile}, start, err)
+			return false, fmt.Errorf("failed to update arguments: %w", err)
+		}
+	}
+	linesChanged, err := snaputil.UpdateManagedServiceArgumentLines(s.snap, configFile, addLines, removeLines)
 	if err != nil {
+		s.recordResult(Action{Kind: ActionWriteServiceArguments, Target: configFile}, start, err)
 		return false, fmt.Errorf("failed to update arguments: %w", err)
 	}
+	changed = changed || linesChanged
+	if changed {
+		s.recordResult(Action{Kind: ActionWriteServiceArguments, Target: configFile}, start, nil)
+	}
 	return changed, nil
 }
 
-func (s *launcherScope) reconcileExtraSANs(extraSANs *[]string) error {
+func (s *launcherScope) reconcileExtraSANs(ctx context.Context, extraSANs *[]string) error {
 	if extraSANs == nil {
 		return nil
 	}
-	csr, err := util.GenerateCSRConf(*extraSANs)
+	interfaceAddrs := s.opts.InterfaceAddrs
+	if interfaceAddrs == nil {
+		interfaceAddrs = localInterfaceAddrs
+	}
+	entries, err := expandInterfaceSANs(*extraSANs, interfaceAddrs)
+	if err != nil {
+		return err
+	}
+	s.extraSANs = mergeExtraSANs(s.extraSANs, entries)
+	csr, err := util.GenerateCSRConf(s.extraSANs)
 	if err != nil {
 		return fmt.Errorf("failed to generate csr configuration: %w", err)
 	}
-	if err := s.launcher.snap.WriteCSRConfig(csr); err != nil {
+	if err := s.record(ctx, Action{Kind: ActionWriteCSRConfig, Arguments: s.extraSANs}, func() error { return s.snap.WriteCSRConfig(csr) }); err != nil {
 		return fmt.Errorf("failed to write csr configuration: %w", err)
 	}
 	return nil
 }
 
-func (s *launcherScope) reconcileContainerdRegistryConfigs(configs map[string]string) error {
+// reconcileContainerRuntime switches the local node kubelet to the specified container runtime.
+// This is a no-op if the kubelet already uses the container runtime.
+func (s *launcherScope) reconcileContainerRuntime(ctx context.Context, name string) (bool, error) {
+	if name == "" || currentContainerRuntime(s.snap) == name {
+		return false, nil
+	}
+	runtime, ok := containerRuntimes[name]
+	if !ok {
+		return false, fmt.Errorf("container runtime %q is not supported", name)
+	}
+	return s.reconcileServiceArgs(ctx, "kubelet", map[string]*string{"--container-runtime-endpoint": &runtime.endpoint})
+}
+
+func (s *launcherScope) reconcileContainerdConfig(ctx context.Context, configToml string) (bool, error) {
+	if configToml == "" {
+		return false, nil
+	}
+	if existing, err := s.snap.ReadServiceArguments("containerd-template.toml"); err == nil && existing == configToml {
+		return false, nil
+	}
+	if err := s.record(ctx, Action{Kind: ActionWriteConfigFile, Target: "containerd-template.toml"}, func() error { return s.snap.WriteServiceArguments("containerd-template.toml", []byte(configToml)) }); err != nil {
+		return false, fmt.Errorf("failed to write containerd config: %w", err)
+	}
+	return true, nil
+}
+
+func (s *launcherScope) reconcileContainerdRegistryConfigs(ctx context.Context, configs map[string]string) error {
 	if len(configs) == 0 {
 		return nil
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	cfgs := make(map[string][]byte, len(configs))
 	for registry, hostsToml := range configs {
 		cfgs[registry] = []byte(hostsToml)
 	}
 
-	if err := s.launcher.snap.UpdateContainerdRegistryConfigs(cfgs); err != nil {
+	start := time.Now()
+	err := s.snap.UpdateContainerdRegistryConfigs(cfgs)
+	for _, registry := range util.SortedKeys(configs) {
+		s.recordResult(Action{Kind: ActionWriteContainerdRegistryConfig, Target: registry}, start, err)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to update containerd registry configs: %w", err)
 	}
 	return nil
 }
 
+// reconcileCNI writes the CNI manifest (or flannel network configuration) and applies it if it was changed.
+// In pre-init mode, the manifest is only written, and is applied when MicroK8s first starts.
+func (s *launcherScope) reconcileCNI(ctx context.Context, cni CNIConfiguration) error {
+	if f := cni.Flannel; f != nil {
+		config := flannelNetworkConfig(f)
+		if existing, err := s.snap.ReadServiceArguments(flannelNetworkConfigFile); err == nil && existing == config {
+			s.markServices(false, "flanneld")
+			return nil
+		}
+		if err := s.record(ctx, Action{Kind: ActionWriteConfigFile, Target: flannelNetworkConfigFile}, func() error { return s.snap.WriteServiceArguments(flannelNetworkConfigFile, []byte(config)) }); err != nil {
+			return fmt.Errorf("failed to write flannel network config: %w", err)
+		}
+		s.markServices(true, "flanneld")
+		return nil
+	}
+
+	if cni.Config == "" && cni.Calico == nil {
+		return nil
+	}
+	existing, err := s.snap.ReadCNIYaml()
+	if err != nil && cni.Calico != nil {
+		return fmt.Errorf("failed to read existing cni manifest: %w", err)
+	}
+	manifest := cni.Config
+	if cni.Calico != nil {
+		manifest = patchCalicoManifest(existing, cni.Calico)
+	}
+	if manifest == existing {
+		return nil
+	}
+
+	if err := s.record(ctx, Action{Kind: ActionWriteCNIConfig}, func() error { return s.snap.WriteCNIYaml([]byte(manifest)) }); err != nil {
+		return fmt.Errorf("failed to write cni manifest: %w", err)
+	}
+	if !s.launcher.preInit {
+		if err := s.record(ctx, Action{Kind: ActionApplyCNI}, func() error { return s.snap.ApplyCNI(ctx) }); err != nil {
+			return fmt.Errorf("failed to apply cni manifest: %w", err)
+		}
+	}
+	return nil
+}
+
+// reconcileAddonRepositories removes and adds addon repositories. All repositories are removed first, so that a repository
+// can be replaced by another one with the same addons.
 func (s *launcherScope) reconcileAddonRepositories(ctx context.Context, repos []AddonRepositoryConfiguration) error {
 	if len(repos) == 0 {
 		return nil
 	}
 	for _, repo := range repos {
-		if err := s.launcher.snap.AddAddonsRepository(ctx, repo.Name, repo.URL, repo.Reference, true); err != nil {
+		if !repo.Disable {
+			continue
+		}
+		if err := s.record(ctx, Action{Kind: ActionRemoveAddonRepository, Target: repo.Name}, func() error { return s.snap.RemoveAddonsRepository(ctx, repo.Name) }); err != nil {
+			return fmt.Errorf("failed to remove repository %s: %w", repo.Name, err)
+		}
+	}
+	for _, repo := range repos {
+		if repo.Disable {
+			continue
+		}
+		if err := s.record(ctx, Action{Kind: ActionAddAddonRepository, Target: repo.Name, Arguments: []string{repo.URL, repo.Reference}}, func() error { return s.snap.AddAddonsRepository(ctx, repo.Name, repo.URL, repo.Reference, true) }); err != nil {
 			return fmt.Errorf("failed to add repository %s: %w", repo.Name, err)
 		}
 	}
@@ -0,0 +1,124 @@
+package k8sinit
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// argFilePrefix is the prefix of extra argument values that are read from a file at apply time, e.g. "file:/etc/oidc/secret".
+const argFilePrefix = "file:"
+
+// isArgFileAllowed returns true if the clean absolute path p is within one of the allowed directories.
+func isArgFileAllowed(p string, dirs []string) bool {
+	for _, dir := range dirs {
+		dir = path.Clean(dir)
+		if dir == "/" || p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// readArgFile returns the contents of the file that an extra argument value of the form "file:/path" refers to, without
+// trailing newlines, and its clean path. Files must be within one of opts.ArgFileDirs, and are read from opts.ArgFileFS.
+func (s *launcherScope) readArgFile(field serviceArgsField, key, value string) (string, string, error) {
+	p := strings.TrimPrefix(value, argFilePrefix)
+	if !path.IsAbs(p) {
+		return "", "", fmt.Errorf("%s[%s]: file %q must be an absolute path", field.name, key, p)
+	}
+	p = path.Clean(p)
+	if !isArgFileAllowed(p, s.opts.ArgFileDirs) {
+		return "", "", fmt.Errorf("%s[%s]: file %q is not in any of the allowed directories %q", field.name, key, p, s.opts.ArgFileDirs)
+	}
+
+	fsys := s.opts.ArgFileFS
+	if fsys == nil {
+		fsys = os.DirFS("/")
+	}
+	b, err := fs.ReadFile(fsys, strings.TrimPrefix(p, "/"))
+	if err != nil {
+		return "", "", fmt.Errorf("%s[%s]: failed to read file %q: %w", field.name, key, p, err)
+	}
+	return p, strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// isArgFileValue returns true if the value of an extra argument is read from a file.
+func isArgFileValue(key string, value *string) bool {
+	return !isVerbatimArg(key) && value != nil && strings.HasPrefix(*value, argFilePrefix)
+}
+
+// withArgFiles returns the configuration with extra argument values of the form "file:/path" replaced by the contents
+// of the file (see readArgFile). The configuration is returned as-is if it does not refer to any files, otherwise a
+// copy is returned.
+func (s *launcherScope) withArgFiles(c *Configuration) (*Configuration, error) {
+	var resolved *Configuration
+	for idx, field := range c.serviceArgsFields() {
+		for _, key := range util.SortedKeys(*field.args) {
+			value := (*field.args)[key]
+			if !isArgFileValue(key, value) {
+				continue
+			}
+			_, contents, err := s.readArgFile(field, key, *value)
+			if err != nil {
+				return nil, err
+			}
+
+			if resolved == nil {
+				resolved = c.Clone()
+			}
+			(*resolved.serviceArgsFields()[idx].args)[key] = &contents
+		}
+	}
+	if resolved == nil {
+		return c, nil
+	}
+	return resolved, nil
+}
+
+// argFileDigests returns the sha256 digests of the contents of the files that the configuration parts refer to, by
+// path. Only the digests are kept, so that changed files (e.g. a rotated secret) are detected without storing them.
+func (s *launcherScope) argFileDigests(c MultiPartConfiguration) (map[string]string, error) {
+	digests := make(map[string]string)
+	for _, part := range c.Parts {
+		if part == nil {
+			continue
+		}
+		for _, field := range part.serviceArgsFields() {
+			for _, key := range util.SortedKeys(*field.args) {
+				value := (*field.args)[key]
+				if !isArgFileValue(key, value) {
+					continue
+				}
+				p, contents, err := s.readArgFile(field, key, *value)
+				if err != nil {
+					return nil, err
+				}
+				digests[p] = fmt.Sprintf("%x", sha256.Sum256([]byte(contents)))
+			}
+		}
+	}
+	return digests, nil
+}
+
+// withArgFileDigests returns a copy of the configuration with extra argument values of the form "file:/path" suffixed
+// with the digest of the file, so that configurations referring to files with different contents are not equal.
+func withArgFileDigests(c *Configuration, digests map[string]string) *Configuration {
+	c = c.Clone()
+	for _, field := range c.serviceArgsFields() {
+		for key, value := range *field.args {
+			if !isArgFileValue(key, value) {
+				continue
+			}
+			p := path.Clean(strings.TrimPrefix(*value, argFilePrefix))
+			digested := fmt.Sprintf("%s sha256=%s", *value, digests[p])
+			(*field.args)[key] = &digested
+		}
+	}
+	return c
+}
@@ -0,0 +1,126 @@
+package k8sinit_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestArgFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"etc/microk8s/secrets/oidc-client-secret": {Data: []byte("s3cr3t\n")},
+		"etc/shadow": {Data: []byte("root:x")},
+	}
+	apply := func(s *mock.Snap, value string) (*k8sinit.ApplyResult, error) {
+		c := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{{
+			Version: "0.1.0",
+			ExtraKubeAPIServerArgs: k8sinit.ExtraArgs{
+				"--oidc-client-secret": &value,
+				"--oidc-client-id":     &[]string{"microk8s"}[0],
+			},
+		}}}
+		return k8sinit.NewLauncher(s, false).ApplyWithOptions(context.Background(), c, k8sinit.ApplyOptions{
+			ArgFileDirs: []string{"/etc/microk8s/secrets"},
+			ArgFileFS:   fsys,
+		})
+	}
+
+	t.Run("Substitute", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		_, err := apply(s, "file:/etc/microk8s/secrets/oidc-client-secret")
+		g.Expect(err).To(BeNil())
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(ContainSubstring("--oidc-client-secret=s3cr3t\n"))
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(ContainSubstring("--oidc-client-id=microk8s\n"))
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		_, err := apply(s, "file:/etc/microk8s/secrets/missing")
+		g.Expect(err).To(MatchError(fs.ErrNotExist))
+		g.Expect(err).To(MatchError(ContainSubstring(`extraKubeAPIServerArgs[--oidc-client-secret]: failed to read file "/etc/microk8s/secrets/missing"`)))
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(BeEmpty())
+	})
+
+	for _, tc := range []struct {
+		name   string
+		value  string
+		expect string
+	}{
+		{name: "Disallowed", value: "file:/etc/shadow", expect: `file "/etc/shadow" is not in any of the allowed directories ["/etc/microk8s/secrets"]`},
+		{name: "Traversal", value: "file:/etc/microk8s/secrets/../../shadow", expect: `file "/etc/shadow" is not in any of the allowed directories`},
+		{name: "Prefix", value: "file:/etc/microk8s/secrets-other/token", expect: `file "/etc/microk8s/secrets-other/token" is not in any of the allowed directories`},
+		{name: "Relative", value: "file:secrets/token", expect: `file "secrets/token" must be an absolute path`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			s := &mock.Snap{}
+			_, err := apply(s, tc.value)
+			g.Expect(err).To(MatchError(ContainSubstring(tc.expect)))
+			g.Expect(s.ServiceArguments["kube-apiserver"]).To(BeEmpty())
+		})
+	}
+
+	t.Run("NoAllowedDirs", func(t *testing.T) {
+		g := NewWithT(t)
+		value := "file:/etc/microk8s/secrets/oidc-client-secret"
+		c := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{{
+			Version:                "0.1.0",
+			ExtraKubeAPIServerArgs: k8sinit.ExtraArgs{"--oidc-client-secret": &value},
+		}}}
+		err := k8sinit.NewLauncher(&mock.Snap{}, false).Apply(context.Background(), c)
+		g.Expect(err).To(MatchError(ContainSubstring("is not in any of the allowed directories")))
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		for _, tc := range []struct {
+			name      string
+			statePath bool
+		}{
+			{name: "Hash"},
+			{name: "State", statePath: true},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				g := NewWithT(t)
+				fsys := fstest.MapFS{"etc/s/x": {Data: []byte("one\n")}}
+				value := "file:/etc/s/x"
+				c := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{{
+					Version:                "0.1.0",
+					ExtraKubeAPIServerArgs: k8sinit.ExtraArgs{"--oidc-client-secret": &value},
+				}}}
+				opts := k8sinit.ApplyOptions{ArgFileDirs: []string{"/etc/s"}, ArgFileFS: fsys}
+				if tc.statePath {
+					opts.StatePath = filepath.Join(t.TempDir(), "state.json")
+				}
+
+				s := &mock.Snap{}
+				l := k8sinit.NewLauncher(s, false)
+				_, err := l.ApplyWithOptions(context.Background(), c, opts)
+				g.Expect(err).To(BeNil())
+				g.Expect(s.ServiceArguments["kube-apiserver"]).To(ContainSubstring("--oidc-client-secret=one\n"))
+
+				fsys["etc/s/x"] = &fstest.MapFile{Data: []byte("two\n")}
+				_, err = l.ApplyWithOptions(context.Background(), c, opts)
+				g.Expect(err).To(BeNil())
+				g.Expect(s.ServiceArguments["kube-apiserver"]).To(ContainSubstring("--oidc-client-secret=two\n"))
+				g.Expect(s.RestartServiceCalledWith).To(ConsistOf("kubelite", "kubelite"))
+
+				if tc.statePath {
+					b, err := os.ReadFile(opts.StatePath)
+					g.Expect(err).To(BeNil())
+					g.Expect(string(b)).ToNot(ContainSubstring("two"))
+					g.Expect(string(b)).To(ContainSubstring("file:/etc/s/x"))
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package k8sinit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExtraArgs are extra arguments (or environment variables) of a service, e.g. `--max-pods: "110"`.
+//
+// Extra arguments may also be written as a list of arguments in the form "--flag=value", e.g. `- --feature-gates=A=true`.
+// Each list entry is added verbatim to the service arguments, so that a flag may appear multiple times. List entries are
+// stored with the whole argument as the key and an empty value, so `- --feature-gates=A=true` is the same as
+// `--feature-gates=A=true: ""`. Setting such a key to null removes the argument.
+//
+// When both forms configure the same flag, the map form is applied first and replaces (or removes) all existing occurrences
+// of the flag, then the list entries are added. For example, `--feature-gates: B=true` in a later configuration part
+// replaces the arguments added by `- --feature-gates=A=true` in an earlier part.
+type ExtraArgs map[string]*string
+
+// UnmarshalYAML implements yaml.Unmarshaler. It accepts both the map and the list form (see ExtraArgs).
+func (a *ExtraArgs) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// arguments are decoded into the existing map, so that "<<" merge keys and explicit keys are combined
+	args := map[string]*string(*a)
+	var list []interface{}
+	if err := unmarshal(&list); err != nil {
+		// not a list, decode the map form (also reporting any duplicate keys in strict mode)
+		err := unmarshal(&args)
+		*a = args
+		return err
+	}
+
+	var items []string
+	if err := unmarshal(&items); err != nil {
+		return err
+	}
+	if args == nil {
+		args = make(map[string]*string, len(items))
+	}
+	for _, item := range items {
+		if !isVerbatimArg(item) {
+			return fmt.Errorf("argument %q must be written as \"--flag=value\" in the list form", item)
+		}
+		empty := ""
+		args[item] = &empty
+	}
+	*a = args
+	return nil
+}
+
+// isVerbatimArg returns true if an extra arguments key is a whole argument (e.g. "--feature-gates=A=true") added by
+// the list form, rather than a flag name.
+func isVerbatimArg(key string) bool {
+	return strings.Contains(key, "=")
+}
+
+// argFlag returns the flag name of an extra arguments key, e.g. "--feature-gates" for "--feature-gates=A=true".
+func argFlag(key string) string {
+	if idx := strings.Index(key, "="); idx != -1 {
+		return key[:idx]
+	}
+	return key
+}
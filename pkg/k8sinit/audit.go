@@ -0,0 +1,140 @@
+package k8sinit
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// auditPolicyFile is the file in $SNAP_DATA/args that the audit policy is written to.
+	auditPolicyFile = "audit-policy.yaml"
+	// auditPolicyFileArg is the kube-apiserver argument pointing to the audit policy file.
+	auditPolicyFileArg = "--audit-policy-file"
+	// auditLogPathArg is the kube-apiserver argument for the audit log file.
+	auditLogPathArg = "--audit-log-path"
+	// defaultAuditLogPath is the audit log file used if AuditPolicyConfiguration.LogPath is not set.
+	defaultAuditLogPath = "${SNAP_COMMON}/var/log/kube-apiserver-audit.log"
+)
+
+// validAuditLevels are the supported audit levels of audit policy rules.
+var validAuditLevels = []string{"None", "Metadata", "Request", "RequestResponse"}
+
+// auditPolicy is the subset of a Kubernetes audit Policy (audit.k8s.io/v1) that is validated.
+type auditPolicy struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Rules      []struct {
+		Level string `yaml:"level"`
+	} `yaml:"rules"`
+}
+
+// withAuditPolicy returns the configuration with the audit policy translated to an extra config file and kube-apiserver
+// arguments. An empty audit policy removes the kube-apiserver arguments. The configuration is returned as-is if it does not
+// set an audit policy, otherwise a copy is returned.
+func (c *Configuration) withAuditPolicy() *Configuration {
+	if c.AuditPolicy == nil {
+		return c
+	}
+	policy := *c.AuditPolicy
+	c = c.Clone()
+	if c.ExtraKubeAPIServerArgs == nil {
+		c.ExtraKubeAPIServerArgs = make(ExtraArgs)
+	}
+	if policy.Policy == "" {
+		c.ExtraKubeAPIServerArgs[auditPolicyFileArg] = nil
+		c.ExtraKubeAPIServerArgs[auditLogPathArg] = nil
+		return c
+	}
+
+	if c.ExtraConfigFiles == nil {
+		c.ExtraConfigFiles = make(map[string]string)
+	}
+	c.ExtraConfigFiles[auditPolicyFile] = policy.Policy
+	policyPath := "${SNAP_DATA}/args/" + auditPolicyFile
+	logPath := policy.LogPath
+	if logPath == "" {
+		logPath = defaultAuditLogPath
+	}
+	c.ExtraKubeAPIServerArgs[auditPolicyFileArg] = &policyPath
+	c.ExtraKubeAPIServerArgs[auditLogPathArg] = &logPath
+	return c
+}
+
+// hasNullField returns true if the configuration explicitly sets a top-level field (e.g. "auditPolicy") to null.
+// yaml.v2 decodes null as a nil pointer, so this cannot be told apart from an absent field after parsing.
+func hasNullField(input []byte, field string) bool {
+	if !bytes.Contains(input, []byte(field)) {
+		return false
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(input, &raw); err != nil {
+		return false
+	}
+	value, ok := raw[field]
+	return ok && value == nil
+}
+
+// validateAuditPolicy checks that the audit policy is a Kubernetes audit Policy document, that the audit log path is
+// valid, and that the kube-apiserver arguments it is translated to are not also set in ExtraKubeAPIServerArgs.
+func validateAuditPolicy(c *Configuration) []error {
+	if c.AuditPolicy == nil {
+		return nil
+	}
+
+	var errs []error
+	if c.AuditPolicy.Policy != "" {
+		errs = append(errs, validateAuditPolicyDocument(c.AuditPolicy.Policy)...)
+	}
+	if logPath := c.AuditPolicy.LogPath; logPath != "" && logPath != "-" && !path.IsAbs(logPath) && !strings.HasPrefix(logPath, "${SNAP") {
+		errs = append(errs, fmt.Errorf("auditPolicy logPath %q must be an absolute path or \"-\"", logPath))
+	}
+	if _, ok := c.ExtraConfigFiles[auditPolicyFile]; ok && c.AuditPolicy.Policy != "" {
+		errs = append(errs, fmt.Errorf("auditPolicy cannot be used together with extraConfigFiles[%s], since both write the audit policy file", auditPolicyFile))
+	}
+	for _, key := range util.SortedKeys(c.ExtraKubeAPIServerArgs) {
+		if arg := argFlag(normalizeArgKey(key)); arg == auditPolicyFileArg || arg == auditLogPathArg {
+			errs = append(errs, fmt.Errorf("auditPolicy cannot be used together with extraKubeAPIServerArgs[%s], since both set the kube-apiserver %q argument", key, arg))
+		}
+	}
+	return errs
+}
+
+// validateAuditPolicyDocument checks that policy is a Kubernetes audit Policy with valid rule levels.
+func validateAuditPolicyDocument(policy string) []error {
+	var p auditPolicy
+	if err := yaml.Unmarshal([]byte(policy), &p); err != nil {
+		return []error{fmt.Errorf("auditPolicy policy is not a valid YAML document: %w", err)}
+	}
+
+	var errs []error
+	if p.APIVersion != "audit.k8s.io/v1" {
+		errs = append(errs, fmt.Errorf("auditPolicy policy apiVersion %q must be \"audit.k8s.io/v1\"", p.APIVersion))
+	}
+	if p.Kind != "Policy" {
+		errs = append(errs, fmt.Errorf("auditPolicy policy kind %q must be \"Policy\"", p.Kind))
+	}
+	if len(p.Rules) == 0 {
+		errs = append(errs, fmt.Errorf("auditPolicy policy must have at least one rule"))
+	}
+	for idx, rule := range p.Rules {
+		if !isValidAuditLevel(rule.Level) {
+			errs = append(errs, fmt.Errorf("auditPolicy policy rules[%d] level %q must be one of %s", idx, rule.Level, strings.Join(validAuditLevels, ", ")))
+		}
+	}
+	return errs
+}
+
+// isValidAuditLevel returns true if level is a supported audit level.
+func isValidAuditLevel(level string) bool {
+	for _, valid := range validAuditLevels {
+		if level == valid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package k8sinit
+
+// ConfigurationBuilder constructs a Configuration programmatically, e.g.
+//
+//	c, err := NewConfiguration("0.2.0").EnableAddon("dns").SetKubeletArg("max-pods", "110").AddSAN("10.0.0.1").Build()
+//
+// Argument names may be written with or without leading dashes, e.g. "max-pods" or "--max-pods".
+type ConfigurationBuilder struct {
+	c *Configuration
+}
+
+// NewConfiguration returns a builder for a configuration with the given file format version.
+func NewConfiguration(version string) *ConfigurationBuilder {
+	return &ConfigurationBuilder{c: &Configuration{Version: version}}
+}
+
+// EnableAddon adds an addon to enable, with optional arguments.
+func (b *ConfigurationBuilder) EnableAddon(name string, args ...string) *ConfigurationBuilder {
+	b.c.Addons = append(b.c.Addons, AddonConfiguration{Name: name, Arguments: args})
+	return b
+}
+
+// DisableAddon adds an addon to disable, with optional arguments.
+func (b *ConfigurationBuilder) DisableAddon(name string, args ...string) *ConfigurationBuilder {
+	b.c.Addons = append(b.c.Addons, AddonConfiguration{Name: name, Arguments: args, Disable: true})
+	return b
+}
+
+// SetKubeletArg sets an extra argument of the kubelet.
+func (b *ConfigurationBuilder) SetKubeletArg(name string, value string) *ConfigurationBuilder {
+	setArg(&b.c.ExtraKubeletArgs, name, &value)
+	return b
+}
+
+// RemoveKubeletArg removes an argument of the kubelet (it is set to null).
+func (b *ConfigurationBuilder) RemoveKubeletArg(name string) *ConfigurationBuilder {
+	setArg(&b.c.ExtraKubeletArgs, name, nil)
+	return b
+}
+
+// SetKubeAPIServerArg sets an extra argument of the kube-apiserver.
+func (b *ConfigurationBuilder) SetKubeAPIServerArg(name string, value string) *ConfigurationBuilder {
+	setArg(&b.c.ExtraKubeAPIServerArgs, name, &value)
+	return b
+}
+
+// RemoveKubeAPIServerArg removes an argument of the kube-apiserver (it is set to null).
+func (b *ConfigurationBuilder) RemoveKubeAPIServerArg(name string) *ConfigurationBuilder {
+	setArg(&b.c.ExtraKubeAPIServerArgs, name, nil)
+	return b
+}
+
+// SetContainerdArg sets an extra argument of containerd.
+func (b *ConfigurationBuilder) SetContainerdArg(name string, value string) *ConfigurationBuilder {
+	setArg(&b.c.ExtraContainerdArgs, name, &value)
+	return b
+}
+
+// RemoveContainerdArg removes an argument of containerd (it is set to null).
+func (b *ConfigurationBuilder) RemoveContainerdArg(name string) *ConfigurationBuilder {
+	setArg(&b.c.ExtraContainerdArgs, name, nil)
+	return b
+}
+
+// AddSAN adds an extra Subject Alternate Name to the API server certificate.
+func (b *ConfigurationBuilder) AddSAN(san string) *ConfigurationBuilder {
+	if b.c.ExtraSANs == nil {
+		b.c.ExtraSANs = &[]string{}
+	}
+	*b.c.ExtraSANs = append(*b.c.ExtraSANs, san)
+	return b
+}
+
+// Build validates the configuration and returns it. The builder may be reused, changes made after Build do not affect
+// the returned configuration.
+func (b *ConfigurationBuilder) Build() (*Configuration, error) {
+	if err := b.c.Validate(); err != nil {
+		return nil, err
+	}
+	return b.c.Clone(), nil
+}
+
+// setArg sets an extra argument in an arguments map, allocating the map if needed. A nil value removes the argument.
+func setArg(args *ExtraArgs, name string, value *string) {
+	if *args == nil {
+		*args = make(ExtraArgs)
+	}
+	(*args)[normalizeArgKey(name)] = value
+}
@@ -0,0 +1,72 @@
+package k8sinit_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestConfigurationBuilder(t *testing.T) {
+	t.Run("Build", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.NewConfiguration("0.2.0").
+			EnableAddon("dns", "1.1.1.1").
+			DisableAddon("ha-cluster", "--force").
+			SetKubeletArg("max-pods", "110").
+			RemoveKubeletArg("--foo").
+			SetKubeAPIServerArg("--event-ttl", "1h").
+			AddSAN("10.0.0.1").
+			AddSAN("node.example.com").
+			Build()
+		g.Expect(err).To(BeNil())
+		g.Expect(c).To(Equal(&k8sinit.Configuration{
+			Version: "0.2.0",
+			Addons: []k8sinit.AddonConfiguration{
+				{Name: "dns", Arguments: []string{"1.1.1.1"}},
+				{Name: "ha-cluster", Arguments: []string{"--force"}, Disable: true},
+			},
+			ExtraKubeletArgs:       map[string]*string{"--max-pods": &[]string{"110"}[0], "--foo": nil},
+			ExtraKubeAPIServerArgs: map[string]*string{"--event-ttl": &[]string{"1h"}[0]},
+			ExtraSANs:              &[]string{"10.0.0.1", "node.example.com"},
+		}))
+
+		t.Run("Parse", func(t *testing.T) {
+			g := NewWithT(t)
+			b, err := c.Marshal()
+			g.Expect(err).To(BeNil())
+			g.Expect(string(b)).To(ContainSubstring("--foo: null"))
+
+			parsed, err := k8sinit.ParseConfiguration(b)
+			g.Expect(err).To(BeNil())
+			g.Expect(parsed).To(Equal(c))
+		})
+	})
+
+	t.Run("RemoveArg", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.NewConfiguration("0.1.0").RemoveKubeletArg("foo").Build()
+		g.Expect(err).To(BeNil())
+		g.Expect(c.ExtraKubeletArgs).To(HaveKeyWithValue("--foo", BeNil()))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.NewConfiguration("0.1.0").AddSAN("not a valid SAN!").Build()
+		var validationErr *k8sinit.ValidationError
+		g.Expect(errors.As(err, &validationErr)).To(BeTrue())
+		g.Expect(c).To(BeNil())
+	})
+
+	t.Run("Reuse", func(t *testing.T) {
+		g := NewWithT(t)
+		b := k8sinit.NewConfiguration("0.1.0").SetKubeletArg("max-pods", "110")
+		c, err := b.Build()
+		g.Expect(err).To(BeNil())
+
+		b.SetKubeletArg("max-pods", "250")
+		g.Expect(*c.ExtraKubeletArgs["--max-pods"]).To(Equal("110"))
+	})
+}
@@ -0,0 +1,126 @@
+package k8sinit
+
+// Clone returns a deep copy of the configuration. Changes to the clone (including its extra arguments values, lists of SANs
+// and addons) do not affect the original configuration.
+// NOTE: this needs to be updated when new reference fields (maps, slices, pointers) are added to the Configuration struct.
+func (c *Configuration) Clone() *Configuration {
+	if c == nil {
+		return nil
+	}
+
+	clone := *c
+	clone.Include = cloneStrings(c.Include)
+	if c.Roles != nil {
+		clone.Roles = append(make([]NodeRole, 0, len(c.Roles)), c.Roles...)
+	}
+	if c.AddonRepositories != nil {
+		clone.AddonRepositories = append(make([]AddonRepositoryConfiguration, 0, len(c.AddonRepositories)), c.AddonRepositories...)
+	}
+	if c.RegistryMirrors != nil {
+		clone.RegistryMirrors = make([]RegistryMirrorConfiguration, 0, len(c.RegistryMirrors))
+		for _, mirror := range c.RegistryMirrors {
+			mirror.Mirrors = cloneStrings(mirror.Mirrors)
+			clone.RegistryMirrors = append(clone.RegistryMirrors, mirror)
+		}
+	}
+	if c.Addons != nil {
+		clone.Addons = make([]AddonConfiguration, 0, len(c.Addons))
+		for _, addon := range c.Addons {
+			addon.Arguments = cloneStrings(addon.Arguments)
+			clone.Addons = append(clone.Addons, addon)
+		}
+	}
+	if c.ExtraSANs != nil {
+		sans := cloneStrings(*c.ExtraSANs)
+		clone.ExtraSANs = &sans
+	}
+	clone.Annotations = cloneStringMap(c.Annotations)
+	clone.ContainerdRegistryConfigs = cloneStringMap(c.ContainerdRegistryConfigs)
+	clone.ExtraConfigFiles = cloneStringMap(c.ExtraConfigFiles)
+	clone.NodeLabels = cloneStringMap(c.NodeLabels)
+	clone.NodeTaints = cloneStrings(c.NodeTaints)
+	clone.Hooks.PreApply = cloneStrings(c.Hooks.PreApply)
+	clone.Hooks.PostApply = cloneStrings(c.Hooks.PostApply)
+	if c.AuditPolicy != nil {
+		auditPolicy := *c.AuditPolicy
+		clone.AuditPolicy = &auditPolicy
+	}
+	if c.KubeletConfig != nil {
+		kubeletConfig := *c.KubeletConfig
+		clone.KubeletConfig = &kubeletConfig
+	}
+	cloneGates := clone.featureGatesFields()
+	for idx, field := range c.featureGatesFields() {
+		*cloneGates[idx].gates = cloneBoolMap(*field.gates)
+	}
+	clone.AdmissionPlugins.Enable = cloneStrings(c.AdmissionPlugins.Enable)
+	clone.AdmissionPlugins.Disable = cloneStrings(c.AdmissionPlugins.Disable)
+	if c.Encryption != nil {
+		encryption := EncryptionConfiguration{Resources: cloneStrings(c.Encryption.Resources)}
+		if c.Encryption.Providers != nil {
+			encryption.Providers = make([]EncryptionProviderConfiguration, 0, len(c.Encryption.Providers))
+			for _, provider := range c.Encryption.Providers {
+				if provider.Keys != nil {
+					provider.Keys = append(make([]EncryptionKeyConfiguration, 0, len(provider.Keys)), provider.Keys...)
+				}
+				encryption.Providers = append(encryption.Providers, provider)
+			}
+		}
+		clone.Encryption = &encryption
+	}
+	if c.OIDC != nil {
+		oidc := *c.OIDC
+		clone.OIDC = &oidc
+	}
+	if c.CNI.Calico != nil {
+		calico := *c.CNI.Calico
+		clone.CNI.Calico = &calico
+	}
+	if c.CNI.Flannel != nil {
+		flannel := *c.CNI.Flannel
+		clone.CNI.Flannel = &flannel
+	}
+
+	cloneFields := clone.serviceArgsFields()
+	for idx, field := range c.serviceArgsFields() {
+		*cloneFields[idx].args = cloneArgs(*field.args)
+	}
+	return &clone
+}
+
+// cloneArgs returns a deep copy of an extra arguments map. Null values are preserved.
+func cloneArgs(args map[string]*string) map[string]*string {
+	if args == nil {
+		return nil
+	}
+	clone := make(map[string]*string, len(args))
+	for key, value := range args {
+		if value == nil {
+			clone[key] = nil
+		} else {
+			v := *value
+			clone[key] = &v
+		}
+	}
+	return clone
+}
+
+// cloneStrings returns a copy of a list of strings. A nil list remains nil.
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+// cloneStringMap returns a copy of a map of strings. A nil map remains nil.
+func cloneStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	clone := make(map[string]string, len(m))
+	for key, value := range m {
+		clone[key] = value
+	}
+	return clone
+}
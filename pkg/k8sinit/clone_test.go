@@ -0,0 +1,52 @@
+package k8sinit_test
+
+import (
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestClone(t *testing.T) {
+	b, err := testdata.ReadFile("testdata/schema/full.yaml")
+	if err != nil {
+		t.Fatalf("failed to read testdata: %v", err)
+	}
+
+	t.Run("Equal", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfiguration(b)
+		g.Expect(err).To(BeNil())
+		g.Expect(c.Clone()).To(Equal(c))
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		g := NewWithT(t)
+		var c *k8sinit.Configuration
+		g.Expect(c.Clone()).To(BeNil())
+	})
+
+	t.Run("Independent", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfiguration(b)
+		g.Expect(err).To(BeNil())
+		original, err := k8sinit.ParseConfiguration(b)
+		g.Expect(err).To(BeNil())
+
+		clone := c.Clone()
+		*clone.ExtraKubeletArgs["--cluster-dns"] = "10.0.0.10"
+		clone.ExtraKubeletArgs["--max-pods"] = &[]string{"200"}[0]
+		clone.ExtraKubeAPIServerArgs = nil
+		(*clone.ExtraSANs)[0] = "10.0.0.1"
+		*clone.ExtraSANs = append(*clone.ExtraSANs, "10.0.0.2")
+		clone.Addons[0].Arguments = append(clone.Addons[0].Arguments, "--test")
+		clone.Addons[0].Name = "metallb"
+		clone.AddonRepositories[0].URL = "https://example.com"
+		clone.ContainerdRegistryConfigs["docker.io"] = "changed"
+		clone.ExtraConfigFiles["test"] = "changed"
+
+		g.Expect(c).To(Equal(original))
+		g.Expect(clone).ToNot(Equal(original))
+	})
+}
@@ -0,0 +1,93 @@
+package k8sinit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// calicoPodCIDRRe matches the value of the CALICO_IPV4POOL_CIDR environment variable in the calico manifest.
+	calicoPodCIDRRe = regexp.MustCompile(`(?m)(- name: CALICO_IPV4POOL_CIDR\s*\n\s*value: ).*$`)
+	// calicoMTURe matches the veth_mtu setting in the calico-config ConfigMap.
+	calicoMTURe = regexp.MustCompile(`(?m)(veth_mtu: ).*$`)
+)
+
+// flannelNetworkConfigFile is the file in $SNAP_DATA/args with the flannel network configuration.
+const flannelNetworkConfigFile = "flannel-network-mgr-config"
+
+// patchCalicoManifest updates the pod CIDR and MTU in the calico manifest.
+func patchCalicoManifest(manifest string, calico *CalicoConfiguration) string {
+	if calico.PodCIDR != "" {
+		manifest = calicoPodCIDRRe.ReplaceAllString(manifest, fmt.Sprintf("${1}%q", calico.PodCIDR))
+	}
+	if calico.MTU != 0 {
+		manifest = calicoMTURe.ReplaceAllString(manifest, fmt.Sprintf(`${1}"%d"`, calico.MTU))
+	}
+	return manifest
+}
+
+// flannelNetworkConfig renders the flannel network configuration for the pod CIDR.
+func flannelNetworkConfig(flannel *FlannelConfiguration) string {
+	return fmt.Sprintf(`{"Network": %q, "Backend": {"Type": "vxlan"}}`+"\n", flannel.PodCIDR)
+}
+
+// validateCNI checks that at most one of the inline config and the typed subsections is set, and that they are valid.
+func validateCNI(cni CNIConfiguration) []error {
+	var errs []error
+
+	var set []string
+	if cni.Config != "" {
+		set = append(set, "cni.config")
+	}
+	if cni.Calico != nil {
+		set = append(set, "cni.calico")
+	}
+	if cni.Flannel != nil {
+		set = append(set, "cni.flannel")
+	}
+	if len(set) > 1 {
+		errs = append(errs, fmt.Errorf("only one of %s may be set", strings.Join(set, ", ")))
+	}
+
+	if cni.Config != "" {
+		if err := validateManifest(cni.Config); err != nil {
+			errs = append(errs, fmt.Errorf("cni.config is not a valid YAML or JSON manifest: %w", err))
+		}
+	}
+	if c := cni.Calico; c != nil {
+		if c.PodCIDR != "" {
+			if _, _, err := net.ParseCIDR(c.PodCIDR); err != nil {
+				errs = append(errs, fmt.Errorf("cni.calico.podCIDR %q is not a valid CIDR", c.PodCIDR))
+			}
+		}
+		if c.MTU < 0 {
+			errs = append(errs, fmt.Errorf("cni.calico.mtu %d must not be negative", c.MTU))
+		}
+	}
+	if f := cni.Flannel; f != nil {
+		if _, _, err := net.ParseCIDR(f.PodCIDR); err != nil {
+			errs = append(errs, fmt.Errorf("cni.flannel.podCIDR %q is not a valid CIDR", f.PodCIDR))
+		}
+	}
+	return errs
+}
+
+// validateManifest checks that all documents of a manifest are valid YAML.
+func validateManifest(manifest string) error {
+	decoder := yaml.NewDecoder(strings.NewReader(manifest))
+	for {
+		var doc interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
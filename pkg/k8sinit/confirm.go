@@ -0,0 +1,266 @@
+package k8sinit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultConfirmTimeout is the default duration within which a configuration applied with ApplyStaged must be confirmed.
+const DefaultConfirmTimeout = 10 * time.Minute
+
+var (
+	// ErrApplyNotConfirmed is reported by PendingApply.Err if a configuration applied with ApplyStaged was rolled back,
+	// since it was not confirmed before the deadline.
+	ErrApplyNotConfirmed = errors.New("configuration was not confirmed in time and was rolled back")
+
+	// ErrNoPendingApply is returned by ConfirmApply if no configuration is waiting for confirmation, e.g. because it was
+	// already rolled back.
+	ErrNoPendingApply = errors.New("no configuration is waiting for confirmation")
+)
+
+// pendingApplyState is a configuration applied with ApplyStaged that is waiting for confirmation, stored as JSON in
+// ApplyOptions.PendingPath.
+type pendingApplyState struct {
+	// Deadline is when the configuration is rolled back, unless it is confirmed first.
+	Deadline time.Time `json:"deadline"`
+	// Files are the previous contents of the changed files, in the order they were changed.
+	Files []pendingApplyFile `json:"files"`
+	// RestartedServices are the services that were restarted, and are restarted again after a rollback.
+	RestartedServices []string `json:"restartedServices,omitempty"`
+}
+
+// pendingApplyFile is the previous contents of a file changed by ApplyStaged, see fileSnapshot.
+type pendingApplyFile struct {
+	// Name is the name of the arguments file, or empty for the CNI manifest and registry configurations.
+	Name string `json:"name,omitempty"`
+	// Registry is the registry of a containerd registry configuration, e.g. "docker.io".
+	Registry string `json:"registry,omitempty"`
+	// Contents are the previous contents of the file.
+	Contents string `json:"contents"`
+	// Missing is true if the file did not exist, so that it is removed again.
+	Missing bool `json:"missing,omitempty"`
+}
+
+// readPendingApply reads the pending configuration from path. A missing file is reported with an error wrapping
+// os.ErrNotExist.
+func readPendingApply(path string) (pendingApplyState, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return pendingApplyState{}, err
+	}
+	var state pendingApplyState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return pendingApplyState{}, fmt.Errorf("failed to parse pending configuration %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// writePendingApply stores the pending configuration in path. The file is only readable by the owner, since the
+// previous contents of the files may contain secrets.
+func writePendingApply(path string, state pendingApplyState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pending configuration: %w", err)
+	}
+	return writePrivateFile(path, append(b, '\n'))
+}
+
+// PendingApply is a configuration applied with ApplyStaged, which is rolled back in the background unless it is
+// confirmed with ConfirmApply before the deadline.
+type PendingApply struct {
+	// Deadline is when the configuration is rolled back, unless it is confirmed first.
+	Deadline time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Done returns a channel that is closed once the configuration was confirmed or rolled back, or Stop was called.
+func (p *PendingApply) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns ErrApplyNotConfirmed if the configuration was rolled back, or a *RollbackError if rolling it back failed.
+// Err returns nil if the configuration was confirmed, or if Stop was called. Err must only be called after Done is closed.
+func (p *PendingApply) Err() error {
+	return p.err
+}
+
+// Stop stops waiting for the confirmation in the background, without confirming or rolling back the configuration, e.g.
+// when the process shuts down. The configuration is still pending, see ResumePendingApply.
+func (p *PendingApply) Stop() {
+	p.cancel()
+}
+
+// ApplyStaged applies a multi-part configuration like ApplyWithOptions, but the changes are only kept if ConfirmApply is
+// called within opts.ConfirmTimeout. Otherwise, the changed arguments files, CNI manifest and registry configurations
+// are restored in the background and the restarted services are restarted again, e.g. so that a node that is no longer
+// reachable after a bad change to the arguments of the API server reverts the change on its own. Like failed applies,
+// addons and joining a cluster cannot be rolled back.
+// The previous contents of the changed files and the deadline are stored in opts.PendingPath until the configuration is
+// confirmed or rolled back, so that the rollback can still happen after the process restarts (see ResumePendingApply).
+// Only one configuration can wait for confirmation at a time. The returned PendingApply is nil if no files were changed,
+// in which case there is nothing to confirm. ApplyStaged cannot be used in dry-run mode.
+func (l *Launcher) ApplyStaged(ctx context.Context, c MultiPartConfiguration, opts ApplyOptions) (*ApplyResult, *PendingApply, error) {
+	switch {
+	case opts.DryRun:
+		return &ApplyResult{}, nil, fmt.Errorf("ApplyStaged cannot be used in dry-run mode")
+	case opts.PendingPath == "":
+		return &ApplyResult{}, nil, fmt.Errorf("ApplyStaged requires a PendingPath to store the pending configuration in")
+	}
+
+	start := time.Now()
+	result, pending, err := l.lockAndApplyStaged(ctx, c, opts)
+	metricsOrDefault(opts.Metrics).ConfigurationApplied(time.Since(start), err)
+	return result, pending, err
+}
+
+// lockAndApplyStaged applies a multi-part configuration while holding the apply lock, and arms the rollback of the
+// changes unless they are confirmed (see ApplyStaged).
+func (l *Launcher) lockAndApplyStaged(ctx context.Context, c MultiPartConfiguration, opts ApplyOptions) (*ApplyResult, *PendingApply, error) {
+	unlock, err := lockApply(ctx, opts)
+	if err != nil {
+		return &ApplyResult{}, nil, err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(opts.PendingPath); err == nil {
+		return &ApplyResult{}, nil, fmt.Errorf("another configuration is waiting for confirmation in %s", opts.PendingPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return &ApplyResult{}, nil, fmt.Errorf("failed to check for a pending configuration: %w", err)
+	}
+
+	s, rollback := l.newScope(opts)
+	result, err := l.applyInScope(ctx, s, rollback, c, opts)
+	if rollback.rolledBack || len(rollback.snapshots) == 0 {
+		return result, nil, err
+	}
+
+	timeout := opts.ConfirmTimeout
+	if timeout == 0 {
+		timeout = DefaultConfirmTimeout
+	}
+	state := pendingApplyState{Deadline: time.Now().Add(timeout), RestartedServices: rollback.restartedServices}
+	for _, snapshot := range rollback.snapshots {
+		state.Files = append(state.Files, pendingApplyFile{Name: snapshot.name, Registry: snapshot.registry, Contents: snapshot.contents, Missing: snapshot.missing})
+	}
+	if writeErr := writePendingApply(opts.PendingPath, state); writeErr != nil {
+		// the changes could not be rolled back after a restart, so they are not kept
+		return result, nil, s.rollback(ctx, rollback, fmt.Errorf("failed to record the pending configuration: %w", writeErr))
+	}
+	s.logger.Infof("The configuration must be confirmed before %s, otherwise it is rolled back", state.Deadline.Format(time.RFC3339))
+	return result, l.watchPendingApply(state.Deadline, opts), err
+}
+
+// ResumePendingApply resumes waiting for the confirmation of a configuration applied with ApplyStaged by an earlier
+// process, e.g. after the cluster agent restarted. If the deadline already expired, the configuration is rolled back
+// right away. The returned PendingApply is nil if no configuration is waiting for confirmation in opts.PendingPath.
+func (l *Launcher) ResumePendingApply(opts ApplyOptions) (*PendingApply, error) {
+	state, err := readPendingApply(opts.PendingPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return l.watchPendingApply(state.Deadline, opts), nil
+}
+
+// ConfirmApply confirms the configuration applied with ApplyStaged, so that the changes are kept. Configurations can
+// also be confirmed by another process, using the same opts.PendingPath and opts.Locker. ErrNoPendingApply is returned if
+// no configuration is waiting for confirmation.
+func (l *Launcher) ConfirmApply(ctx context.Context, opts ApplyOptions) error {
+	unlock, err := lockApply(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.Remove(opts.PendingPath); errors.Is(err, os.ErrNotExist) {
+		return ErrNoPendingApply
+	} else if err != nil {
+		return fmt.Errorf("failed to confirm configuration: %w", err)
+	}
+	l.mu.Lock()
+	if l.pending != nil {
+		l.pending.cancel()
+		l.pending = nil
+	}
+	l.mu.Unlock()
+	loggerOrDefault(opts.Logger).Infof("The configuration was confirmed")
+	return nil
+}
+
+// watchPendingApply rolls back the pending configuration in the background once the deadline expires, unless it is
+// confirmed or the returned PendingApply is stopped first.
+func (l *Launcher) watchPendingApply(deadline time.Time, opts ApplyOptions) *PendingApply {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &PendingApply{Deadline: deadline, cancel: cancel, done: make(chan struct{})}
+	l.mu.Lock()
+	l.pending = p
+	l.mu.Unlock()
+
+	go func() {
+		defer close(p.done)
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		p.err = l.rollbackPendingApply(opts)
+
+		l.mu.Lock()
+		if l.pending == p {
+			l.pending = nil
+		}
+		l.mu.Unlock()
+	}()
+	return p
+}
+
+// rollbackPendingApply restores the previous contents of the files changed by the pending configuration, and restarts
+// the restarted services again. Nothing is rolled back if the configuration was confirmed in the meantime.
+// The last applied configuration (see ApplyOptions.StatePath) is removed, so that the next apply applies the full
+// configuration.
+func (l *Launcher) rollbackPendingApply(opts ApplyOptions) error {
+	logger := loggerOrDefault(opts.Logger)
+	unlock, err := lockApply(context.Background(), opts)
+	if err != nil {
+		return fmt.Errorf("failed to roll back unconfirmed configuration: %w", err)
+	}
+	defer unlock()
+
+	state, err := readPendingApply(opts.PendingPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// confirmed by another process
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to roll back unconfirmed configuration: %w", err)
+	}
+
+	logger.Warnf("The configuration was not confirmed before %s, rolling back changes", state.Deadline.Format(time.RFC3339))
+	rollback := &rollbackSnap{Snap: l.snap, restartedServices: state.RestartedServices}
+	for _, file := range state.Files {
+		rollback.snapshots = append(rollback.snapshots, fileSnapshot{name: file.Name, registry: file.Registry, contents: file.Contents, missing: file.Missing})
+	}
+	rollbackErr := rollback.rollback(context.Background(), logger)
+
+	if opts.StatePath != "" {
+		if err := os.Remove(opts.StatePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warnf("failed to remove the last applied configuration: %v", err)
+		}
+	}
+	if err := os.Remove(opts.PendingPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warnf("failed to remove the pending configuration: %v", err)
+	}
+	if rollbackErr != nil {
+		return &RollbackError{Err: ErrApplyNotConfirmed, RollbackErr: rollbackErr}
+	}
+	return ErrApplyNotConfirmed
+}
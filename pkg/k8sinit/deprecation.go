@@ -0,0 +1,63 @@
+package k8sinit
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// Deprecation describes a deprecated configuration field, and how to migrate away from it.
+type Deprecation struct {
+	// Field is the path of the deprecated field, e.g. "extraContainerdArgs".
+	Field string
+	// Replacement describes what to use instead, e.g. `"containerd.extraArgs"`.
+	Replacement string
+	// Since is the config file version that introduced the replacement. Configurations with an older version are not
+	// warned, since they cannot use the replacement yet.
+	Since *version.Version
+}
+
+// String returns the deprecation warning, e.g. `field "extraContainerdArgs" is deprecated, use "containerd.extraArgs" instead`.
+func (d Deprecation) String() string {
+	return fmt.Sprintf("field %q is deprecated, use %s instead", d.Field, d.Replacement)
+}
+
+// deprecatedFields is a registry of deprecated configuration fields. Deprecated fields are still applied, but a warning
+// is reported while parsing configurations that use them.
+var deprecatedFields = []struct {
+	Deprecation
+	isSet func(c *Configuration) bool
+}{
+	{
+		Deprecation: Deprecation{Field: "extraContainerdArgs", Replacement: `"containerd.extraArgs"`, Since: version.MustParseSemantic("0.2.0")},
+		isSet:       func(c *Configuration) bool { return len(c.ExtraContainerdArgs) > 0 },
+	},
+	{
+		Deprecation: Deprecation{Field: "extraDqliteArgs", Replacement: `"datastore.extraArgs"`, Since: version.MustParseSemantic("0.2.0")},
+		isSet:       func(c *Configuration) bool { return len(c.ExtraDqliteArgs) > 0 },
+	},
+}
+
+// Deprecations returns the deprecated fields used by the configuration.
+func (c *Configuration) Deprecations() []Deprecation {
+	v, err := version.ParseSemantic(c.Version)
+	if err != nil {
+		return nil
+	}
+	var deprecations []Deprecation
+	for _, entry := range deprecatedFields {
+		if entry.isSet(c) && !v.LessThan(entry.Since) {
+			deprecations = append(deprecations, entry.Deprecation)
+		}
+	}
+	return deprecations
+}
+
+// deprecationWarnings returns a warning for each deprecated field used by the configuration.
+func deprecationWarnings(c *Configuration) []string {
+	var warnings []string
+	for _, deprecation := range c.Deprecations() {
+		warnings = append(warnings, deprecation.String())
+	}
+	return warnings
+}
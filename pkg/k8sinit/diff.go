@@ -0,0 +1,215 @@
+package k8sinit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// DiffAction is the kind of change reported in a ConfigDiff.
+type DiffAction string
+
+const (
+	// DiffAdded means that a value is added.
+	DiffAdded DiffAction = "added"
+	// DiffChanged means that an existing value is changed.
+	DiffChanged DiffAction = "changed"
+	// DiffRemoved means that an existing value is removed.
+	DiffRemoved DiffAction = "removed"
+)
+
+// ArgDiff is a change to an extra argument (or environment variable).
+type ArgDiff struct {
+	// Field is the configuration field of the argument, e.g. "extraKubeletArgs".
+	Field string
+	// Key is the name of the argument, e.g. "--max-pods".
+	Key string
+	// Action is the kind of change.
+	Action DiffAction
+	// OldValue is the current value of the argument. Empty if the argument is added.
+	OldValue string
+	// NewValue is the new value of the argument. Empty if the argument is removed.
+	NewValue string
+	// Source is the configuration part that sets the argument, if known (see SetSources).
+	Source string
+}
+
+// AddonDiff is a change to the state of an addon.
+type AddonDiff struct {
+	// Name of the addon.
+	Name string
+	// Enable is true if the addon will be enabled, false if it will be disabled.
+	Enable bool
+	// Source is the configuration part that configures the addon, if known (see SetSources).
+	Source string
+}
+
+// ConfigDiff is the set of changes between two configurations.
+type ConfigDiff struct {
+	// Args are changes to extra arguments, sorted by field and key.
+	Args []ArgDiff
+	// Addons are addons whose state changes, in the order they are configured.
+	Addons []AddonDiff
+	// AddedSANs are SANs that are added to the API server certificate.
+	AddedSANs []string
+	// RemovedSANs are SANs that are removed from the API server certificate.
+	RemovedSANs []string
+}
+
+// IsEmpty returns true if there are no changes.
+func (d *ConfigDiff) IsEmpty() bool {
+	return len(d.Args) == 0 && len(d.Addons) == 0 && len(d.AddedSANs) == 0 && len(d.RemovedSANs) == 0
+}
+
+// SetSources sets the Source of each argument and addon change, using the provenance of a merged configuration.
+// Arguments removed by resetting all arguments of a field are attributed to the part that reset them.
+func (d *ConfigDiff) SetSources(p Provenance) {
+	for idx, arg := range d.Args {
+		source, ok := p[fmt.Sprintf("%s[%s]", arg.Field, arg.Key)]
+		if !ok {
+			source = p[fmt.Sprintf("%s[%s]", arg.Field, ResetArgsKey)]
+		}
+		d.Args[idx].Source = source
+	}
+	for idx, addon := range d.Addons {
+		d.Addons[idx].Source = p[fmt.Sprintf("addons[%s]", addon.Name)]
+	}
+}
+
+// String returns a human-readable summary of the changes, one change per line.
+// The source of each change is included, if known.
+func (d *ConfigDiff) String() string {
+	var lines []string
+	for _, arg := range d.Args {
+		switch arg.Action {
+		case DiffAdded:
+			lines = append(lines, withSource(fmt.Sprintf("%s: + %s=%s", arg.Field, arg.Key, arg.NewValue), arg.Source))
+		case DiffChanged:
+			lines = append(lines, withSource(fmt.Sprintf("%s: ~ %s=%s -> %s", arg.Field, arg.Key, arg.OldValue, arg.NewValue), arg.Source))
+		case DiffRemoved:
+			lines = append(lines, withSource(fmt.Sprintf("%s: - %s", arg.Field, arg.Key), arg.Source))
+		}
+	}
+	for _, addon := range d.Addons {
+		if addon.Enable {
+			lines = append(lines, withSource(fmt.Sprintf("addons: + %s", addon.Name), addon.Source))
+		} else {
+			lines = append(lines, withSource(fmt.Sprintf("addons: - %s", addon.Name), addon.Source))
+		}
+	}
+	for _, san := range d.AddedSANs {
+		lines = append(lines, fmt.Sprintf("extraSANs: + %s", san))
+	}
+	for _, san := range d.RemovedSANs {
+		lines = append(lines, fmt.Sprintf("extraSANs: - %s", san))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// withSource appends the source of a change to its summary line, if known.
+func withSource(line string, source string) string {
+	if source == "" {
+		return line
+	}
+	return fmt.Sprintf("%s (from %s)", line, source)
+}
+
+// Diff returns the changes that applying c would make to a node whose current state is described by current.
+//
+// Arguments that are not mentioned in c are left untouched, and are not reported, unless c resets all arguments of the field (see ResetArgsKey). Addons not present in current
+// are assumed to be disabled. If c does not set ExtraSANs, the SANs of the node are left untouched.
+func (c *Configuration) Diff(current *Configuration) (*ConfigDiff, error) {
+	if c == nil {
+		return nil, fmt.Errorf("cannot diff a nil configuration")
+	}
+	if current == nil {
+		current = &Configuration{}
+	}
+	c, current = c.withNodeArgs().withAuditPolicy().withEncryptionArgs().withOIDCArgs().withKubeletConfigArgs(), current.withNodeArgs().withAuditPolicy().withEncryptionArgs().withOIDCArgs().withKubeletConfigArgs()
+	c = c.withFeatureGates(func(configFile string) string {
+		for _, field := range current.serviceArgsFields() {
+			if value := (*field.args)[featureGatesArg]; field.configFile == configFile && value != nil {
+				return *value
+			}
+		}
+		return ""
+	})
+	c = c.withAdmissionPlugins(func(arg string) string {
+		if value := current.ExtraKubeAPIServerArgs[arg]; value != nil {
+			return *value
+		}
+		return ""
+	})
+
+	diff := &ConfigDiff{}
+
+	currentFields := current.serviceArgsFields()
+	for idx, field := range c.serviceArgsFields() {
+		currentArgs := *currentFields[idx].args
+		if hasResetArgs(*field.args) {
+			for _, key := range util.SortedKeys(currentArgs) {
+				if _, ok := (*field.args)[key]; !ok && currentArgs[key] != nil {
+					diff.Args = append(diff.Args, ArgDiff{Field: field.name, Key: key, Action: DiffRemoved, OldValue: *currentArgs[key]})
+				}
+			}
+		}
+		for _, key := range util.SortedKeys(*field.args) {
+			if key == ResetArgsKey {
+				continue
+			}
+			newValue := (*field.args)[key]
+			oldValue, exists := currentArgs[key]
+			exists = exists && oldValue != nil
+			switch {
+			case newValue == nil && exists:
+				diff.Args = append(diff.Args, ArgDiff{Field: field.name, Key: key, Action: DiffRemoved, OldValue: *oldValue})
+			case newValue != nil && !exists:
+				diff.Args = append(diff.Args, ArgDiff{Field: field.name, Key: key, Action: DiffAdded, NewValue: *newValue})
+			case newValue != nil && *newValue != *oldValue:
+				diff.Args = append(diff.Args, ArgDiff{Field: field.name, Key: key, Action: DiffChanged, OldValue: *oldValue, NewValue: *newValue})
+			}
+		}
+	}
+	sort.SliceStable(diff.Args, func(i, j int) bool { return diff.Args[i].Field < diff.Args[j].Field })
+
+	currentlyEnabled := make(map[string]bool, len(current.Addons))
+	for _, addon := range current.Addons {
+		currentlyEnabled[addon.QualifiedName()] = !addon.Disable
+	}
+	for _, addon := range c.Addons {
+		name := addon.QualifiedName()
+		if enable := !addon.Disable; enable != currentlyEnabled[name] {
+			diff.Addons = append(diff.Addons, AddonDiff{Name: name, Enable: enable})
+			currentlyEnabled[name] = enable
+		}
+	}
+
+	if c.ExtraSANs != nil {
+		var currentSANs []string
+		if current.ExtraSANs != nil {
+			currentSANs = mergeExtraSANs(nil, *current.ExtraSANs)
+		}
+		newSANs := mergeExtraSANs(nil, *c.ExtraSANs)
+		diff.AddedSANs = subtractSANs(newSANs, currentSANs)
+		diff.RemovedSANs = subtractSANs(currentSANs, newSANs)
+	}
+
+	return diff, nil
+}
+
+// subtractSANs returns the SANs of a that are not in b (see canonicalSAN), preserving order.
+func subtractSANs(a, b []string) []string {
+	exclude := make(map[string]struct{}, len(b))
+	for _, san := range b {
+		exclude[canonicalSAN(san)] = struct{}{}
+	}
+	var result []string
+	for _, san := range a {
+		if _, ok := exclude[canonicalSAN(san)]; !ok {
+			result = append(result, san)
+		}
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package k8sinit_test
+
+import (
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestDiff(t *testing.T) {
+	current := &k8sinit.Configuration{
+		Version:   "0.1.0",
+		ExtraSANs: &[]string{"10.0.0.1", "10.0.0.2"},
+		ExtraKubeletArgs: map[string]*string{
+			"--max-pods":    &[]string{"110"}[0],
+			"--cluster-dns": &[]string{"10.152.183.10"}[0],
+			"--node-ip":     &[]string{"10.0.0.1"}[0],
+		},
+		Addons: []k8sinit.AddonConfiguration{
+			{Name: "dns"},
+			{Name: "registry"},
+		},
+	}
+
+	c := &k8sinit.Configuration{
+		Version:   "0.1.0",
+		ExtraSANs: &[]string{"10.0.0.1", "node.example.com"},
+		ExtraKubeletArgs: map[string]*string{
+			"--max-pods":    &[]string{"250"}[0],
+			"--cluster-dns": nil,
+			"--node-ip":     &[]string{"10.0.0.1"}[0],
+			"--not-present": nil,
+		},
+		ExtraKubeAPIServerArgs: map[string]*string{
+			"--event-ttl": &[]string{"1h"}[0],
+		},
+		Addons: []k8sinit.AddonConfiguration{
+			{Name: "dns"},
+			{Name: "ingress"},
+			{Name: "registry", Disable: true},
+			{Name: "metallb", Disable: true},
+		},
+	}
+
+	g := NewWithT(t)
+	diff, err := c.Diff(current)
+	g.Expect(err).To(BeNil())
+	g.Expect(diff).To(Equal(&k8sinit.ConfigDiff{
+		Args: []k8sinit.ArgDiff{
+			{Field: "extraKubeAPIServerArgs", Key: "--event-ttl", Action: k8sinit.DiffAdded, NewValue: "1h"},
+			{Field: "extraKubeletArgs", Key: "--cluster-dns", Action: k8sinit.DiffRemoved, OldValue: "10.152.183.10"},
+			{Field: "extraKubeletArgs", Key: "--max-pods", Action: k8sinit.DiffChanged, OldValue: "110", NewValue: "250"},
+		},
+		Addons: []k8sinit.AddonDiff{
+			{Name: "ingress", Enable: true},
+			{Name: "registry", Enable: false},
+		},
+		AddedSANs:   []string{"node.example.com"},
+		RemovedSANs: []string{"10.0.0.2"},
+	}))
+	g.Expect(diff.IsEmpty()).To(BeFalse())
+	g.Expect(diff.String()).To(Equal(`extraKubeAPIServerArgs: + --event-ttl=1h
+extraKubeletArgs: - --cluster-dns
+extraKubeletArgs: ~ --max-pods=110 -> 250
+addons: + ingress
+addons: - registry
+extraSANs: + node.example.com
+extraSANs: - 10.0.0.2`))
+
+	t.Run("NoChanges", func(t *testing.T) {
+		g := NewWithT(t)
+		diff, err := current.Diff(current)
+		g.Expect(err).To(BeNil())
+		g.Expect(diff.IsEmpty()).To(BeTrue())
+		g.Expect(diff.String()).To(BeEmpty())
+	})
+}
+
+func TestDiffSources(t *testing.T) {
+	m := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{
+		{
+			Version:          "0.1.0",
+			Source:           "base.yaml part 0",
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"110"}[0], "--cluster-dns": &[]string{"10.0.0.10"}[0]},
+			Addons:           []k8sinit.AddonConfiguration{{Name: "dns"}},
+		},
+		{
+			Version:          "0.1.0",
+			Source:           "node.yaml part 0",
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"250"}[0]},
+		},
+	}}
+
+	g := NewWithT(t)
+	merged, provenance, err := m.MergeWithProvenance(k8sinit.MergeOptions{})
+	g.Expect(err).To(BeNil())
+	diff, err := merged.Diff(nil)
+	g.Expect(err).To(BeNil())
+	diff.SetSources(provenance)
+	g.Expect(diff.String()).To(Equal(`extraKubeletArgs: + --cluster-dns=10.0.0.10 (from base.yaml part 0)
+extraKubeletArgs: + --max-pods=250 (from node.yaml part 0)
+addons: + dns (from base.yaml part 0)`))
+}
@@ -0,0 +1,70 @@
+package k8sinit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// isConfigFileName returns true if name is a YAML configuration file, e.g. "10-base.yaml" or "20-node.yml".
+func isConfigFileName(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// ParseConfigurationDir parses all YAML files (*.yaml and *.yml) of a directory into a MultiPartConfiguration.
+// Files are parsed in lexical order, and their parts are concatenated in the same order. Other files and subdirectories
+// are skipped. Errors are returned with the name of the file that failed to parse.
+func ParseConfigurationDir(fsys fs.FS, dir string) (MultiPartConfiguration, error) {
+	return ParseConfigurationDirWithOptions(fsys, dir, ParseOptions{})
+}
+
+// ParseConfigurationDirWithOptions is like ParseConfigurationDir, but allows to configure how the files are parsed.
+// The maximum size and number of parts apply to each file. Files without any non-empty documents are skipped, and
+// ErrEmptyConfiguration is returned if no file of the directory has any parts.
+func ParseConfigurationDirWithOptions(fsys fs.FS, dir string, opts ParseOptions) (MultiPartConfiguration, error) {
+	c, err := parseConfigurationDir(fsys, dir, opts)
+	observeParse(metricsOrDefault(opts.Metrics), err)
+	return c, err
+}
+
+// parseConfigurationDir parses all YAML files of a directory into a MultiPartConfiguration.
+func parseConfigurationDir(fsys fs.FS, dir string, opts ParseOptions) (MultiPartConfiguration, error) {
+	// fs.ReadDir returns the entries sorted by file name.
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return MultiPartConfiguration{}, fmt.Errorf("failed to read config directory %q: %w", dir, err)
+	}
+
+	cfg := MultiPartConfiguration{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isConfigFileName(entry.Name()) {
+			continue
+		}
+		file := path.Join(dir, entry.Name())
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return MultiPartConfiguration{}, fmt.Errorf("failed to read config file %q: %w", file, err)
+		}
+
+		fileOpts := opts
+		fileOpts.SourceName = file
+		part, err := parseMultiPartConfiguration(bytes.NewReader(b), fileOpts, nil)
+		cfg.SkippedEmptyParts += part.SkippedEmptyParts
+		if errors.Is(err, ErrEmptyConfiguration) {
+			continue
+		}
+		if err != nil {
+			return MultiPartConfiguration{}, fmt.Errorf("failed to parse config file %q: %w", file, err)
+		}
+		cfg.Parts = append(cfg.Parts, part.Parts...)
+	}
+
+	if len(cfg.Parts) == 0 {
+		return cfg, ErrEmptyConfiguration
+	}
+	return cfg, nil
+}
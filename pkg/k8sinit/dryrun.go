@@ -0,0 +1,166 @@
+package k8sinit
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+)
+
+// dryRunSnap wraps a snap.Snap and turns all operations that change the local node into no-ops.
+// Service arguments written through dryRunSnap are kept in memory, so that they can be read back.
+type dryRunSnap struct {
+	snap.Snap
+
+	serviceArguments map[string]string
+}
+
+// newDryRunSnap wraps s for dry-run mode.
+func newDryRunSnap(s snap.Snap) *dryRunSnap {
+	return &dryRunSnap{Snap: s, serviceArguments: make(map[string]string)}
+}
+
+// EnableAddon is a no-op in dry-run mode.
+func (s *dryRunSnap) EnableAddon(context.Context, string, ...string) error {
+	return nil
+}
+
+// DisableAddon is a no-op in dry-run mode.
+func (s *dryRunSnap) DisableAddon(context.Context, string, ...string) error {
+	return nil
+}
+
+// RestartService is a no-op in dry-run mode.
+func (s *dryRunSnap) RestartService(context.Context, string) error {
+	return nil
+}
+
+// RunUpgrade is a no-op in dry-run mode.
+func (s *dryRunSnap) RunUpgrade(context.Context, string, string) error {
+	return nil
+}
+
+// WriteCNIYaml is a no-op in dry-run mode.
+func (s *dryRunSnap) WriteCNIYaml([]byte) error {
+	return nil
+}
+
+// RemoveCNIYaml is a no-op in dry-run mode.
+func (s *dryRunSnap) RemoveCNIYaml() error {
+	return nil
+}
+
+// ApplyCNI is a no-op in dry-run mode.
+func (s *dryRunSnap) ApplyCNI(context.Context) error {
+	return nil
+}
+
+// WriteDqliteUpdateYaml is a no-op in dry-run mode.
+func (s *dryRunSnap) WriteDqliteUpdateYaml([]byte) error {
+	return nil
+}
+
+// CreateNoCertsReissueLock is a no-op in dry-run mode.
+func (s *dryRunSnap) CreateNoCertsReissueLock() error {
+	return nil
+}
+
+// AddPersistentClusterToken is a no-op in dry-run mode.
+func (s *dryRunSnap) AddPersistentClusterToken(string) error {
+	return nil
+}
+
+// AddCertificateRequestToken is a no-op in dry-run mode.
+func (s *dryRunSnap) AddCertificateRequestToken(string) error {
+	return nil
+}
+
+// AddCallbackToken is a no-op in dry-run mode.
+func (s *dryRunSnap) AddCallbackToken(string, string) error {
+	return nil
+}
+
+// ImportImage is a no-op in dry-run mode.
+func (s *dryRunSnap) ImportImage(context.Context, io.Reader) error {
+	return nil
+}
+
+// WriteCSRConfig is a no-op in dry-run mode.
+func (s *dryRunSnap) WriteCSRConfig([]byte) error {
+	return nil
+}
+
+// UpdateContainerdRegistryConfigs is a no-op in dry-run mode.
+func (s *dryRunSnap) UpdateContainerdRegistryConfigs(map[string][]byte) error {
+	return nil
+}
+
+// RemoveContainerdRegistryConfig is a no-op in dry-run mode.
+func (s *dryRunSnap) RemoveContainerdRegistryConfig(string) error {
+	return nil
+}
+
+// JoinCluster is a no-op in dry-run mode.
+func (s *dryRunSnap) JoinCluster(context.Context, string, bool) error {
+	return nil
+}
+
+// ConsumeClusterToken always fails in dry-run mode.
+func (s *dryRunSnap) ConsumeClusterToken(string) bool {
+	return false
+}
+
+// ConsumeCertificateRequestToken always fails in dry-run mode.
+func (s *dryRunSnap) ConsumeCertificateRequestToken(string) bool {
+	return false
+}
+
+// SignCertificate is not supported in dry-run mode.
+func (s *dryRunSnap) SignCertificate(context.Context, []byte) ([]byte, error) {
+	return nil, errDryRun
+}
+
+// GetOrCreateSelfCallbackToken is not supported in dry-run mode.
+func (s *dryRunSnap) GetOrCreateSelfCallbackToken() (string, error) {
+	return "", errDryRun
+}
+
+// GetOrCreateKubeletToken is not supported in dry-run mode.
+func (s *dryRunSnap) GetOrCreateKubeletToken(string) (string, error) {
+	return "", errDryRun
+}
+
+// AddAddonsRepository is a no-op in dry-run mode.
+func (s *dryRunSnap) AddAddonsRepository(context.Context, string, string, string, bool) error {
+	return nil
+}
+
+// RemoveAddonsRepository is a no-op in dry-run mode.
+func (s *dryRunSnap) RemoveAddonsRepository(context.Context, string) error {
+	return nil
+}
+
+// errDryRun is returned by operations that cannot be performed in dry-run mode.
+var errDryRun = errors.New("operation not supported in dry-run mode")
+
+// ReadServiceArguments returns the service arguments written in dry-run mode, or the actual service arguments otherwise.
+func (s *dryRunSnap) ReadServiceArguments(serviceName string) (string, error) {
+	if args, ok := s.serviceArguments[serviceName]; ok {
+		return args, nil
+	}
+	return s.Snap.ReadServiceArguments(serviceName)
+}
+
+// WriteServiceArguments keeps the service arguments in memory.
+func (s *dryRunSnap) WriteServiceArguments(serviceName string, b []byte) error {
+	s.serviceArguments[serviceName] = string(b)
+	return nil
+}
+
+// RemoveServiceArguments is a no-op in dry-run mode.
+func (s *dryRunSnap) RemoveServiceArguments(string) error {
+	return nil
+}
+
+var _ snap.Snap = &dryRunSnap{}
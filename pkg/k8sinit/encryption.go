@@ -0,0 +1,237 @@
+package k8sinit
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// encryptionConfigFile is the file in $SNAP_DATA/args that the encryption configuration is written to.
+	encryptionConfigFile = "encryption-config.yaml"
+	// encryptionProviderConfigArg is the kube-apiserver argument pointing to the encryption configuration file.
+	encryptionProviderConfigArg = "--encryption-provider-config"
+)
+
+const (
+	// EncryptionProviderAESCBC encrypts resources with AES-CBC, using 16, 24 or 32 byte keys.
+	EncryptionProviderAESCBC = "aescbc"
+	// EncryptionProviderSecretbox encrypts resources with XSalsa20 and Poly1305, using 32 byte keys.
+	EncryptionProviderSecretbox = "secretbox"
+	// EncryptionProviderKMS encrypts resources with keys managed by an external KMS plugin (KMS v2).
+	EncryptionProviderKMS = "kms"
+	// EncryptionProviderIdentity does not encrypt resources. It is used to read resources that are not encrypted yet.
+	EncryptionProviderIdentity = "identity"
+)
+
+// encryptionKeySizes are the valid key sizes (in bytes) of the encryption providers that use keys.
+var encryptionKeySizes = map[string][]int{
+	EncryptionProviderAESCBC:    {16, 24, 32},
+	EncryptionProviderSecretbox: {32},
+}
+
+// withEncryptionArgs returns the configuration with the kube-apiserver argument pointing to the encryption configuration
+// file. The configuration is returned as-is if it does not configure encryption, otherwise a copy is returned.
+func (c *Configuration) withEncryptionArgs() *Configuration {
+	if c.Encryption == nil {
+		return c
+	}
+	c = c.Clone()
+	if c.ExtraKubeAPIServerArgs == nil {
+		c.ExtraKubeAPIServerArgs = make(ExtraArgs)
+	}
+	configPath := "${SNAP_DATA}/args/" + encryptionConfigFile
+	c.ExtraKubeAPIServerArgs[encryptionProviderConfigArg] = &configPath
+	return c
+}
+
+// renderEncryptionConfig renders the encryption configuration as a Kubernetes EncryptionConfiguration (apiserver.config.k8s.io/v1).
+func renderEncryptionConfig(e *EncryptionConfiguration) ([]byte, error) {
+	type key struct {
+		Name   string `yaml:"name"`
+		Secret string `yaml:"secret"`
+	}
+	type keys struct {
+		Keys []key `yaml:"keys"`
+	}
+	type kms struct {
+		APIVersion string `yaml:"apiVersion"`
+		Name       string `yaml:"name"`
+		Endpoint   string `yaml:"endpoint"`
+		Timeout    string `yaml:"timeout,omitempty"`
+	}
+	type provider struct {
+		AESCBC    *keys     `yaml:"aescbc,omitempty"`
+		Secretbox *keys     `yaml:"secretbox,omitempty"`
+		KMS       *kms      `yaml:"kms,omitempty"`
+		Identity  *struct{} `yaml:"identity,omitempty"`
+	}
+	type resource struct {
+		Resources []string   `yaml:"resources"`
+		Providers []provider `yaml:"providers"`
+	}
+	type encryptionConfiguration struct {
+		APIVersion string     `yaml:"apiVersion"`
+		Kind       string     `yaml:"kind"`
+		Resources  []resource `yaml:"resources"`
+	}
+
+	r := resource{Resources: e.Resources}
+	if len(r.Resources) == 0 {
+		r.Resources = []string{"secrets"}
+	}
+	for _, p := range e.Providers {
+		var ks []key
+		for _, k := range p.Keys {
+			ks = append(ks, key{Name: k.Name, Secret: k.Secret})
+		}
+		switch p.Type {
+		case EncryptionProviderAESCBC:
+			r.Providers = append(r.Providers, provider{AESCBC: &keys{Keys: ks}})
+		case EncryptionProviderSecretbox:
+			r.Providers = append(r.Providers, provider{Secretbox: &keys{Keys: ks}})
+		case EncryptionProviderKMS:
+			r.Providers = append(r.Providers, provider{KMS: &kms{APIVersion: "v2", Name: p.Name, Endpoint: p.Endpoint, Timeout: p.Timeout}})
+		case EncryptionProviderIdentity:
+			r.Providers = append(r.Providers, provider{Identity: &struct{}{}})
+		default:
+			return nil, fmt.Errorf("unknown encryption provider type %q", p.Type)
+		}
+	}
+
+	b, err := yaml.Marshal(encryptionConfiguration{
+		APIVersion: "apiserver.config.k8s.io/v1",
+		Kind:       "EncryptionConfiguration",
+		Resources:  []resource{r},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal encryption configuration: %w", err)
+	}
+	return b, nil
+}
+
+// reconcileEncryptionConfig writes the encryption configuration file. It returns true if the file was changed.
+// The contents of the file are not recorded in the action, since they include the encryption keys.
+func (s *launcherScope) reconcileEncryptionConfig(ctx context.Context, e *EncryptionConfiguration) (bool, error) {
+	b, err := renderEncryptionConfig(e)
+	if err != nil {
+		return false, err
+	}
+	if existing, err := s.snap.ReadServiceArguments(encryptionConfigFile); err == nil && existing == string(b) {
+		return false, nil
+	}
+	if err := s.record(ctx, Action{Kind: ActionWriteConfigFile, Target: encryptionConfigFile}, func() error { return s.snap.WriteServiceArguments(encryptionConfigFile, b) }); err != nil {
+		return false, fmt.Errorf("failed to write encryption configuration: %w", err)
+	}
+	return true, nil
+}
+
+// validateEncryption checks that at least one encryption provider is configured, that the keys of each provider are
+// valid base64 of the size required by the provider, and that the kube-apiserver argument pointing to the encryption
+// configuration file is not also set in ExtraKubeAPIServerArgs. Key material is never included in the errors.
+func validateEncryption(c *Configuration) []error {
+	e := c.Encryption
+	if e == nil {
+		return nil
+	}
+
+	var errs []error
+	if len(e.Providers) == 0 {
+		errs = append(errs, fmt.Errorf("encryption must have at least one provider"))
+	}
+	for idx, resource := range e.Resources {
+		if strings.TrimSpace(resource) == "" {
+			errs = append(errs, fmt.Errorf("encryption.resources[%d] must not be empty", idx))
+		}
+	}
+	for idx, p := range e.Providers {
+		field := fmt.Sprintf("encryption.providers[%d]", idx)
+		switch p.Type {
+		case EncryptionProviderAESCBC, EncryptionProviderSecretbox:
+			errs = append(errs, validateEncryptionKeys(field, p)...)
+		case EncryptionProviderKMS:
+			if p.Name == "" {
+				errs = append(errs, fmt.Errorf("%s name is required for the %q provider", field, p.Type))
+			}
+			if !strings.HasPrefix(p.Endpoint, "unix://") {
+				errs = append(errs, fmt.Errorf("%s endpoint %q must be a unix socket, e.g. \"unix:///var/run/kms.sock\"", field, p.Endpoint))
+			}
+			if p.Timeout != "" {
+				if timeout, err := time.ParseDuration(p.Timeout); err != nil || timeout <= 0 {
+					errs = append(errs, fmt.Errorf("%s timeout %q is not a valid positive duration", field, p.Timeout))
+				}
+			}
+		case EncryptionProviderIdentity:
+		default:
+			errs = append(errs, fmt.Errorf("%s type %q must be one of %q, %q, %q or %q", field, p.Type, EncryptionProviderAESCBC, EncryptionProviderSecretbox, EncryptionProviderKMS, EncryptionProviderIdentity))
+		}
+		if len(p.Keys) > 0 && encryptionKeySizes[p.Type] == nil {
+			errs = append(errs, fmt.Errorf("%s keys are not supported by the %q provider", field, p.Type))
+		}
+	}
+
+	if _, ok := c.ExtraConfigFiles[encryptionConfigFile]; ok {
+		errs = append(errs, fmt.Errorf("encryption cannot be used together with extraConfigFiles[%s], since both write the encryption configuration file", encryptionConfigFile))
+	}
+	for _, key := range util.SortedKeys(c.ExtraKubeAPIServerArgs) {
+		if arg := argFlag(normalizeArgKey(key)); arg == encryptionProviderConfigArg {
+			errs = append(errs, fmt.Errorf("encryption cannot be used together with extraKubeAPIServerArgs[%s], since both set the kube-apiserver %q argument", key, arg))
+		}
+	}
+	return errs
+}
+
+// validateEncryptionKeys checks the keys of an aescbc or secretbox encryption provider.
+func validateEncryptionKeys(field string, p EncryptionProviderConfiguration) []error {
+	if len(p.Keys) == 0 {
+		return []error{fmt.Errorf("%s must have at least one key for the %q provider", field, p.Type)}
+	}
+
+	var errs []error
+	names := make(map[string]struct{}, len(p.Keys))
+	for idx, key := range p.Keys {
+		if key.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.keys[%d] name must not be empty", field, idx))
+		} else if _, ok := names[key.Name]; ok {
+			errs = append(errs, fmt.Errorf("%s.keys[%d] name %q is used by multiple keys", field, idx, key.Name))
+		}
+		names[key.Name] = struct{}{}
+
+		secret, err := base64.StdEncoding.DecodeString(key.Secret)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.keys[%d] secret is not valid base64", field, idx))
+			continue
+		}
+		if !isValidKeySize(len(secret), encryptionKeySizes[p.Type]) {
+			errs = append(errs, fmt.Errorf("%s.keys[%d] secret is %d bytes, but the %q provider requires keys of %s bytes", field, idx, len(secret), p.Type, joinInts(encryptionKeySizes[p.Type])))
+		}
+	}
+	return errs
+}
+
+// isValidKeySize returns true if size is one of the valid sizes.
+func isValidKeySize(size int, valid []int) bool {
+	for _, v := range valid {
+		if size == v {
+			return true
+		}
+	}
+	return false
+}
+
+// joinInts formats a list of numbers, e.g. "16, 24 or 32".
+func joinInts(values []int) string {
+	s := make([]string, 0, len(values))
+	for _, v := range values {
+		s = append(s, fmt.Sprintf("%d", v))
+	}
+	if len(s) == 1 {
+		return s[0]
+	}
+	return strings.Join(s[:len(s)-1], ", ") + " or " + s[len(s)-1]
+}
@@ -0,0 +1,60 @@
+package k8sinit
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// expandEnv expands environment variable references in ExtraSANs, extra arguments values and arguments of the list form.
+// Undefined variables are an error, unless allowEmpty is set.
+func (c *Configuration) expandEnv(lookupEnv func(string) (string, bool), allowEmpty bool) error {
+	var missing []string
+	expand := func(s string) string {
+		return os.Expand(s, func(name string) string {
+			if name == "$" {
+				return "$"
+			}
+			v, ok := lookupEnv(name)
+			if !ok && !allowEmpty {
+				missing = append(missing, name)
+			}
+			return v
+		})
+	}
+
+	if c.ExtraSANs != nil {
+		for idx, san := range *c.ExtraSANs {
+			(*c.ExtraSANs)[idx] = expand(san)
+			if len(missing) > 0 {
+				return fmt.Errorf("extraSANs[%d]: environment variable %q is not set", idx, missing[0])
+			}
+		}
+	}
+
+	for _, field := range c.serviceArgsFields() {
+		for _, key := range util.SortedKeys(*field.args) {
+			value := (*field.args)[key]
+			if isVerbatimArg(key) {
+				// arguments of the list form (see ExtraArgs) are stored in the key
+				expanded := expand(key)
+				if len(missing) > 0 {
+					return fmt.Errorf("%s[%s]: environment variable %q is not set", field.name, key, missing[0])
+				}
+				delete(*field.args, key)
+				(*field.args)[expanded] = value
+				continue
+			}
+			if value == nil {
+				continue
+			}
+			expanded := expand(*value)
+			if len(missing) > 0 {
+				return fmt.Errorf("%s[%s]: environment variable %q is not set", field.name, key, missing[0])
+			}
+			(*field.args)[key] = &expanded
+		}
+	}
+	return nil
+}
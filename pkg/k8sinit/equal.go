@@ -0,0 +1,122 @@
+package k8sinit
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Equal returns true if c and other describe the same configuration. Unlike reflect.DeepEqual, Equal compares
+// configurations by their meaning:
+//
+//   - Nil and empty lists and maps are equal, and so are nil and empty lists of ExtraSANs.
+//   - Extra arguments that are set to null are equal to absent arguments. An argument set to null is not equal to an
+//     argument set to an empty string. ResetArgsKey is compared like any other argument.
+//   - ExtraSANs are compared regardless of order and duplicates, in canonical form (see canonicalSAN).
+//   - Addons are compared regardless of their order in the list. The priority of each addon is still compared, so
+//     configurations that enable the same addons in a different priority order are not equal.
+//   - Source and Annotations are ignored. All other fields are compared by value.
+//
+// Two nil configurations are equal, a nil configuration is not equal to a non-nil one.
+func (c *Configuration) Equal(other *Configuration) bool {
+	if c == nil || other == nil {
+		return c == nil && other == nil
+	}
+	return reflect.DeepEqual(c.normalized(), other.normalized())
+}
+
+// normalized returns a copy of the configuration in the canonical form used by Equal.
+// NOTE: this needs to be updated when new reference fields (maps, slices, pointers) are added to the Configuration struct.
+func (c *Configuration) normalized() *Configuration {
+	n := c.Clone()
+	n.Source = ""
+	n.Annotations = nil
+
+	for _, field := range n.serviceArgsFields() {
+		for key, value := range *field.args {
+			if value == nil && key != ResetArgsKey {
+				delete(*field.args, key)
+			}
+		}
+		if len(*field.args) == 0 {
+			*field.args = nil
+		}
+	}
+
+	if n.ExtraSANs != nil {
+		var sans []string
+		seen := make(map[string]struct{}, len(*n.ExtraSANs))
+		for _, entry := range *n.ExtraSANs {
+			san := strings.TrimPrefix(entry, extraSANRemovePrefix)
+			san = entry[:len(entry)-len(san)] + canonicalSAN(san)
+			if _, ok := seen[san]; !ok {
+				seen[san] = struct{}{}
+				sans = append(sans, san)
+			}
+		}
+		sort.Strings(sans)
+		n.ExtraSANs = nil
+		if len(sans) > 0 {
+			n.ExtraSANs = &sans
+		}
+	}
+
+	if len(n.Addons) == 0 {
+		n.Addons = nil
+	}
+	for idx := range n.Addons {
+		if len(n.Addons[idx].Arguments) == 0 {
+			n.Addons[idx].Arguments = nil
+		}
+	}
+	sort.SliceStable(n.Addons, func(i, j int) bool {
+		a, b := n.Addons[i], n.Addons[j]
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.QualifiedName() < b.QualifiedName()
+	})
+
+	for _, field := range n.featureGatesFields() {
+		if len(*field.gates) == 0 {
+			*field.gates = nil
+		}
+	}
+	if len(n.AdmissionPlugins.Enable) == 0 {
+		n.AdmissionPlugins.Enable = nil
+	}
+	if len(n.AdmissionPlugins.Disable) == 0 {
+		n.AdmissionPlugins.Disable = nil
+	}
+	if len(n.Include) == 0 {
+		n.Include = nil
+	}
+	if len(n.Roles) == 0 {
+		n.Roles = nil
+	}
+	if len(n.AddonRepositories) == 0 {
+		n.AddonRepositories = nil
+	}
+	if len(n.RegistryMirrors) == 0 {
+		n.RegistryMirrors = nil
+	}
+	if len(n.ContainerdRegistryConfigs) == 0 {
+		n.ContainerdRegistryConfigs = nil
+	}
+	if len(n.ExtraConfigFiles) == 0 {
+		n.ExtraConfigFiles = nil
+	}
+	if len(n.NodeLabels) == 0 {
+		n.NodeLabels = nil
+	}
+	if len(n.NodeTaints) == 0 {
+		n.NodeTaints = nil
+	}
+	if len(n.Hooks.PreApply) == 0 {
+		n.Hooks.PreApply = nil
+	}
+	if len(n.Hooks.PostApply) == 0 {
+		n.Hooks.PostApply = nil
+	}
+	return n
+}
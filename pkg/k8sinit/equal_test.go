@@ -0,0 +1,102 @@
+package k8sinit_test
+
+import (
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestEqual(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		a, b        *k8sinit.Configuration
+		expectEqual bool
+	}{
+		{name: "nil", expectEqual: true},
+		{name: "nil-and-empty", a: &k8sinit.Configuration{}},
+		{name: "empty", a: &k8sinit.Configuration{}, b: &k8sinit.Configuration{}, expectEqual: true},
+		{
+			name:        "nil-and-empty-args",
+			a:           &k8sinit.Configuration{ExtraKubeletArgs: map[string]*string{}},
+			b:           &k8sinit.Configuration{},
+			expectEqual: true,
+		},
+		{
+			name:        "null-and-absent-arg",
+			a:           &k8sinit.Configuration{ExtraKubeletArgs: map[string]*string{"--max-pods": nil}},
+			b:           &k8sinit.Configuration{},
+			expectEqual: true,
+		},
+		{
+			name: "null-and-empty-arg",
+			a:    &k8sinit.Configuration{ExtraKubeletArgs: map[string]*string{"--max-pods": nil}},
+			b:    &k8sinit.Configuration{ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{""}[0]}},
+		},
+		{
+			name: "different-arg",
+			a:    &k8sinit.Configuration{ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"110"}[0]}},
+			b:    &k8sinit.Configuration{ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"250"}[0]}},
+		},
+		{
+			name: "reset-args",
+			a:    &k8sinit.Configuration{ExtraKubeletArgs: map[string]*string{k8sinit.ResetArgsKey: nil}},
+			b:    &k8sinit.Configuration{},
+		},
+		{
+			name:        "nil-and-empty-sans",
+			a:           &k8sinit.Configuration{ExtraSANs: &[]string{}},
+			b:           &k8sinit.Configuration{},
+			expectEqual: true,
+		},
+		{
+			name:        "reordered-sans",
+			a:           &k8sinit.Configuration{ExtraSANs: &[]string{"10.0.0.1", "node.example.com", "fd00::1"}},
+			b:           &k8sinit.Configuration{ExtraSANs: &[]string{"fd00:0:0:0:0:0:0:1", "Node.Example.com", "10.0.0.1", "10.0.0.1"}},
+			expectEqual: true,
+		},
+		{
+			name: "removed-san",
+			a:    &k8sinit.Configuration{ExtraSANs: &[]string{"10.0.0.1"}},
+			b:    &k8sinit.Configuration{ExtraSANs: &[]string{"-10.0.0.1"}},
+		},
+		{
+			name:        "reordered-addons",
+			a:           &k8sinit.Configuration{Addons: []k8sinit.AddonConfiguration{{Name: "dns"}, {Name: "ingress"}}},
+			b:           &k8sinit.Configuration{Addons: []k8sinit.AddonConfiguration{{Name: "ingress"}, {Name: "dns"}}},
+			expectEqual: true,
+		},
+		{
+			name: "addon-priority",
+			a:    &k8sinit.Configuration{Addons: []k8sinit.AddonConfiguration{{Name: "dns", Priority: 1}, {Name: "ingress"}}},
+			b:    &k8sinit.Configuration{Addons: []k8sinit.AddonConfiguration{{Name: "dns"}, {Name: "ingress"}}},
+		},
+		{
+			name:        "source",
+			a:           &k8sinit.Configuration{Version: "0.1.0", Source: "a.yaml"},
+			b:           &k8sinit.Configuration{Version: "0.1.0", Source: "b.yaml"},
+			expectEqual: true,
+		},
+		{
+			name:        "annotations",
+			a:           &k8sinit.Configuration{Version: "0.2.0", Annotations: map[string]string{"owner": "team-a"}},
+			b:           &k8sinit.Configuration{Version: "0.2.0"},
+			expectEqual: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(tc.a.Equal(tc.b)).To(Equal(tc.expectEqual))
+			g.Expect(tc.b.Equal(tc.a)).To(Equal(tc.expectEqual))
+		})
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		g := NewWithT(t)
+		c := &k8sinit.Configuration{ExtraKubeletArgs: map[string]*string{"--max-pods": nil}, ExtraSANs: &[]string{"b", "a"}}
+		g.Expect(c.Equal(&k8sinit.Configuration{})).To(BeFalse())
+		g.Expect(c.ExtraKubeletArgs).To(HaveKey("--max-pods"))
+		g.Expect(*c.ExtraSANs).To(Equal([]string{"b", "a"}))
+	})
+}
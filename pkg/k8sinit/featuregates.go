@@ -0,0 +1,133 @@
+package k8sinit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// featureGatesArg is the argument that feature gates are merged into.
+const featureGatesArg = "--feature-gates"
+
+// featureGatesField is a component of the FeatureGates section, and the extra arguments field it is applied to.
+type featureGatesField struct {
+	// name is the path of the field in the configuration file, e.g. "featureGates.kubelet".
+	name string
+	// gates are the configured feature gates.
+	gates *map[string]bool
+	// argsField is the name of the extra arguments field of the component, e.g. "extraKubeletArgs".
+	argsField string
+	// args are the extra arguments of the component.
+	args *ExtraArgs
+	// configFile is the arguments file of the component in $SNAP_DATA/args.
+	configFile string
+}
+
+// featureGatesFields is a registry of the FeatureGates components.
+// NOTE: this needs to be updated when new components are added to the FeatureGatesConfiguration struct.
+func (c *Configuration) featureGatesFields() []featureGatesField {
+	return []featureGatesField{
+		{name: "featureGates.kubeAPIServer", gates: &c.FeatureGates.KubeAPIServer, argsField: "extraKubeAPIServerArgs", args: &c.ExtraKubeAPIServerArgs, configFile: "kube-apiserver"},
+		{name: "featureGates.kubelet", gates: &c.FeatureGates.Kubelet, argsField: "extraKubeletArgs", args: &c.ExtraKubeletArgs, configFile: "kubelet"},
+		{name: "featureGates.kubeControllerManager", gates: &c.FeatureGates.KubeControllerManager, argsField: "extraKubeControllerManagerArgs", args: &c.ExtraKubeControllerManagerArgs, configFile: "kube-controller-manager"},
+		{name: "featureGates.kubeScheduler", gates: &c.FeatureGates.KubeScheduler, argsField: "extraKubeSchedulerArgs", args: &c.ExtraKubeSchedulerArgs, configFile: "kube-scheduler"},
+		{name: "featureGates.kubeProxy", gates: &c.FeatureGates.KubeProxy, argsField: "extraKubeProxyArgs", args: &c.ExtraKubeProxyArgs, configFile: "kube-proxy"},
+	}
+}
+
+// parseFeatureGates parses a "--feature-gates" value, e.g. "A=true,B=false". Malformed entries are ignored.
+func parseFeatureGates(value string) map[string]bool {
+	gates := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		name, enabled, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
+			continue
+		}
+		if v, err := strconv.ParseBool(strings.TrimSpace(enabled)); err == nil {
+			gates[name] = v
+		}
+	}
+	return gates
+}
+
+// mergeFeatureGates merges gates into an existing "--feature-gates" value. Gates of the existing value that are not in
+// gates are kept. The result is sorted by gate name, e.g. "A=true,B=false".
+func mergeFeatureGates(existing string, gates map[string]bool) string {
+	merged := parseFeatureGates(existing)
+	for name, enabled := range gates {
+		merged[name] = enabled
+	}
+	entries := make([]string, 0, len(merged))
+	for _, name := range util.SortedKeys(merged) {
+		entries = append(entries, fmt.Sprintf("%s=%t", name, merged[name]))
+	}
+	return strings.Join(entries, ",")
+}
+
+// withFeatureGates returns the configuration with feature gates merged into the "--feature-gates" argument of each component.
+// existing returns the current "--feature-gates" value of the component with the given arguments file. The configuration is
+// returned as-is if it does not set any feature gates, otherwise a copy is returned.
+func (c *Configuration) withFeatureGates(existing func(configFile string) string) *Configuration {
+	if !c.hasFeatureGates() {
+		return c
+	}
+
+	c = c.Clone()
+	for _, field := range c.featureGatesFields() {
+		if len(*field.gates) == 0 {
+			continue
+		}
+		value := mergeFeatureGates(existing(field.configFile), *field.gates)
+		if *field.args == nil {
+			*field.args = make(ExtraArgs)
+		}
+		(*field.args)[featureGatesArg] = &value
+	}
+	return c
+}
+
+// validateFeatureGates checks that feature gate names are valid, and that the "--feature-gates" argument of components
+// with feature gates is not also set in their extra arguments.
+func validateFeatureGates(c *Configuration) []error {
+	var errs []error
+	for _, field := range c.featureGatesFields() {
+		if len(*field.gates) == 0 {
+			continue
+		}
+		for _, name := range util.SortedKeys(*field.gates) {
+			if name == "" || strings.ContainsAny(name, "=, ") {
+				errs = append(errs, fmt.Errorf("%s key %q is not a valid feature gate name", field.name, name))
+			}
+		}
+		for _, key := range util.SortedKeys(*field.args) {
+			if argFlag(normalizeArgKey(key)) == featureGatesArg {
+				errs = append(errs, fmt.Errorf("%s cannot be used together with %s[%s], since both set the %q argument", field.name, field.argsField, key, featureGatesArg))
+			}
+		}
+	}
+	return errs
+}
+
+// hasFeatureGates returns true if the configuration sets feature gates of any component.
+func (c *Configuration) hasFeatureGates() bool {
+	for _, field := range c.featureGatesFields() {
+		if len(*field.gates) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneBoolMap returns a copy of a map.
+func cloneBoolMap(m map[string]bool) map[string]bool {
+	if m == nil {
+		return nil
+	}
+	clone := make(map[string]bool, len(m))
+	for key, value := range m {
+		clone[key] = value
+	}
+	return clone
+}
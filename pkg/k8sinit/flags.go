@@ -0,0 +1,130 @@
+package k8sinit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// UnknownFlagPolicy controls how extra arguments that are not flags of the installed service binaries are handled.
+type UnknownFlagPolicy string
+
+const (
+	// UnknownFlagPolicyIgnore does not check extra arguments against the flags of the service binaries.
+	UnknownFlagPolicyIgnore UnknownFlagPolicy = ""
+	// UnknownFlagPolicyWarn logs a warning for each unknown flag, and applies the configuration anyway.
+	UnknownFlagPolicyWarn UnknownFlagPolicy = "warn"
+	// UnknownFlagPolicyError fails the apply before any changes are made if there are unknown flags.
+	UnknownFlagPolicyError UnknownFlagPolicy = "error"
+)
+
+// FlagSetSource returns the flags (e.g. "--max-pods") supported by the installed binary of a service, by the name of its
+// arguments file in $SNAP_DATA/args (e.g. "kubelet").
+type FlagSetSource func(ctx context.Context, service string) (map[string]struct{}, error)
+
+// flagCheckedServices are the arguments files whose extra arguments are checked against the flags of the service binary.
+var flagCheckedServices = map[string]struct{}{
+	"kube-apiserver":          {},
+	"kubelet":                 {},
+	"kube-proxy":              {},
+	"kube-controller-manager": {},
+	"kube-scheduler":          {},
+}
+
+// helpFlagRegexp matches flags in the "--help" output of a Kubernetes binary, e.g. "      --max-pods int32" or "  -v, --v Level".
+var helpFlagRegexp = regexp.MustCompile(`(?m)^\s*(?:(-[A-Za-z0-9]), )?(--[A-Za-z0-9][A-Za-z0-9.-]*)`)
+
+// NewHelpFlagSetSource returns a FlagSetSource that parses the "--help" output of the service binaries in snapDir,
+// e.g. "$SNAP/kubelet --help". runCommand is used to run the binaries. If nil, util.RunCommandWithOutput is used.
+func NewHelpFlagSetSource(snapDir string, runCommand func(ctx context.Context, stdout io.Writer, stderr io.Writer, command ...string) error) FlagSetSource {
+	if runCommand == nil {
+		runCommand = util.RunCommandWithOutput
+	}
+	return func(ctx context.Context, service string) (map[string]struct{}, error) {
+		var stdout, stderr bytes.Buffer
+		binary := filepath.Join(snapDir, service)
+		if err := runCommand(ctx, &stdout, &stderr, binary, "--help"); err != nil {
+			return nil, fmt.Errorf("failed to run %s --help: %w", binary, err)
+		}
+		flags := parseHelpFlags(stdout.String() + stderr.String())
+		if len(flags) == 0 {
+			return nil, fmt.Errorf("no flags found in the output of %s --help", binary)
+		}
+		return flags, nil
+	}
+}
+
+// parseHelpFlags returns the flags listed in the "--help" output of a binary, including their shorthands (e.g. "-v").
+func parseHelpFlags(help string) map[string]struct{} {
+	flags := make(map[string]struct{})
+	for _, match := range helpFlagRegexp.FindAllStringSubmatch(help, -1) {
+		if match[1] != "" {
+			flags[match[1]] = struct{}{}
+		}
+		flags[match[2]] = struct{}{}
+	}
+	return flags
+}
+
+// checkUnknownFlags checks the extra arguments of the Kubernetes services against the flags of the installed binaries,
+// according to opts.UnknownFlags. Arguments that are removed (set to null) are not checked. Services whose flags cannot
+// be discovered are skipped with a warning.
+func (s *launcherScope) checkUnknownFlags(ctx context.Context, c MultiPartConfiguration) error {
+	switch s.opts.UnknownFlags {
+	case UnknownFlagPolicyIgnore:
+		return nil
+	case UnknownFlagPolicyWarn, UnknownFlagPolicyError:
+	default:
+		return fmt.Errorf("unknown flag policy %q must be one of %q or %q", s.opts.UnknownFlags, UnknownFlagPolicyWarn, UnknownFlagPolicyError)
+	}
+	source := s.opts.FlagSets
+	if source == nil {
+		source = NewHelpFlagSetSource(os.Getenv("SNAP"), nil)
+	}
+
+	var errs []error
+	flagSets := make(map[string]map[string]struct{})
+	for _, part := range c.Parts {
+		for _, field := range part.serviceArgsFields() {
+			if _, ok := flagCheckedServices[field.configFile]; !ok || len(*field.args) == 0 {
+				continue
+			}
+			flags, ok := flagSets[field.configFile]
+			if !ok {
+				var err error
+				if flags, err = source(ctx, field.configFile); err != nil {
+					s.logger.Warnf("failed to discover the flags of %s, its extra arguments are not checked: %v", field.configFile, err)
+				}
+				flagSets[field.configFile] = flags
+			}
+			if flags == nil {
+				continue
+			}
+			for _, key := range util.SortedKeys(*field.args) {
+				if key == ResetArgsKey || (*field.args)[key] == nil {
+					continue
+				}
+				if _, ok := flags[argFlag(normalizeArgKey(key))]; !ok {
+					errs = append(errs, fmt.Errorf("%s[%s] is not a flag of the installed %s", field.name, key, field.configFile))
+				}
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if s.opts.UnknownFlags == UnknownFlagPolicyError {
+		return &ValidationError{Errors: errs}
+	}
+	for _, err := range errs {
+		s.logger.Warnf("%v, the service may fail to start", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package k8sinit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestUnknownFlags(t *testing.T) {
+	flagSets := func(ctx context.Context, service string) (map[string]struct{}, error) {
+		switch service {
+		case "kubelet":
+			return map[string]struct{}{"--max-pods": {}, "--node-labels": {}, "-v": {}, "--v": {}}, nil
+		case "kube-apiserver":
+			return nil, errors.New("binary not found")
+		}
+		return map[string]struct{}{}, nil
+	}
+	c := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{{
+		Version: "0.2.0",
+		ExtraKubeletArgs: k8sinit.ExtraArgs{
+			"--max-pods":          &[]string{"200"}[0],
+			"--v=2":               &[]string{""}[0],
+			"--container-runtime": &[]string{"remote"}[0],
+			"--removed-flag":      nil,
+			k8sinit.ResetArgsKey:  nil,
+		},
+		ExtraKubeAPIServerArgs: k8sinit.ExtraArgs{"--unknown": &[]string{"value"}[0]},
+		ExtraKubeliteEnv:       k8sinit.ExtraArgs{"GOFIPS": &[]string{"1"}[0]},
+	}}}
+
+	t.Run("Warn", func(t *testing.T) {
+		s := &mock.Snap{}
+		logger := &recordingLogger{}
+		_, err := k8sinit.NewLauncher(s, false).ApplyWithOptions(context.Background(), c, k8sinit.ApplyOptions{
+			Logger:       logger,
+			UnknownFlags: k8sinit.UnknownFlagPolicyWarn,
+			FlagSets:     flagSets,
+		})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(logger.warnings).To(ConsistOf(
+			"failed to discover the flags of kube-apiserver, its extra arguments are not checked: binary not found",
+			"extraKubeletArgs[--container-runtime] is not a flag of the installed kubelet, the service may fail to start",
+		))
+		g.Expect(s.ServiceArguments["kubelet"]).To(ContainSubstring("--container-runtime=remote"))
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		s := &mock.Snap{}
+		_, err := k8sinit.NewLauncher(s, false).ApplyWithOptions(context.Background(), c, k8sinit.ApplyOptions{
+			Logger:       &recordingLogger{},
+			UnknownFlags: k8sinit.UnknownFlagPolicyError,
+			FlagSets:     flagSets,
+		})
+
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError("invalid configuration: extraKubeletArgs[--container-runtime] is not a flag of the installed kubelet"))
+		g.Expect(s.ServiceArguments).To(BeEmpty())
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+
+	t.Run("KnownFlags", func(t *testing.T) {
+		s := &mock.Snap{}
+		logger := &recordingLogger{}
+		_, err := k8sinit.NewLauncher(s, false).ApplyWithOptions(context.Background(), k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{{
+			Version:          "0.2.0",
+			ExtraKubeletArgs: k8sinit.ExtraArgs{"--max-pods": &[]string{"200"}[0], "-v": &[]string{"2"}[0]},
+		}}}, k8sinit.ApplyOptions{Logger: logger, UnknownFlags: k8sinit.UnknownFlagPolicyError, FlagSets: flagSets})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(logger.warnings).To(BeEmpty())
+	})
+
+	t.Run("InvalidPolicy", func(t *testing.T) {
+		_, err := k8sinit.NewLauncher(&mock.Snap{}, false).ApplyWithOptions(context.Background(), c, k8sinit.ApplyOptions{
+			Logger:       &recordingLogger{},
+			UnknownFlags: "fail",
+			FlagSets:     flagSets,
+		})
+
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(`unknown flag policy "fail" must be one of "warn" or "error"`))
+	})
+}
+
+func TestHelpFlagSetSource(t *testing.T) {
+	help := `The kubelet is the primary "node agent" that runs on each node.
+
+Usage:
+  kubelet [flags]
+
+Flags:
+      --max-pods int32                 Number of Pods that can run on this Kubelet. (default 110)
+      --node-labels mapStringString    Labels to add when registering the node in the cluster.
+  -v, --v Level                        number for the log level verbosity
+  -h, --help                           help for kubelet
+`
+	t.Run("Parse", func(t *testing.T) {
+		var command []string
+		source := k8sinit.NewHelpFlagSetSource("/snap/microk8s/current", func(ctx context.Context, stdout io.Writer, stderr io.Writer, cmd ...string) error {
+			command = cmd
+			_, err := fmt.Fprint(stdout, help)
+			return err
+		})
+
+		g := NewWithT(t)
+		flags, err := source(context.Background(), "kubelet")
+		g.Expect(err).To(BeNil())
+		g.Expect(command).To(Equal([]string{"/snap/microk8s/current/kubelet", "--help"}))
+		g.Expect(flags).To(Equal(map[string]struct{}{"--max-pods": {}, "--node-labels": {}, "-v": {}, "--v": {}, "-h": {}, "--help": {}}))
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		source := k8sinit.NewHelpFlagSetSource("/snap/microk8s/current", func(ctx context.Context, stdout io.Writer, stderr io.Writer, cmd ...string) error {
+			return errors.New("exit status 1")
+		})
+
+		g := NewWithT(t)
+		flags, err := source(context.Background(), "kubelet")
+		g.Expect(err).To(MatchError("failed to run /snap/microk8s/current/kubelet --help: exit status 1"))
+		g.Expect(flags).To(BeNil())
+	})
+}
@@ -0,0 +1,49 @@
+package k8sinit
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// configurationHashFile is the file in $SNAP_DATA/args with the hash of the last applied configuration.
+const configurationHashFile = "launch-configuration.sha256"
+
+// configurationHash computes a stable hash of the effective configuration and the options used to apply it.
+// The hash does not depend on the order of map keys and extra SANs, or on annotations. It includes the digests of the
+// files that extra arguments refer to (see argFileDigests), so that changing a file changes the hash.
+func (l *Launcher) configurationHash(c MultiPartConfiguration, opts ApplyOptions, argFiles map[string]string) (string, error) {
+	merged, err := c.Merge()
+	if err != nil {
+		return "", fmt.Errorf("failed to merge configuration: %w", err)
+	}
+	merged.Annotations = nil
+	if merged.ExtraSANs != nil {
+		sans := append([]string(nil), *merged.ExtraSANs...)
+		sort.Strings(sans)
+		merged.ExtraSANs = &sans
+	}
+
+	// map keys are always sorted when marshaling
+	b, err := merged.Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "preInit=%v\npreserveAddonOrder=%v\n", l.preInit, opts.PreserveAddonOrder)
+	h.Write(b)
+	for _, p := range util.SortedKeys(argFiles) {
+		fmt.Fprintf(h, "argFile=%s sha256=%s\n", p, argFiles[p])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// isApplied returns true if the configuration hash matches the hash of the last applied configuration.
+func (s *launcherScope) isApplied(hash string) bool {
+	existing, err := s.snap.ReadServiceArguments(configurationHashFile)
+	return err == nil && strings.TrimSpace(existing) == hash
+}
@@ -0,0 +1,78 @@
+package k8sinit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// DefaultHookTimeout is the default timeout of each hook command.
+const DefaultHookTimeout = 5 * time.Minute
+
+// HookPhase is when a hook command is run.
+type HookPhase string
+
+const (
+	// HookPhasePreApply is before any changes are made.
+	HookPhasePreApply HookPhase = "pre-apply"
+	// HookPhasePostApply is after the configuration was applied successfully.
+	HookPhasePostApply HookPhase = "post-apply"
+)
+
+// mergedHooks returns the hooks of all configuration parts (see Merge).
+func mergedHooks(c MultiPartConfiguration) (HooksConfiguration, error) {
+	if len(c.Parts) == 0 {
+		return HooksConfiguration{}, nil
+	}
+	merged, err := c.Merge()
+	if err != nil {
+		return HooksConfiguration{}, fmt.Errorf("failed to merge hooks: %w", err)
+	}
+	return merged.Hooks, nil
+}
+
+// runHooks runs hook commands in order, and records their output in the apply result. In dry-run mode, commands are
+// recorded but not run. Failures of commands with the "continue" failure policy are logged, and nil is returned.
+func (s *launcherScope) runHooks(ctx context.Context, phase HookPhase, hooks HooksConfiguration, commands []string) error {
+	if len(commands) == 0 {
+		return nil
+	}
+	timeout := DefaultHookTimeout
+	if hooks.Timeout != "" {
+		t, err := time.ParseDuration(hooks.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid hooks timeout %q: %w", hooks.Timeout, err)
+		}
+		timeout = t
+	}
+	runCommand := s.opts.CommandRunner
+	if runCommand == nil {
+		runCommand = util.RunCommandWithOutput
+	}
+
+	for _, command := range commands {
+		err := s.record(ctx, Action{Kind: ActionRunHook, Target: string(phase), Arguments: []string{command}}, func() error {
+			if s.opts.DryRun {
+				return nil
+			}
+			hookCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			var stdout, stderr bytes.Buffer
+			err := runCommand(hookCtx, &stdout, &stderr, "/bin/sh", "-c", command)
+			s.result.Hooks = append(s.result.Hooks, HookResult{Phase: phase, Command: command, Stdout: stdout.String(), Stderr: stderr.String()})
+			return err
+		})
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("%s hook %q failed: %w", phase, command, err)
+		if hooks.FailurePolicy != AddonFailurePolicyContinue || ctx.Err() != nil {
+			return err
+		}
+		s.logger.Warnf("%v (continuing due to failure policy)", err)
+	}
+	return nil
+}
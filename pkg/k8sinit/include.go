@@ -0,0 +1,90 @@
+package k8sinit
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// resolveIncludes parses the files included by the configuration, and merges them before the fields of the configuration.
+// Included files may be multi-part configurations, and may include other files. Any warnings while parsing included
+// files are returned, prefixed with the included file name.
+func (c *Configuration) resolveIncludes(opts ParseOptions) (*Configuration, []string, error) {
+	if opts.IncludeFS == nil {
+		return nil, nil, fmt.Errorf("configuration includes %q, but includes are not enabled", c.Include)
+	}
+
+	var (
+		parts    []*Configuration
+		warnings []string
+	)
+	for _, name := range c.Include {
+		file, err := resolveIncludePath(opts.IncludeDir, name)
+		if err != nil {
+			return nil, warnings, err
+		}
+		for _, included := range opts.includeChain {
+			if included == file {
+				return nil, warnings, fmt.Errorf("circular include of %q (%s)", name, strings.Join(append(opts.includeChain, file), " -> "))
+			}
+		}
+
+		b, err := fs.ReadFile(opts.IncludeFS, file)
+		if err != nil {
+			return nil, warnings, fmt.Errorf("failed to read included file %q: %w", name, err)
+		}
+
+		includeOpts := opts
+		includeOpts.includeChain = append(append([]string(nil), opts.includeChain...), file)
+		includeOpts.Logger = &warningCollector{prefix: file, warnings: &warnings}
+		includeOpts.SourceName = file
+		included, err := parseMultiPartConfiguration(bytes.NewReader(b), includeOpts, nil)
+		if err != nil {
+			return nil, warnings, fmt.Errorf("failed to parse included file %q: %w", name, err)
+		}
+		parts = append(parts, included.Parts...)
+	}
+
+	own := c.Clone()
+	own.Include = nil
+	merged, err := MultiPartConfiguration{Parts: append(parts, own)}.Merge()
+	if err != nil {
+		return nil, warnings, fmt.Errorf("failed to merge included files: %w", err)
+	}
+	if err := merged.ValidateWithOptions(opts.Validation); err != nil {
+		return nil, warnings, err
+	}
+	return merged, warnings, nil
+}
+
+// resolveIncludePath returns the path of an included file in the include filesystem.
+// Absolute paths and paths that refer to files outside of the include filesystem (e.g. "../config.yaml") are rejected.
+func resolveIncludePath(dir string, name string) (string, error) {
+	if path.IsAbs(name) {
+		return "", fmt.Errorf("include path %q must be relative to the include directory", name)
+	}
+	if dir == "" {
+		dir = "."
+	}
+	file := path.Join(dir, name)
+	if !fs.ValidPath(file) {
+		return "", fmt.Errorf("include path %q is outside of the include directory", name)
+	}
+	return file, nil
+}
+
+// warningCollector is a Logger that collects the warnings of an included file.
+type warningCollector struct {
+	prefix   string
+	warnings *[]string
+}
+
+// Infof implements Logger. Informational messages are discarded.
+func (w *warningCollector) Infof(format string, args ...interface{}) {}
+
+// Warnf implements Logger.
+func (w *warningCollector) Warnf(format string, args ...interface{}) {
+	*w.warnings = append(*w.warnings, fmt.Sprintf("%s: %s", w.prefix, fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,139 @@
+package k8sinit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// jsonSchemaDraft is the JSON Schema dialect of the generated schema.
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// semanticVersionPattern matches semantic versions, e.g. "0.1.0" or "0.2.0-rc1".
+const semanticVersionPattern = `^(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`
+
+// jsonScalarTypes are the JSON types accepted for string fields. YAML scalars such as 1.26 or true are decoded as strings.
+var jsonScalarTypes = []string{"string", "number", "boolean"}
+
+// jsonSchema is a (partial) JSON Schema object.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+}
+
+// jsonSchemaEnums are the allowed values of string types with a fixed set of values.
+var jsonSchemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(AddonFailurePolicy("")): {string(AddonFailurePolicyAbort), string(AddonFailurePolicyContinue)},
+	reflect.TypeOf(RestartPolicy("")):      {string(RestartPolicyAlways), string(RestartPolicyOnChange), string(RestartPolicyNever)},
+	reflect.TypeOf(NodeRole("")):           {string(NodeRoleControlPlane), string(NodeRoleWorker)},
+}
+
+// GenerateJSONSchema returns a JSON Schema (draft-07) describing a single part of a launch configuration.
+// The schema is generated from the Configuration struct, so that it does not drift from the parser.
+//
+// JSON Schema cannot compare semantic versions, so the schema only checks that the version is a semantic version.
+// The range of supported versions is included in its description.
+func GenerateJSONSchema() ([]byte, error) {
+	schema, err := jsonSchemaForType(reflect.TypeOf(Configuration{}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate schema: %w", err)
+	}
+	schema.Schema = jsonSchemaDraft
+	schema.Title = "MicroK8s launch configuration"
+	schema.Required = []string{"version"}
+	schema.Properties["version"] = &jsonSchema{
+		Type:        "string",
+		Pattern:     semanticVersionPattern,
+		Description: fmt.Sprintf("version of the configuration file format, at least %v and at most %v", minimumConfigFileVersionRequired, maximumConfigFileVersionSupported),
+	}
+
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	return b, nil
+}
+
+// jsonSchemaForType returns the JSON Schema of a configuration type.
+func jsonSchemaForType(t reflect.Type) (*jsonSchema, error) {
+	if values, ok := jsonSchemaEnums[t]; ok {
+		return &jsonSchema{Type: "string", Enum: values}, nil
+	}
+
+	if t == reflect.TypeOf(ExtraArgs(nil)) {
+		// extra arguments are a map, or a list of "--flag=value" arguments
+		return &jsonSchema{
+			Type:                 []string{"object", "array"},
+			AdditionalProperties: &jsonSchema{Type: []string{"string", "number", "boolean", "null"}},
+			Items:                &jsonSchema{Type: jsonScalarTypes},
+		}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &jsonSchema{Type: jsonScalarTypes}, nil
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}, nil
+	case reflect.Int:
+		return &jsonSchema{Type: "integer"}, nil
+	case reflect.Ptr:
+		// null is allowed for optional fields, e.g. a null list (extraSANs) is the same as not setting it, and a null
+		// auditPolicy or kubeletConfig removes it
+		schema, err := jsonSchemaForType(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		switch typ := schema.Type.(type) {
+		case string:
+			schema.Type = []string{typ, "null"}
+		case []string:
+			schema.Type = append(append([]string(nil), typ...), "null")
+		}
+		return schema, nil
+	case reflect.Slice:
+		items, err := jsonSchemaForType(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %v", t.Key())
+		}
+		switch t.Elem() {
+		case reflect.TypeOf(""):
+			return &jsonSchema{Type: "object", AdditionalProperties: &jsonSchema{Type: jsonScalarTypes}}, nil
+		case reflect.TypeOf((*string)(nil)):
+			// null removes the argument or environment variable
+			return &jsonSchema{Type: "object", AdditionalProperties: &jsonSchema{Type: []string{"string", "number", "boolean", "null"}}}, nil
+		case reflect.TypeOf(false):
+			return &jsonSchema{Type: "object", AdditionalProperties: &jsonSchema{Type: "boolean"}}, nil
+		}
+		return nil, fmt.Errorf("unsupported map value type %v", t.Elem())
+	case reflect.Struct:
+		schema := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}, AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			fieldSchema, err := jsonSchemaForType(field.Type)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", name, err)
+			}
+			schema.Properties[name] = fieldSchema
+		}
+		return schema, nil
+	}
+	return nil, fmt.Errorf("unsupported type %v", t)
+}
@@ -0,0 +1,82 @@
+package k8sinit_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestGenerateJSONSchema(t *testing.T) {
+	g := NewWithT(t)
+
+	b, err := k8sinit.GenerateJSONSchema()
+	g.Expect(err).To(BeNil())
+
+	compiler := jsonschema.NewCompiler()
+	g.Expect(compiler.AddResource("launch-configuration.json", bytes.NewReader(b))).To(Succeed())
+	schema, err := compiler.Compile("launch-configuration.json")
+	g.Expect(err).To(BeNil())
+
+	for _, tc := range []struct {
+		name      string
+		expectErr bool
+	}{
+		{name: "full.yaml"},
+		{name: "containerd.yaml"},
+		{name: "extra-sans.yaml"},
+		{name: "kube-proxy-only.yaml"},
+		{name: "multi-part.yaml"},
+		{name: "multi-part-with-header.yaml"},
+		{name: "anchors.yaml"},
+		{name: "list-args.yaml"},
+		{name: "json/remove-kubelet-arg.json"},
+		{name: "invalid-schema.yaml", expectErr: true},
+		{name: "unknown-fields.yaml", expectErr: true},
+		{name: "version/non-semantic.yaml", expectErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			b, err := testdata.ReadFile(filepath.Join("testdata", "schema", tc.name))
+			g.Expect(err).To(BeNil())
+
+			var errs []error
+			reader := k8syaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(b)))
+			for {
+				doc, err := reader.Read()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				g.Expect(err).To(BeNil())
+
+				j, err := k8syaml.ToJSON(doc)
+				g.Expect(err).To(BeNil())
+				var v interface{}
+				g.Expect(json.Unmarshal(j, &v)).To(Succeed())
+				if v == nil {
+					continue
+				}
+				if err := schema.Validate(v); err != nil {
+					errs = append(errs, err)
+				}
+			}
+
+			if tc.expectErr {
+				g.Expect(errs).ToNot(BeEmpty())
+			} else {
+				g.Expect(errs).To(BeEmpty())
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package k8sinit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// kubeletConfigFile is the file in $SNAP_DATA/args that the kubelet config is written to.
+	kubeletConfigFile = "kubelet-config.yaml"
+	// kubeletConfigArg is the kubelet argument pointing to the kubelet config file.
+	kubeletConfigArg = "--config"
+)
+
+// withKubeletConfigArgs returns the configuration with the kubelet argument pointing to the kubelet config file. An empty
+// kubelet config removes the kubelet argument. The configuration is returned as-is if it does not set a kubelet config,
+// otherwise a copy is returned.
+func (c *Configuration) withKubeletConfigArgs() *Configuration {
+	if c.KubeletConfig == nil {
+		return c
+	}
+	empty := *c.KubeletConfig == ""
+	c = c.Clone()
+	if c.ExtraKubeletArgs == nil {
+		c.ExtraKubeletArgs = make(ExtraArgs)
+	}
+	if empty {
+		c.ExtraKubeletArgs[kubeletConfigArg] = nil
+		return c
+	}
+	configPath := "${SNAP_DATA}/args/" + kubeletConfigFile
+	c.ExtraKubeletArgs[kubeletConfigArg] = &configPath
+	return c
+}
+
+// mergeKubeletConfig merges the top-level fields of config into an existing kubelet config file. Fields of the existing
+// file that are not set in config are kept, in their original order. An existing file that cannot be parsed is replaced.
+func mergeKubeletConfig(existing string, config string) ([]byte, error) {
+	var override yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(config), &override); err != nil {
+		return nil, fmt.Errorf("failed to parse kubelet config: %w", err)
+	}
+	var merged yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(existing), &merged); err != nil {
+		merged = nil
+	}
+
+	index := make(map[interface{}]int, len(merged))
+	for idx, item := range merged {
+		index[item.Key] = idx
+	}
+	for _, item := range override {
+		if idx, ok := index[item.Key]; ok {
+			merged[idx].Value = item.Value
+			continue
+		}
+		index[item.Key] = len(merged)
+		merged = append(merged, item)
+	}
+
+	b, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal kubelet config: %w", err)
+	}
+	return b, nil
+}
+
+// reconcileKubeletConfig merges the kubelet config into the kubelet config file. It returns true if the file was changed.
+func (s *launcherScope) reconcileKubeletConfig(ctx context.Context, config string) (bool, error) {
+	existing, _ := s.snap.ReadServiceArguments(kubeletConfigFile)
+	b, err := mergeKubeletConfig(existing, config)
+	if err != nil {
+		return false, err
+	}
+	if existing == string(b) {
+		return false, nil
+	}
+	if err := s.record(ctx, Action{Kind: ActionWriteConfigFile, Target: kubeletConfigFile}, func() error { return s.snap.WriteServiceArguments(kubeletConfigFile, b) }); err != nil {
+		return false, fmt.Errorf("failed to write kubelet config: %w", err)
+	}
+	return true, nil
+}
+
+// validateKubeletConfig checks that the kubelet config is a KubeletConfiguration document, and that the kubelet argument
+// it is translated to is not also set in ExtraKubeletArgs.
+func validateKubeletConfig(c *Configuration) []error {
+	if c.KubeletConfig == nil {
+		return nil
+	}
+
+	var errs []error
+	if config := *c.KubeletConfig; config != "" {
+		var doc struct {
+			APIVersion string `yaml:"apiVersion"`
+			Kind       string `yaml:"kind"`
+		}
+		if err := yaml.Unmarshal([]byte(config), &doc); err != nil {
+			errs = append(errs, fmt.Errorf("kubeletConfig is not a valid YAML document: %w", err))
+		} else {
+			if doc.APIVersion != "kubelet.config.k8s.io/v1beta1" {
+				errs = append(errs, fmt.Errorf("kubeletConfig apiVersion %q must be \"kubelet.config.k8s.io/v1beta1\"", doc.APIVersion))
+			}
+			if doc.Kind != "KubeletConfiguration" {
+				errs = append(errs, fmt.Errorf("kubeletConfig kind %q must be \"KubeletConfiguration\"", doc.Kind))
+			}
+		}
+	}
+	if _, ok := c.ExtraConfigFiles[kubeletConfigFile]; ok {
+		errs = append(errs, fmt.Errorf("kubeletConfig cannot be used together with extraConfigFiles[%s], since both write the kubelet config file", kubeletConfigFile))
+	}
+	for _, key := range util.SortedKeys(c.ExtraKubeletArgs) {
+		if arg := argFlag(normalizeArgKey(key)); arg == kubeletConfigArg {
+			errs = append(errs, fmt.Errorf("kubeletConfig cannot be used together with extraKubeletArgs[%s], since both set the kubelet %q argument", key, arg))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,107 @@
+package k8sinit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// nodeLabelsArg is the kubelet argument that NodeLabels are translated to.
+	nodeLabelsArg = "--node-labels"
+	// nodeTaintsArg is the kubelet argument that NodeTaints are translated to.
+	nodeTaintsArg = "--register-with-taints"
+)
+
+// validTaintEffects are the supported effects of node taints.
+var validTaintEffects = []string{"NoSchedule", "PreferNoSchedule", "NoExecute"}
+
+// withNodeArgs returns the configuration with NodeLabels and NodeTaints translated to kubelet arguments. The configuration
+// is returned as-is if it does not set any node labels or taints, otherwise a copy is returned.
+func (c *Configuration) withNodeArgs() *Configuration {
+	if len(c.NodeLabels) == 0 && len(c.NodeTaints) == 0 {
+		return c
+	}
+	c = c.Clone()
+	if c.ExtraKubeletArgs == nil {
+		c.ExtraKubeletArgs = make(ExtraArgs)
+	}
+	if len(c.NodeLabels) > 0 {
+		labels := make([]string, 0, len(c.NodeLabels))
+		for _, key := range util.SortedKeys(c.NodeLabels) {
+			labels = append(labels, fmt.Sprintf("%s=%s", key, c.NodeLabels[key]))
+		}
+		value := strings.Join(labels, ",")
+		c.ExtraKubeletArgs[nodeLabelsArg] = &value
+	}
+	if len(c.NodeTaints) > 0 {
+		value := strings.Join(c.NodeTaints, ",")
+		c.ExtraKubeletArgs[nodeTaintsArg] = &value
+	}
+	return c
+}
+
+// validateNodeLabelsAndTaints checks that node labels and taints are well-formed, and that the kubelet arguments they
+// are translated to are not also set in ExtraKubeletArgs.
+func validateNodeLabelsAndTaints(c *Configuration) []error {
+	var errs []error
+	for _, key := range util.SortedKeys(c.NodeLabels) {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, fmt.Errorf("nodeLabels key %q is not valid: %s", key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(c.NodeLabels[key]) {
+			errs = append(errs, fmt.Errorf("nodeLabels[%s] value %q is not valid: %s", key, c.NodeLabels[key], msg))
+		}
+	}
+	for idx, taint := range c.NodeTaints {
+		if err := validateTaint(taint); err != nil {
+			errs = append(errs, fmt.Errorf("nodeTaints[%d] %q is not valid: %w", idx, taint, err))
+		}
+	}
+
+	for _, check := range []struct {
+		field string
+		set   bool
+		arg   string
+	}{
+		{field: "nodeLabels", set: len(c.NodeLabels) > 0, arg: nodeLabelsArg},
+		{field: "nodeTaints", set: len(c.NodeTaints) > 0, arg: nodeTaintsArg},
+	} {
+		if !check.set {
+			continue
+		}
+		for _, key := range util.SortedKeys(c.ExtraKubeletArgs) {
+			if argFlag(normalizeArgKey(key)) == check.arg {
+				errs = append(errs, fmt.Errorf("%s cannot be used together with extraKubeletArgs[%s], since both set the kubelet %q argument", check.field, key, check.arg))
+			}
+		}
+	}
+	return errs
+}
+
+// validateTaint checks that a taint is in the form "key[=value]:effect".
+func validateTaint(taint string) error {
+	idx := strings.LastIndex(taint, ":")
+	if idx == -1 {
+		return fmt.Errorf("must be in the form \"key[=value]:effect\"")
+	}
+	keyValue, effect := taint[:idx], taint[idx+1:]
+	key, value := keyValue, ""
+	if parts := strings.SplitN(keyValue, "=", 2); len(parts) == 2 {
+		key, value = parts[0], parts[1]
+	}
+	if msgs := validation.IsQualifiedName(key); len(msgs) > 0 {
+		return fmt.Errorf("key %q is not valid: %s", key, strings.Join(msgs, "; "))
+	}
+	if msgs := validation.IsValidLabelValue(value); len(msgs) > 0 {
+		return fmt.Errorf("value %q is not valid: %s", value, strings.Join(msgs, "; "))
+	}
+	for _, valid := range validTaintEffects {
+		if effect == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("effect %q must be one of %s", effect, strings.Join(validTaintEffects, ", "))
+}
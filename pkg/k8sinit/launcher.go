@@ -1,6 +1,8 @@
 package k8sinit
 
 import (
+	"sync"
+
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 )
 
@@ -8,6 +10,11 @@ import (
 type Launcher struct {
 	snap    snap.Snap
 	preInit bool
+
+	// mu protects pending.
+	mu sync.Mutex
+	// pending is the last configuration applied with ApplyStaged that is waiting for confirmation, if any.
+	pending *PendingApply
 }
 
 // NewLauncher creates a new launcher instance.
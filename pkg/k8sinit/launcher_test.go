@@ -2,10 +2,20 @@ package k8sinit
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
 	. "github.com/onsi/gomega"
 )
 
@@ -31,6 +41,23 @@ func TestAddons(t *testing.T) {
 				"registry",
 			},
 		},
+		{
+			name: "repository",
+			addons: []AddonConfiguration{
+				{Name: "dns"},
+				{Name: "community/istio"},
+				{Name: "linkerd", Repository: "community", Arguments: []string{"--proxy-auto-inject"}},
+				{Name: "core/registry", Disable: true},
+			},
+			expectEnableAddons: []string{
+				"dns",
+				"community/istio",
+				"community/linkerd --proxy-auto-inject",
+			},
+			expectDisableAddons: []string{
+				"core/registry",
+			},
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			for _, preInit := range []bool{false, true} {
@@ -38,7 +65,7 @@ func TestAddons(t *testing.T) {
 					s := &mock.Snap{}
 
 					l := NewLauncher(s, preInit)
-					c := MultiPartConfiguration{[]*Configuration{
+					c := MultiPartConfiguration{Parts: []*Configuration{
 						{Version: minimumConfigFileVersionRequired.String(), Addons: tc.addons},
 					}}
 					g := NewWithT(t)
@@ -82,7 +109,7 @@ func TestAddonRepositories(t *testing.T) {
 					s := &mock.Snap{}
 
 					l := NewLauncher(s, preInit)
-					c := MultiPartConfiguration{[]*Configuration{
+					c := MultiPartConfiguration{Parts: []*Configuration{
 						{Version: minimumConfigFileVersionRequired.String(), AddonRepositories: tc.repos},
 					}}
 					g := NewWithT(t)
@@ -98,13 +125,55 @@ func TestAddonRepositories(t *testing.T) {
 			}
 		})
 	}
+
+	apply := func(s *mock.Snap, repos []AddonRepositoryConfiguration) *ApplyResult {
+		result, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), MultiPartConfiguration{Parts: []*Configuration{
+			{Version: "0.2.0", AddonRepositories: repos},
+		}}, ApplyOptions{})
+		NewWithT(t).Expect(err).To(BeNil())
+		return result
+	}
+
+	t.Run("UpdateReference", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		apply(s, []AddonRepositoryConfiguration{{Name: "community", URL: "https://github.com/canonical/microk8s-community-addons", Reference: "1.27"}})
+		apply(s, []AddonRepositoryConfiguration{{Name: "community", URL: "https://github.com/canonical/microk8s-community-addons", Reference: "1.28"}})
+		g.Expect(s.AddonRepositories).To(Equal(map[string]mock.AddonRepository{
+			"community": {URL: "https://github.com/canonical/microk8s-community-addons", Reference: "1.28", Force: true},
+		}))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		apply(s, []AddonRepositoryConfiguration{
+			{Name: "core", URL: "https://github.com/canonical/microk8s-core-addons"},
+			{Name: "community", URL: "https://github.com/canonical/microk8s-community-addons"},
+		})
+		result := apply(s, []AddonRepositoryConfiguration{
+			{Name: "private", URL: "https://git.example.com/addons.git"},
+			{Name: "community", Disable: true},
+		})
+		g.Expect(s.AddonRepositories).To(Equal(map[string]mock.AddonRepository{
+			"core":    {URL: "https://github.com/canonical/microk8s-core-addons", Force: true},
+			"private": {URL: "https://git.example.com/addons.git", Force: true},
+		}))
+
+		// removals are performed before additions
+		g.Expect(result.Actions).To(HaveLen(2))
+		g.Expect(result.Actions[0].Kind).To(Equal(ActionRemoveAddonRepository))
+		g.Expect(result.Actions[0].Target).To(Equal("community"))
+		g.Expect(result.Actions[1].Kind).To(Equal(ActionAddAddonRepository))
+		g.Expect(result.Actions[1].Target).To(Equal("private"))
+	})
 }
 
 func TestContainerdRegistryConfigs(t *testing.T) {
 	s := &mock.Snap{}
 
 	l := NewLauncher(s, false)
-	c := MultiPartConfiguration{[]*Configuration{
+	c := MultiPartConfiguration{Parts: []*Configuration{
 		{
 			Version: minimumConfigFileVersionRequired.String(),
 			ContainerdRegistryConfigs: map[string]string{
@@ -183,6 +252,20 @@ func TestComponentConfiguration(t *testing.T) {
 			},
 			expectServiceRestart: []string{"containerd"},
 		},
+		{
+			name: "containerd-section",
+			setConfig: func(c *Configuration) {
+				c.Containerd.ExtraArgs = map[string]*string{
+					"--log-level": &[]string{"debug"}[0],
+				}
+				c.Containerd.ConfigToml = "version = 2\n"
+			},
+			expectServiceArgs: map[string][]string{
+				"containerd":               {"--log-level=debug\n"},
+				"containerd-template.toml": {"version = 2\n"},
+			},
+			expectServiceRestart: []string{"containerd"},
+		},
 		{
 			name: "containerd-env",
 			setConfig: func(c *Configuration) {
@@ -360,7 +443,7 @@ func TestComponentConfiguration(t *testing.T) {
 					s := &mock.Snap{}
 
 					l := NewLauncher(s, preInit)
-					c := MultiPartConfiguration{[]*Configuration{{
+					c := MultiPartConfiguration{Parts: []*Configuration{{
 						Version: minimumConfigFileVersionRequired.String(),
 					}}}
 
@@ -396,7 +479,7 @@ func TestPersistentClusterToken(t *testing.T) {
 					s := &mock.Snap{}
 
 					l := NewLauncher(s, preInit)
-					c := MultiPartConfiguration{[]*Configuration{
+					c := MultiPartConfiguration{Parts: []*Configuration{
 						{Version: minimumConfigFileVersionRequired.String()},
 					}}
 					if withToken {
@@ -424,7 +507,7 @@ func TestJoinCluster(t *testing.T) {
 					s := &mock.Snap{}
 
 					l := NewLauncher(s, preInit)
-					c := MultiPartConfiguration{[]*Configuration{{
+					c := MultiPartConfiguration{Parts: []*Configuration{{
 						Version: minimumConfigFileVersionRequired.String(),
 						Join:    JoinConfiguration{URL: "10.10.10.10:25000/token/hash", Worker: worker},
 					}}}
@@ -454,7 +537,7 @@ func TestExtraSANs(t *testing.T) {
 					s := &mock.Snap{}
 
 					l := NewLauncher(s, preInit)
-					c := MultiPartConfiguration{[]*Configuration{{
+					c := MultiPartConfiguration{Parts: []*Configuration{{
 						Version:   minimumConfigFileVersionRequired.String(),
 						ExtraSANs: sans,
 					}}}
@@ -475,3 +558,2857 @@ func TestExtraSANs(t *testing.T) {
 		})
 	}
 }
+
+func TestExtraSANsDeduplication(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		parts      [][]string
+		expectSANs []string
+	}{
+		{
+			name:       "dns-case",
+			parts:      [][]string{{"Node.Example.com", "10.0.0.5", "node.example.com"}, {"NODE.EXAMPLE.COM"}},
+			expectSANs: []string{"Node.Example.com", "10.0.0.5"},
+		},
+		{
+			name:       "ipv6-forms",
+			parts:      [][]string{{"::1", "fd00::10"}, {"0:0:0:0:0:0:0:1", "fd00:0:0:0:0:0:0:10"}},
+			expectSANs: []string{"::1", "fd00::10"},
+		},
+		{
+			name:       "remove-other-form",
+			parts:      [][]string{{"node.example.com", "fd00::10"}, {"-NODE.example.com", "-fd00:0::10"}},
+			expectSANs: []string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &mock.Snap{}
+
+			l := NewLauncher(s, false)
+			c := MultiPartConfiguration{}
+			for _, sans := range tc.parts {
+				sans := sans
+				c.Parts = append(c.Parts, &Configuration{
+					Version:   minimumConfigFileVersionRequired.String(),
+					ExtraSANs: &sans,
+				})
+			}
+
+			g := NewWithT(t)
+			result, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{})
+			g.Expect(err).To(BeNil())
+			last := result.Actions[len(result.Actions)-1]
+			g.Expect(last.Kind).To(Equal(ActionWriteCSRConfig))
+			g.Expect(last.Arguments).To(Equal(tc.expectSANs))
+
+			t.Run("Merge", func(t *testing.T) {
+				g := NewWithT(t)
+				merged, err := c.Merge()
+				g.Expect(err).To(BeNil())
+				g.Expect(*merged.ExtraSANs).To(Equal(tc.expectSANs))
+			})
+		})
+	}
+}
+
+func TestUpdateServiceArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		setConfig         func(*Configuration)
+		initialArgs       map[string]string
+		expectServiceArgs map[string]string
+	}{
+		{
+			name: "kubelet-null-empty-value",
+			setConfig: func(c *Configuration) {
+				c.ExtraKubeletArgs = map[string]*string{
+					"--max-pods":           &[]string{"200"}[0],
+					"--resolv-conf":        &[]string{""}[0],
+					"--cluster-domain":     nil,
+					"--node-labels":        &[]string{""}[0],
+					"--not-there-to-start": nil,
+				}
+			},
+			initialArgs: map[string]string{
+				"kubelet": "--max-pods=110\n--resolv-conf=/etc/resolv.conf\n--cluster-domain=cluster.local\n",
+			},
+			expectServiceArgs: map[string]string{
+				"kubelet": "--max-pods=200\n--resolv-conf=\n--node-labels=\n",
+			},
+		},
+		{
+			name: "kube-apiserver-null-empty-value",
+			setConfig: func(c *Configuration) {
+				c.ExtraKubeAPIServerArgs = map[string]*string{
+					"--event-ttl":             &[]string{"1h"}[0],
+					"--audit-log-path":        &[]string{""}[0],
+					"--authorization-webhook": nil,
+				}
+			},
+			initialArgs: map[string]string{
+				"kube-apiserver": "--audit-log-path=/var/log/audit.log\n--authorization-webhook=/etc/webhook.yaml\n",
+			},
+			expectServiceArgs: map[string]string{
+				"kube-apiserver": "--audit-log-path=\n--event-ttl=1h\n",
+			},
+		},
+		{
+			name: "kube-controller-manager",
+			setConfig: func(c *Configuration) {
+				c.ExtraKubeControllerManagerArgs = map[string]*string{
+					"--leader-elect-lease-duration": &[]string{"30s"}[0],
+					"--node-monitor-grace-period":   &[]string{"20s"}[0],
+					"--terminated-pod-gc-threshold": nil,
+				}
+			},
+			initialArgs: map[string]string{
+				"kube-controller-manager": "--node-monitor-grace-period=40s\n--terminated-pod-gc-threshold=10\n",
+			},
+			expectServiceArgs: map[string]string{
+				"kube-controller-manager": "--node-monitor-grace-period=20s\n--leader-elect-lease-duration=30s\n",
+			},
+		},
+		{
+			name: "kube-scheduler",
+			setConfig: func(c *Configuration) {
+				c.ExtraKubeSchedulerArgs = map[string]*string{
+					"--leader-elect-lease-duration": &[]string{"30s"}[0],
+					"--leader-elect-renew-deadline": nil,
+				}
+			},
+			initialArgs: map[string]string{
+				"kube-scheduler": "--leader-elect-lease-duration=15s\n--leader-elect-renew-deadline=10s\n",
+			},
+			expectServiceArgs: map[string]string{
+				"kube-scheduler": "--leader-elect-lease-duration=30s\n",
+			},
+		},
+		{
+			name: "kube-proxy",
+			setConfig: func(c *Configuration) {
+				c.ExtraKubeProxyArgs = map[string]*string{
+					"--proxy-mode":   &[]string{"ipvs"}[0],
+					"--cluster-cidr": nil,
+				}
+			},
+			initialArgs: map[string]string{
+				"kube-proxy": "--proxy-mode=iptables\n--cluster-cidr=10.1.0.0/16\n--kubeconfig=${SNAP_DATA}/credentials/proxy.config\n",
+			},
+			expectServiceArgs: map[string]string{
+				"kube-proxy": "--proxy-mode=ipvs\n--kubeconfig=${SNAP_DATA}/credentials/proxy.config\n",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &mock.Snap{ServiceArguments: map[string]string{}}
+			for svc, args := range tc.initialArgs {
+				s.ServiceArguments[svc] = args
+			}
+
+			l := NewLauncher(s, false)
+			c := MultiPartConfiguration{Parts: []*Configuration{{
+				Version: minimumConfigFileVersionRequired.String(),
+			}}}
+			tc.setConfig(c.Parts[0])
+
+			g := NewWithT(t)
+			err := l.Apply(context.Background(), c)
+			g.Expect(err).To(BeNil())
+
+			for svc, args := range tc.expectServiceArgs {
+				g.Expect(s.ServiceArguments[svc]).To(Equal(managedArgs(args)))
+			}
+		})
+	}
+}
+
+func TestResetArgs(t *testing.T) {
+	s := &mock.Snap{
+		ServiceArguments: map[string]string{
+			"kubelet":       "--kubeconfig=${SNAP_DATA}/credentials/kubelet.config\n--max-pods=200\n--node-labels=zone=a\n",
+			"kube-proxy":    "--cluster-cidr=10.1.0.0/16\n",
+			appliedArgsFile: "kube-proxy --cluster-cidr\nkubelet --max-pods\nkubelet --node-labels\n",
+		},
+	}
+	l := NewLauncher(s, false)
+	c := MultiPartConfiguration{Parts: []*Configuration{{
+		Version: "0.2.0",
+		ExtraKubeletArgs: map[string]*string{
+			ResetArgsKey:    nil,
+			"--cluster-dns": &[]string{"10.152.183.10"}[0],
+		},
+	}}}
+
+	g := NewWithT(t)
+	g.Expect(l.Apply(context.Background(), c)).To(Succeed())
+	g.Expect(s.ServiceArguments["kubelet"]).To(Equal(managedArgs("--kubeconfig=${SNAP_DATA}/credentials/kubelet.config\n--cluster-dns=10.152.183.10\n")))
+	g.Expect(s.ServiceArguments["kube-proxy"]).To(Equal("--cluster-cidr=10.1.0.0/16\n"))
+	g.Expect(s.ServiceArguments[appliedArgsFile]).To(Equal("kube-proxy --cluster-cidr\nkubelet --cluster-dns\n"))
+	g.Expect(s.RestartServiceCalledWith).To(ConsistOf("kubelite"))
+
+	t.Run("ResetOnly", func(t *testing.T) {
+		c := MultiPartConfiguration{Parts: []*Configuration{{
+			Version:          "0.2.0",
+			ExtraKubeletArgs: map[string]*string{ResetArgsKey: nil},
+		}}}
+
+		g := NewWithT(t)
+		g.Expect(l.Apply(context.Background(), c)).To(Succeed())
+		g.Expect(s.ServiceArguments["kubelet"]).To(Equal(managedArgs("--kubeconfig=${SNAP_DATA}/credentials/kubelet.config\n")))
+		g.Expect(s.ServiceArguments[appliedArgsFile]).To(Equal("kube-proxy --cluster-cidr\n"))
+	})
+}
+
+func TestManualArgs(t *testing.T) {
+	s := &mock.Snap{
+		ServiceArguments: map[string]string{
+			"kubelet": "--max-pods=110\n# BEGIN MANUAL ARGUMENTS\n--v=4\n# END MANUAL ARGUMENTS\n",
+		},
+	}
+	l := NewLauncher(s, false)
+	for _, maxPods := range []string{"200", "250"} {
+		g := NewWithT(t)
+		g.Expect(l.Apply(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+			Version:          "0.2.0",
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &maxPods},
+		}}})).To(Succeed())
+		g.Expect(s.ServiceArguments["kubelet"]).To(Equal(managedArgs("--max-pods=" + maxPods + "\n# BEGIN MANUAL ARGUMENTS\n--v=4\n# END MANUAL ARGUMENTS\n")))
+	}
+}
+
+func TestEquivalentArgs(t *testing.T) {
+	s := &mock.Snap{ServiceArguments: map[string]string{
+		"kubelet": "--max-pods=\"110\"\n--feature-gates=B=false,A=true\n",
+	}}
+	l := NewLauncher(s, false)
+
+	g := NewWithT(t)
+	result, err := l.ApplyWithOptions(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+		Version:          "0.2.0",
+		ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"110"}[0], "--feature-gates": &[]string{"A=true,B=false"}[0]},
+	}}}, ApplyOptions{})
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Actions).To(BeEmpty())
+	g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+
+	t.Run("Changed", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(l.Apply(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+			Version:          "0.2.0",
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"250"}[0]},
+		}}})).To(Succeed())
+		g.Expect(s.ServiceArguments["kubelet"]).To(Equal(managedArgs("--max-pods=250\n--feature-gates=A=true,B=false\n")))
+		g.Expect(s.RestartServiceCalledWith).To(ConsistOf("kubelite"))
+	})
+}
+
+func TestListArgs(t *testing.T) {
+	s := &mock.Snap{
+		ServiceArguments: map[string]string{
+			"kube-apiserver": "--secure-port=16443\n",
+		},
+	}
+	l := NewLauncher(s, false)
+	c, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+extraKubeAPIServerArgs:
+  - --feature-gates=A=true
+  - --feature-gates=B=true
+  - --runtime-config=api/all=true
+`))
+	g := NewWithT(t)
+	g.Expect(err).To(BeNil())
+	g.Expect(l.Apply(context.Background(), c)).To(Succeed())
+	g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(managedArgs("--secure-port=16443\n--feature-gates=A=true\n--feature-gates=B=true\n--runtime-config=api/all=true\n")))
+	g.Expect(s.RestartServiceCalledWith).To(ConsistOf("kubelite"))
+
+	t.Run("Reapply", func(t *testing.T) {
+		s.RestartServiceCalledWith = nil
+
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{Force: true})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(managedArgs("--secure-port=16443\n--feature-gates=A=true\n--feature-gates=B=true\n--runtime-config=api/all=true\n")))
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+
+	t.Run("MapFormReplaces", func(t *testing.T) {
+		c := MultiPartConfiguration{Parts: []*Configuration{{
+			Version: "0.2.0",
+			ExtraKubeAPIServerArgs: map[string]*string{
+				"--feature-gates":               &[]string{"C=true"}[0],
+				"--feature-gates=D=true":        &[]string{""}[0],
+				"--runtime-config=api/all=true": nil,
+			},
+		}}}
+
+		g := NewWithT(t)
+		g.Expect(l.Apply(context.Background(), c)).To(Succeed())
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(managedArgs("--secure-port=16443\n--feature-gates=C=true\n--feature-gates=D=true\n")))
+	})
+}
+
+func TestNodeLabelsAndTaints(t *testing.T) {
+	s := &mock.Snap{
+		ServiceArguments: map[string]string{
+			"kubelet": "--max-pods=110\n",
+		},
+	}
+	l := NewLauncher(s, false)
+	c, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+nodeLabels:
+  node-role.kubernetes.io/worker: ""
+  example.com/zone: zone-a
+nodeTaints:
+  - dedicated=gpu:NoSchedule
+  - maintenance:NoExecute
+extraKubeletArgs:
+  --max-pods: "250"
+`))
+	g := NewWithT(t)
+	g.Expect(err).To(BeNil())
+	g.Expect(l.Apply(context.Background(), c)).To(Succeed())
+	g.Expect(strings.Split(strings.TrimSpace(s.ServiceArguments["kubelet"]), "\n")).To(ConsistOf(
+		snaputil.ManagedArgsHeader,
+		"--max-pods=250",
+		"--node-labels=example.com/zone=zone-a,node-role.kubernetes.io/worker=",
+		"--register-with-taints=dedicated=gpu:NoSchedule,maintenance:NoExecute",
+	))
+	g.Expect(s.RestartServiceCalledWith).To(ConsistOf("kubelite"))
+
+	t.Run("Conflict", func(t *testing.T) {
+		_, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+nodeLabels:
+  example.com/zone: zone-a
+nodeTaints:
+  - maintenance:NoExecute
+extraKubeletArgs:
+  --node-labels: example.com/zone=zone-b
+  --register-with-taints: dedicated=gpu:NoSchedule
+`))
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring(`nodeLabels cannot be used together with extraKubeletArgs[--node-labels], since both set the kubelet "--node-labels" argument`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`nodeTaints cannot be used together with extraKubeletArgs[--register-with-taints], since both set the kubelet "--register-with-taints" argument`)))
+	})
+}
+
+func TestRestartServicesOnlyOnChange(t *testing.T) {
+	s := &mock.Snap{}
+
+	l := NewLauncher(s, false)
+	c := MultiPartConfiguration{Parts: []*Configuration{{
+		Version: minimumConfigFileVersionRequired.String(),
+		ExtraKubeSchedulerArgs: map[string]*string{
+			"--leader-elect-lease-duration": &[]string{"30s"}[0],
+		},
+	}}}
+
+	g := NewWithT(t)
+	err := l.Apply(context.Background(), c)
+	g.Expect(err).To(BeNil())
+	g.Expect(s.RestartServiceCalledWith).To(ConsistOf("kubelite"))
+
+	s.RestartServiceCalledWith = nil
+	err = l.Apply(context.Background(), c)
+	g.Expect(err).To(BeNil())
+	g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+}
+
+func TestRestartPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy               RestartPolicy
+		expectRestartService []string
+	}{
+		{policy: "", expectRestartService: []string{"kubelite"}},
+		{policy: RestartPolicyOnChange, expectRestartService: []string{"kubelite"}},
+		{policy: RestartPolicyAlways, expectRestartService: []string{"containerd", "kubelite"}},
+		{policy: RestartPolicyNever},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			s := &mock.Snap{ServiceArguments: map[string]string{"containerd": "--state=/run/containerd\n"}}
+
+			l := NewLauncher(s, false)
+			err := l.Apply(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+				Version:             "0.2.0",
+				RestartServices:     tc.policy,
+				ExtraKubeletArgs:    map[string]*string{"--max-pods": &[]string{"200"}[0]},
+				ExtraContainerdArgs: map[string]*string{"--state": &[]string{"/run/containerd"}[0]},
+			}}})
+
+			g := NewWithT(t)
+			g.Expect(err).To(BeNil())
+			g.Expect(s.ServiceArguments["kubelet"]).To(Equal(managedArgs("--max-pods=200\n")))
+			if tc.expectRestartService == nil {
+				g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+			} else {
+				g.Expect(s.RestartServiceCalledWith).To(Equal(tc.expectRestartService))
+			}
+		})
+	}
+}
+
+func TestContainerdConfigToml(t *testing.T) {
+	s := &mock.Snap{}
+
+	l := NewLauncher(s, false)
+	c := MultiPartConfiguration{Parts: []*Configuration{{
+		Version:    minimumConfigFileVersionRequired.String(),
+		Containerd: ContainerdConfiguration{ConfigToml: "version = 2\n"},
+	}}}
+
+	g := NewWithT(t)
+	err := l.Apply(context.Background(), c)
+	g.Expect(err).To(BeNil())
+	g.Expect(s.ServiceArguments["containerd-template.toml"]).To(Equal("version = 2\n"))
+	g.Expect(s.RestartServiceCalledWith).To(ConsistOf("containerd"))
+
+	t.Run("Unchanged", func(t *testing.T) {
+		s.RestartServiceCalledWith = nil
+
+		g := NewWithT(t)
+		err := l.Apply(context.Background(), c)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+}
+
+func TestDatastoreArgs(t *testing.T) {
+	s := &mock.Snap{ServiceArguments: map[string]string{
+		"k8s-dqlite": "--storage-dir=${SNAP_DATA}/var/kubernetes/backend/\n--disk-mode=true\n",
+	}}
+
+	l := NewLauncher(s, false)
+	c := MultiPartConfiguration{Parts: []*Configuration{{
+		Version: "0.2.0",
+		Datastore: DatastoreConfiguration{ExtraArgs: map[string]*string{
+			"--datastore-max-idle-connections": &[]string{"5"}[0],
+			"--disk-mode":                      nil,
+		}},
+	}}}
+
+	g := NewWithT(t)
+	err := l.Apply(context.Background(), c)
+	g.Expect(err).To(BeNil())
+	g.Expect(s.ServiceArguments["k8s-dqlite"]).To(Equal(managedArgs("--storage-dir=${SNAP_DATA}/var/kubernetes/backend/\n--datastore-max-idle-connections=5\n")))
+	g.Expect(s.RestartServiceCalledWith).To(ConsistOf("k8s-dqlite"))
+
+	t.Run("Unchanged", func(t *testing.T) {
+		s.RestartServiceCalledWith = nil
+
+		g := NewWithT(t)
+		err := l.Apply(context.Background(), c)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+}
+
+func TestCNI(t *testing.T) {
+	const calicoManifest = `kind: ConfigMap
+data:
+  veth_mtu: "1440"
+---
+kind: DaemonSet
+spec:
+  containers:
+    - env:
+        - name: CALICO_IPV4POOL_CIDR
+          value: "10.1.0.0/16"
+`
+
+	t.Run("Inline", func(t *testing.T) {
+		s := &mock.Snap{CNIYaml: calicoManifest}
+
+		l := NewLauncher(s, false)
+		c := MultiPartConfiguration{Parts: []*Configuration{{
+			Version: "0.2.0",
+			CNI:     CNIConfiguration{Config: "kind: DaemonSet\n"},
+		}}}
+
+		g := NewWithT(t)
+		err := l.Apply(context.Background(), c)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.CNIYaml).To(Equal("kind: DaemonSet\n"))
+		g.Expect(s.ApplyCNICalled).To(HaveLen(1))
+
+		t.Run("Unchanged", func(t *testing.T) {
+			g := NewWithT(t)
+			err := l.Apply(context.Background(), c)
+			g.Expect(err).To(BeNil())
+			g.Expect(s.ApplyCNICalled).To(HaveLen(1))
+		})
+	})
+
+	t.Run("Calico", func(t *testing.T) {
+		s := &mock.Snap{CNIYaml: calicoManifest}
+
+		l := NewLauncher(s, false)
+		err := l.Apply(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+			Version: "0.2.0",
+			CNI:     CNIConfiguration{Calico: &CalicoConfiguration{PodCIDR: "10.100.0.0/16", MTU: 1400}},
+		}}})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.CNIYaml).To(ContainSubstring(`veth_mtu: "1400"`))
+		g.Expect(s.CNIYaml).To(ContainSubstring("- name: CALICO_IPV4POOL_CIDR\n          value: \"10.100.0.0/16\"\n"))
+		g.Expect(s.ApplyCNICalled).To(HaveLen(1))
+	})
+
+	t.Run("CalicoPreInit", func(t *testing.T) {
+		s := &mock.Snap{CNIYaml: calicoManifest}
+
+		l := NewLauncher(s, true)
+		err := l.Apply(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+			Version: "0.2.0",
+			CNI:     CNIConfiguration{Calico: &CalicoConfiguration{MTU: 1400}},
+		}}})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.CNIYaml).To(ContainSubstring(`veth_mtu: "1400"`))
+		g.Expect(s.ApplyCNICalled).To(BeEmpty())
+	})
+
+	t.Run("Flannel", func(t *testing.T) {
+		s := &mock.Snap{}
+
+		l := NewLauncher(s, false)
+		err := l.Apply(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+			Version: "0.2.0",
+			CNI:     CNIConfiguration{Flannel: &FlannelConfiguration{PodCIDR: "10.100.0.0/16"}},
+		}}})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.ServiceArguments["flannel-network-mgr-config"]).To(Equal(`{"Network": "10.100.0.0/16", "Backend": {"Type": "vxlan"}}` + "\n"))
+		g.Expect(s.RestartServiceCalledWith).To(ConsistOf("flanneld"))
+		g.Expect(s.ApplyCNICalled).To(BeEmpty())
+	})
+}
+
+func TestContainerRuntime(t *testing.T) {
+	for _, tc := range []struct {
+		name                 string
+		kubeletArgs          string
+		expectKubeletArgs    string
+		expectRestartService []string
+	}{
+		{
+			name:        "default",
+			kubeletArgs: "--kubeconfig=${SNAP_DATA}/credentials/kubelet.config\n",
+		},
+		{
+			name:        "already-matching",
+			kubeletArgs: "--container-runtime-endpoint=${SNAP_COMMON}/run/containerd.sock\n",
+		},
+		{
+			name:                 "switch",
+			kubeletArgs:          "--container-runtime-endpoint=/run/other.sock\n",
+			expectKubeletArgs:    "--container-runtime-endpoint=${SNAP_COMMON}/run/containerd.sock\n",
+			expectRestartService: []string{"containerd", "kubelite"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &mock.Snap{ServiceArguments: map[string]string{"kubelet": tc.kubeletArgs}}
+
+			l := NewLauncher(s, false)
+			err := l.Apply(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+				Version:          "0.2.0",
+				ContainerRuntime: "containerd",
+			}}})
+
+			g := NewWithT(t)
+			g.Expect(err).To(BeNil())
+			if tc.expectKubeletArgs == "" {
+				g.Expect(s.ServiceArguments["kubelet"]).To(Equal(tc.kubeletArgs))
+				g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+			} else {
+				g.Expect(s.ServiceArguments["kubelet"]).To(Equal(managedArgs(tc.expectKubeletArgs)))
+				g.Expect(s.RestartServiceCalledWith).To(ConsistOf(tc.expectRestartService))
+			}
+		})
+	}
+}
+
+func TestExtraSANsRemoval(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		parts         [][]string
+		expectSANs    []string
+		expectNotSANs []string
+	}{
+		{
+			name:          "add-then-remove",
+			parts:         [][]string{{"10.0.0.5", "microk8s.example.com"}, {"-10.0.0.5"}},
+			expectSANs:    []string{"microk8s.example.com"},
+			expectNotSANs: []string{"10.0.0.5"},
+		},
+		{
+			name:       "remove-missing",
+			parts:      [][]string{{"10.0.0.5"}, {"-10.0.0.6"}},
+			expectSANs: []string{"10.0.0.5"},
+		},
+		{
+			name:          "remove-then-add",
+			parts:         [][]string{{"10.0.0.5"}, {"-10.0.0.5", "10.0.0.6"}},
+			expectSANs:    []string{"10.0.0.6"},
+			expectNotSANs: []string{"10.0.0.5"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &mock.Snap{}
+
+			l := NewLauncher(s, false)
+			c := MultiPartConfiguration{}
+			for _, sans := range tc.parts {
+				sans := sans
+				c.Parts = append(c.Parts, &Configuration{
+					Version:   minimumConfigFileVersionRequired.String(),
+					ExtraSANs: &sans,
+				})
+			}
+
+			g := NewWithT(t)
+			err := l.Apply(context.Background(), c)
+			g.Expect(err).To(BeNil())
+			for _, san := range tc.expectSANs {
+				g.Expect(s.CSRConfig).To(ContainSubstring(san))
+			}
+			for _, san := range tc.expectNotSANs {
+				g.Expect(s.CSRConfig).NotTo(ContainSubstring(san))
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	s := &mock.Snap{
+		ServiceArguments: map[string]string{
+			"kubelet": "--max-pods=110\n",
+		},
+	}
+
+	l := NewLauncher(s, false)
+	c := MultiPartConfiguration{Parts: []*Configuration{
+		{
+			Version:   minimumConfigFileVersionRequired.String(),
+			ExtraSANs: &[]string{"10.0.0.1"},
+			ExtraKubeletArgs: map[string]*string{
+				"--max-pods": &[]string{"250"}[0],
+			},
+			ExtraKubeAPIServerArgs: map[string]*string{
+				"--event-ttl": nil,
+			},
+			Addons: []AddonConfiguration{
+				{Name: "dns"},
+				{Name: "registry", Disable: true},
+			},
+			AddonRepositories: []AddonRepositoryConfiguration{
+				{Name: "core", URL: "https://github.com/canonical/microk8s-core-addons"},
+			},
+			ContainerdRegistryConfigs: map[string]string{
+				"docker.io": `server = "http://dockerhub.mirror:32000"`,
+			},
+			ExtraConfigFiles: map[string]string{
+				"flannel-network-mgr-config": `{"Network": "10.1.0.0/16"}`,
+			},
+			PersistentClusterToken: "my-token",
+			Join:                   JoinConfiguration{URL: "10.10.10.10:25000/token/hash"},
+		},
+		{
+			Version: minimumConfigFileVersionRequired.String(),
+			ExtraKubeletArgs: map[string]*string{
+				"--max-pods": &[]string{"250"}[0],
+			},
+		},
+	}}
+
+	g := NewWithT(t)
+	result, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{DryRun: true})
+	g.Expect(err).To(BeNil())
+	g.Expect(withoutDurations(result.Actions)).To(Equal([]Action{
+		{Kind: ActionAddAddonRepository, Target: "core", Arguments: []string{"https://github.com/canonical/microk8s-core-addons", ""}},
+		{Kind: ActionDisableAddon, Target: "registry"},
+		{Kind: ActionEnableAddon, Target: "dns"},
+		{Kind: ActionAddPersistentClusterToken},
+		{Kind: ActionWriteConfigFile, Target: "flannel-network-mgr-config"},
+		{Kind: ActionWriteServiceArguments, Target: "kubelet"},
+		{Kind: ActionWriteCSRConfig, Arguments: []string{"10.0.0.1"}},
+		{Kind: ActionWriteContainerdRegistryConfig, Target: "docker.io"},
+		{Kind: ActionJoinCluster, Target: "10.10.10.10:25000/token/hash"},
+		{Kind: ActionRestartService, Target: "kubelite"},
+	}))
+
+	// no side effects
+	g.Expect(s.ServiceArguments).To(Equal(map[string]string{"kubelet": "--max-pods=110\n"}))
+	g.Expect(s.WriteServiceArgumentsCalled).To(BeFalse())
+	g.Expect(s.EnableAddonCalledWith).To(BeEmpty())
+	g.Expect(s.DisableAddonCalledWith).To(BeEmpty())
+	g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	g.Expect(s.AddPersistentClusterTokenCalledWith).To(BeEmpty())
+	g.Expect(s.AddonRepositories).To(BeEmpty())
+	g.Expect(s.ContainerdRegistryConfigs).To(BeEmpty())
+	g.Expect(s.CSRConfig).To(BeEmpty())
+	g.Expect(s.JoinClusterCalledWith).To(BeEmpty())
+}
+
+func TestAddonsOrder(t *testing.T) {
+	addons := []AddonConfiguration{
+		{Name: "ingress", Priority: 10},
+		{Name: "registry", Disable: true},
+		{Name: "dns", Priority: -10},
+		{Name: "metallb"},
+		{Name: "hostpath-storage", Disable: true},
+	}
+
+	for _, tc := range []struct {
+		name          string
+		opts          ApplyOptions
+		expectActions []Action
+	}{
+		{
+			name: "default",
+			expectActions: []Action{
+				{Kind: ActionDisableAddon, Target: "registry"},
+				{Kind: ActionDisableAddon, Target: "hostpath-storage"},
+				{Kind: ActionEnableAddon, Target: "dns"},
+				{Kind: ActionEnableAddon, Target: "metallb"},
+				{Kind: ActionEnableAddon, Target: "ingress"},
+			},
+		},
+		{
+			name: "preserve-order",
+			opts: ApplyOptions{PreserveAddonOrder: true},
+			expectActions: []Action{
+				{Kind: ActionEnableAddon, Target: "ingress"},
+				{Kind: ActionDisableAddon, Target: "registry"},
+				{Kind: ActionEnableAddon, Target: "dns"},
+				{Kind: ActionEnableAddon, Target: "metallb"},
+				{Kind: ActionDisableAddon, Target: "hostpath-storage"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &mock.Snap{}
+
+			l := NewLauncher(s, false)
+			c := MultiPartConfiguration{Parts: []*Configuration{
+				{Version: minimumConfigFileVersionRequired.String(), Addons: addons},
+			}}
+
+			g := NewWithT(t)
+			result, err := l.ApplyWithOptions(context.Background(), c, tc.opts)
+			g.Expect(err).To(BeNil())
+			g.Expect(withoutDurations(result.Actions)).To(Equal(tc.expectActions))
+		})
+	}
+}
+
+func TestAddonDependencies(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		addons        []AddonConfiguration
+		deps          map[string][]string
+		expectActions []Action
+		expectErr     string
+	}{
+		{
+			name:   "default",
+			addons: []AddonConfiguration{{Name: "ingress"}, {Name: "registry"}, {Name: "dns"}, {Name: "hostpath-storage"}},
+			expectActions: []Action{
+				{Kind: ActionEnableAddon, Target: "dns"},
+				{Kind: ActionEnableAddon, Target: "ingress"},
+				{Kind: ActionEnableAddon, Target: "hostpath-storage"},
+				{Kind: ActionEnableAddon, Target: "registry"},
+			},
+		},
+		{
+			name:   "before-priority",
+			addons: []AddonConfiguration{{Name: "ingress", Priority: -10}, {Name: "metallb"}, {Name: "core/dns", Priority: 10}},
+			expectActions: []Action{
+				{Kind: ActionEnableAddon, Target: "metallb"},
+				{Kind: ActionEnableAddon, Target: "core/dns"},
+				{Kind: ActionEnableAddon, Target: "ingress"},
+			},
+		},
+		{
+			name:   "missing-dependency",
+			addons: []AddonConfiguration{{Name: "ingress"}, {Name: "dns", Disable: true}},
+			expectActions: []Action{
+				{Kind: ActionDisableAddon, Target: "dns"},
+				{Kind: ActionEnableAddon, Target: "ingress"},
+			},
+		},
+		{
+			name:   "custom",
+			addons: []AddonConfiguration{{Name: "ingress"}, {Name: "dns"}, {Name: "metallb"}},
+			deps:   map[string][]string{"metallb": {"ingress"}, "ingress": {}},
+			expectActions: []Action{
+				{Kind: ActionEnableAddon, Target: "ingress"},
+				{Kind: ActionEnableAddon, Target: "dns"},
+				{Kind: ActionEnableAddon, Target: "metallb"},
+			},
+		},
+		{
+			name:      "cycle",
+			addons:    []AddonConfiguration{{Name: "registry", Disable: true}, {Name: "metallb"}, {Name: "ingress"}, {Name: "dns"}},
+			deps:      map[string][]string{"ingress": {"dns"}, "dns": {"metallb"}, "metallb": {"ingress"}},
+			expectErr: "addons have circular dependencies: metallb -> ingress -> dns -> metallb",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			s := &mock.Snap{}
+			c := MultiPartConfiguration{Parts: []*Configuration{{Version: "0.2.0", Addons: tc.addons}}}
+			result, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), c, ApplyOptions{AddonDependencies: tc.deps})
+			if tc.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectErr)))
+				g.Expect(result.Actions).To(BeEmpty())
+				return
+			}
+			g.Expect(err).To(BeNil())
+			g.Expect(withoutDurations(result.Actions)).To(Equal(tc.expectActions))
+		})
+	}
+
+	t.Run("Groups", func(t *testing.T) {
+		g := NewWithT(t)
+		addons := []AddonConfiguration{{Name: "ingress"}, {Name: "dns"}, {Name: "metallb"}, {Name: "cert-manager"}}
+		sorted, err := sortAddonDependencies(sortAddons(addons), DefaultAddonDependencies)
+		g.Expect(err).To(BeNil())
+		g.Expect(groupAddons(sorted, DefaultAddonDependencies)).To(Equal([][]AddonConfiguration{
+			{{Name: "dns"}},
+			{{Name: "ingress"}, {Name: "metallb"}, {Name: "cert-manager"}},
+		}))
+	})
+}
+
+// blockingAddonSnap is a mock snap where enabling the "blocking" addon hangs until the context is cancelled.
+type blockingAddonSnap struct {
+	*mock.Snap
+}
+
+func (s *blockingAddonSnap) EnableAddon(ctx context.Context, addon string, args ...string) error {
+	if addon == "blocking" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.Snap.EnableAddon(ctx, addon, args...)
+}
+
+func TestAddonArgumentTemplates(t *testing.T) {
+	nodeInfo := func() (NodeInfo, error) {
+		return NodeInfo{NodeName: "node-1", NodeIP: "10.0.0.11"}, nil
+	}
+
+	t.Run("Resolve", func(t *testing.T) {
+		s := &mock.Snap{}
+		l := NewLauncher(s, false)
+		c := MultiPartConfiguration{Parts: []*Configuration{{
+			Version: minimumConfigFileVersionRequired.String(),
+			Addons: []AddonConfiguration{
+				{Name: "dns"},
+				{Name: "ingress", Arguments: []string{"--default-ssl-certificate={{.NodeName}}/tls", "--external-ip={{.NodeIP}}"}},
+			},
+		}}}
+
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{NodeInfo: nodeInfo})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{
+			"dns",
+			"ingress --default-ssl-certificate=node-1/tls --external-ip=10.0.0.11",
+		}))
+		g.Expect(c.Parts[0].Addons[1].Arguments).To(Equal([]string{"--default-ssl-certificate={{.NodeName}}/tls", "--external-ip={{.NodeIP}}"}))
+	})
+
+	t.Run("UnknownVariable", func(t *testing.T) {
+		s := &mock.Snap{}
+		l := NewLauncher(s, false)
+		c := MultiPartConfiguration{Parts: []*Configuration{{
+			Version: minimumConfigFileVersionRequired.String(),
+			Addons:  []AddonConfiguration{{Name: "ingress", Arguments: []string{"--zone={{.NodeZone}}"}}},
+		}}}
+
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{NodeInfo: nodeInfo})
+		g.Expect(err).To(MatchError(ContainSubstring(`failed to resolve arguments of addon "ingress": argument "--zone={{.NodeZone}}": unknown template variable ".NodeZone"`)))
+		g.Expect(s.EnableAddonCalledWith).To(BeEmpty())
+	})
+
+	t.Run("NodeInfoNotNeeded", func(t *testing.T) {
+		s := &mock.Snap{}
+		l := NewLauncher(s, false)
+		c := MultiPartConfiguration{Parts: []*Configuration{{
+			Version: minimumConfigFileVersionRequired.String(),
+			Addons:  []AddonConfiguration{{Name: "dns", Arguments: []string{"1.1.1.1"}}},
+		}}}
+
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{NodeInfo: func() (NodeInfo, error) {
+			return NodeInfo{}, fmt.Errorf("no node info")
+		}})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns 1.1.1.1"}))
+	})
+}
+
+// parallelAddonSnap is a mock snap that records when each addon operation starts and ends, and the maximum number of
+// concurrent operations. Addons in failAddons fail to enable.
+type parallelAddonSnap struct {
+	*mock.Snap
+
+	mu         sync.Mutex
+	failAddons []string
+	events     []string
+	active     int
+	maxActive  int
+}
+
+func (s *parallelAddonSnap) run(op string, addon string, f func() error) error {
+	s.mu.Lock()
+	s.events = append(s.events, "start "+op+" "+addon)
+	s.active++
+	if s.active > s.maxActive {
+		s.maxActive = s.active
+	}
+	s.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	s.events = append(s.events, "end "+op+" "+addon)
+	for _, failAddon := range s.failAddons {
+		if addon == failAddon {
+			return fmt.Errorf("failed to enable %s", addon)
+		}
+	}
+	return f()
+}
+
+func (s *parallelAddonSnap) EnableAddon(ctx context.Context, addon string, args ...string) error {
+	return s.run("enable", addon, func() error { return s.Snap.EnableAddon(ctx, addon, args...) })
+}
+
+func (s *parallelAddonSnap) DisableAddon(ctx context.Context, addon string, args ...string) error {
+	return s.run("disable", addon, func() error { return s.Snap.DisableAddon(ctx, addon, args...) })
+}
+
+// eventIndex returns the index of an event, or -1 if it was not recorded.
+func (s *parallelAddonSnap) eventIndex(event string) int {
+	for idx, e := range s.events {
+		if e == event {
+			return idx
+		}
+	}
+	return -1
+}
+
+func TestAddonsParallel(t *testing.T) {
+	t.Run("Bounded", func(t *testing.T) {
+		s := &parallelAddonSnap{Snap: &mock.Snap{}}
+		l := NewLauncher(s, false)
+		c := MultiPartConfiguration{Parts: []*Configuration{{
+			Version: minimumConfigFileVersionRequired.String(),
+			Addons:  []AddonConfiguration{{Name: "dns"}, {Name: "ingress"}, {Name: "metallb"}, {Name: "metrics-server"}, {Name: "registry"}},
+		}}}
+
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{AddonWorkers: 2})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.maxActive).To(Equal(2))
+		g.Expect(s.EnableAddonCalledWith).To(ConsistOf("dns", "ingress", "metallb", "metrics-server", "registry"))
+	})
+
+	t.Run("Sequential", func(t *testing.T) {
+		s := &parallelAddonSnap{Snap: &mock.Snap{}}
+		l := NewLauncher(s, false)
+		c := MultiPartConfiguration{Parts: []*Configuration{{
+			Version: minimumConfigFileVersionRequired.String(),
+			Addons:  []AddonConfiguration{{Name: "dns"}, {Name: "ingress"}, {Name: "metallb"}},
+		}}}
+
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.maxActive).To(Equal(1))
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns", "ingress", "metallb"}))
+	})
+
+	t.Run("Ordering", func(t *testing.T) {
+		s := &parallelAddonSnap{Snap: &mock.Snap{}}
+		l := NewLauncher(s, false)
+		c := MultiPartConfiguration{Parts: []*Configuration{{
+			Version: "0.2.0",
+			Addons: []AddonConfiguration{
+				{Name: "hostpath-storage", Priority: 10},
+				{Name: "ingress"},
+				{Name: "dns", Priority: -10},
+				{Name: "registry", Disable: true},
+				{Name: "metallb"},
+				{Name: "metrics-server"},
+			},
+		}}}
+
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{AddonWorkers: 4})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.maxActive).To(Equal(3))
+
+		g.Expect(s.eventIndex("end disable registry")).To(BeNumerically("<", s.eventIndex("start enable dns")))
+		for _, addon := range []string{"ingress", "metallb", "metrics-server"} {
+			g.Expect(s.eventIndex("end enable dns")).To(BeNumerically("<", s.eventIndex("start enable "+addon)))
+			g.Expect(s.eventIndex("end enable " + addon)).To(BeNumerically("<", s.eventIndex("start enable hostpath-storage")))
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		s := &parallelAddonSnap{Snap: &mock.Snap{}, failAddons: []string{"ingress", "metallb"}}
+		l := NewLauncher(s, false)
+		c := MultiPartConfiguration{Parts: []*Configuration{{
+			Version: "0.2.0",
+			Addons: []AddonConfiguration{
+				{Name: "ingress"},
+				{Name: "metallb"},
+				{Name: "metrics-server", FailurePolicy: AddonFailurePolicyContinue},
+				{Name: "hostpath-storage", Priority: 10},
+			},
+		}}}
+
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{AddonWorkers: 2})
+		var addonsErr *AddonsError
+		g.Expect(errors.As(err, &addonsErr)).To(BeTrue())
+		g.Expect(addonsErr.Errors).To(HaveLen(2))
+		g.Expect(err).To(MatchError(ContainSubstring(`failed to enable addon "ingress"`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`failed to enable addon "metallb"`)))
+		g.Expect(s.eventIndex("start enable metrics-server")).To(Equal(-1))
+		g.Expect(s.eventIndex("start enable hostpath-storage")).To(Equal(-1))
+	})
+}
+
+func TestAddonTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		policy             AddonFailurePolicy
+		expectEnableAddons []string
+		expectRestart      []string
+	}{
+		{policy: AddonFailurePolicyAbort, expectEnableAddons: []string{"dns"}},
+		{policy: AddonFailurePolicyContinue, expectEnableAddons: []string{"dns", "ingress"}, expectRestart: []string{"kubelite"}},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			s := &blockingAddonSnap{Snap: &mock.Snap{}}
+
+			l := NewLauncher(s, false)
+			c := MultiPartConfiguration{Parts: []*Configuration{
+				{
+					Version: minimumConfigFileVersionRequired.String(),
+					Addons: []AddonConfiguration{
+						{Name: "dns"},
+						{Name: "blocking", Timeout: "10ms", FailurePolicy: tc.policy},
+						{Name: "ingress"},
+					},
+					ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"250"}[0]},
+				},
+			}}
+
+			g := NewWithT(t)
+			err := l.Apply(context.Background(), c)
+			g.Expect(err).To(MatchError(ContainSubstring(`failed to enable addon "blocking"`)))
+			g.Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			g.Expect(s.EnableAddonCalledWith).To(Equal(tc.expectEnableAddons))
+			g.Expect(s.RestartServiceCalledWith).To(Equal(tc.expectRestart))
+
+			var addonsErr *AddonsError
+			g.Expect(errors.As(err, &addonsErr)).To(Equal(tc.policy == AddonFailurePolicyContinue))
+		})
+	}
+}
+
+func TestApplyIdempotent(t *testing.T) {
+	s := &mock.Snap{}
+	l := NewLauncher(s, false)
+
+	c := MultiPartConfiguration{Parts: []*Configuration{{
+		Version:          minimumConfigFileVersionRequired.String(),
+		Addons:           []AddonConfiguration{{Name: "dns"}},
+		ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"200"}[0]},
+		ExtraSANs:        &[]string{"10.0.0.1", "node.example.com"},
+	}}}
+
+	g := NewWithT(t)
+	g.Expect(l.Apply(context.Background(), c)).To(Succeed())
+	g.Expect(s.EnableAddonCalledWith).To(HaveLen(1))
+	g.Expect(s.RestartServiceCalledWith).To(ConsistOf("kubelite"))
+	g.Expect(s.CSRConfig).To(ContainSubstring("node.example.com"))
+
+	t.Run("Unchanged", func(t *testing.T) {
+		s.CSRConfig = ""
+
+		g := NewWithT(t)
+		g.Expect(l.Apply(context.Background(), c)).To(Succeed())
+		g.Expect(s.EnableAddonCalledWith).To(HaveLen(1))
+		g.Expect(s.RestartServiceCalledWith).To(HaveLen(1))
+		g.Expect(s.CSRConfig).To(BeEmpty())
+	})
+
+	t.Run("OrderIndependent", func(t *testing.T) {
+		g := NewWithT(t)
+		reordered := MultiPartConfiguration{Parts: []*Configuration{{
+			Version:          minimumConfigFileVersionRequired.String(),
+			Addons:           []AddonConfiguration{{Name: "dns"}},
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"200"}[0]},
+			ExtraSANs:        &[]string{"node.example.com", "10.0.0.1"},
+		}}}
+		g.Expect(l.Apply(context.Background(), reordered)).To(Succeed())
+		g.Expect(s.EnableAddonCalledWith).To(HaveLen(1))
+	})
+
+	t.Run("Force", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{Force: true})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.EnableAddonCalledWith).To(HaveLen(2))
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		g := NewWithT(t)
+		c.Parts[0].ExtraKubeletArgs["--max-pods"] = &[]string{"250"}[0]
+		g.Expect(l.Apply(context.Background(), c)).To(Succeed())
+		g.Expect(s.EnableAddonCalledWith).To(HaveLen(3))
+		g.Expect(s.RestartServiceCalledWith).To(HaveLen(2))
+	})
+}
+
+// failingWriteSnap is a mock snap where writing the arguments of the specified services fails.
+type failingWriteSnap struct {
+	*mock.Snap
+
+	failServices map[string]struct{}
+}
+
+func (s *failingWriteSnap) WriteServiceArguments(service string, b []byte) error {
+	if _, fail := s.failServices[service]; fail {
+		return fmt.Errorf("failed to write %s", service)
+	}
+	return s.Snap.WriteServiceArguments(service, b)
+}
+
+func TestRollback(t *testing.T) {
+	c := MultiPartConfiguration{Parts: []*Configuration{{
+		Version:                minimumConfigFileVersionRequired.String(),
+		ExtraKubeAPIServerArgs: map[string]*string{"--event-ttl": &[]string{"1h"}[0]},
+		ExtraKubeletArgs:       map[string]*string{"--max-pods": &[]string{"200"}[0]},
+		ExtraKubeSchedulerArgs: map[string]*string{"--leader-elect-lease-duration": &[]string{"30s"}[0]},
+	}}}
+
+	t.Run("Restored", func(t *testing.T) {
+		s := &failingWriteSnap{
+			Snap: &mock.Snap{ServiceArguments: map[string]string{
+				"kube-apiserver": "--event-ttl=5m\n",
+			}},
+			failServices: map[string]struct{}{"kube-scheduler": {}},
+		}
+
+		g := NewWithT(t)
+		err := NewLauncher(s, false).Apply(context.Background(), c)
+		g.Expect(err).To(MatchError(ContainSubstring("failed to write kube-scheduler")))
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal("--event-ttl=5m\n"))
+		g.Expect(s.ServiceArguments["kubelet"]).To(BeEmpty())
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+
+	t.Run("RollbackFailed", func(t *testing.T) {
+		// kubelet arguments do not exist, so restoring them writes an empty file, which fails in this test
+		s := &rollbackFailingSnap{failingWriteSnap: failingWriteSnap{
+			Snap:         &mock.Snap{},
+			failServices: map[string]struct{}{"kube-scheduler": {}},
+		}}
+
+		g := NewWithT(t)
+		err := NewLauncher(s, false).Apply(context.Background(), c)
+
+		var rollbackErr *RollbackError
+		g.Expect(errors.As(err, &rollbackErr)).To(BeTrue())
+		g.Expect(rollbackErr.Err).To(MatchError(ContainSubstring("failed to write kube-scheduler")))
+		g.Expect(rollbackErr.RollbackErr).To(MatchError(ContainSubstring("failed to restore arguments of service kubelet")))
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(BeEmpty())
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		s := &missingFilesSnap{failingWriteSnap: failingWriteSnap{
+			Snap:         &mock.Snap{ServiceArguments: map[string]string{"kube-apiserver": "--event-ttl=5m\n"}},
+			failServices: map[string]struct{}{"kube-scheduler": {}},
+		}}
+
+		g := NewWithT(t)
+		err := NewLauncher(s, false).Apply(context.Background(), c)
+		g.Expect(err).To(MatchError(ContainSubstring("failed to write kube-scheduler")))
+		g.Expect(s.ServiceArguments).To(Equal(map[string]string{"kube-apiserver": "--event-ttl=5m\n"}))
+	})
+}
+
+// missingFilesSnap is a failingWriteSnap where reading arguments files that do not exist fails with os.ErrNotExist,
+// like the real snap does.
+type missingFilesSnap struct {
+	failingWriteSnap
+}
+
+func (s *missingFilesSnap) ReadServiceArguments(service string) (string, error) {
+	if _, ok := s.ServiceArguments[service]; !ok {
+		return "", fmt.Errorf("failed to read %s: %w", service, os.ErrNotExist)
+	}
+	return s.failingWriteSnap.ReadServiceArguments(service)
+}
+
+// rollbackFailingSnap is a failingWriteSnap where writing empty arguments of the kubelet fails.
+type rollbackFailingSnap struct {
+	failingWriteSnap
+}
+
+func (s *rollbackFailingSnap) WriteServiceArguments(service string, b []byte) error {
+	if service == "kubelet" && len(b) == 0 {
+		return fmt.Errorf("failed to write %s", service)
+	}
+	return s.failingWriteSnap.WriteServiceArguments(service, b)
+}
+
+// withoutDurations returns a copy of actions with all durations set to zero, so that they can be compared.
+func withoutDurations(actions []Action) []Action {
+	result := make([]Action, 0, len(actions))
+	for _, action := range actions {
+		action.Duration = 0
+		result = append(result, action)
+	}
+	return result
+}
+
+// failingAddonSnap is a mock snap where enabling the "failing" addon fails.
+type failingAddonSnap struct {
+	*mock.Snap
+}
+
+func (s *failingAddonSnap) EnableAddon(ctx context.Context, addon string, args ...string) error {
+	if addon == "failing" {
+		return fmt.Errorf("addon is broken")
+	}
+	return s.Snap.EnableAddon(ctx, addon, args...)
+}
+
+func TestApplyResult(t *testing.T) {
+	s := &failingAddonSnap{Snap: &mock.Snap{}}
+
+	l := NewLauncher(s, false)
+	result, err := l.ApplyWithOptions(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+		Version: "0.2.0",
+		Addons: []AddonConfiguration{
+			{Name: "dns"},
+			{Name: "failing", FailurePolicy: AddonFailurePolicyContinue},
+			{Name: "ingress"},
+		},
+		ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"200"}[0]},
+	}}}, ApplyOptions{})
+
+	g := NewWithT(t)
+	var addonsErr *AddonsError
+	g.Expect(errors.As(err, &addonsErr)).To(BeTrue())
+	g.Expect(result).NotTo(BeNil())
+	g.Expect(withoutDurations(result.Actions)).To(Equal([]Action{
+		{Kind: ActionEnableAddon, Target: "dns"},
+		{Kind: ActionEnableAddon, Target: "failing", Error: "addon is broken"},
+		{Kind: ActionEnableAddon, Target: "ingress"},
+		{Kind: ActionWriteServiceArguments, Target: "kubelet"},
+		{Kind: ActionRestartService, Target: "kubelite"},
+	}))
+	g.Expect(result.Actions[1].Failed()).To(BeTrue())
+	g.Expect(result.Actions[1].String()).To(Equal("enable-addon failing (failed: addon is broken)"))
+	for _, action := range result.Actions {
+		g.Expect(action.Duration).To(BeNumerically(">", 0))
+	}
+}
+
+// cancelingAddonSnap is a mock snap that cancels the apply context after enabling the "cancel" addon.
+type cancelingAddonSnap struct {
+	*mock.Snap
+
+	cancel context.CancelFunc
+}
+
+func (s *cancelingAddonSnap) EnableAddon(ctx context.Context, addon string, args ...string) error {
+	if addon == "cancel" {
+		s.cancel()
+	}
+	return s.Snap.EnableAddon(ctx, addon, args...)
+}
+
+func TestApplyCancel(t *testing.T) {
+	t.Run("Cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := &cancelingAddonSnap{Snap: &mock.Snap{}, cancel: cancel}
+
+		l := NewLauncher(s, false)
+		result, err := l.ApplyWithOptions(ctx, MultiPartConfiguration{Parts: []*Configuration{{
+			Version:          "0.2.0",
+			Addons:           []AddonConfiguration{{Name: "dns"}, {Name: "cancel"}, {Name: "ingress", FailurePolicy: AddonFailurePolicyContinue}},
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"200"}[0]},
+		}}}, ApplyOptions{})
+
+		g := NewWithT(t)
+		g.Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		var canceledErr *CanceledError
+		g.Expect(errors.As(err, &canceledErr)).To(BeTrue())
+		g.Expect(withoutDurations(canceledErr.Actions)).To(Equal([]Action{
+			{Kind: ActionEnableAddon, Target: "dns"},
+			{Kind: ActionEnableAddon, Target: "cancel"},
+		}))
+		g.Expect(withoutDurations(result.Actions)).To(Equal(withoutDurations(canceledErr.Actions)))
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns", "cancel"}))
+		g.Expect(s.ServiceArguments).NotTo(HaveKey("kubelet"))
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		s := &blockingAddonSnap{Snap: &mock.Snap{}}
+
+		l := NewLauncher(s, false)
+		result, err := l.ApplyWithOptions(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+			Version: "0.2.0",
+			Addons:  []AddonConfiguration{{Name: "dns"}, {Name: "blocking"}, {Name: "ingress"}},
+		}}}, ApplyOptions{Timeout: 50 * time.Millisecond})
+
+		g := NewWithT(t)
+		g.Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		g.Expect(err).To(MatchError(HavePrefix("applying configuration was stopped after 2 action(s): ")))
+		g.Expect(withoutDurations(result.Actions)).To(Equal([]Action{
+			{Kind: ActionEnableAddon, Target: "dns"},
+			{Kind: ActionEnableAddon, Target: "blocking", Error: context.DeadlineExceeded.Error()},
+		}))
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns"}))
+	})
+
+	t.Run("Parallel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := &cancelingAddonSnap{Snap: &mock.Snap{}, cancel: cancel}
+
+		l := NewLauncher(s, false)
+		_, err := l.ApplyWithOptions(ctx, MultiPartConfiguration{Parts: []*Configuration{{
+			Version: "0.2.0",
+			Addons:  []AddonConfiguration{{Name: "cancel"}, {Name: "dns"}, {Name: "ingress"}, {Name: "metallb", Priority: 1}},
+		}}}, ApplyOptions{AddonWorkers: 2})
+
+		g := NewWithT(t)
+		g.Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		// addons of the same priority may already be running, but no further groups are started
+		g.Expect(s.EnableAddonCalledWith).To(ContainElement("cancel"))
+		g.Expect(s.EnableAddonCalledWith).NotTo(ContainElement("metallb"))
+	})
+}
+
+// argsAccessSnap is a mock snap that records which arguments files are read and written.
+type argsAccessSnap struct {
+	*mock.Snap
+
+	read    []string
+	written []string
+}
+
+func (s *argsAccessSnap) ReadServiceArguments(service string) (string, error) {
+	s.read = append(s.read, service)
+	return s.Snap.ReadServiceArguments(service)
+}
+
+func (s *argsAccessSnap) WriteServiceArguments(service string, b []byte) error {
+	s.written = append(s.written, service)
+	return s.Snap.WriteServiceArguments(service, b)
+}
+
+func TestApplySkipsMissingSections(t *testing.T) {
+	s := &argsAccessSnap{Snap: &mock.Snap{ServiceArguments: map[string]string{
+		"kubelet":        "--max-pods=110\n",
+		"kube-apiserver": "--secure-port=16443\n",
+	}}}
+
+	l := NewLauncher(s, false)
+	result, err := l.ApplyWithOptions(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+		Version:   minimumConfigFileVersionRequired.String(),
+		ExtraSANs: &[]string{"10.0.0.10"},
+	}}}, ApplyOptions{})
+
+	g := NewWithT(t)
+	g.Expect(err).To(BeNil())
+	g.Expect(withoutDurations(result.Actions)).To(Equal([]Action{
+		{Kind: ActionWriteCSRConfig, Arguments: []string{"10.0.0.10"}},
+	}))
+	// only the hash of the last applied configuration is read (also to roll it back) and written
+	g.Expect(s.read).To(HaveEach(configurationHashFile))
+	g.Expect(s.written).To(ConsistOf(configurationHashFile))
+	g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+}
+
+func TestExtraConfigFileNames(t *testing.T) {
+	for _, file := range []string{"../../etc/cron.d/x", "certs.d/hosts.toml", `..\x`, "..", ".", "kube-apiserver", configurationHashFile, appliedArgsFile} {
+		t.Run(file, func(t *testing.T) {
+			s := &mock.Snap{}
+
+			// configurations that are constructed programmatically may not be validated
+			_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+				Version:          minimumConfigFileVersionRequired.String(),
+				ExtraConfigFiles: map[string]string{file: "contents"},
+			}}}, ApplyOptions{})
+
+			g := NewWithT(t)
+			g.Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("file name %q", file))))
+			g.Expect(s.ServiceArguments).ToNot(HaveKey(file))
+		})
+	}
+}
+
+// hookRunner is a mock CommandRunner for hook commands. Commands that contain "fail" exit with an error.
+type hookRunner struct {
+	commands []string
+}
+
+func (r *hookRunner) run(ctx context.Context, stdout io.Writer, stderr io.Writer, command ...string) error {
+	script := command[len(command)-1]
+	r.commands = append(r.commands, script)
+	fmt.Fprintf(stdout, "running %s\n", script)
+	if strings.Contains(script, "fail") {
+		fmt.Fprintln(stderr, "something went wrong")
+		return fmt.Errorf("command %v failed with exit code 1", command)
+	}
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	config := func(hooks HooksConfiguration) MultiPartConfiguration {
+		return MultiPartConfiguration{Parts: []*Configuration{{
+			Version:          "0.2.0",
+			Addons:           []AddonConfiguration{{Name: "dns"}},
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"200"}[0]},
+			Hooks:            hooks,
+		}}}
+	}
+
+	t.Run("PreApplyFailure", func(t *testing.T) {
+		s := &argsAccessSnap{Snap: &mock.Snap{}}
+		runner := &hookRunner{}
+
+		l := NewLauncher(s, false)
+		result, err := l.ApplyWithOptions(context.Background(), config(HooksConfiguration{
+			PreApply:  []string{"snapshot", "fail", "never"},
+			PostApply: []string{"done"},
+		}), ApplyOptions{CommandRunner: runner.run})
+
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(`pre-apply hook "fail" failed: command [/bin/sh -c fail] failed with exit code 1`))
+		g.Expect(runner.commands).To(Equal([]string{"snapshot", "fail"}))
+		g.Expect(result.Hooks).To(Equal([]HookResult{
+			{Phase: HookPhasePreApply, Command: "snapshot", Stdout: "running snapshot\n"},
+			{Phase: HookPhasePreApply, Command: "fail", Stdout: "running fail\n", Stderr: "something went wrong\n"},
+		}))
+		// no changes are made
+		g.Expect(s.written).To(BeEmpty())
+		g.Expect(s.EnableAddonCalledWith).To(BeEmpty())
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		s := &mock.Snap{}
+		runner := &hookRunner{}
+
+		l := NewLauncher(s, false)
+		result, err := l.ApplyWithOptions(context.Background(), config(HooksConfiguration{
+			PreApply:  []string{"snapshot"},
+			PostApply: []string{"done"},
+		}), ApplyOptions{CommandRunner: runner.run})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(runner.commands).To(Equal([]string{"snapshot", "done"}))
+		actions := withoutDurations(result.Actions)
+		g.Expect(actions[0]).To(Equal(Action{Kind: ActionRunHook, Target: "pre-apply", Arguments: []string{"snapshot"}}))
+		g.Expect(actions[len(actions)-1]).To(Equal(Action{Kind: ActionRunHook, Target: "post-apply", Arguments: []string{"done"}}))
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns"}))
+	})
+
+	t.Run("ContinueOnFailure", func(t *testing.T) {
+		s := &mock.Snap{}
+		runner := &hookRunner{}
+
+		l := NewLauncher(s, false)
+		result, err := l.ApplyWithOptions(context.Background(), config(HooksConfiguration{
+			PreApply:      []string{"fail"},
+			FailurePolicy: AddonFailurePolicyContinue,
+		}), ApplyOptions{CommandRunner: runner.run})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(withoutDurations(result.Actions)[0]).To(Equal(Action{Kind: ActionRunHook, Target: "pre-apply", Arguments: []string{"fail"}, Error: "command [/bin/sh -c fail] failed with exit code 1"}))
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns"}))
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		s := &mock.Snap{}
+		runner := &hookRunner{}
+
+		l := NewLauncher(s, false)
+		result, err := l.ApplyWithOptions(context.Background(), config(HooksConfiguration{
+			PreApply: []string{"snapshot"},
+		}), ApplyOptions{CommandRunner: runner.run, DryRun: true})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(runner.commands).To(BeEmpty())
+		g.Expect(result.Hooks).To(BeEmpty())
+		g.Expect(withoutDurations(result.Actions)[0]).To(Equal(Action{Kind: ActionRunHook, Target: "pre-apply", Arguments: []string{"snapshot"}}))
+	})
+}
+
+// gatedAddonSnap is a mock snap where enabling the "gated" addon signals started and hangs until release is closed.
+type gatedAddonSnap struct {
+	*mock.Snap
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *gatedAddonSnap) EnableAddon(ctx context.Context, addon string, args ...string) error {
+	if addon == "gated" {
+		close(s.started)
+		<-s.release
+	}
+	return s.Snap.EnableAddon(ctx, addon, args...)
+}
+
+func TestApplyLock(t *testing.T) {
+	c := MultiPartConfiguration{Parts: []*Configuration{{
+		Version: minimumConfigFileVersionRequired.String(),
+		Addons:  []AddonConfiguration{{Name: "gated"}},
+	}}}
+	second := MultiPartConfiguration{Parts: []*Configuration{{
+		Version: minimumConfigFileVersionRequired.String(),
+		Addons:  []AddonConfiguration{{Name: "dns"}},
+	}}}
+
+	// startFirst starts an apply that holds the lock until release is closed.
+	startFirst := func(locker ApplyLocker) (release chan struct{}, done chan error) {
+		s := &gatedAddonSnap{Snap: &mock.Snap{}, started: make(chan struct{}), release: make(chan struct{})}
+		done = make(chan error, 1)
+		go func() {
+			_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), c, ApplyOptions{Locker: locker})
+			done <- err
+		}()
+		<-s.started
+		return s.release, done
+	}
+
+	t.Run("Serialize", func(t *testing.T) {
+		g := NewWithT(t)
+		locker := NewMutexApplyLocker()
+		release, firstDone := startFirst(locker)
+
+		s := &mock.Snap{}
+		secondDone := make(chan error, 1)
+		go func() {
+			_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), second, ApplyOptions{Locker: locker})
+			secondDone <- err
+		}()
+
+		g.Consistently(secondDone, "50ms").ShouldNot(Receive())
+		close(release)
+		g.Expect(<-firstDone).To(BeNil())
+		g.Expect(<-secondDone).To(BeNil())
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns"}))
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		g := NewWithT(t)
+		locker := NewMutexApplyLocker()
+		release, firstDone := startFirst(locker)
+		defer func() {
+			close(release)
+			g.Expect(<-firstDone).To(BeNil())
+		}()
+
+		s := &mock.Snap{}
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), second, ApplyOptions{Locker: locker, LockTimeout: 10 * time.Millisecond})
+		g.Expect(errors.Is(err, ErrApplyInProgress)).To(BeTrue())
+		g.Expect(err).To(MatchError("apply already in progress, gave up waiting after 10ms"))
+		g.Expect(s.EnableAddonCalledWith).To(BeEmpty())
+
+		// dry-runs do not wait for the lock
+		_, err = NewLauncher(s, false).ApplyWithOptions(context.Background(), second, ApplyOptions{Locker: locker, LockTimeout: 10 * time.Millisecond, DryRun: true})
+		g.Expect(err).To(BeNil())
+	})
+
+	t.Run("File", func(t *testing.T) {
+		g := NewWithT(t)
+		path := filepath.Join(t.TempDir(), "lock", "apply.lock")
+		first, other := NewFileApplyLocker(path), NewFileApplyLocker(path)
+
+		unlock, err := first.Lock(context.Background())
+		g.Expect(err).To(BeNil())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+		defer cancel()
+		_, err = other.Lock(ctx)
+		g.Expect(err).To(MatchError(context.DeadlineExceeded))
+
+		unlock()
+		unlock, err = other.Lock(context.Background())
+		g.Expect(err).To(BeNil())
+		unlock()
+	})
+}
+
+func TestInterfaceSANs(t *testing.T) {
+	interfaceAddrs := func(name string) ([]net.IP, error) {
+		switch name {
+		case "eth0":
+			return []net.IP{net.ParseIP("10.0.0.11"), net.ParseIP("fd00::11"), net.ParseIP("fe80::1")}, nil
+		case "eth1":
+			return []net.IP{net.ParseIP("192.168.1.11"), net.ParseIP("192.168.1.12")}, nil
+		}
+		return nil, fmt.Errorf("unknown network interface %q", name)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		parts      [][]string
+		expectSANs []string
+	}{
+		{name: "all", parts: [][]string{{"interface:eth0"}}, expectSANs: []string{"10.0.0.11", "fd00::11"}},
+		{name: "ipv4", parts: [][]string{{"interface:eth0:ipv4"}}, expectSANs: []string{"10.0.0.11"}},
+		{name: "ipv6", parts: [][]string{{"interface:eth0:ipv6"}}, expectSANs: []string{"fd00::11"}},
+		{name: "multiple", parts: [][]string{{"node.example.com", "interface:eth1"}}, expectSANs: []string{"node.example.com", "192.168.1.11", "192.168.1.12"}},
+		{name: "remove", parts: [][]string{{"interface:eth0", "interface:eth1"}, {"-interface:eth1"}}, expectSANs: []string{"10.0.0.11", "fd00::11"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &mock.Snap{}
+			l := NewLauncher(s, false)
+			c := MultiPartConfiguration{}
+			for _, sans := range tc.parts {
+				sans := sans
+				c.Parts = append(c.Parts, &Configuration{Version: "0.2.0", ExtraSANs: &sans})
+			}
+
+			g := NewWithT(t)
+			result, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{InterfaceAddrs: interfaceAddrs})
+			g.Expect(err).To(BeNil())
+			last := result.Actions[len(result.Actions)-1]
+			g.Expect(last.Kind).To(Equal(ActionWriteCSRConfig))
+			g.Expect(last.Arguments).To(Equal(tc.expectSANs))
+			g.Expect(s.CSRConfig).NotTo(ContainSubstring("fe80::1"))
+		})
+	}
+
+	t.Run("UnknownInterface", func(t *testing.T) {
+		s := &mock.Snap{}
+		l := NewLauncher(s, false)
+		c := MultiPartConfiguration{Parts: []*Configuration{{Version: "0.2.0", ExtraSANs: &[]string{"interface:wlan0"}}}}
+
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{InterfaceAddrs: interfaceAddrs})
+		g.Expect(err).To(MatchError(ContainSubstring(`failed to expand SAN "interface:wlan0": unknown network interface "wlan0"`)))
+		g.Expect(s.CSRConfig).To(BeEmpty())
+	})
+}
+
+func TestAuditPolicy(t *testing.T) {
+	s := &mock.Snap{
+		ServiceArguments: map[string]string{
+			"kube-apiserver": "--secure-port=16443\n",
+		},
+	}
+	l := NewLauncher(s, false)
+	apply := func(config string) error {
+		c, err := ParseMultiPartConfiguration([]byte(config))
+		if err != nil {
+			return err
+		}
+		return l.Apply(context.Background(), c)
+	}
+
+	t.Run("Enable", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(apply(`
+version: 0.2.0
+auditPolicy:
+  policy: |
+    apiVersion: audit.k8s.io/v1
+    kind: Policy
+    rules:
+    - level: Metadata
+`)).To(Succeed())
+		g.Expect(s.ServiceArguments["audit-policy.yaml"]).To(Equal("apiVersion: audit.k8s.io/v1\nkind: Policy\nrules:\n- level: Metadata\n"))
+		g.Expect(strings.Split(strings.TrimSpace(s.ServiceArguments["kube-apiserver"]), "\n")).To(ConsistOf(
+			snaputil.ManagedArgsHeader,
+			"--secure-port=16443",
+			"--audit-policy-file=${SNAP_DATA}/args/audit-policy.yaml",
+			"--audit-log-path=${SNAP_COMMON}/var/log/kube-apiserver-audit.log",
+		))
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(apply(`
+version: 0.2.0
+auditPolicy:
+  logPath: /var/log/kube-audit.log
+  policy: |
+    apiVersion: audit.k8s.io/v1
+    kind: Policy
+    rules:
+    - level: RequestResponse
+`)).To(Succeed())
+		g.Expect(s.ServiceArguments["audit-policy.yaml"]).To(ContainSubstring("level: RequestResponse"))
+		g.Expect(strings.Split(strings.TrimSpace(s.ServiceArguments["kube-apiserver"]), "\n")).To(ConsistOf(
+			snaputil.ManagedArgsHeader,
+			"--secure-port=16443",
+			"--audit-policy-file=${SNAP_DATA}/args/audit-policy.yaml",
+			"--audit-log-path=/var/log/kube-audit.log",
+		))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(apply("version: 0.2.0\nauditPolicy: null\n")).To(Succeed())
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(managedArgs("--secure-port=16443\n")))
+	})
+}
+
+func TestEncryption(t *testing.T) {
+	s := &mock.Snap{
+		ServiceArguments: map[string]string{
+			"kube-apiserver": "--secure-port=16443\n",
+		},
+	}
+	l := NewLauncher(s, false)
+	c, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+encryption:
+  resources: [secrets, configmaps]
+  providers:
+  - type: secretbox
+    keys:
+    - name: key2
+      secret: YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXoxMjM0NTY=
+  - type: kms
+    name: vault
+    endpoint: unix:///var/run/kms.sock
+  - type: identity
+`))
+	g := NewWithT(t)
+	g.Expect(err).To(BeNil())
+	result, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{})
+	g.Expect(err).To(BeNil())
+	g.Expect(s.ServiceArguments["encryption-config.yaml"]).To(Equal(`apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- resources:
+  - secrets
+  - configmaps
+  providers:
+  - secretbox:
+      keys:
+      - name: key2
+        secret: YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXoxMjM0NTY=
+  - kms:
+      apiVersion: v2
+      name: vault
+      endpoint: unix:///var/run/kms.sock
+  - identity: {}
+`))
+	g.Expect(strings.Split(strings.TrimSpace(s.ServiceArguments["kube-apiserver"]), "\n")).To(ConsistOf(
+		snaputil.ManagedArgsHeader,
+		"--secure-port=16443",
+		"--encryption-provider-config=${SNAP_DATA}/args/encryption-config.yaml",
+	))
+	g.Expect(s.RestartServiceCalledWith).To(ConsistOf("kubelite"))
+	g.Expect(fmt.Sprint(result.Actions)).NotTo(ContainSubstring("YWJjZGVm"))
+
+	t.Run("Unchanged", func(t *testing.T) {
+		g := NewWithT(t)
+		s.RestartServiceCalledWith = nil
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{Force: true})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+}
+
+func TestFeatureGates(t *testing.T) {
+	s := &mock.Snap{
+		ServiceArguments: map[string]string{
+			"kube-apiserver": "--secure-port=16443\n--feature-gates=APIListChunking=true,AnyVolumeDataSource=false\n",
+			"kubelet":        "--max-pods=110\n",
+		},
+	}
+	l := NewLauncher(s, false)
+	c, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+featureGates:
+  kubeAPIServer:
+    AnyVolumeDataSource: true
+    ValidatingAdmissionPolicy: true
+  kubelet:
+    GracefulNodeShutdown: true
+---
+version: 0.2.0
+featureGates:
+  kubelet:
+    GracefulNodeShutdown: false
+    MemoryQoS: true
+`))
+	g := NewWithT(t)
+	g.Expect(err).To(BeNil())
+	g.Expect(l.Apply(context.Background(), c)).To(Succeed())
+	g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(managedArgs("--secure-port=16443\n--feature-gates=APIListChunking=true,AnyVolumeDataSource=true,ValidatingAdmissionPolicy=true\n")))
+	g.Expect(strings.Split(strings.TrimSpace(s.ServiceArguments["kubelet"]), "\n")).To(ConsistOf(
+		snaputil.ManagedArgsHeader,
+		"--max-pods=110",
+		"--feature-gates=GracefulNodeShutdown=false,MemoryQoS=true",
+	))
+
+	t.Run("Conflict", func(t *testing.T) {
+		_, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+featureGates:
+  kubeAPIServer:
+    AnyVolumeDataSource: true
+  kubelet:
+    GracefulNodeShutdown: true
+extraKubeAPIServerArgs:
+  --feature-gates: APIListChunking=true
+extraKubeletArgs:
+  - --feature-gates=MemoryQoS=true
+`))
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring(`featureGates.kubeAPIServer cannot be used together with extraKubeAPIServerArgs[--feature-gates], since both set the "--feature-gates" argument`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`featureGates.kubelet cannot be used together with extraKubeletArgs[--feature-gates=MemoryQoS=true], since both set the "--feature-gates" argument`)))
+	})
+
+	t.Run("InvalidName", func(t *testing.T) {
+		_, err := ParseConfiguration([]byte("version: 0.2.0\nfeatureGates:\n  kubelet:\n    \"A=B\": true\n"))
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring(`featureGates.kubelet key "A=B" is not a valid feature gate name`)))
+	})
+}
+
+func TestAdmissionPlugins(t *testing.T) {
+	t.Run("Enable", func(t *testing.T) {
+		s := &mock.Snap{
+			ServiceArguments: map[string]string{
+				"kube-apiserver": "--secure-port=16443\n--enable-admission-plugins=EventRateLimit,NodeRestriction\n",
+			},
+		}
+		c, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+admissionPlugins:
+  enable: [NodeRestriction, PodSecurity]
+---
+version: 0.2.0
+admissionPlugins:
+  enable: [AlwaysPullImages]
+`))
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(NewLauncher(s, false).Apply(context.Background(), c)).To(Succeed())
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(managedArgs("--secure-port=16443\n--enable-admission-plugins=EventRateLimit,NodeRestriction,PodSecurity,AlwaysPullImages\n")))
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		s := &mock.Snap{
+			ServiceArguments: map[string]string{
+				"kube-apiserver": "--secure-port=16443\n--enable-admission-plugins=EventRateLimit,NodeRestriction\n--disable-admission-plugins=DefaultStorageClass\n",
+			},
+		}
+		c, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+admissionPlugins:
+  disable: [EventRateLimit, NodeRestriction, ServiceAccount]
+`))
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(NewLauncher(s, false).Apply(context.Background(), c)).To(Succeed())
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(managedArgs("--secure-port=16443\n--disable-admission-plugins=DefaultStorageClass,EventRateLimit,NodeRestriction,ServiceAccount\n")))
+	})
+
+	t.Run("LaterPartWins", func(t *testing.T) {
+		c, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+admissionPlugins:
+  enable: [PodSecurity]
+  disable: [EventRateLimit]
+---
+version: 0.2.0
+admissionPlugins:
+  enable: [EventRateLimit]
+  disable: [PodSecurity]
+`))
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		merged, err := c.Merge()
+		g.Expect(err).To(BeNil())
+		g.Expect(merged.AdmissionPlugins).To(Equal(AdmissionPluginsConfiguration{Enable: []string{"EventRateLimit"}, Disable: []string{"PodSecurity"}}))
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		_, err := ParseConfiguration([]byte(`
+version: 0.2.0
+admissionPlugins:
+  enable: [PodSecurity, NodeRestriction]
+  disable: [NodeRestriction]
+extraKubeAPIServerArgs:
+  --enable-admission-plugins: PodSecurity
+`))
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring(`admissionPlugins.disable[0] "NodeRestriction" cannot be both enabled and disabled`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`admissionPlugins cannot be used together with extraKubeAPIServerArgs[--enable-admission-plugins], since both set the "--enable-admission-plugins" argument`)))
+	})
+
+	t.Run("InvalidName", func(t *testing.T) {
+		_, err := ParseConfiguration([]byte("version: 0.2.0\nadmissionPlugins:\n  enable: [\"A,B\"]\n"))
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring(`admissionPlugins.enable[0] "A,B" is not a valid admission plugin name`)))
+	})
+}
+
+func TestSectionSummary(t *testing.T) {
+	c, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+roles: [control-plane]
+extraKubeAPIServerArgs:
+  --event-ttl: 1h
+---
+version: 0.2.0
+extraKubeletArgs:
+  --max-pods: "200"
+extraKubeProxyArgs:
+  --cluster-cidr: 10.1.0.0/16
+`))
+	if err != nil {
+		t.Fatalf("failed to parse configuration: %v", err)
+	}
+
+	s := &mock.Snap{
+		ClusteredLock:    true,
+		ServiceArguments: map[string]string{"kube-proxy": "--cluster-cidr=10.1.0.0/16\n"},
+	}
+	result, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), c, ApplyOptions{})
+
+	g := NewWithT(t)
+	g.Expect(err).To(BeNil())
+	g.Expect(result.Sections).To(Equal(&SectionSummary{
+		Present:   []Section{SectionExtraKubeletArgs, SectionExtraKubeAPIServerArgs, SectionExtraKubeProxyArgs},
+		Applied:   []Section{SectionExtraKubeletArgs},
+		Unchanged: []Section{SectionExtraKubeProxyArgs},
+		Skipped:   []Section{SectionExtraKubeAPIServerArgs},
+	}))
+
+	t.Run("AlreadyApplied", func(t *testing.T) {
+		result, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), c, ApplyOptions{})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(result.Actions).To(BeEmpty())
+		g.Expect(result.Sections).To(Equal(&SectionSummary{
+			Present:   []Section{SectionExtraKubeletArgs, SectionExtraKubeAPIServerArgs, SectionExtraKubeProxyArgs},
+			Unchanged: []Section{SectionExtraKubeletArgs, SectionExtraKubeProxyArgs},
+			Skipped:   []Section{SectionExtraKubeAPIServerArgs},
+		}))
+	})
+
+	t.Run("Derived", func(t *testing.T) {
+		s := &mock.Snap{}
+		result, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+			Version:    "0.2.0",
+			NodeLabels: map[string]string{"zone": "a"},
+		}}}, ApplyOptions{})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(result.Sections.Applied).To(Equal([]Section{SectionNodeLabels}))
+	})
+
+	t.Run("EmptySANs", func(t *testing.T) {
+		c, err := ParseMultiPartConfiguration([]byte("version: 0.2.0\nextraSANs: []\nextraKubeletArgs:\n  --max-pods: \"200\"\n"))
+		if err != nil {
+			t.Fatalf("failed to parse configuration: %v", err)
+		}
+		result, err := NewLauncher(&mock.Snap{}, false).ApplyWithOptions(context.Background(), c, ApplyOptions{})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(result.Sections.Present).To(Equal([]Section{SectionExtraKubeletArgs}))
+	})
+}
+
+func TestNodeRoles(t *testing.T) {
+	c, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+roles: [control-plane]
+extraKubeAPIServerArgs:
+  --event-ttl: 1h
+---
+version: 0.2.0
+roles: [worker]
+extraMicroK8sAPIServerProxyArgs:
+  --refresh-interval: 30s
+---
+version: 0.2.0
+extraKubeletArgs:
+  --max-pods: "200"
+`))
+	if err != nil {
+		t.Fatalf("failed to parse configuration: %v", err)
+	}
+
+	t.Run("Worker", func(t *testing.T) {
+		s := &mock.Snap{ClusteredLock: true}
+		g := NewWithT(t)
+		g.Expect(NewLauncher(s, false).Apply(context.Background(), c)).To(Succeed())
+		g.Expect(s.ServiceArguments).ToNot(HaveKey("kube-apiserver"))
+		g.Expect(s.ServiceArguments["apiserver-proxy"]).To(Equal(managedArgs("--refresh-interval=30s\n")))
+		g.Expect(s.ServiceArguments["kubelet"]).To(Equal(managedArgs("--max-pods=200\n")))
+	})
+
+	t.Run("ControlPlane", func(t *testing.T) {
+		s := &mock.Snap{}
+		g := NewWithT(t)
+		g.Expect(NewLauncher(s, false).Apply(context.Background(), c)).To(Succeed())
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(managedArgs("--event-ttl=1h\n")))
+		g.Expect(s.ServiceArguments).ToNot(HaveKey("apiserver-proxy"))
+		g.Expect(s.ServiceArguments["kubelet"]).To(Equal(managedArgs("--max-pods=200\n")))
+	})
+
+	t.Run("InjectedRole", func(t *testing.T) {
+		s := &mock.Snap{}
+		g := NewWithT(t)
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), c, ApplyOptions{
+			NodeRole: func() (NodeRole, error) { return NodeRoleWorker, nil },
+		})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.ServiceArguments).ToNot(HaveKey("kube-apiserver"))
+		g.Expect(s.ServiceArguments["apiserver-proxy"]).To(Equal(managedArgs("--refresh-interval=30s\n")))
+	})
+
+	t.Run("DetectionFailed", func(t *testing.T) {
+		s := &mock.Snap{}
+		g := NewWithT(t)
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), c, ApplyOptions{
+			NodeRole: func() (NodeRole, error) { return "", errors.New("no node") },
+		})
+		g.Expect(err).To(MatchError("failed to detect node role: no node"))
+		g.Expect(s.ServiceArguments).To(BeEmpty())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := ParseConfiguration([]byte("version: 0.2.0\nroles: [master, worker, worker]\n"))
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring(`roles[0] "master" must be one of "control-plane" or "worker"`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`roles[2] "worker" is listed more than once`)))
+	})
+
+	t.Run("OldVersion", func(t *testing.T) {
+		_, err := ParseConfiguration([]byte("version: 0.1.0\nroles: [worker]\n"))
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring(`field "roles" requires config file version 0.2.0 or newer`)))
+	})
+}
+
+func TestIncrementalApply(t *testing.T) {
+	parse := func(t *testing.T, containerdLogLevel string) MultiPartConfiguration {
+		c, err := ParseMultiPartConfiguration([]byte(fmt.Sprintf(`
+version: 0.2.0
+addons:
+  - name: dns
+extraKubeletArgs:
+  --max-pods: "200"
+---
+version: 0.2.0
+containerd:
+  extraArgs:
+    --log-level: %s
+`, containerdLogLevel)))
+		if err != nil {
+			t.Fatalf("failed to parse configuration: %v", err)
+		}
+		return c
+	}
+
+	t.Run("OnlyChanged", func(t *testing.T) {
+		s := &mock.Snap{}
+		l := NewLauncher(s, false)
+		statePath := filepath.Join(t.TempDir(), "state", "last-applied.json")
+		opts := ApplyOptions{StatePath: statePath}
+
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), parse(t, "info"), opts)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns"}))
+		g.Expect(s.RestartServiceCalledWith).To(ConsistOf("containerd", "kubelite"))
+		g.Expect(statePath).To(BeAnExistingFile())
+
+		s.EnableAddonCalledWith = nil
+		s.RestartServiceCalledWith = nil
+		result, err := l.ApplyWithOptions(context.Background(), parse(t, "debug"), opts)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.EnableAddonCalledWith).To(BeEmpty())
+		g.Expect(s.RestartServiceCalledWith).To(Equal([]string{"containerd"}))
+		g.Expect(s.ServiceArguments["containerd"]).To(Equal(managedArgs("--log-level=debug\n")))
+		for _, action := range result.Actions {
+			g.Expect(action.Target).ToNot(Equal("kubelet"))
+		}
+
+		t.Run("Force", func(t *testing.T) {
+			s.EnableAddonCalledWith = nil
+			_, err := l.ApplyWithOptions(context.Background(), parse(t, "debug"), ApplyOptions{StatePath: statePath, Force: true})
+			g := NewWithT(t)
+			g.Expect(err).To(BeNil())
+			g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns"}))
+		})
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		s := &mock.Snap{}
+		l := NewLauncher(s, false)
+		statePath := filepath.Join(t.TempDir(), "last-applied.json")
+		g := NewWithT(t)
+		g.Expect(os.WriteFile(statePath, []byte(`{"configuration": `), 0600)).To(Succeed())
+
+		_, err := l.ApplyWithOptions(context.Background(), parse(t, "info"), ApplyOptions{StatePath: statePath})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns"}))
+		g.Expect(s.RestartServiceCalledWith).To(ConsistOf("containerd", "kubelite"))
+
+		b, err := os.ReadFile(statePath)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(b)).To(HavePrefix(`{"preInit":false,"configuration":{`))
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		s := &mock.Snap{}
+		statePath := filepath.Join(t.TempDir(), "last-applied.json")
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), parse(t, "info"), ApplyOptions{StatePath: statePath, DryRun: true})
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(statePath).ToNot(BeAnExistingFile())
+	})
+}
+
+func TestKubeletConfig(t *testing.T) {
+	s := &mock.Snap{
+		ServiceArguments: map[string]string{
+			"kubelet": "--max-pods=110\n",
+		},
+	}
+	l := NewLauncher(s, false)
+	apply := func(config string) error {
+		c, err := ParseMultiPartConfiguration([]byte(config))
+		if err != nil {
+			return err
+		}
+		return l.Apply(context.Background(), c)
+	}
+
+	t.Run("Write", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(apply(`
+version: 0.2.0
+kubeletConfig: |
+  apiVersion: kubelet.config.k8s.io/v1beta1
+  kind: KubeletConfiguration
+  shutdownGracePeriod: 30s
+  memorySwap:
+    swapBehavior: LimitedSwap
+`)).To(Succeed())
+		g.Expect(s.ServiceArguments["kubelet-config.yaml"]).To(Equal("apiVersion: kubelet.config.k8s.io/v1beta1\nkind: KubeletConfiguration\nshutdownGracePeriod: 30s\nmemorySwap:\n  swapBehavior: LimitedSwap\n"))
+		g.Expect(strings.Split(strings.TrimSpace(s.ServiceArguments["kubelet"]), "\n")).To(ConsistOf(
+			snaputil.ManagedArgsHeader,
+			"--max-pods=110",
+			"--config=${SNAP_DATA}/args/kubelet-config.yaml",
+		))
+		g.Expect(s.RestartServiceCalledWith).To(Equal([]string{"kubelite"}))
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		s.RestartServiceCalledWith = nil
+		g := NewWithT(t)
+		g.Expect(apply(`
+version: 0.2.0
+kubeletConfig: |
+  apiVersion: kubelet.config.k8s.io/v1beta1
+  kind: KubeletConfiguration
+  shutdownGracePeriod: 60s
+  containerLogMaxSize: 50Mi
+`)).To(Succeed())
+		g.Expect(s.ServiceArguments["kubelet-config.yaml"]).To(Equal("apiVersion: kubelet.config.k8s.io/v1beta1\nkind: KubeletConfiguration\nshutdownGracePeriod: 60s\nmemorySwap:\n  swapBehavior: LimitedSwap\ncontainerLogMaxSize: 50Mi\n"))
+		g.Expect(s.RestartServiceCalledWith).To(Equal([]string{"kubelite"}))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(apply("version: 0.2.0\nkubeletConfig: null\n")).To(Succeed())
+		g.Expect(s.ServiceArguments["kubelet"]).To(Equal(managedArgs("--max-pods=110\n")))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := ParseConfiguration([]byte(`
+version: 0.2.0
+kubeletConfig: |
+  apiVersion: kubelet.config.k8s.io/v1
+  kind: KubeProxyConfiguration
+extraKubeletArgs:
+  --config: /etc/kubelet.yaml
+`))
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring(`kubeletConfig apiVersion "kubelet.config.k8s.io/v1" must be "kubelet.config.k8s.io/v1beta1"`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`kubeletConfig kind "KubeProxyConfiguration" must be "KubeletConfiguration"`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`kubeletConfig cannot be used together with extraKubeletArgs[--config], since both set the kubelet "--config" argument`)))
+	})
+
+	t.Run("NotYAML", func(t *testing.T) {
+		_, err := ParseConfiguration([]byte("version: 0.2.0\nkubeletConfig: \"not a document\"\n"))
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring("kubeletConfig is not a valid YAML document")))
+	})
+}
+
+// exitError is a command error with a non-zero exit code.
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string { return fmt.Sprintf("exit status %d", e.code) }
+func (e *exitError) ExitCode() int { return e.code }
+
+// flakySnap is a mock snap where enabling addons and restarting services fails with err for the first failures calls.
+type flakySnap struct {
+	*mock.Snap
+	err      error
+	failures int
+	calls    int
+}
+
+func (s *flakySnap) fail() error {
+	s.calls++
+	if s.calls <= s.failures {
+		return s.err
+	}
+	return nil
+}
+
+func (s *flakySnap) EnableAddon(ctx context.Context, addon string, args ...string) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.Snap.EnableAddon(ctx, addon, args...)
+}
+
+func (s *flakySnap) RestartService(ctx context.Context, service string) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.Snap.RestartService(ctx, service)
+}
+
+func TestRetry(t *testing.T) {
+	addonConfig := MultiPartConfiguration{Parts: []*Configuration{{
+		Version: "0.2.0",
+		Addons:  []AddonConfiguration{{Name: "dns"}},
+	}}}
+	newOpts := func(sleeps *[]time.Duration) ApplyOptions {
+		return ApplyOptions{MaxRetries: 3, Backoff: time.Second, RetrySleep: func(ctx context.Context, d time.Duration) error {
+			*sleeps = append(*sleeps, d)
+			return nil
+		}}
+	}
+
+	t.Run("FlakyAddon", func(t *testing.T) {
+		s := &flakySnap{Snap: &mock.Snap{}, err: fmt.Errorf("command failed: %w", &exitError{code: 1}), failures: 2}
+		var sleeps []time.Duration
+		result, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), addonConfig, newOpts(&sleeps))
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.calls).To(Equal(3))
+		g.Expect(sleeps).To(Equal([]time.Duration{time.Second, 2 * time.Second}))
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns"}))
+		g.Expect(withoutDurations(result.Actions)).To(Equal([]Action{{Kind: ActionEnableAddon, Target: "dns"}}))
+	})
+
+	t.Run("FlakyRestart", func(t *testing.T) {
+		s := &flakySnap{Snap: &mock.Snap{}, err: &exitError{code: 1}, failures: 1}
+		var sleeps []time.Duration
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+			Version:          "0.2.0",
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"200"}[0]},
+		}}}, newOpts(&sleeps))
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.calls).To(Equal(2))
+		g.Expect(sleeps).To(Equal([]time.Duration{time.Second}))
+		g.Expect(s.RestartServiceCalledWith).To(Equal([]string{"kubelite"}))
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		s := &flakySnap{Snap: &mock.Snap{}, err: &exitError{code: 1}, failures: 10}
+		var sleeps []time.Duration
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), addonConfig, newOpts(&sleeps))
+
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring("exit status 1")))
+		g.Expect(s.calls).To(Equal(4))
+		g.Expect(sleeps).To(Equal([]time.Duration{time.Second, 2 * time.Second, 4 * time.Second}))
+	})
+
+	t.Run("HardError", func(t *testing.T) {
+		s := &flakySnap{Snap: &mock.Snap{}, err: fmt.Errorf("addon is broken"), failures: 1}
+		var sleeps []time.Duration
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), addonConfig, newOpts(&sleeps))
+
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring("addon is broken")))
+		g.Expect(s.calls).To(Equal(1))
+		g.Expect(sleeps).To(BeEmpty())
+	})
+
+	t.Run("Patterns", func(t *testing.T) {
+		// the error of a real command, which includes its stderr output
+		commandErr := util.RunCommandWithOutput(context.Background(), nil, io.Discard, "/bin/sh", "-c", "echo 'dial tcp 127.0.0.1:16443: connect: connection refused' >&2; exit 1")
+		for _, tc := range []struct {
+			name     string
+			patterns []string
+			calls    int
+		}{
+			{name: "Match", patterns: []string{"timed out", "connection refused"}, calls: 2},
+			{name: "NoMatch", patterns: []string{"timed out"}, calls: 1},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				s := &flakySnap{Snap: &mock.Snap{}, err: fmt.Errorf("failed to enable addon: %w", commandErr), failures: 1}
+				var sleeps []time.Duration
+				opts := newOpts(&sleeps)
+				opts.RetryPatterns = tc.patterns
+				NewLauncher(s, false).ApplyWithOptions(context.Background(), addonConfig, opts)
+
+				g := NewWithT(t)
+				g.Expect(s.calls).To(Equal(tc.calls))
+			})
+		}
+	})
+
+	t.Run("RedactedWarning", func(t *testing.T) {
+		s := &flakySnap{Snap: &mock.Snap{}, err: &exitError{code: 1}, failures: 1}
+		var sleeps []time.Duration
+		var warnings []string
+		opts := newOpts(&sleeps)
+		opts.Logger = &warningCollector{prefix: "apply", warnings: &warnings}
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), MultiPartConfiguration{Parts: []*Configuration{{
+			Version: "0.2.0",
+			Addons:  []AddonConfiguration{{Name: "observability", Arguments: []string{"--grafana-password=hunter2"}}},
+		}}}, opts)
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(warnings).To(HaveLen(1))
+		g.Expect(warnings[0]).To(ContainSubstring("enable-addon observability [--grafana-password=<redacted>] failed, retrying"))
+		g.Expect(warnings[0]).NotTo(ContainSubstring("hunter2"))
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"observability --grafana-password=hunter2"}))
+	})
+
+	t.Run("InvalidPattern", func(t *testing.T) {
+		s := &flakySnap{Snap: &mock.Snap{}}
+		var sleeps []time.Duration
+		opts := newOpts(&sleeps)
+		opts.RetryPatterns = []string{"("}
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), addonConfig, opts)
+
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring(`invalid retry pattern "("`)))
+		g.Expect(s.calls).To(Equal(0))
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		s := &flakySnap{Snap: &mock.Snap{}, err: &exitError{code: 1}, failures: 10}
+		opts := ApplyOptions{MaxRetries: 3, RetrySleep: func(ctx context.Context, d time.Duration) error { return context.Canceled }}
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), addonConfig, opts)
+
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring("exit status 1")))
+		g.Expect(s.calls).To(Equal(1))
+	})
+}
+
+// managedArgs returns the contents of an arguments file with the given lines, as written by the cluster agent.
+func managedArgs(lines string) string {
+	return snaputil.ManagedArgsHeader + "\n" + lines
+}
+
+func TestAnnotations(t *testing.T) {
+	s := &mock.Snap{ServiceArguments: map[string]string{"kubelet": "--max-pods=110\n"}}
+	l := NewLauncher(s, false)
+	c := MultiPartConfiguration{Parts: []*Configuration{{
+		Version:          "0.2.0",
+		Annotations:      map[string]string{"owner": "team-a"},
+		ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"250"}[0]},
+	}}}
+
+	g := NewWithT(t)
+	g.Expect(l.Apply(context.Background(), c)).To(Succeed())
+	g.Expect(s.ServiceArguments["kubelet"]).To(Equal(managedArgs("--max-pods=250\n")))
+	g.Expect(s.RestartServiceCalledWith).To(ConsistOf("kubelite"))
+
+	t.Run("ChangedAnnotations", func(t *testing.T) {
+		changed := c.Parts[0].Clone()
+		changed.Annotations = map[string]string{"owner": "team-b", "reason": "reviewed"}
+
+		g := NewWithT(t)
+		result, err := l.ApplyWithOptions(context.Background(), MultiPartConfiguration{Parts: []*Configuration{changed}}, ApplyOptions{})
+		g.Expect(err).To(BeNil())
+		g.Expect(result.Actions).To(BeEmpty())
+		g.Expect(result.Sections.Unchanged).To(Equal([]Section{SectionExtraKubeletArgs}))
+	})
+}
+
+func TestStagedApply(t *testing.T) {
+	c := MultiPartConfiguration{Parts: []*Configuration{{
+		Version:          "0.2.0",
+		ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"250"}[0]},
+		ExtraSANs:        &[]string{"10.0.0.10"},
+		Addons:           []AddonConfiguration{{Name: "dns"}, {Name: "ingress"}, {Name: "hostpath-storage", Disable: true}},
+	}}}
+
+	t.Run("SkipAddons", func(t *testing.T) {
+		s := &mock.Snap{ServiceArguments: map[string]string{"kubelet": "--max-pods=110\n"}}
+		result, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), c, ApplyOptions{SkipAddons: true})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.ServiceArguments["kubelet"]).To(Equal(managedArgs("--max-pods=250\n")))
+		g.Expect(s.CSRConfig).To(ContainSubstring("10.0.0.10"))
+		g.Expect(s.EnableAddonCalledWith).To(BeEmpty())
+		g.Expect(s.DisableAddonCalledWith).To(BeEmpty())
+		g.Expect(result.DeferredAddons).To(Equal([]string{"dns", "ingress", "hostpath-storage"}))
+		g.Expect(result.Sections.Applied).To(Equal([]Section{SectionExtraKubeletArgs, SectionExtraSANs}))
+		g.Expect(result.Sections.Skipped).To(Equal([]Section{SectionAddons}))
+		// the configuration is not recorded as applied, so that applying it in full is not a no-op
+		g.Expect(s.ServiceArguments).ToNot(HaveKey(configurationHashFile))
+
+		t.Run("OnlyAddons", func(t *testing.T) {
+			s.RestartServiceCalledWith = nil
+			result, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), c, ApplyOptions{OnlyAddons: true})
+
+			g := NewWithT(t)
+			g.Expect(err).To(BeNil())
+			g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns", "ingress"}))
+			g.Expect(s.DisableAddonCalledWith).To(Equal([]string{"hostpath-storage"}))
+			g.Expect(result.DeferredAddons).To(BeEmpty())
+			g.Expect(result.Sections.Applied).To(Equal([]Section{SectionAddons}))
+			g.Expect(result.Sections.Skipped).To(Equal([]Section{SectionExtraKubeletArgs, SectionExtraSANs}))
+			for _, action := range result.Actions {
+				g.Expect(action.Kind).To(BeElementOf(ActionEnableAddon, ActionDisableAddon))
+			}
+		})
+	})
+
+	t.Run("OnlyAddons", func(t *testing.T) {
+		s := &mock.Snap{ServiceArguments: map[string]string{"kubelet": "--max-pods=110\n"}}
+		result, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), c, ApplyOptions{OnlyAddons: true})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.ServiceArguments["kubelet"]).To(Equal("--max-pods=110\n"))
+		g.Expect(s.CSRConfig).To(BeEmpty())
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+		g.Expect(s.EnableAddonCalledWith).To(Equal([]string{"dns", "ingress"}))
+		g.Expect(result.Sections.Skipped).To(Equal([]Section{SectionExtraKubeletArgs, SectionExtraSANs}))
+	})
+
+	t.Run("Both", func(t *testing.T) {
+		s := &mock.Snap{}
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), c, ApplyOptions{SkipAddons: true, OnlyAddons: true})
+
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError("SkipAddons and OnlyAddons cannot be used together"))
+		g.Expect(s.EnableAddonCalledWith).To(BeEmpty())
+	})
+}
+
+func TestRegistryMirrors(t *testing.T) {
+	parse := func(t *testing.T, config string) MultiPartConfiguration {
+		c, err := ParseMultiPartConfiguration([]byte(config))
+		if err != nil {
+			t.Fatalf("failed to parse configuration: %v", err)
+		}
+		return c
+	}
+
+	s := &mock.Snap{ContainerdRegistryConfigs: map[string]string{"quay.io": "server = \"https://quay.io\"\n"}}
+	l := NewLauncher(s, false)
+
+	g := NewWithT(t)
+	result, err := l.ApplyWithOptions(context.Background(), parse(t, `
+version: 0.2.0
+registryMirrors:
+  - host: docker.io
+    mirrors: [https://mirror.example.com, http://10.0.0.10:5000]
+    username: user
+    password: pass
+`), ApplyOptions{})
+	g.Expect(err).To(BeNil())
+	g.Expect(s.ContainerdRegistryConfigs["docker.io"]).To(Equal(`server = "https://registry-1.docker.io"
+
+[host."https://mirror.example.com"]
+  capabilities = ["pull", "resolve"]
+  [host."https://mirror.example.com".header]
+    authorization = "Basic dXNlcjpwYXNz"
+
+[host."http://10.0.0.10:5000"]
+  capabilities = ["pull", "resolve"]
+  [host."http://10.0.0.10:5000".header]
+    authorization = "Basic dXNlcjpwYXNz"
+`))
+	g.Expect(withoutDurations(result.Actions)).To(Equal([]Action{
+		{Kind: ActionWriteContainerdRegistryConfig, Target: "docker.io"},
+		{Kind: ActionRestartService, Target: "containerd"},
+	}))
+
+	t.Run("Unchanged", func(t *testing.T) {
+		s.RestartServiceCalledWith = nil
+
+		g := NewWithT(t)
+		_, err := l.ApplyWithOptions(context.Background(), parse(t, `
+version: 0.2.0
+registryMirrors:
+  - host: docker.io
+    mirrors: [https://mirror.example.com, http://10.0.0.10:5000]
+    username: user
+    password: pass
+`), ApplyOptions{Force: true})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		s.RestartServiceCalledWith = nil
+
+		g := NewWithT(t)
+		result, err := l.ApplyWithOptions(context.Background(), parse(t, `
+version: 0.2.0
+registryMirrors:
+  - host: docker.io
+    remove: true
+  - host: ghcr.io
+    remove: true
+`), ApplyOptions{})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.ContainerdRegistryConfigs).To(Equal(map[string]string{"quay.io": "server = \"https://quay.io\"\n"}))
+		g.Expect(withoutDurations(result.Actions)).To(Equal([]Action{
+			{Kind: ActionRemoveContainerdRegistryConfig, Target: "docker.io"},
+			{Kind: ActionRestartService, Target: "containerd"},
+		}))
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		s := &mock.Snap{ContainerdRegistryConfigs: map[string]string{"quay.io": "server = \"https://quay.io\"\n"}}
+
+		g := NewWithT(t)
+		result, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), parse(t, `
+version: 0.2.0
+registryMirrors:
+  - host: docker.io
+    mirrors: [https://mirror.example.com]
+  - host: quay.io
+    remove: true
+`), ApplyOptions{DryRun: true})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.ContainerdRegistryConfigs).To(Equal(map[string]string{"quay.io": "server = \"https://quay.io\"\n"}))
+		g.Expect(result.Actions).To(HaveLen(3))
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		s := &failingWriteSnap{
+			Snap:         &mock.Snap{ContainerdRegistryConfigs: map[string]string{"quay.io": "server = \"https://quay.io\"\n"}},
+			failServices: map[string]struct{}{"kubelet": {}},
+		}
+
+		g := NewWithT(t)
+		_, err := NewLauncher(s, false).ApplyWithOptions(context.Background(), parse(t, `
+version: 0.2.0
+registryMirrors:
+  - host: docker.io
+    mirrors: [https://mirror.example.com]
+  - host: quay.io
+    remove: true
+---
+version: 0.2.0
+extraKubeletArgs:
+  --max-pods: "200"
+`), ApplyOptions{})
+		g.Expect(err).To(MatchError(ContainSubstring("failed to write kubelet")))
+		g.Expect(s.ContainerdRegistryConfigs).To(Equal(map[string]string{"quay.io": "server = \"https://quay.io\"\n"}))
+	})
+
+	t.Run("NotConfirmed", func(t *testing.T) {
+		s := &mock.Snap{ContainerdRegistryConfigs: map[string]string{"quay.io": "server = \"https://quay.io\"\n"}}
+		opts := ApplyOptions{PendingPath: filepath.Join(t.TempDir(), "pending.json"), ConfirmTimeout: 10 * time.Millisecond}
+
+		g := NewWithT(t)
+		l := NewLauncher(s, false)
+		_, pending, err := l.ApplyStaged(context.Background(), parse(t, `
+version: 0.2.0
+registryMirrors:
+  - host: docker.io
+    mirrors: [https://mirror.example.com]
+  - host: quay.io
+    remove: true
+`), opts)
+		g.Expect(err).To(BeNil())
+		g.Expect(pending).ToNot(BeNil())
+		g.Expect(s.ContainerdRegistryConfigs).To(HaveKey("docker.io"))
+
+		// the previous registry configurations are restored after a restart, from the pending configuration
+		pending.Stop()
+		<-pending.Done()
+		pending, err = NewLauncher(s, false).ResumePendingApply(opts)
+		g.Expect(err).To(BeNil())
+		select {
+		case <-pending.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("pending apply was not done in time")
+		}
+		g.Expect(pending.Err()).To(MatchError(ErrApplyNotConfirmed))
+		g.Expect(s.ContainerdRegistryConfigs).To(Equal(map[string]string{"quay.io": "server = \"https://quay.io\"\n"}))
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		g := NewWithT(t)
+		merged, err := parse(t, `
+version: 0.2.0
+registryMirrors:
+  - host: docker.io
+    mirrors: [https://a.example.com]
+  - host: quay.io
+    mirrors: [https://b.example.com]
+---
+version: 0.2.0
+registryMirrors:
+  - host: docker.io
+    remove: true
+`).Merge()
+		g.Expect(err).To(BeNil())
+		g.Expect(merged.RegistryMirrors).To(Equal([]RegistryMirrorConfiguration{
+			{Host: "docker.io", Remove: true},
+			{Host: "quay.io", Mirrors: []string{"https://b.example.com"}},
+		}))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+containerdRegistryConfigs:
+  quay.io: "server = \"https://quay.io\""
+registryMirrors:
+  - host: https://docker.io
+    mirrors: [mirror.example.com]
+  - host: quay.io
+    mirrors: [https://mirror.example.com]
+    password: pass
+  - host: quay.io
+    remove: true
+  - host: ghcr.io
+`))
+		g.Expect(err).To(MatchError(ContainSubstring(`registryMirrors[0] host "https://docker.io" must be a registry host name`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`registryMirrors[0].mirrors[0] "mirror.example.com" must be an http or https URL`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`registryMirrors[1] password requires a username`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`registryMirrors[1] cannot be used together with containerdRegistryConfigs[quay.io]`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`registryMirrors[2] host "quay.io" is listed more than once`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`registryMirrors[3] must have at least one mirror, or set remove to remove the mirrors of "ghcr.io"`)))
+	})
+}
+
+func TestApplyStagedConfirm(t *testing.T) {
+	c := MultiPartConfiguration{Parts: []*Configuration{{
+		Version:                "0.2.0",
+		ExtraKubeAPIServerArgs: map[string]*string{"--event-ttl": &[]string{"1h"}[0]},
+	}}}
+	newSnap := func() *mock.Snap {
+		return &mock.Snap{ServiceArguments: map[string]string{"kube-apiserver": "--event-ttl=5m\n"}}
+	}
+	newOptions := func(t *testing.T, confirmTimeout time.Duration) ApplyOptions {
+		return ApplyOptions{
+			PendingPath:    filepath.Join(t.TempDir(), "pending.json"),
+			ConfirmTimeout: confirmTimeout,
+			Locker:         NewMutexApplyLocker(),
+		}
+	}
+	waitDone := func(g Gomega, p *PendingApply) {
+		select {
+		case <-p.Done():
+		case <-time.After(5 * time.Second):
+			g.Expect(fmt.Errorf("pending apply was not done in time")).To(BeNil())
+		}
+	}
+
+	t.Run("NotConfirmed", func(t *testing.T) {
+		s := newSnap()
+		opts := newOptions(t, 10*time.Millisecond)
+		l := NewLauncher(s, false)
+		_, pending, err := l.ApplyStaged(context.Background(), c, opts)
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(pending).ToNot(BeNil())
+		waitDone(g, pending)
+		g.Expect(pending.Err()).To(MatchError(ErrApplyNotConfirmed))
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal("--event-ttl=5m\n"))
+		g.Expect(s.RestartServiceCalledWith).To(Equal([]string{"kubelite", "kubelite"}))
+		g.Expect(opts.PendingPath).ToNot(BeAnExistingFile())
+		g.Expect(l.ConfirmApply(context.Background(), opts)).To(MatchError(ErrNoPendingApply))
+	})
+
+	t.Run("Confirmed", func(t *testing.T) {
+		s := newSnap()
+		opts := newOptions(t, time.Hour)
+		l := NewLauncher(s, false)
+		_, pending, err := l.ApplyStaged(context.Background(), c, opts)
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(pending.Deadline).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+		g.Expect(opts.PendingPath).To(BeAnExistingFile())
+
+		g.Expect(l.ConfirmApply(context.Background(), opts)).To(BeNil())
+		waitDone(g, pending)
+		g.Expect(pending.Err()).To(BeNil())
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(managedArgs("--event-ttl=1h\n")))
+		g.Expect(s.RestartServiceCalledWith).To(Equal([]string{"kubelite"}))
+		g.Expect(opts.PendingPath).ToNot(BeAnExistingFile())
+		g.Expect(l.ConfirmApply(context.Background(), opts)).To(MatchError(ErrNoPendingApply))
+	})
+
+	t.Run("Resumed", func(t *testing.T) {
+		s := newSnap()
+		opts := newOptions(t, time.Hour)
+		_, pending, err := NewLauncher(s, false).ApplyStaged(context.Background(), c, opts)
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		pending.Stop()
+		waitDone(g, pending)
+		g.Expect(pending.Err()).To(BeNil())
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(managedArgs("--event-ttl=1h\n")))
+
+		// the process restarted after the deadline expired
+		state, err := readPendingApply(opts.PendingPath)
+		g.Expect(err).To(BeNil())
+		state.Deadline = time.Now().Add(-time.Minute)
+		g.Expect(writePendingApply(opts.PendingPath, state)).To(BeNil())
+
+		resumed, err := NewLauncher(s, false).ResumePendingApply(opts)
+		g.Expect(err).To(BeNil())
+		waitDone(g, resumed)
+		g.Expect(resumed.Err()).To(MatchError(ErrApplyNotConfirmed))
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal("--event-ttl=5m\n"))
+
+		resumed, err = NewLauncher(s, false).ResumePendingApply(opts)
+		g.Expect(err).To(BeNil())
+		g.Expect(resumed).To(BeNil())
+	})
+
+	t.Run("AlreadyPending", func(t *testing.T) {
+		s := newSnap()
+		opts := newOptions(t, time.Hour)
+		l := NewLauncher(s, false)
+		_, pending, err := l.ApplyStaged(context.Background(), c, opts)
+		defer pending.Stop()
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		_, again, err := l.ApplyStaged(context.Background(), c, opts)
+		g.Expect(err).To(MatchError(ContainSubstring("another configuration is waiting for confirmation")))
+		g.Expect(again).To(BeNil())
+	})
+
+	t.Run("Unchanged", func(t *testing.T) {
+		s := &mock.Snap{ServiceArguments: map[string]string{"kube-apiserver": "--event-ttl=1h\n"}}
+		opts := newOptions(t, time.Hour)
+		_, pending, err := NewLauncher(s, false).ApplyStaged(context.Background(), MultiPartConfiguration{}, opts)
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(pending).To(BeNil())
+		g.Expect(opts.PendingPath).ToNot(BeAnExistingFile())
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		opts := newOptions(t, time.Hour)
+		opts.DryRun = true
+		_, _, err := NewLauncher(newSnap(), false).ApplyStaged(context.Background(), c, opts)
+
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring("cannot be used in dry-run mode")))
+	})
+}
+
+func TestOIDC(t *testing.T) {
+	const ca = "-----BEGIN CERTIFICATE-----\nMIIBszCCAVmgAwIBAgIUJq3v\n-----END CERTIFICATE-----\n"
+	s := &mock.Snap{
+		ServiceArguments: map[string]string{
+			"kube-apiserver": "--secure-port=16443\n--oidc-groups-claim=roles\n",
+		},
+	}
+	l := NewLauncher(s, false)
+	c := MultiPartConfiguration{Parts: []*Configuration{{
+		Version: "0.2.0",
+		OIDC:    &OIDCConfiguration{IssuerURL: "https://accounts.example.com", ClientID: "kubernetes", UsernameClaim: "email", CA: ca},
+	}}}
+
+	g := NewWithT(t)
+	result, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{})
+	g.Expect(err).To(BeNil())
+	g.Expect(s.ServiceArguments[oidcCAFile]).To(Equal(ca))
+	g.Expect(strings.Split(strings.TrimSpace(s.ServiceArguments["kube-apiserver"]), "\n")).To(ConsistOf(
+		snaputil.ManagedArgsHeader,
+		"--secure-port=16443",
+		"--oidc-issuer-url=https://accounts.example.com",
+		"--oidc-client-id=kubernetes",
+		"--oidc-username-claim=email",
+		"--oidc-ca-file=${SNAP_DATA}/args/oidc-ca.crt",
+	))
+	g.Expect(s.RestartServiceCalledWith).To(ConsistOf("kubelite"))
+	g.Expect(result.Sections.Applied).To(Equal([]Section{SectionOIDC}))
+
+	t.Run("Unchanged", func(t *testing.T) {
+		g := NewWithT(t)
+		s.RestartServiceCalledWith = nil
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{Force: true})
+		g.Expect(err).To(BeNil())
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+
+	t.Run("CAFile", func(t *testing.T) {
+		g := NewWithT(t)
+		c := MultiPartConfiguration{Parts: []*Configuration{{
+			Version: "0.2.0",
+			OIDC:    &OIDCConfiguration{IssuerURL: "https://accounts.example.com", ClientID: "kubernetes", GroupsClaim: "groups", CAFile: "/etc/ssl/certs/oidc-ca.pem"},
+		}}}
+		_, err := l.ApplyWithOptions(context.Background(), c, ApplyOptions{})
+		g.Expect(err).To(BeNil())
+		g.Expect(strings.Split(strings.TrimSpace(s.ServiceArguments["kube-apiserver"]), "\n")).To(ConsistOf(
+			snaputil.ManagedArgsHeader,
+			"--secure-port=16443",
+			"--oidc-issuer-url=https://accounts.example.com",
+			"--oidc-client-id=kubernetes",
+			"--oidc-groups-claim=groups",
+			"--oidc-ca-file=/etc/ssl/certs/oidc-ca.pem",
+		))
+	})
+}
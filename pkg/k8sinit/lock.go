@@ -0,0 +1,113 @@
+package k8sinit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// DefaultApplyLockTimeout is the default maximum duration to wait for another apply to finish.
+const DefaultApplyLockTimeout = 5 * time.Minute
+
+// ErrApplyInProgress is returned when the apply lock could not be acquired before the lock timeout.
+var ErrApplyInProgress = errors.New("apply already in progress")
+
+// ApplyLocker ensures that only one configuration is applied at a time.
+type ApplyLocker interface {
+	// Lock blocks until the lock is acquired or ctx is done, and returns a function that releases the lock.
+	Lock(ctx context.Context) (unlock func(), err error)
+}
+
+// mutexApplyLocker is an ApplyLocker that serializes applies within a single process.
+type mutexApplyLocker struct {
+	ch chan struct{}
+}
+
+// NewMutexApplyLocker returns an ApplyLocker that serializes applies within the current process.
+func NewMutexApplyLocker() ApplyLocker {
+	return &mutexApplyLocker{ch: make(chan struct{}, 1)}
+}
+
+// Lock implements ApplyLocker.
+func (l *mutexApplyLocker) Lock(ctx context.Context) (func(), error) {
+	select {
+	case l.ch <- struct{}{}:
+		return func() { <-l.ch }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fileApplyLocker is an ApplyLocker that holds an exclusive lock (flock) on a file, so that applies are also serialized
+// across processes.
+type fileApplyLocker struct {
+	path         string
+	pollInterval time.Duration
+}
+
+// NewFileApplyLocker returns an ApplyLocker that holds an exclusive lock on a file, e.g. "$SNAP_DATA/var/lock/launch-configuration.lock".
+// The file and its parent directory are created if they do not exist.
+func NewFileApplyLocker(path string) ApplyLocker {
+	return &fileApplyLocker{path: path, pollInterval: 100 * time.Millisecond}
+}
+
+// Lock implements ApplyLocker.
+func (l *fileApplyLocker) Lock(ctx context.Context) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock file directory: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return func() {
+				_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+				f.Close()
+			}, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", l.path, err)
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(l.pollInterval):
+		}
+	}
+}
+
+// DefaultApplyLocker is the ApplyLocker used if none is specified. It serializes applies within the current process.
+var DefaultApplyLocker = NewMutexApplyLocker()
+
+// lockApply acquires the apply lock, waiting up to opts.LockTimeout for another apply to finish.
+func lockApply(ctx context.Context, opts ApplyOptions) (func(), error) {
+	locker := opts.Locker
+	if locker == nil {
+		locker = DefaultApplyLocker
+	}
+	timeout := opts.LockTimeout
+	if timeout == 0 {
+		timeout = DefaultApplyLockTimeout
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	unlock, err := locker.Lock(lockCtx)
+	switch {
+	case err == nil:
+		return unlock, nil
+	case ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded):
+		return nil, fmt.Errorf("%w, gave up waiting after %v", ErrApplyInProgress, timeout)
+	default:
+		return nil, fmt.Errorf("failed to acquire apply lock: %w", err)
+	}
+}
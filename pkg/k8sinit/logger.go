@@ -0,0 +1,35 @@
+package k8sinit
+
+import "log"
+
+// Logger is used to report progress and warnings while parsing and applying configurations.
+type Logger interface {
+	// Infof logs an informational message.
+	Infof(format string, args ...interface{})
+	// Warnf logs a warning message.
+	Warnf(format string, args ...interface{})
+}
+
+// stdLogger is a Logger that writes to the standard logger.
+type stdLogger struct{}
+
+// Infof implements Logger.
+func (stdLogger) Infof(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// Warnf implements Logger.
+func (stdLogger) Warnf(format string, args ...interface{}) {
+	log.Printf("WARNING: "+format, args...)
+}
+
+// DefaultLogger is the Logger used if none is specified. It writes to the standard logger.
+var DefaultLogger Logger = stdLogger{}
+
+// loggerOrDefault returns logger, or DefaultLogger if logger is nil.
+func loggerOrDefault(logger Logger) Logger {
+	if logger == nil {
+		return DefaultLogger
+	}
+	return logger
+}
@@ -0,0 +1,65 @@
+package k8sinit_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+
+	. "github.com/onsi/gomega"
+)
+
+// recordingLogger is a k8sinit.Logger that records all messages.
+type recordingLogger struct {
+	infos    []string
+	warnings []string
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.infos = append(l.infos, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	var globalLog bytes.Buffer
+	log.SetOutput(&globalLog)
+	defer log.SetOutput(os.Stderr)
+
+	t.Run("Parse", func(t *testing.T) {
+		logger := &recordingLogger{}
+
+		g := NewWithT(t)
+		_, err := k8sinit.ParseConfigurationWithOptions([]byte("version: 0.1.0\nx-unknown-field: test\n"), k8sinit.ParseOptions{Logger: logger})
+		g.Expect(err).To(BeNil())
+		g.Expect(logger.warnings).To(ConsistOf(`unknown field "x-unknown-field" will be ignored`))
+		g.Expect(globalLog.String()).To(BeEmpty())
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		logger := &recordingLogger{}
+
+		l := k8sinit.NewLauncher(&mock.Snap{}, false)
+		_, err := l.ApplyWithOptions(context.Background(), k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{{
+			Version:          "0.2.0",
+			RestartServices:  k8sinit.RestartPolicyNever,
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"200"}[0]},
+		}}}, k8sinit.ApplyOptions{DryRun: true, Logger: logger})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(logger.infos).To(ConsistOf(
+			"[dry-run] write-service-arguments kubelet",
+			"Configuration sections: present=[extraKubeletArgs restartServices] applied=[extraKubeletArgs restartServices] unchanged=[] skipped=[]",
+		))
+		g.Expect(logger.warnings).To(ConsistOf(`not restarting services [kubelite] due to restart policy "never", restart them to apply the configuration`))
+		g.Expect(globalLog.String()).To(BeEmpty())
+	})
+}
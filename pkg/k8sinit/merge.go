@@ -0,0 +1,351 @@
+package k8sinit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// Merge folds all configuration parts, in order, into a single effective Configuration.
+//
+// The following rules apply when merging:
+//   - The version of the merged configuration is the highest version of all parts.
+//   - Extra arguments and environment maps are merged per key. Later parts override earlier ones. A null value
+//     replaces any earlier value, so that applying the merged configuration removes the argument.
+//   - Setting the ResetArgsKey to null discards the extra arguments of earlier parts for the same field.
+//   - ExtraSANs are accumulated across parts. Entries prefixed with "-" remove a SAN added by an earlier part.
+//   - Addons (by qualified name) and addon repositories (by name) are merged. Later parts override earlier ones (e.g. an addon enabled in
+//     one part and disabled in a later part is disabled). Each entry keeps the position it was first seen at.
+//   - Containerd registry configs and extra config files are merged per key, later parts override earlier ones.
+//   - Registry mirrors are merged by host, later parts override earlier ones. Each host keeps the position it was first seen at.
+//   - Scalar fields (persistent cluster token, container runtime, restart policy, containerd config, join configuration) are overridden by later parts
+//     that set them.
+//   - The CNI configuration is overridden as a whole by later parts that set it.
+//   - Node labels and node taints are each overridden as a whole by later parts that set them, since each of them is
+//     applied as a single kubelet argument.
+//   - The audit policy is overridden as a whole by later parts that set it (including to null).
+//   - The kubelet config is overridden as a whole by later parts that set it (including to null).
+//   - Feature gates are merged per component and gate, later parts override earlier ones.
+//   - The encryption configuration is overridden as a whole by later parts that set it.
+//   - The OIDC configuration is overridden as a whole by later parts that set it.
+//   - Hook commands are accumulated across parts, in order. The hooks timeout and failure policy are overridden by later parts that set them.
+//   - Unsafe datastore arguments are allowed in the merged configuration if any part allows them.
+//   - Annotations are merged per key, later parts override earlier ones.
+//   - Includes are not merged, since they are resolved while parsing.
+//   - Roles are not merged, since they select which parts are applied. The merged configuration applies to all nodes.
+//
+// NOTE: this needs to be updated when new fields are added to the Configuration struct.
+func (m MultiPartConfiguration) Merge() (*Configuration, error) {
+	return m.MergeWithOptions(MergeOptions{})
+}
+
+// MergeWithOptions folds all configuration parts, in order, into a single effective Configuration.
+// See Merge for the rules that apply when merging. In strict mode, an extra argument that is set to different
+// values by two parts is an error instead of being overridden.
+func (m MultiPartConfiguration) MergeWithOptions(opts MergeOptions) (*Configuration, error) {
+	merged, _, err := m.MergeWithProvenance(opts)
+	return merged, err
+}
+
+// Provenance maps each setting of a merged configuration to the Source of the configuration part it was taken from.
+// Settings are identified by their path, e.g. "persistentClusterToken", "extraKubeletArgs[--max-pods]", "addons[dns]" or
+// "extraSANs[10.10.10.10]".
+type Provenance map[string]string
+
+// MergeWithProvenance folds all configuration parts, in order, into a single effective Configuration, and reports
+// which part each setting of the merged configuration was taken from. Parts without a Source are identified by their index.
+// See Merge for the rules that apply when merging.
+func (m MultiPartConfiguration) MergeWithProvenance(opts MergeOptions) (*Configuration, Provenance, error) {
+	if len(m.Parts) == 0 {
+		return nil, nil, fmt.Errorf("no configuration parts to merge")
+	}
+
+	merged := &Configuration{}
+	provenance := Provenance{}
+	var mergedVersion *version.Version
+	// argSources is the index of the part that last set each extra argument, used in strict mode.
+	argSources := make(map[argSource]int)
+	for idx, part := range m.Parts {
+		if part == nil {
+			continue
+		}
+		source := part.Source
+		if source == "" {
+			source = fmt.Sprintf("part %d", idx)
+		}
+
+		v, err := version.ParseSemantic(part.Version)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse version %q of config part %d: %w", part.Version, idx, err)
+		}
+		if mergedVersion == nil || mergedVersion.LessThan(v) {
+			mergedVersion = v
+			merged.Version = part.Version
+			provenance["version"] = source
+		}
+
+		if part.PersistentClusterToken != "" {
+			merged.PersistentClusterToken = part.PersistentClusterToken
+			provenance["persistentClusterToken"] = source
+		}
+		if part.RestartServices != "" {
+			merged.RestartServices = part.RestartServices
+			provenance["restartServices"] = source
+		}
+		if part.ContainerRuntime != "" {
+			merged.ContainerRuntime = part.ContainerRuntime
+			provenance["containerRuntime"] = source
+		}
+		if part.Join.URL != "" {
+			merged.Join = part.Join
+			provenance["join"] = source
+		}
+		if len(part.NodeLabels) > 0 {
+			merged.NodeLabels = cloneStringMap(part.NodeLabels)
+			provenance["nodeLabels"] = source
+		}
+		if len(part.NodeTaints) > 0 {
+			merged.NodeTaints = cloneStrings(part.NodeTaints)
+			provenance["nodeTaints"] = source
+		}
+		if part.AuditPolicy != nil {
+			auditPolicy := *part.AuditPolicy
+			merged.AuditPolicy = &auditPolicy
+			provenance["auditPolicy"] = source
+		}
+		if part.KubeletConfig != nil {
+			kubeletConfig := *part.KubeletConfig
+			merged.KubeletConfig = &kubeletConfig
+			provenance["kubeletConfig"] = source
+		}
+		if part.Encryption != nil {
+			merged.Encryption = part.Clone().Encryption
+			provenance["encryption"] = source
+		}
+		if part.OIDC != nil {
+			oidc := *part.OIDC
+			merged.OIDC = &oidc
+			provenance["oidc"] = source
+		}
+		if part.Containerd.ConfigToml != "" {
+			merged.Containerd.ConfigToml = part.Containerd.ConfigToml
+			provenance["containerd.configToml"] = source
+		}
+		if cni := part.CNI; cni.Config != "" || cni.Calico != nil || cni.Flannel != nil {
+			merged.CNI = cni
+			provenance["cni"] = source
+		}
+		for _, command := range part.Hooks.PreApply {
+			merged.Hooks.PreApply = append(merged.Hooks.PreApply, command)
+			provenance[fmt.Sprintf("hooks.preApply[%s]", command)] = source
+		}
+		for _, command := range part.Hooks.PostApply {
+			merged.Hooks.PostApply = append(merged.Hooks.PostApply, command)
+			provenance[fmt.Sprintf("hooks.postApply[%s]", command)] = source
+		}
+		if part.Hooks.Timeout != "" {
+			merged.Hooks.Timeout = part.Hooks.Timeout
+			provenance["hooks.timeout"] = source
+		}
+		if part.Hooks.FailurePolicy != "" {
+			merged.Hooks.FailurePolicy = part.Hooks.FailurePolicy
+			provenance["hooks.failurePolicy"] = source
+		}
+		if part.Datastore.AllowUnsafe && !merged.Datastore.AllowUnsafe {
+			merged.Datastore.AllowUnsafe = true
+			provenance["datastore.allowUnsafe"] = source
+		}
+
+		mergedGates := merged.featureGatesFields()
+		for fieldIdx, field := range part.featureGatesFields() {
+			for _, name := range util.SortedKeys(*field.gates) {
+				if *mergedGates[fieldIdx].gates == nil {
+					*mergedGates[fieldIdx].gates = make(map[string]bool)
+				}
+				(*mergedGates[fieldIdx].gates)[name] = (*field.gates)[name]
+				provenance[fmt.Sprintf("%s[%s]", field.name, name)] = source
+			}
+		}
+
+		if part.hasAdmissionPlugins() {
+			mergeAdmissionPlugins(&merged.AdmissionPlugins, part.AdmissionPlugins)
+			for _, plugin := range part.AdmissionPlugins.Enable {
+				provenance[fmt.Sprintf("admissionPlugins[%s]", plugin)] = source
+			}
+			for _, plugin := range part.AdmissionPlugins.Disable {
+				provenance[fmt.Sprintf("admissionPlugins[%s]", plugin)] = source
+			}
+		}
+
+		mergedFields := merged.serviceArgsFields()
+		for fieldIdx, field := range part.serviceArgsFields() {
+			if opts.Strict {
+				if err := checkArgConflicts(argSources, field.name, *mergedFields[fieldIdx].args, *field.args, idx); err != nil {
+					return nil, nil, err
+				}
+			}
+			mergeArgs(mergedFields[fieldIdx].args, *field.args)
+			if hasResetArgs(*field.args) {
+				provenance.removePrefix(field.name + "[")
+			}
+			provenance.setKeys(field.name, util.SortedKeys(*field.args), source)
+		}
+
+		if part.ExtraSANs != nil {
+			sans := []string{}
+			if merged.ExtraSANs != nil {
+				sans = *merged.ExtraSANs
+			}
+			previous := sans
+			sans = mergeExtraSANs(sans, *part.ExtraSANs)
+			merged.ExtraSANs = &sans
+			for _, san := range previous {
+				if indexOfSAN(sans, san) == -1 {
+					delete(provenance, fmt.Sprintf("extraSANs[%s]", san))
+				}
+			}
+			for _, entry := range *part.ExtraSANs {
+				if strings.HasPrefix(entry, extraSANRemovePrefix) {
+					continue
+				}
+				// duplicate SANs are recorded in the form they were first seen
+				if idx := indexOfSAN(sans, entry); idx != -1 {
+					provenance[fmt.Sprintf("extraSANs[%s]", sans[idx])] = source
+				}
+			}
+		}
+
+		merged.Addons = mergeAddons(merged.Addons, part.Addons)
+		merged.AddonRepositories = mergeAddonRepositories(merged.AddonRepositories, part.AddonRepositories)
+		merged.ContainerdRegistryConfigs = mergeStrings(merged.ContainerdRegistryConfigs, part.ContainerdRegistryConfigs)
+		merged.RegistryMirrors = mergeRegistryMirrors(merged.RegistryMirrors, part.RegistryMirrors)
+		merged.ExtraConfigFiles = mergeStrings(merged.ExtraConfigFiles, part.ExtraConfigFiles)
+
+		for _, addon := range part.Addons {
+			provenance[fmt.Sprintf("addons[%s]", addon.QualifiedName())] = source
+		}
+		for _, repo := range part.AddonRepositories {
+			provenance[fmt.Sprintf("addonRepositories[%s]", repo.Name)] = source
+		}
+		merged.Annotations = mergeStrings(merged.Annotations, part.Annotations)
+		provenance.setKeys("annotations", util.SortedKeys(part.Annotations), source)
+		provenance.setKeys("containerdRegistryConfigs", util.SortedKeys(part.ContainerdRegistryConfigs), source)
+		for _, mirror := range part.RegistryMirrors {
+			provenance[fmt.Sprintf("registryMirrors[%s]", mirror.Host)] = source
+		}
+		provenance.setKeys("extraConfigFiles", util.SortedKeys(part.ExtraConfigFiles), source)
+	}
+
+	return merged, provenance, nil
+}
+
+// setKeys records source as the provenance of keys of a map field.
+func (p Provenance) setKeys(field string, keys []string, source string) {
+	for _, key := range keys {
+		p[fmt.Sprintf("%s[%s]", field, key)] = source
+	}
+}
+
+// removePrefix removes the provenance of all settings whose path starts with prefix.
+func (p Provenance) removePrefix(prefix string) {
+	for path := range p {
+		if strings.HasPrefix(path, prefix) {
+			delete(p, path)
+		}
+	}
+}
+
+// argSource identifies an extra argument of a configuration field.
+type argSource struct {
+	field string
+	key   string
+}
+
+// checkArgConflicts returns an error if src sets an extra argument to a different non-null value than dst.
+// The index of the part setting each argument is tracked in sources.
+func checkArgConflicts(sources map[argSource]int, field string, dst map[string]*string, src map[string]*string, part int) error {
+	for _, key := range util.SortedKeys(src) {
+		source := argSource{field: field, key: key}
+		newValue := src[key]
+		if oldValue, ok := dst[key]; ok && oldValue != nil && newValue != nil && *oldValue != *newValue {
+			return fmt.Errorf("conflicting values for %s[%s]: %q in config part %d and %q in config part %d", field, key, *oldValue, sources[source], *newValue, part)
+		}
+		sources[source] = part
+	}
+	return nil
+}
+
+// mergeArgs merges the extra arguments of src into dst. dst is allocated if needed.
+func mergeArgs(dst *ExtraArgs, src map[string]*string) {
+	if len(src) == 0 {
+		return
+	}
+	if *dst == nil || hasResetArgs(src) {
+		// resetting arguments discards the arguments of earlier parts
+		*dst = make(ExtraArgs, len(src))
+	}
+	// the map form replaces all arguments of the list form with the same flag (see ExtraArgs)
+	for key := range *dst {
+		if !isVerbatimArg(key) {
+			continue
+		}
+		if _, ok := src[argFlag(key)]; ok {
+			delete(*dst, key)
+		}
+	}
+	for key, value := range src {
+		if value == nil {
+			(*dst)[key] = nil
+		} else {
+			v := *value
+			(*dst)[key] = &v
+		}
+	}
+}
+
+// mergeStrings merges the entries of src into dst, and returns the result.
+func mergeStrings(dst map[string]string, src map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	for key, value := range src {
+		dst[key] = value
+	}
+	return dst
+}
+
+// mergeAddons merges the addons of src into dst by name, and returns the result.
+func mergeAddons(dst []AddonConfiguration, src []AddonConfiguration) []AddonConfiguration {
+nextAddon:
+	for _, addon := range src {
+		addon.Arguments = append([]string(nil), addon.Arguments...)
+		for idx := range dst {
+			if dst[idx].QualifiedName() == addon.QualifiedName() {
+				dst[idx] = addon
+				continue nextAddon
+			}
+		}
+		dst = append(dst, addon)
+	}
+	return dst
+}
+
+// mergeAddonRepositories merges the addon repositories of src into dst by name, and returns the result.
+func mergeAddonRepositories(dst []AddonRepositoryConfiguration, src []AddonRepositoryConfiguration) []AddonRepositoryConfiguration {
+nextRepository:
+	for _, repo := range src {
+		for idx := range dst {
+			if dst[idx].Name == repo.Name {
+				dst[idx] = repo
+				continue nextRepository
+			}
+		}
+		dst = append(dst, repo)
+	}
+	return dst
+}
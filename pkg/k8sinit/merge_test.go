@@ -0,0 +1,282 @@
+package k8sinit_test
+
+import (
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestMerge(t *testing.T) {
+	t.Run("ThreeParts", func(t *testing.T) {
+		m := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{
+			{
+				Version:   "0.1.0",
+				ExtraSANs: &[]string{"10.0.0.1", "10.0.0.2"},
+				ExtraKubeletArgs: map[string]*string{
+					"--max-pods":    &[]string{"110"}[0],
+					"--cluster-dns": &[]string{"10.152.183.10"}[0],
+				},
+				Addons: []k8sinit.AddonConfiguration{
+					{Name: "dns"},
+					{Name: "ingress"},
+				},
+				PersistentClusterToken: "token-1",
+			},
+			{
+				Version:   "0.2.0",
+				ExtraSANs: &[]string{"-10.0.0.1", "node.example.com"},
+				ExtraKubeletArgs: map[string]*string{
+					"--max-pods":    &[]string{"250"}[0],
+					"--cluster-dns": nil,
+				},
+				Addons: []k8sinit.AddonConfiguration{
+					{Name: "ingress", Disable: true},
+				},
+				ContainerdRegistryConfigs: map[string]string{"docker.io": "server = \"http://mirror:5000\""},
+			},
+			{
+				Version: "0.1.0",
+				ExtraKubeAPIServerArgs: map[string]*string{
+					"--event-ttl": &[]string{"1h"}[0],
+				},
+				Addons: []k8sinit.AddonConfiguration{
+					{Name: "ingress", Arguments: []string{"--default-ssl-certificate=ns/cert"}},
+					{Name: "registry", Disable: true},
+				},
+				PersistentClusterToken: "token-3",
+			},
+		}}
+
+		g := NewWithT(t)
+		c, err := m.Merge()
+		g.Expect(err).To(BeNil())
+		g.Expect(c).To(Equal(&k8sinit.Configuration{
+			Version:   "0.2.0",
+			ExtraSANs: &[]string{"10.0.0.2", "node.example.com"},
+			ExtraKubeletArgs: map[string]*string{
+				"--max-pods":    &[]string{"250"}[0],
+				"--cluster-dns": nil,
+			},
+			ExtraKubeAPIServerArgs: map[string]*string{
+				"--event-ttl": &[]string{"1h"}[0],
+			},
+			Addons: []k8sinit.AddonConfiguration{
+				{Name: "dns"},
+				{Name: "ingress", Arguments: []string{"--default-ssl-certificate=ns/cert"}},
+				{Name: "registry", Disable: true},
+			},
+			ContainerdRegistryConfigs: map[string]string{"docker.io": "server = \"http://mirror:5000\""},
+			PersistentClusterToken:    "token-3",
+		}))
+
+		t.Run("DoesNotModifyParts", func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(*m.Parts[0].ExtraKubeletArgs["--max-pods"]).To(Equal("110"))
+			g.Expect(m.Parts[1].Addons).To(Equal([]k8sinit.AddonConfiguration{{Name: "ingress", Disable: true}}))
+		})
+	})
+
+	t.Run("NoParts", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.MultiPartConfiguration{}.Merge()
+		g.Expect(err).NotTo(BeNil())
+		g.Expect(c).To(BeNil())
+	})
+
+	t.Run("InvalidVersion", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{{Version: "invalid"}}}.Merge()
+		g.Expect(err).NotTo(BeNil())
+		g.Expect(c).To(BeNil())
+	})
+}
+
+func TestMergeStrict(t *testing.T) {
+	m := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{
+		{
+			Version:          "0.1.0",
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"110"}[0], "--cluster-dns": &[]string{"10.152.183.10"}[0]},
+		},
+		{
+			Version:          "0.1.0",
+			ExtraKubeletArgs: map[string]*string{"--cluster-dns": &[]string{"10.152.183.10"}[0]},
+		},
+		{
+			Version:          "0.1.0",
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"250"}[0]},
+		},
+	}}
+
+	t.Run("Override", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := m.Merge()
+		g.Expect(err).To(BeNil())
+		g.Expect(c.ExtraKubeletArgs).To(Equal(k8sinit.ExtraArgs{
+			"--max-pods":    &[]string{"250"}[0],
+			"--cluster-dns": &[]string{"10.152.183.10"}[0],
+		}))
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := m.MergeWithOptions(k8sinit.MergeOptions{Strict: true})
+		g.Expect(err).To(MatchError(`conflicting values for extraKubeletArgs[--max-pods]: "110" in config part 0 and "250" in config part 2`))
+		g.Expect(c).To(BeNil())
+	})
+
+	t.Run("SameValue", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.MultiPartConfiguration{Parts: m.Parts[:2]}.MergeWithOptions(k8sinit.MergeOptions{Strict: true})
+		g.Expect(err).To(BeNil())
+		g.Expect(c.ExtraKubeletArgs).To(HaveLen(2))
+	})
+}
+
+func TestMergeNullAndEmptyArgs(t *testing.T) {
+	m := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{
+		{
+			Version:                "0.1.0",
+			ExtraKubeletArgs:       map[string]*string{"--resolv-conf": &[]string{"/etc/resolv.conf"}[0], "--max-pods": &[]string{"110"}[0]},
+			ExtraKubeAPIServerArgs: map[string]*string{"--audit-log-path": nil},
+		},
+		{
+			Version:                "0.1.0",
+			ExtraKubeletArgs:       map[string]*string{"--resolv-conf": &[]string{""}[0], "--max-pods": nil},
+			ExtraKubeAPIServerArgs: map[string]*string{"--audit-log-path": &[]string{""}[0], "--event-ttl": &[]string{"1h"}[0]},
+		},
+	}}
+
+	g := NewWithT(t)
+	c, err := m.Merge()
+	g.Expect(err).To(BeNil())
+	g.Expect(c.ExtraKubeletArgs).To(Equal(k8sinit.ExtraArgs{
+		"--resolv-conf": &[]string{""}[0],
+		"--max-pods":    nil,
+	}))
+	g.Expect(c.ExtraKubeAPIServerArgs).To(Equal(k8sinit.ExtraArgs{
+		"--audit-log-path": &[]string{""}[0],
+		"--event-ttl":      &[]string{"1h"}[0],
+	}))
+}
+
+func TestMergeResetArgs(t *testing.T) {
+	m := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{
+		{
+			Version:          "0.2.0",
+			ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"110"}[0], "--node-labels": &[]string{"zone=a"}[0]},
+			ExtraEtcdArgs:    map[string]*string{"--quota-backend-bytes": &[]string{"8589934592"}[0]},
+		},
+		{
+			Version:          "0.2.0",
+			ExtraKubeletArgs: map[string]*string{k8sinit.ResetArgsKey: nil, "--cluster-dns": &[]string{"10.152.183.10"}[0]},
+		},
+	}}
+
+	g := NewWithT(t)
+	c, err := m.Merge()
+	g.Expect(err).To(BeNil())
+	g.Expect(c.ExtraKubeletArgs).To(Equal(k8sinit.ExtraArgs{
+		k8sinit.ResetArgsKey: nil,
+		"--cluster-dns":      &[]string{"10.152.183.10"}[0],
+	}))
+	g.Expect(c.ExtraEtcdArgs).To(Equal(k8sinit.ExtraArgs{"--quota-backend-bytes": &[]string{"8589934592"}[0]}))
+}
+
+func TestMergeListArgs(t *testing.T) {
+	m := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{
+		{
+			Version:                "0.2.0",
+			ExtraKubeAPIServerArgs: map[string]*string{"--feature-gates=A=true": &[]string{""}[0], "--runtime-config=api/all=true": &[]string{""}[0]},
+		},
+		{
+			Version:                "0.2.0",
+			ExtraKubeAPIServerArgs: map[string]*string{"--feature-gates": &[]string{"B=true"}[0], "--feature-gates=C=true": &[]string{""}[0]},
+		},
+	}}
+
+	g := NewWithT(t)
+	c, err := m.Merge()
+	g.Expect(err).To(BeNil())
+	// the map form replaces the list form arguments of the same flag from earlier parts
+	g.Expect(c.ExtraKubeAPIServerArgs).To(Equal(k8sinit.ExtraArgs{
+		"--feature-gates":               &[]string{"B=true"}[0],
+		"--feature-gates=C=true":        &[]string{""}[0],
+		"--runtime-config=api/all=true": &[]string{""}[0],
+	}))
+}
+
+func TestMergeHooks(t *testing.T) {
+	m := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{
+		{Version: "0.2.0", Hooks: k8sinit.HooksConfiguration{PreApply: []string{"snapshot"}, Timeout: "1m"}},
+		{Version: "0.2.0", Hooks: k8sinit.HooksConfiguration{PreApply: []string{"drain"}, PostApply: []string{"uncordon"}, FailurePolicy: k8sinit.AddonFailurePolicyContinue}},
+	}}
+
+	g := NewWithT(t)
+	c, err := m.Merge()
+	g.Expect(err).To(BeNil())
+	g.Expect(c.Hooks).To(Equal(k8sinit.HooksConfiguration{
+		PreApply:      []string{"snapshot", "drain"},
+		PostApply:     []string{"uncordon"},
+		Timeout:       "1m",
+		FailurePolicy: k8sinit.AddonFailurePolicyContinue,
+	}))
+}
+
+func TestMergeProvenance(t *testing.T) {
+	m, err := k8sinit.ParseMultiPartConfigurationWithOptions([]byte(`
+version: 0.1.0
+persistentClusterToken: my-token
+extraSANs: [10.0.0.1, 10.0.0.2]
+extraKubeletArgs:
+  --max-pods: "110"
+  --cluster-dns: 10.152.183.10
+addons:
+  - name: dns
+---
+version: 0.2.0
+extraSANs: [-10.0.0.2]
+extraKubeletArgs:
+  --max-pods: "250"
+addons:
+  - name: dns
+    disable: true
+  - name: ingress
+`), k8sinit.ParseOptions{SourceName: "init.yaml"})
+	if err != nil {
+		t.Fatalf("failed to parse configuration: %v", err)
+	}
+
+	g := NewWithT(t)
+	g.Expect(m.Parts[0].Source).To(Equal("init.yaml part 0"))
+	g.Expect(m.Parts[1].Source).To(Equal("init.yaml part 1"))
+
+	merged, provenance, err := m.MergeWithProvenance(k8sinit.MergeOptions{})
+	g.Expect(err).To(BeNil())
+	g.Expect(*merged.ExtraKubeletArgs["--max-pods"]).To(Equal("250"))
+	g.Expect(provenance).To(Equal(k8sinit.Provenance{
+		"version":                         "init.yaml part 1",
+		"persistentClusterToken":          "init.yaml part 0",
+		"extraSANs[10.0.0.1]":             "init.yaml part 0",
+		"extraKubeletArgs[--max-pods]":    "init.yaml part 1",
+		"extraKubeletArgs[--cluster-dns]": "init.yaml part 0",
+		"addons[dns]":                     "init.yaml part 1",
+		"addons[ingress]":                 "init.yaml part 1",
+	}))
+
+	t.Run("Reset", func(t *testing.T) {
+		m := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{
+			{Version: "0.2.0", ExtraKubeletArgs: map[string]*string{"--max-pods": &[]string{"110"}[0]}},
+			{Version: "0.2.0", ExtraKubeletArgs: map[string]*string{k8sinit.ResetArgsKey: nil}},
+		}}
+
+		g := NewWithT(t)
+		_, provenance, err := m.MergeWithProvenance(k8sinit.MergeOptions{})
+		g.Expect(err).To(BeNil())
+		g.Expect(provenance).To(Equal(k8sinit.Provenance{
+			"version":             "part 0",
+			"extraKubeletArgs[*]": "part 1",
+		}))
+	})
+}
@@ -0,0 +1,175 @@
+package k8sinit
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics records metrics about parsing and applying configurations. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// ConfigurationParsed is called after a configuration was parsed successfully.
+	ConfigurationParsed()
+	// ParseFailed is called after parsing a configuration failed.
+	ParseFailed(category ParseErrorCategory)
+	// ConfigurationApplied is called after applying a configuration (except in dry-run mode), with how long it took
+	// and the error, or nil if the configuration was applied successfully.
+	ConfigurationApplied(duration time.Duration, err error)
+	// ServiceRestarted is called after a service was restarted to apply a configuration.
+	ServiceRestarted(service string)
+}
+
+// noopMetrics is a Metrics that discards all metrics.
+type noopMetrics struct{}
+
+// ConfigurationParsed implements Metrics.
+func (noopMetrics) ConfigurationParsed() {}
+
+// ParseFailed implements Metrics.
+func (noopMetrics) ParseFailed(ParseErrorCategory) {}
+
+// ConfigurationApplied implements Metrics.
+func (noopMetrics) ConfigurationApplied(time.Duration, error) {}
+
+// ServiceRestarted implements Metrics.
+func (noopMetrics) ServiceRestarted(string) {}
+
+// DefaultMetrics is the Metrics used if none is specified. It discards all metrics.
+var DefaultMetrics Metrics = noopMetrics{}
+
+// metricsOrDefault returns metrics, or DefaultMetrics if metrics is nil.
+func metricsOrDefault(metrics Metrics) Metrics {
+	if metrics == nil {
+		return DefaultMetrics
+	}
+	return metrics
+}
+
+// ParseErrorCategory is the kind of error returned while parsing a configuration.
+type ParseErrorCategory string
+
+const (
+	// ParseErrorSyntax is invalid YAML (or JSON), or unknown fields and duplicate keys in strict mode.
+	ParseErrorSyntax ParseErrorCategory = "syntax"
+	// ParseErrorValidation is a configuration that parsed, but is not valid.
+	ParseErrorValidation ParseErrorCategory = "validation"
+	// ParseErrorLimit is a configuration that exceeds the maximum size or number of parts.
+	ParseErrorLimit ParseErrorCategory = "limit"
+	// ParseErrorEnv is a failure to expand environment variables.
+	ParseErrorEnv ParseErrorCategory = "env"
+	// ParseErrorEmpty is a configuration without any non-empty documents.
+	ParseErrorEmpty ParseErrorCategory = "empty"
+	// ParseErrorOther is any other error, e.g. failing to read an included file.
+	ParseErrorOther ParseErrorCategory = "other"
+)
+
+// categorizedError is a parse error of a known category.
+type categorizedError struct {
+	category ParseErrorCategory
+	err      error
+}
+
+// Error implements the error interface.
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
+
+// CategorizeParseError returns the category of an error returned while parsing a configuration.
+func CategorizeParseError(err error) ParseErrorCategory {
+	var (
+		categorized   *categorizedError
+		validationErr *ValidationError
+	)
+	switch {
+	case errors.As(err, &categorized):
+		return categorized.category
+	case errors.As(err, &validationErr):
+		return ParseErrorValidation
+	case errors.Is(err, ErrEmptyConfiguration), errors.Is(err, errEmptyConfig):
+		return ParseErrorEmpty
+	}
+	return ParseErrorOther
+}
+
+// observeParse reports the result of parsing a configuration.
+func observeParse(metrics Metrics, err error) {
+	if err != nil {
+		metrics.ParseFailed(CategorizeParseError(err))
+	} else {
+		metrics.ConfigurationParsed()
+	}
+}
+
+// PrometheusMetrics is a Metrics that exports Prometheus counters and histograms.
+type PrometheusMetrics struct {
+	parsed        prometheus.Counter
+	parseErrors   *prometheus.CounterVec
+	applies       *prometheus.CounterVec
+	applyDuration prometheus.Histogram
+	restarts      *prometheus.CounterVec
+}
+
+// NewPrometheusMetrics creates Prometheus metrics and registers them with reg, e.g. prometheus.DefaultRegisterer.
+func NewPrometheusMetrics(reg prometheus.Registerer) (*PrometheusMetrics, error) {
+	m := &PrometheusMetrics{
+		parsed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "microk8s_launch_configurations_parsed_total",
+			Help: "Number of launch configurations parsed successfully.",
+		}),
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "microk8s_launch_configuration_parse_errors_total",
+			Help: "Number of launch configurations that failed to parse, by error category.",
+		}, []string{"category"}),
+		applies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "microk8s_launch_configuration_applies_total",
+			Help: "Number of launch configurations applied, by result (success or failure).",
+		}, []string{"result"}),
+		applyDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "microk8s_launch_configuration_apply_duration_seconds",
+			Help:    "Duration of applying launch configurations.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+		}),
+		restarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "microk8s_launch_configuration_service_restarts_total",
+			Help: "Number of service restarts to apply launch configurations, by service.",
+		}, []string{"service"}),
+	}
+	for _, c := range []prometheus.Collector{m.parsed, m.parseErrors, m.applies, m.applyDuration, m.restarts} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// ConfigurationParsed implements Metrics.
+func (m *PrometheusMetrics) ConfigurationParsed() {
+	m.parsed.Inc()
+}
+
+// ParseFailed implements Metrics.
+func (m *PrometheusMetrics) ParseFailed(category ParseErrorCategory) {
+	m.parseErrors.WithLabelValues(string(category)).Inc()
+}
+
+// ConfigurationApplied implements Metrics.
+func (m *PrometheusMetrics) ConfigurationApplied(duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.applies.WithLabelValues(result).Inc()
+	m.applyDuration.Observe(duration.Seconds())
+}
+
+// ServiceRestarted implements Metrics.
+func (m *PrometheusMetrics) ServiceRestarted(service string) {
+	m.restarts.WithLabelValues(service).Inc()
+}
@@ -0,0 +1,158 @@
+package k8sinit_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+	"github.com/prometheus/client_golang/prometheus"
+
+	. "github.com/onsi/gomega"
+)
+
+// countingMetrics is a k8sinit.Metrics that counts calls.
+type countingMetrics struct {
+	mu          sync.Mutex
+	parsed      int
+	parseErrors []k8sinit.ParseErrorCategory
+	applied     []error
+	restarted   []string
+}
+
+func (m *countingMetrics) ConfigurationParsed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parsed++
+}
+
+func (m *countingMetrics) ParseFailed(category k8sinit.ParseErrorCategory) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parseErrors = append(m.parseErrors, category)
+}
+
+func (m *countingMetrics) ConfigurationApplied(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.applied = append(m.applied, err)
+}
+
+func (m *countingMetrics) ServiceRestarted(service string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restarted = append(m.restarted, service)
+}
+
+func TestParseMetrics(t *testing.T) {
+	t.Run("Parsed", func(t *testing.T) {
+		m := &countingMetrics{}
+		b, err := testdata.ReadFile("testdata/schema/multi-part.yaml")
+		if err != nil {
+			t.Fatalf("failed to read testdata: %v", err)
+		}
+
+		g := NewWithT(t)
+		_, err = k8sinit.ParseMultiPartConfigurationWithOptions(b, k8sinit.ParseOptions{Metrics: m})
+		g.Expect(err).To(BeNil())
+		// a multi-part configuration is counted once
+		g.Expect(m.parsed).To(Equal(1))
+		g.Expect(m.parseErrors).To(BeEmpty())
+	})
+
+	for _, tc := range []struct {
+		name           string
+		input          string
+		opts           k8sinit.ParseOptions
+		expectCategory k8sinit.ParseErrorCategory
+	}{
+		{name: "syntax", input: "version: [0.1.0", expectCategory: k8sinit.ParseErrorSyntax},
+		{name: "strict", input: "version: 0.1.0\nunknown: true", opts: k8sinit.ParseOptions{Strict: true}, expectCategory: k8sinit.ParseErrorSyntax},
+		{name: "validation", input: "version: 0.3.0", expectCategory: k8sinit.ParseErrorValidation},
+		{name: "size", input: "version: 0.1.0", opts: k8sinit.ParseOptions{MaxSize: 4}, expectCategory: k8sinit.ParseErrorLimit},
+		{name: "parts", input: "version: 0.1.0\n---\nversion: 0.1.0", opts: k8sinit.ParseOptions{MaxParts: 1}, expectCategory: k8sinit.ParseErrorLimit},
+		{name: "env", input: "version: 0.1.0\nextraSANs:\n  - ${UNDEFINED_NODE_IP}", opts: k8sinit.ParseOptions{ExpandEnv: true, LookupEnv: func(string) (string, bool) { return "", false }}, expectCategory: k8sinit.ParseErrorEnv},
+		{name: "empty", input: "---\n", expectCategory: k8sinit.ParseErrorEmpty},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &countingMetrics{}
+			tc.opts.Metrics = m
+
+			g := NewWithT(t)
+			_, err := k8sinit.ParseMultiPartConfigurationWithOptions([]byte(tc.input), tc.opts)
+			g.Expect(err).NotTo(BeNil())
+			g.Expect(k8sinit.CategorizeParseError(err)).To(Equal(tc.expectCategory))
+			g.Expect(m.parsed).To(BeZero())
+			g.Expect(m.parseErrors).To(Equal([]k8sinit.ParseErrorCategory{tc.expectCategory}))
+		})
+	}
+}
+
+func TestApplyMetrics(t *testing.T) {
+	m := &countingMetrics{}
+	s := &mock.Snap{}
+
+	l := k8sinit.NewLauncher(s, false)
+	_, err := l.ApplyWithOptions(context.Background(), k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{{
+		Version:          "0.1.0",
+		ExtraKubeletArgs: k8sinit.ExtraArgs{"--max-pods": &[]string{"200"}[0]},
+	}}}, k8sinit.ApplyOptions{Metrics: m})
+
+	g := NewWithT(t)
+	g.Expect(err).To(BeNil())
+	g.Expect(m.applied).To(Equal([]error{nil}))
+	g.Expect(m.restarted).To(Equal([]string{"kubelite"}))
+
+	t.Run("DryRun", func(t *testing.T) {
+		m := &countingMetrics{}
+		_, err := l.ApplyWithOptions(context.Background(), k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{{
+			Version:          "0.1.0",
+			ExtraKubeletArgs: k8sinit.ExtraArgs{"--max-pods": &[]string{"250"}[0]},
+		}}}, k8sinit.ApplyOptions{Metrics: m, DryRun: true})
+
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(m.applied).To(BeEmpty())
+		g.Expect(m.restarted).To(BeEmpty())
+	})
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := k8sinit.NewPrometheusMetrics(reg)
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+
+	k8sinit.ParseConfigurationWithOptions([]byte("version: 0.1.0\naddons: [{name: dns}]"), k8sinit.ParseOptions{Metrics: m})
+	k8sinit.ParseConfigurationWithOptions([]byte("version: [0.1.0"), k8sinit.ParseOptions{Metrics: m})
+	m.ConfigurationApplied(time.Second, nil)
+	m.ServiceRestarted("kubelite")
+
+	g := NewWithT(t)
+	mfs, err := reg.Gather()
+	g.Expect(err).To(BeNil())
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		for _, metric := range mf.GetMetric() {
+			switch {
+			case metric.Counter != nil:
+				values[mf.GetName()] += metric.Counter.GetValue()
+			case metric.Histogram != nil:
+				values[mf.GetName()] += float64(metric.Histogram.GetSampleCount())
+			}
+		}
+	}
+	g.Expect(values).To(Equal(map[string]float64{
+		"microk8s_launch_configurations_parsed_total":          1,
+		"microk8s_launch_configuration_parse_errors_total":     1,
+		"microk8s_launch_configuration_applies_total":          1,
+		"microk8s_launch_configuration_apply_duration_seconds": 1,
+		"microk8s_launch_configuration_service_restarts_total": 1,
+	}))
+
+	_, err = k8sinit.NewPrometheusMetrics(reg)
+	g.Expect(err).To(MatchError(ContainSubstring("failed to register metrics")))
+}
@@ -0,0 +1,79 @@
+package k8sinit
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// migrationStep upgrades a configuration from one config file version to the next one.
+type migrationStep struct {
+	// to is the config file version of the migrated configuration.
+	to string
+	// migrate updates the configuration in place, e.g. moving deprecated fields into their replacement.
+	migrate func(c *Configuration)
+}
+
+// migrations is a registry of migration steps, by the config file version they migrate from.
+var migrations = map[string]migrationStep{
+	"0.1.0": {to: "0.2.0", migrate: func(c *Configuration) {
+		c.Containerd.ExtraArgs = moveExtraArgs(c.ExtraContainerdArgs, c.Containerd.ExtraArgs)
+		c.ExtraContainerdArgs = nil
+		c.Datastore.ExtraArgs = moveExtraArgs(c.ExtraDqliteArgs, c.Datastore.ExtraArgs)
+		c.ExtraDqliteArgs = nil
+	}},
+}
+
+// moveExtraArgs moves the arguments of a deprecated field into its replacement, and returns the result. Arguments that
+// are already set in the replacement are kept, since they are applied after the deprecated field.
+func moveExtraArgs(deprecated ExtraArgs, replacement ExtraArgs) ExtraArgs {
+	if len(deprecated) == 0 {
+		return replacement
+	}
+	if replacement == nil {
+		replacement = make(ExtraArgs, len(deprecated))
+	}
+	for key, value := range deprecated {
+		if _, ok := replacement[key]; !ok {
+			replacement[key] = value
+		}
+	}
+	return replacement
+}
+
+// Migrate returns a copy of the configuration upgraded to the given config file version, e.g. CurrentVersion.
+// Deprecated fields are moved into their replacements (see Deprecations), so that the migrated configuration describes
+// the same state. The configuration is not changed. An error is returned if toVersion is older than the version of
+// the configuration, or if there is no migration path between the versions.
+func Migrate(c *Configuration, toVersion string) (*Configuration, error) {
+	from, err := version.ParseSemantic(c.Version)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config file version %q: %w", c.Version, err)
+	}
+	to, err := version.ParseSemantic(toVersion)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse target config file version %q: %w", toVersion, err)
+	}
+	if to.LessThan(from) {
+		return nil, fmt.Errorf("cannot migrate config file version %v to older version %v", c.Version, toVersion)
+	}
+
+	migrated := c.Clone()
+	current := versionKey(from)
+	for current != versionKey(to) {
+		step, ok := migrations[current]
+		if !ok || to.LessThan(version.MustParseSemantic(step.to)) {
+			return nil, fmt.Errorf("no migration from config file version %v to %v", current, toVersion)
+		}
+		step.migrate(migrated)
+		migrated.Version = step.to
+		current = step.to
+	}
+	return migrated, nil
+}
+
+// versionKey returns the key of a config file version in the migrations registry, without pre-release and build
+// metadata, e.g. "0.2.0".
+func versionKey(v *version.Version) string {
+	return fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch())
+}
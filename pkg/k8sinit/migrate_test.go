@@ -0,0 +1,84 @@
+package k8sinit_test
+
+import (
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestMigrate(t *testing.T) {
+	t.Run("RenameFields", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfiguration([]byte(`
+version: 0.1.0
+extraContainerdArgs:
+  --log-level: debug
+  --state: /var/run/containerd
+extraDqliteArgs:
+  --disk-mode: "true"
+addons:
+  - name: dns
+`))
+		g.Expect(err).To(BeNil())
+
+		migrated, err := k8sinit.Migrate(c, k8sinit.CurrentVersion)
+		g.Expect(err).To(BeNil())
+		g.Expect(migrated.Version).To(Equal("0.2.0"))
+		g.Expect(migrated.ExtraContainerdArgs).To(BeNil())
+		g.Expect(migrated.Containerd.ExtraArgs).To(Equal(k8sinit.ExtraArgs{
+			"--log-level": &[]string{"debug"}[0],
+			"--state":     &[]string{"/var/run/containerd"}[0],
+		}))
+		g.Expect(migrated.ExtraDqliteArgs).To(BeNil())
+		g.Expect(migrated.Datastore.ExtraArgs).To(Equal(k8sinit.ExtraArgs{"--disk-mode": &[]string{"true"}[0]}))
+		g.Expect(migrated.Addons).To(Equal(c.Addons))
+		g.Expect(migrated.Validate()).To(Succeed())
+		g.Expect(migrated.Deprecations()).To(BeEmpty())
+
+		// the original configuration is not changed
+		g.Expect(c.Version).To(Equal("0.1.0"))
+		g.Expect(c.ExtraContainerdArgs).To(HaveLen(2))
+		g.Expect(c.Containerd.ExtraArgs).To(BeNil())
+	})
+
+	t.Run("ReplacementWins", func(t *testing.T) {
+		g := NewWithT(t)
+		c := &k8sinit.Configuration{
+			Version:             "0.1.0",
+			ExtraContainerdArgs: k8sinit.ExtraArgs{"--log-level": &[]string{"debug"}[0]},
+			Containerd:          k8sinit.ContainerdConfiguration{ExtraArgs: k8sinit.ExtraArgs{"--log-level": &[]string{"info"}[0]}},
+		}
+		migrated, err := k8sinit.Migrate(c, "0.2.0")
+		g.Expect(err).To(BeNil())
+		g.Expect(*migrated.Containerd.ExtraArgs["--log-level"]).To(Equal("info"))
+	})
+
+	t.Run("SameVersion", func(t *testing.T) {
+		g := NewWithT(t)
+		c := &k8sinit.Configuration{Version: "0.2.0", ExtraContainerdArgs: k8sinit.ExtraArgs{"--log-level": &[]string{"debug"}[0]}}
+		migrated, err := k8sinit.Migrate(c, "0.2.0")
+		g.Expect(err).To(BeNil())
+		g.Expect(migrated).To(Equal(c))
+		g.Expect(migrated).ToNot(BeIdenticalTo(c))
+	})
+
+	for _, tc := range []struct {
+		name      string
+		version   string
+		toVersion string
+		expectErr string
+	}{
+		{name: "Downgrade", version: "0.2.0", toVersion: "0.1.0", expectErr: "cannot migrate config file version 0.2.0 to older version 0.1.0"},
+		{name: "UnknownPath", version: "0.1.5", toVersion: "0.2.0", expectErr: "no migration from config file version 0.1.5 to 0.2.0"},
+		{name: "UnknownTarget", version: "0.1.0", toVersion: "0.3.0", expectErr: "no migration from config file version 0.2.0 to 0.3.0"},
+		{name: "InvalidTarget", version: "0.1.0", toVersion: "latest", expectErr: `could not parse target config file version "latest"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			_, err := k8sinit.Migrate(&k8sinit.Configuration{Version: tc.version}, tc.toVersion)
+			g.Expect(err).To(MatchError(ContainSubstring(tc.expectErr)))
+		})
+	}
+}
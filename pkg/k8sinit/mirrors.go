@@ -0,0 +1,142 @@
+package k8sinit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// registryServer returns the URL of the upstream server of a registry host, e.g. "https://registry-1.docker.io" for "docker.io".
+func registryServer(host string) string {
+	if host == "docker.io" {
+		return "https://registry-1.docker.io"
+	}
+	return "https://" + host
+}
+
+// renderRegistryHosts renders the containerd hosts.toml file of registry mirrors. Mirrors are listed in order, so that
+// containerd tries them before the upstream server of the registry.
+func renderRegistryHosts(m RegistryMirrorConfiguration) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "server = %q\n", registryServer(m.Host))
+	for _, mirror := range m.Mirrors {
+		fmt.Fprintf(&b, "\n[host.%q]\n", mirror)
+		fmt.Fprintf(&b, "  capabilities = [\"pull\", \"resolve\"]\n")
+		if m.Username != "" {
+			auth := base64.StdEncoding.EncodeToString([]byte(m.Username + ":" + m.Password))
+			fmt.Fprintf(&b, "  [host.%q.header]\n", mirror)
+			fmt.Fprintf(&b, "    authorization = %q\n", "Basic "+auth)
+		}
+	}
+	return b.Bytes()
+}
+
+// reconcileRegistryMirrors writes (or removes) the hosts.toml files of registry mirrors. It returns true if any file was
+// changed.
+func (s *launcherScope) reconcileRegistryMirrors(ctx context.Context, mirrors []RegistryMirrorConfiguration) (bool, error) {
+	changed := false
+	for _, mirror := range mirrors {
+		host := mirror.Host
+		existing, err := s.snap.ReadContainerdRegistryConfig(host)
+		exists := err == nil
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("failed to read hosts.toml of registry %s: %w", host, err)
+		}
+
+		if mirror.Remove {
+			if !exists {
+				continue
+			}
+			if err := s.record(ctx, Action{Kind: ActionRemoveContainerdRegistryConfig, Target: host}, func() error { return s.snap.RemoveContainerdRegistryConfig(host) }); err != nil {
+				return false, fmt.Errorf("failed to remove hosts.toml of registry %s: %w", host, err)
+			}
+			changed = true
+			continue
+		}
+
+		hostsToml := renderRegistryHosts(mirror)
+		if exists && bytes.Equal(existing, hostsToml) {
+			continue
+		}
+		if err := s.record(ctx, Action{Kind: ActionWriteContainerdRegistryConfig, Target: host}, func() error {
+			return s.snap.UpdateContainerdRegistryConfigs(map[string][]byte{host: hostsToml})
+		}); err != nil {
+			return false, fmt.Errorf("failed to write hosts.toml of registry %s: %w", host, err)
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// isRegistryHost returns true if host is a registry host name or IP address with an optional port, e.g. "docker.io" or
+// "10.0.0.10:5000".
+func isRegistryHost(host string) bool {
+	name := host
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return false
+		}
+		name = h
+	}
+	return net.ParseIP(name) != nil || len(validation.IsDNS1123Subdomain(name)) == 0
+}
+
+// validateRegistryMirrors checks that registry mirrors have a valid and unique host, that mirrors are HTTP(S) URLs, and
+// that the hosts.toml file of a registry is not also set in ContainerdRegistryConfigs.
+func validateRegistryMirrors(c *Configuration) []error {
+	var errs []error
+	seen := make(map[string]struct{}, len(c.RegistryMirrors))
+	for idx, mirror := range c.RegistryMirrors {
+		if !isRegistryHost(mirror.Host) {
+			errs = append(errs, fmt.Errorf("registryMirrors[%d] host %q must be a registry host name, e.g. \"docker.io\" or \"registry.example.com:5000\"", idx, mirror.Host))
+		}
+		if _, ok := seen[mirror.Host]; ok {
+			errs = append(errs, fmt.Errorf("registryMirrors[%d] host %q is listed more than once", idx, mirror.Host))
+		}
+		seen[mirror.Host] = struct{}{}
+
+		switch {
+		case mirror.Remove && len(mirror.Mirrors) > 0:
+			errs = append(errs, fmt.Errorf("registryMirrors[%d] cannot both set and remove the mirrors of %q", idx, mirror.Host))
+		case !mirror.Remove && len(mirror.Mirrors) == 0:
+			errs = append(errs, fmt.Errorf("registryMirrors[%d] must have at least one mirror, or set remove to remove the mirrors of %q", idx, mirror.Host))
+		}
+		for mirrorIdx, mirrorURL := range mirror.Mirrors {
+			if u, err := url.Parse(mirrorURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(mirrorURL, "\"\n") {
+				errs = append(errs, fmt.Errorf("registryMirrors[%d].mirrors[%d] %q must be an http or https URL, e.g. \"https://mirror.example.com\"", idx, mirrorIdx, mirrorURL))
+			}
+		}
+		if mirror.Password != "" && mirror.Username == "" {
+			errs = append(errs, fmt.Errorf("registryMirrors[%d] password requires a username", idx))
+		}
+		if _, ok := c.ContainerdRegistryConfigs[mirror.Host]; ok {
+			errs = append(errs, fmt.Errorf("registryMirrors[%d] cannot be used together with containerdRegistryConfigs[%s], since both write the hosts.toml file of the registry", idx, mirror.Host))
+		}
+	}
+	return errs
+}
+
+// mergeRegistryMirrors merges the registry mirrors of src into dst by host, and returns the result.
+func mergeRegistryMirrors(dst []RegistryMirrorConfiguration, src []RegistryMirrorConfiguration) []RegistryMirrorConfiguration {
+nextMirror:
+	for _, mirror := range src {
+		mirror.Mirrors = cloneStrings(mirror.Mirrors)
+		for idx := range dst {
+			if dst[idx].Host == mirror.Host {
+				dst[idx] = mirror
+				continue nextMirror
+			}
+		}
+		dst = append(dst, mirror)
+	}
+	return dst
+}
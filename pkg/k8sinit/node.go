@@ -0,0 +1,87 @@
+package k8sinit
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
+)
+
+// NodeInfo is information about the local node. Addon arguments may reference its fields as template variables,
+// e.g. "--default-ssl-certificate={{.NodeName}}/tls".
+type NodeInfo struct {
+	// NodeName is the name of the local node.
+	NodeName string
+	// NodeIP is the primary IP address of the local node.
+	NodeIP string
+}
+
+// unknownTemplateFieldRegexp matches text/template errors for unknown fields.
+var unknownTemplateFieldRegexp = regexp.MustCompile(`can't evaluate field (\w+) in type`)
+
+// nodeInfoVariables returns the template variables of NodeInfo, e.g. "{{.NodeName}}".
+func nodeInfoVariables() []string {
+	t := reflect.TypeOf(NodeInfo{})
+	variables := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		variables = append(variables, fmt.Sprintf("{{.%s}}", t.Field(i).Name))
+	}
+	return variables
+}
+
+// isAddonArgumentTemplate returns true if an addon argument contains template actions.
+func isAddonArgumentTemplate(arg string) bool {
+	return strings.Contains(arg, "{{")
+}
+
+// resolveAddonArgument resolves the template variables of an addon argument.
+// Referencing an unknown template variable is an error.
+func resolveAddonArgument(arg string, info NodeInfo) (string, error) {
+	tmpl, err := template.New("arg").Parse(arg)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, info); err != nil {
+		if m := unknownTemplateFieldRegexp.FindStringSubmatch(err.Error()); len(m) == 2 {
+			return "", fmt.Errorf("unknown template variable %q (known variables are %s)", "."+m[1], strings.Join(nodeInfoVariables(), ", "))
+		}
+		return "", fmt.Errorf("failed to resolve template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// localNodeInfo returns information about the local node.
+// The node name and IP address configured for the kubelet take precedence over the hostname and the IP address of the default route.
+func localNodeInfo(s snap.Snap) (NodeInfo, error) {
+	nodeName := snaputil.GetServiceArgument(s, "kubelet", "--hostname-override")
+	if nodeName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return NodeInfo{}, fmt.Errorf("failed to get hostname: %w", err)
+		}
+		nodeName = strings.ToLower(hostname)
+	}
+
+	nodeIP := snaputil.GetServiceArgument(s, "kubelet", "--node-ip")
+	if nodeIP == "" {
+		// dialing UDP does not send any packets, it only selects the source address of the default route
+		conn, err := net.Dial("udp", "8.8.8.8:53")
+		if err != nil {
+			return NodeInfo{}, fmt.Errorf("failed to find the IP address of the default route: %w", err)
+		}
+		defer conn.Close()
+		nodeIP = conn.LocalAddr().(*net.UDPAddr).IP.String()
+	} else if idx := strings.Index(nodeIP, ","); idx != -1 {
+		// dual-stack nodes list multiple IP addresses, the first one is the primary
+		nodeIP = nodeIP[:idx]
+	}
+
+	return NodeInfo{NodeName: nodeName, NodeIP: nodeIP}, nil
+}
@@ -0,0 +1,120 @@
+package k8sinit
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+const (
+	// oidcCAFile is the file in $SNAP_DATA/args that an inline OIDC CA certificate is written to.
+	oidcCAFile = "oidc-ca.crt"
+
+	// oidcIssuerURLArg is the kube-apiserver argument of the OIDC issuer URL.
+	oidcIssuerURLArg = "--oidc-issuer-url"
+	// oidcClientIDArg is the kube-apiserver argument of the OIDC client ID.
+	oidcClientIDArg = "--oidc-client-id"
+	// oidcUsernameClaimArg is the kube-apiserver argument of the OIDC username claim.
+	oidcUsernameClaimArg = "--oidc-username-claim"
+	// oidcGroupsClaimArg is the kube-apiserver argument of the OIDC groups claim.
+	oidcGroupsClaimArg = "--oidc-groups-claim"
+	// oidcCAFileArg is the kube-apiserver argument pointing to the CA certificate of the OIDC issuer.
+	oidcCAFileArg = "--oidc-ca-file"
+)
+
+// oidcArgs returns the kube-apiserver arguments of an OIDC configuration. Arguments of optional fields that are not set
+// are empty, so that they are removed.
+func oidcArgs(o OIDCConfiguration) map[string]string {
+	caFile := o.CAFile
+	if o.CA != "" {
+		caFile = "${SNAP_DATA}/args/" + oidcCAFile
+	}
+	return map[string]string{
+		oidcIssuerURLArg:     o.IssuerURL,
+		oidcClientIDArg:      o.ClientID,
+		oidcUsernameClaimArg: o.UsernameClaim,
+		oidcGroupsClaimArg:   o.GroupsClaim,
+		oidcCAFileArg:        caFile,
+	}
+}
+
+// withOIDCArgs returns the configuration with the OIDC configuration translated to kube-apiserver arguments. Arguments
+// of optional fields that are not set are removed. The configuration is returned as-is if it does not configure OIDC,
+// otherwise a copy is returned.
+func (c *Configuration) withOIDCArgs() *Configuration {
+	if c.OIDC == nil {
+		return c
+	}
+	args := oidcArgs(*c.OIDC)
+	c = c.Clone()
+	if c.ExtraKubeAPIServerArgs == nil {
+		c.ExtraKubeAPIServerArgs = make(ExtraArgs)
+	}
+	for _, arg := range util.SortedKeys(args) {
+		if value := args[arg]; value != "" {
+			c.ExtraKubeAPIServerArgs[arg] = &value
+		} else {
+			c.ExtraKubeAPIServerArgs[arg] = nil
+		}
+	}
+	return c
+}
+
+// reconcileOIDCCA writes the inline CA certificate of the OIDC issuer. It returns true if the file was changed.
+func (s *launcherScope) reconcileOIDCCA(ctx context.Context, ca string) (bool, error) {
+	if existing, err := s.snap.ReadServiceArguments(oidcCAFile); err == nil && existing == ca {
+		return false, nil
+	}
+	if err := s.record(ctx, Action{Kind: ActionWriteConfigFile, Target: oidcCAFile}, func() error { return s.snap.WriteServiceArguments(oidcCAFile, []byte(ca)) }); err != nil {
+		return false, fmt.Errorf("failed to write OIDC CA certificate: %w", err)
+	}
+	return true, nil
+}
+
+// validateOIDC checks that the OIDC issuer URL is an https URL, that the issuer URL and client ID are set together, that
+// the CA certificate is either a file or an inline PEM certificate, and that the kube-apiserver arguments of the OIDC
+// configuration are not also set in ExtraKubeAPIServerArgs.
+func validateOIDC(c *Configuration) []error {
+	o := c.OIDC
+	if o == nil {
+		return nil
+	}
+
+	var errs []error
+	if o.IssuerURL == "" {
+		errs = append(errs, fmt.Errorf("oidc.issuerURL is required"))
+	} else if u, err := url.Parse(o.IssuerURL); err != nil || u.Scheme != "https" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("oidc.issuerURL %q must be an https URL, e.g. \"https://accounts.example.com\"", o.IssuerURL))
+	}
+	if o.ClientID == "" {
+		errs = append(errs, fmt.Errorf("oidc.clientID is required"))
+	}
+
+	switch {
+	case o.CAFile != "" && o.CA != "":
+		errs = append(errs, fmt.Errorf("oidc.caFile and oidc.ca cannot be used together"))
+	case o.CAFile != "" && !filepath.IsAbs(o.CAFile) && !strings.HasPrefix(o.CAFile, "${"):
+		errs = append(errs, fmt.Errorf("oidc.caFile %q must be an absolute path", o.CAFile))
+	case o.CA != "":
+		if block, _ := pem.Decode([]byte(o.CA)); block == nil || block.Type != "CERTIFICATE" {
+			errs = append(errs, fmt.Errorf("oidc.ca must be a PEM-encoded certificate"))
+		}
+		if _, ok := c.ExtraConfigFiles[oidcCAFile]; ok {
+			errs = append(errs, fmt.Errorf("oidc.ca cannot be used together with extraConfigFiles[%s], since both write the OIDC CA certificate", oidcCAFile))
+		}
+	}
+
+	args := oidcArgs(*o)
+	for _, key := range util.SortedKeys(c.ExtraKubeAPIServerArgs) {
+		arg := argFlag(normalizeArgKey(key))
+		if _, ok := args[arg]; ok {
+			errs = append(errs, fmt.Errorf("oidc cannot be used together with extraKubeAPIServerArgs[%s], since both set the kube-apiserver %q argument", key, arg))
+		}
+	}
+	return errs
+}
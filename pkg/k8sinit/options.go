@@ -0,0 +1,273 @@
+package k8sinit
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"time"
+)
+
+// ParseOptions configures how configuration files are parsed.
+type ParseOptions struct {
+	// Strict fails parsing if the configuration contains unknown fields or duplicate keys.
+	// By default, unknown fields are ignored and reported as warnings. For multi-part configurations, Strict applies to
+	// every part, as well as to included files and to all files of a configuration directory.
+	Strict bool
+
+	// ExpandEnv expands environment variable references (e.g. "${NODE_IP}") in ExtraSANs, extra arguments values and arguments of the list form.
+	// Use "$$" for a literal "$".
+	ExpandEnv bool
+	// AllowEmptyEnv expands undefined environment variables to an empty string instead of failing.
+	AllowEmptyEnv bool
+	// LookupEnv is used to retrieve environment variables. If nil, os.LookupEnv is used.
+	LookupEnv func(string) (string, bool)
+
+	// Validation configures how parsed configurations are validated.
+	Validation ValidateOptions
+
+	// ExactVersions is the list of config file versions that are accepted, e.g. []string{"0.1.0"}. By default, any version
+	// within the supported range is accepted (e.g. "0.1.5"). Setting ExactVersions catches drift between the tool that
+	// generates configurations and the agent.
+	ExactVersions []string
+
+	// Logger is used to report warnings. If nil, DefaultLogger is used.
+	Logger Logger
+
+	// Metrics is used to count parsed configurations and parse errors. If nil, DefaultMetrics is used.
+	Metrics Metrics
+
+	// MaxSize is the maximum size in bytes of a multi-part configuration. If 0, DefaultMaxConfigSize is used.
+	MaxSize int
+	// MaxParts is the maximum number of documents of a multi-part configuration. If 0, DefaultMaxConfigParts is used.
+	MaxParts int
+
+	// SourceName is a name for the parsed data (e.g. the file name), used to identify the Source of each configuration part.
+	SourceName string
+
+	// IncludeFS is the directory that included configuration files are read from. Includes cannot refer to files outside
+	// of IncludeFS. If nil, configurations that include other files are rejected.
+	// Note that the directory returned by os.DirFS follows symbolic links.
+	IncludeFS fs.FS
+	// IncludeDir is the directory, relative to the root of IncludeFS, that include paths are relative to.
+	// If empty, include paths are relative to the root of IncludeFS.
+	IncludeDir string
+
+	// includeChain is the list of files currently being included, used to detect circular includes.
+	includeChain []string
+}
+
+const (
+	// DefaultMaxConfigSize is the default maximum size in bytes of a multi-part configuration.
+	DefaultMaxConfigSize = 1 << 20
+	// DefaultMaxConfigParts is the default maximum number of documents of a multi-part configuration.
+	DefaultMaxConfigParts = 64
+)
+
+// ValidateOptions configures how configurations are validated.
+type ValidateOptions struct {
+	// KnownAddons is the list of addon names that may be enabled or disabled. If nil, DefaultKnownAddons is used.
+	KnownAddons []string
+
+	// MaxAddonArguments is the maximum number of arguments of each addon. If 0, DefaultMaxAddonArguments is used.
+	MaxAddonArguments int
+	// MaxAddonArgumentsSize is the maximum total size in bytes of the arguments of each addon. If 0, DefaultMaxAddonArgumentsSize is used.
+	MaxAddonArgumentsSize int
+
+	// ResolveDuplicateAddons allows listing the same addon multiple times in a configuration. When parsing, the last entry
+	// of each addon is used and a warning is logged for each duplicate. By default, duplicate addons are an error.
+	ResolveDuplicateAddons bool
+
+	// NodeRole is the role of the node that the configuration is applied to. When parsing, a warning is reported for
+	// each field that has no effect on nodes with this role (see Configuration.RoleWarnings). If empty, the role is
+	// unknown and fields are not checked.
+	NodeRole NodeRole
+
+	// AddonArgConflicts are the known arguments of services that are managed by addons. When parsing, a warning is
+	// reported for each of them that is set while its addon is also enabled (see Configuration.AddonConflictWarnings).
+	// If nil, DefaultAddonArgConflicts is used.
+	AddonArgConflicts []AddonArgConflict
+}
+
+const (
+	// DefaultMaxAddonArguments is the default maximum number of arguments of each addon.
+	DefaultMaxAddonArguments = 64
+	// DefaultMaxAddonArgumentsSize is the default maximum total size in bytes of the arguments of each addon.
+	DefaultMaxAddonArgumentsSize = 64 << 10
+)
+
+// DefaultKnownAddons is the list of known MicroK8s addons from the core and community repositories.
+var DefaultKnownAddons = []string{
+	// core addons
+	"cert-manager", "cis-hardening", "community", "dashboard", "dns", "gpu", "ha-cluster", "helm", "helm3",
+	"host-access", "hostpath-storage", "ingress", "kube-ovn", "mayastor", "metallb", "metrics-server", "minio",
+	"observability", "prometheus", "rbac", "registry", "storage",
+	// community addons
+	"argocd", "cilium", "dashboard-ingress", "easyhaproxy", "fluentd", "gopaddle-lite", "inaccel", "istio",
+	"jaeger", "kata", "keda", "knative", "kwasm", "linkerd", "multus", "nfs", "ondat", "openebs", "openfaas",
+	"osm-edge", "portainer", "sosivio", "traefik", "trivy",
+}
+
+// MergeOptions configures how multi-part configurations are merged.
+type MergeOptions struct {
+	// Strict fails the merge if two parts set the same extra argument to different values.
+	// By default, later parts silently override earlier ones.
+	Strict bool
+}
+
+// ApplyOptions configures how configurations are applied to the local node.
+type ApplyOptions struct {
+	// DryRun computes and logs the actions needed to apply the configuration, without performing any changes.
+	DryRun bool
+
+	// PreserveAddonOrder enables and disables addons in the order they are listed.
+	// By default, all addons are disabled first, then addons are enabled by ascending priority, and after the addons
+	// they depend on (see AddonDependencies).
+	PreserveAddonOrder bool
+
+	// AddonDependencies are the dependencies between addons, by addon name (e.g. "ingress": {"dns"}). Addons are enabled
+	// after the addons they depend on, if those are also enabled by the configuration, even if they have a lower priority.
+	// Circular dependencies between enabled addons fail the apply before any addons are enabled.
+	// If nil, DefaultAddonDependencies is used. AddonDependencies is ignored if PreserveAddonOrder is set.
+	AddonDependencies map[string][]string
+
+	// Logger is used to report progress and warnings. If nil, DefaultLogger is used.
+	Logger Logger
+
+	// Metrics is used to count applied configurations and restarted services. If nil, DefaultMetrics is used.
+	Metrics Metrics
+
+	// Force applies the configuration even if it is identical to the last applied configuration.
+	// By default, applying an unchanged configuration is a no-op.
+	Force bool
+
+	// MaxRetries is the maximum number of times that enabling or disabling an addon, or restarting a service, is retried
+	// if it fails with a retriable error: the command exited with a non-zero exit code, and the addon timeout (if any)
+	// did not expire. If 0, operations are not retried.
+	MaxRetries int
+	// Backoff is the delay before the first retry, which is doubled for each further retry. If 0, DefaultRetryBackoff is used.
+	Backoff time.Duration
+	// RetryPatterns are regular expressions matched against the error message of failed commands, which includes the end
+	// of their stderr output. If set, only errors that match one of the patterns are retried, e.g. []string{"connection reset by peer"}.
+	RetryPatterns []string
+	// RetrySleep waits before a retry, and returns an error if ctx is done first. If nil, a timer is used.
+	RetrySleep func(ctx context.Context, d time.Duration) error
+
+	// SkipAddons applies the configuration without its addons and addon repositories, e.g. to configure the services of a
+	// new node first and enable addons in a later stage with OnlyAddons. The addons that were not enabled or disabled are
+	// reported in ApplyResult.DeferredAddons.
+	// Since only part of the configuration is applied, the configuration is not recorded as the last applied configuration.
+	SkipAddons bool
+	// OnlyAddons only applies the addons and addon repositories of the configuration, see SkipAddons. Hooks are run
+	// regardless of SkipAddons and OnlyAddons. SkipAddons and OnlyAddons cannot be used together.
+	OnlyAddons bool
+
+	// AddonWorkers is the maximum number of addons that are enabled in parallel. Addons are only enabled in parallel
+	// if they have the same priority, and after all addons have been disabled. If 0 or 1, addons are enabled one at a time.
+	// AddonWorkers is ignored if PreserveAddonOrder is set.
+	AddonWorkers int
+
+	// NodeInfo is used to resolve template variables in addon arguments. It is only called if an addon argument
+	// contains a template. If nil, the node name and IP are detected from the kubelet arguments and the local host.
+	NodeInfo func() (NodeInfo, error)
+
+	// InterfaceAddrs is used to expand ExtraSANs interface entries (e.g. "interface:eth0") to the IP addresses of the
+	// network interface. It must return an error if the interface does not exist. If nil, the local network interfaces are used.
+	InterfaceAddrs func(name string) ([]net.IP, error)
+
+	// Timeout is the maximum duration of applying the configuration, after which no further actions are started.
+	// If 0, there is no timeout other than the deadline of the context.
+	Timeout time.Duration
+
+	// Locker ensures that only one configuration is applied at a time. If nil, DefaultApplyLocker is used.
+	Locker ApplyLocker
+	// LockTimeout is the maximum duration to wait for another apply to finish. If 0, DefaultApplyLockTimeout is used.
+	LockTimeout time.Duration
+
+	// CommandRunner is used to run hook commands, writing their output to stdout and stderr.
+	// If nil, util.RunCommandWithOutput is used.
+	CommandRunner func(ctx context.Context, stdout io.Writer, stderr io.Writer, command ...string) error
+
+	// SecretPatterns are used to mask secret values in logged actions (see Redacted). If nil, DefaultSecretPatterns is used.
+	SecretPatterns []string
+
+	// StatePath is the file that the last successfully applied configuration is stored in, as JSON. If set, only the
+	// sections that changed since the last applied configuration are applied, e.g. addons are not enabled again if the
+	// addons did not change. If the file is missing or corrupted, or if Force is set, the full configuration is applied.
+	// Changes made outside of launch configurations are not detected for unchanged sections.
+	StatePath string
+
+	// PendingPath is the file that a configuration applied with ApplyStaged is stored in until it is confirmed with
+	// ConfirmApply, as JSON: the previous contents of the changed files and the deadline of the confirmation.
+	// It is required by ApplyStaged.
+	PendingPath string
+	// ConfirmTimeout is the duration within which a configuration applied with ApplyStaged must be confirmed, after which
+	// it is rolled back. If 0, DefaultConfirmTimeout is used.
+	ConfirmTimeout time.Duration
+
+	// ArgFileDirs are the directories that extra argument values of the form "file:/path" may refer to, e.g.
+	// []string{"/etc/microk8s/secrets"}. Such values are replaced by the contents of the file when the configuration is
+	// applied, without trailing newlines, so that secrets are not part of the configuration. Referring to a file outside of
+	// ArgFileDirs, or to a missing file, fails the apply. If empty, no files may be referred to.
+	ArgFileDirs []string
+	// ArgFileFS is the file system that files referred to by extra argument values are read from, with paths relative to
+	// the root directory. If nil, os.DirFS("/") is used. Note that the directory returned by os.DirFS follows symbolic links.
+	ArgFileFS fs.FS
+
+	// NodeRole is used to detect the role of the local node, for configuration parts that only apply to specific
+	// roles (see Configuration.Roles). If nil, nodes that joined a cluster as worker-only nodes are workers, and all
+	// other nodes are control plane nodes.
+	NodeRole func() (NodeRole, error)
+
+	// UnknownFlags checks the extra arguments of the Kubernetes services against the flags of the installed binaries
+	// before any changes are made, to catch flags that are not supported by the installed Kubernetes version.
+	// By default, extra arguments are not checked.
+	UnknownFlags UnknownFlagPolicy
+	// FlagSets is used to discover the flags of the installed binaries. If nil, the "--help" output of the binaries
+	// in $SNAP is parsed (see NewHelpFlagSetSource).
+	FlagSets FlagSetSource
+
+	// Preflight checks the local node before any changes are made: PreflightDataDir must have at least
+	// PreflightMinFreeDisk bytes of free disk space, and the services whose arguments are set by the configuration
+	// must be running. If a check fails, a *PreflightError is returned, unless Force is set.
+	Preflight bool
+	// PreflightDataDir is the directory whose free disk space is checked. If empty, $SNAP_DATA is used.
+	PreflightDataDir string
+	// PreflightMinFreeDisk is the minimum free disk space in bytes. If 0, DefaultPreflightMinFreeDisk is used.
+	PreflightMinFreeDisk uint64
+	// FreeDiskSpace returns the free disk space in bytes of the file system of a path. If nil, statfs is used.
+	FreeDiskSpace func(path string) (uint64, error)
+	// ServiceRunning returns true if a MicroK8s service (e.g. "kubelite") is running. If nil, the output of
+	// "snapctl services" is used (see NewSnapctlServiceRunning).
+	ServiceRunning func(ctx context.Context, service string) (bool, error)
+}
+
+// RemoteApplyOptions configures how configurations are applied to remote nodes (see RemoteApply).
+type RemoteApplyOptions struct {
+	// Transport is used to apply the configuration on each node. It is required.
+	Transport RemoteTransport
+
+	// Validation configures how the configuration is validated before it is sent to the nodes.
+	Validation ValidateOptions
+
+	// DryRun computes the actions needed to apply the configuration on each node, without performing any changes.
+	DryRun bool
+	// Force applies the configuration on each node even if it is identical to the last applied configuration.
+	Force bool
+
+	// Workers is the maximum number of nodes that the configuration is applied on in parallel. If 0 or 1, the
+	// configuration is applied on one node at a time.
+	Workers int
+
+	// Logger is used to report progress and warnings. If nil, DefaultLogger is used.
+	Logger Logger
+}
+
+// MarshalOptions configures how configurations are serialized.
+type MarshalOptions struct {
+	// RedactSecrets masks secret values in the output (see Redacted). The configuration itself is not changed.
+	RedactSecrets bool
+
+	// SecretPatterns are the patterns used to detect secret values. If nil, DefaultSecretPatterns is used.
+	SecretPatterns []string
+}
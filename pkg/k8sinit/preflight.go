@@ -0,0 +1,152 @@
+package k8sinit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// DefaultPreflightMinFreeDisk is the default minimum free disk space in bytes required by the preflight checks.
+const DefaultPreflightMinFreeDisk = 512 << 20
+
+// PreflightError is returned when the preflight checks of the local node fail before applying a configuration.
+type PreflightError struct {
+	// Errors are the failed checks.
+	Errors []error
+}
+
+// Error implements the error interface.
+func (e *PreflightError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d preflight check(s) failed, no changes were made: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the list of failed checks.
+func (e *PreflightError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is reports whether any failed check matches target.
+func (e *PreflightError) Is(target error) bool {
+	return isAnyError(e.Errors, target)
+}
+
+// As finds the first failed check that matches target.
+func (e *PreflightError) As(target interface{}) bool {
+	return asAnyError(e.Errors, target)
+}
+
+// statfsFreeDiskSpace returns the disk space in bytes available to unprivileged users on the file system of path.
+func statfsFreeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("failed to query free disk space of %s: %w", path, err)
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// NewSnapctlServiceRunning returns a function that checks if a MicroK8s service (e.g. "kubelite") is running, using the
+// output of "snapctl services". runCommand is used to run snapctl. If nil, util.RunCommandWithOutput is used.
+func NewSnapctlServiceRunning(runCommand func(ctx context.Context, stdout io.Writer, stderr io.Writer, command ...string) error) func(ctx context.Context, service string) (bool, error) {
+	if runCommand == nil {
+		runCommand = util.RunCommandWithOutput
+	}
+	return func(ctx context.Context, service string) (bool, error) {
+		var stdout, stderr bytes.Buffer
+		name := "microk8s.daemon-" + service
+		if err := runCommand(ctx, &stdout, &stderr, "snapctl", "services", name); err != nil {
+			return false, fmt.Errorf("failed to query status of service %s: %w", service, err)
+		}
+		// Service                   Startup  Current  Notes
+		// microk8s.daemon-kubelite  enabled  active   -
+		for _, line := range strings.Split(stdout.String(), "\n") {
+			if fields := strings.Fields(line); len(fields) >= 3 && fields[0] == name {
+				return fields[2] == "active", nil
+			}
+		}
+		return false, fmt.Errorf("service %s not found in the output of snapctl services", service)
+	}
+}
+
+// targetedServices returns the services whose arguments are set by the configuration, and may be restarted to apply it.
+func targetedServices(c MultiPartConfiguration) []string {
+	services := make(map[string]struct{})
+	for _, part := range c.Parts {
+		if part == nil {
+			continue
+		}
+		for _, field := range part.serviceArgsFields() {
+			if len(*field.args) == 0 {
+				continue
+			}
+			for _, service := range field.restartServices {
+				services[service] = struct{}{}
+			}
+		}
+	}
+	return util.SortedKeys(services)
+}
+
+// preflight checks that the local node has enough free disk space, and that the services targeted by the configuration
+// are running, according to opts.Preflight. Services are not checked in pre-init mode, since they are not started yet.
+// If opts.Force is set, failed checks are logged and the configuration is applied anyway.
+func (s *launcherScope) preflight(ctx context.Context, c MultiPartConfiguration) error {
+	if !s.opts.Preflight {
+		return nil
+	}
+
+	var errs []error
+	dataDir := s.opts.PreflightDataDir
+	if dataDir == "" {
+		dataDir = os.Getenv("SNAP_DATA")
+	}
+	if dataDir != "" {
+		freeDiskSpace := s.opts.FreeDiskSpace
+		if freeDiskSpace == nil {
+			freeDiskSpace = statfsFreeDiskSpace
+		}
+		minFree := s.opts.PreflightMinFreeDisk
+		if minFree == 0 {
+			minFree = DefaultPreflightMinFreeDisk
+		}
+		if free, err := freeDiskSpace(dataDir); err != nil {
+			errs = append(errs, err)
+		} else if free < minFree {
+			errs = append(errs, fmt.Errorf("only %d MiB of disk space are free in %s, but at least %d MiB are required; free up disk space before applying the configuration", free>>20, dataDir, minFree>>20))
+		}
+	}
+
+	if !s.launcher.preInit {
+		serviceRunning := s.opts.ServiceRunning
+		if serviceRunning == nil {
+			serviceRunning = NewSnapctlServiceRunning(nil)
+		}
+		for _, service := range targetedServices(c) {
+			if running, err := serviceRunning(ctx, service); err != nil {
+				errs = append(errs, err)
+			} else if !running {
+				errs = append(errs, fmt.Errorf("service %s is not running; check its logs with 'journalctl -u snap.microk8s.daemon-%s' and fix it before applying the configuration", service, service))
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if s.opts.Force {
+		for _, err := range errs {
+			s.logger.Warnf("preflight check failed, applying the configuration anyway since force is set: %v", err)
+		}
+		return nil
+	}
+	return &PreflightError{Errors: errs}
+}
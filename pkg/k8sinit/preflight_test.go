@@ -0,0 +1,137 @@
+package k8sinit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestPreflight(t *testing.T) {
+	c := k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{{
+		Version:          "0.1.0",
+		ExtraKubeletArgs: k8sinit.ExtraArgs{"--max-pods": &[]string{"200"}[0]},
+	}}}
+	options := func(free uint64, running map[string]bool) k8sinit.ApplyOptions {
+		return k8sinit.ApplyOptions{
+			Preflight:        true,
+			PreflightDataDir: "/var/snap/microk8s/current",
+			FreeDiskSpace: func(path string) (uint64, error) {
+				if path != "/var/snap/microk8s/current" {
+					return 0, fmt.Errorf("unexpected path %s", path)
+				}
+				return free, nil
+			},
+			ServiceRunning: func(ctx context.Context, service string) (bool, error) {
+				return running[service], nil
+			},
+		}
+	}
+
+	t.Run("Pass", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		_, err := k8sinit.NewLauncher(s, false).ApplyWithOptions(context.Background(), c, options(1<<30, map[string]bool{"kubelite": true}))
+		g.Expect(err).To(BeNil())
+		g.Expect(s.ServiceArguments["kubelet"]).To(ContainSubstring("--max-pods=200"))
+	})
+
+	t.Run("LowDisk", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		_, err := k8sinit.NewLauncher(s, false).ApplyWithOptions(context.Background(), c, options(100<<20, map[string]bool{"kubelite": true}))
+		var preflightErr *k8sinit.PreflightError
+		g.Expect(errors.As(err, &preflightErr)).To(BeTrue())
+		g.Expect(preflightErr.Errors).To(HaveLen(1))
+		g.Expect(err).To(MatchError(ContainSubstring("only 100 MiB of disk space are free in /var/snap/microk8s/current, but at least 512 MiB are required")))
+		g.Expect(s.ServiceArguments).To(BeEmpty())
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+
+	t.Run("ServiceNotRunning", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		_, err := k8sinit.NewLauncher(s, false).ApplyWithOptions(context.Background(), c, options(1<<30, nil))
+		g.Expect(err).To(MatchError(ContainSubstring("service kubelite is not running")))
+		g.Expect(s.ServiceArguments).To(BeEmpty())
+	})
+
+	t.Run("Force", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		logger := &recordingLogger{}
+		opts := options(100<<20, nil)
+		opts.Force = true
+		opts.Logger = logger
+		_, err := k8sinit.NewLauncher(s, false).ApplyWithOptions(context.Background(), c, opts)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.ServiceArguments["kubelet"]).To(ContainSubstring("--max-pods=200"))
+		g.Expect(logger.warnings).To(HaveLen(2))
+		g.Expect(logger.warnings[0]).To(HavePrefix("preflight check failed, applying the configuration anyway since force is set: only 100 MiB"))
+	})
+
+	t.Run("PreInit", func(t *testing.T) {
+		// services are not running yet in pre-init mode
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		_, err := k8sinit.NewLauncher(s, true).ApplyWithOptions(context.Background(), c, options(1<<30, nil))
+		g.Expect(err).To(BeNil())
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		opts := options(0, nil)
+		opts.Preflight = false
+		_, err := k8sinit.NewLauncher(s, false).ApplyWithOptions(context.Background(), c, opts)
+		g.Expect(err).To(BeNil())
+	})
+}
+
+func TestSnapctlServiceRunning(t *testing.T) {
+	runCommand := func(output string) func(ctx context.Context, stdout io.Writer, stderr io.Writer, command ...string) error {
+		return func(ctx context.Context, stdout io.Writer, stderr io.Writer, command ...string) error {
+			if len(command) != 3 || command[0] != "snapctl" || command[1] != "services" {
+				return fmt.Errorf("unexpected command %v", command)
+			}
+			fmt.Fprint(stdout, output)
+			return nil
+		}
+	}
+
+	for _, tc := range []struct {
+		name          string
+		output        string
+		expectRunning bool
+		expectErr     bool
+	}{
+		{name: "Active", output: "Service                   Startup  Current  Notes\nmicrok8s.daemon-kubelite  enabled  active   -\n", expectRunning: true},
+		{name: "Inactive", output: "Service                   Startup  Current   Notes\nmicrok8s.daemon-kubelite  enabled  inactive  -\n"},
+		{name: "NotFound", output: "Service  Startup  Current  Notes\n", expectErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			running, err := k8sinit.NewSnapctlServiceRunning(runCommand(tc.output))(context.Background(), "kubelite")
+			if tc.expectErr {
+				g.Expect(err).ToNot(BeNil())
+			} else {
+				g.Expect(err).To(BeNil())
+			}
+			g.Expect(running).To(Equal(tc.expectRunning))
+		})
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.NewSnapctlServiceRunning(func(ctx context.Context, stdout io.Writer, stderr io.Writer, command ...string) error {
+			return errors.New("snapctl not found")
+		})(context.Background(), "kubelite")
+		g.Expect(err).To(MatchError(ContainSubstring("snapctl not found")))
+	})
+}
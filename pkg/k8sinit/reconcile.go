@@ -0,0 +1,106 @@
+package k8sinit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Reconcile re-applies the last applied configuration (see ApplyOptions.StatePath) to the arguments files that drifted
+// from it, e.g. after the files were edited manually, and restarts the affected services according to the restart policy.
+// An arguments file has drifted if applying the last applied configuration would change it (see ArgsRenderer). Files that
+// did not drift are not written, so reconciling a node that matches the last applied configuration is a no-op.
+//
+// Only the arguments of the services are reconciled. Addons and the other sections of the configuration are not applied
+// again, and the last applied configuration is left untouched.
+func (l *Launcher) Reconcile(ctx context.Context, opts ApplyOptions) (*ApplyResult, error) {
+	if opts.StatePath == "" {
+		return &ApplyResult{}, fmt.Errorf("the state path of the last applied configuration must be set to reconcile it")
+	}
+	if !opts.DryRun {
+		unlock, err := lockApply(ctx, opts)
+		if err != nil {
+			return &ApplyResult{}, err
+		}
+		defer unlock()
+	}
+
+	s, rollback := l.newScope(opts)
+	last, _ := s.loadAppliedState(opts.StatePath)
+	if last == nil {
+		s.logger.Infof("No last applied configuration found, nothing to reconcile")
+		return s.result, nil
+	}
+	drift, err := s.driftedArgs(last)
+	if err != nil {
+		return s.result, err
+	}
+	if drift == nil {
+		s.logger.Infof("Arguments files match the last applied configuration, nothing to reconcile")
+		return s.result, nil
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	if err := s.apply(ctx, MultiPartConfiguration{Parts: []*Configuration{drift}}); err != nil {
+		return s.result, s.rollback(ctx, rollback, err)
+	}
+	if err := s.writeAppliedArgs(); err != nil {
+		s.logger.Warnf("failed to record applied arguments: %v", err)
+	}
+	return s.result, nil
+}
+
+// driftedArgs returns a configuration with the extra arguments of the last applied configuration (including arguments
+// derived from other sections, e.g. node labels) for the arguments files that drifted from it, or nil if no file drifted.
+func (s *launcherScope) driftedArgs(last *Configuration) (*Configuration, error) {
+	var role NodeRole
+	if len(last.Roles) > 0 {
+		var err error
+		if role, err = s.nodeRole(); err != nil {
+			return nil, fmt.Errorf("failed to detect node role: %w", err)
+		}
+	}
+	resolved, err := s.withArgFiles(last)
+	if err != nil {
+		return nil, err
+	}
+	renderer := NewArgsRenderer(MultiPartConfiguration{Parts: []*Configuration{resolved}}, s.snap.ReadServiceArguments, role)
+
+	drifted := make(map[string]struct{})
+	for _, field := range resolved.serviceArgsFields() {
+		if _, ok := drifted[field.configFile]; ok {
+			continue
+		}
+		expected, err := renderer.RenderArgs(field.configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render arguments of %s: %w", field.configFile, err)
+		}
+		contents, err := s.snap.ReadServiceArguments(field.configFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read arguments of %s: %w", field.configFile, err)
+		}
+		if strings.Join(argLines(contents), "\n") != strings.Join(expected, "\n") {
+			s.logger.Warnf("Arguments of %s drifted from the last applied configuration, reconciling them", field.configFile)
+			drifted[field.configFile] = struct{}{}
+		}
+	}
+	if len(drifted) == 0 {
+		return nil, nil
+	}
+
+	translated := s.withDerivedArgs(resolved)
+	drift := &Configuration{Version: last.Version, RestartServices: last.RestartServices}
+	driftFields := drift.serviceArgsFields()
+	for idx, field := range translated.serviceArgsFields() {
+		if _, ok := drifted[field.configFile]; ok {
+			*driftFields[idx].args = *field.args
+		}
+	}
+	return drift, nil
+}
@@ -0,0 +1,107 @@
+package k8sinit_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestReconcile(t *testing.T) {
+	c, err := k8sinit.ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+extraKubeletArgs:
+  --max-pods: "200"
+nodeLabels:
+  zone: a
+extraKubeAPIServerArgs:
+  --event-ttl: 1h
+`))
+	if err != nil {
+		t.Fatalf("failed to parse configuration: %v", err)
+	}
+	setup := func(t *testing.T) (*mock.Snap, *k8sinit.Launcher, k8sinit.ApplyOptions) {
+		s := &mock.Snap{
+			ServiceArguments: map[string]string{
+				"kubelet":        "--kubeconfig=${SNAP_DATA}/credentials/kubelet.config\n",
+				"kube-apiserver": "--secure-port=16443\n",
+			},
+		}
+		l := k8sinit.NewLauncher(s, false)
+		opts := k8sinit.ApplyOptions{StatePath: filepath.Join(t.TempDir(), "last-applied.json")}
+		if _, err := l.ApplyWithOptions(context.Background(), c, opts); err != nil {
+			t.Fatalf("failed to apply configuration: %v", err)
+		}
+		s.RestartServiceCalledWith = nil
+		return s, l, opts
+	}
+	kubeletArgs := snaputil.ManagedArgsHeader + "\n--kubeconfig=${SNAP_DATA}/credentials/kubelet.config\n--max-pods=200\n--node-labels=zone=a\n"
+
+	t.Run("NoDrift", func(t *testing.T) {
+		s, l, opts := setup(t)
+		apiserverArgs := s.ServiceArguments["kube-apiserver"]
+
+		result, err := l.Reconcile(context.Background(), opts)
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(result.Actions).To(BeEmpty())
+		g.Expect(s.ServiceArguments["kubelet"]).To(Equal(kubeletArgs))
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(apiserverArgs))
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+
+	t.Run("Drift", func(t *testing.T) {
+		s, l, opts := setup(t)
+		apiserverArgs := s.ServiceArguments["kube-apiserver"]
+		s.ServiceArguments["kubelet"] = "--kubeconfig=${SNAP_DATA}/credentials/kubelet.config\n--max-pods=300\n--v=4\n"
+
+		result, err := l.Reconcile(context.Background(), opts)
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(result.Actions).To(ConsistOf(
+			HaveField("Target", "kubelet"),
+			HaveField("Target", "kubelite"),
+		))
+		g.Expect(s.ServiceArguments["kubelet"]).To(Equal(snaputil.ManagedArgsHeader + "\n--kubeconfig=${SNAP_DATA}/credentials/kubelet.config\n--max-pods=200\n--v=4\n--node-labels=zone=a\n"))
+		g.Expect(s.ServiceArguments["kube-apiserver"]).To(Equal(apiserverArgs))
+		g.Expect(s.RestartServiceCalledWith).To(Equal([]string{"kubelite"}))
+
+		t.Run("Reconciled", func(t *testing.T) {
+			result, err := l.Reconcile(context.Background(), opts)
+			g := NewWithT(t)
+			g.Expect(err).To(BeNil())
+			g.Expect(result.Actions).To(BeEmpty())
+		})
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		s, l, opts := setup(t)
+		s.ServiceArguments["kubelet"] = "--max-pods=300\n"
+
+		opts.DryRun = true
+		result, err := l.Reconcile(context.Background(), opts)
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(result.Actions).To(ContainElement(HaveField("Target", "kubelet")))
+		g.Expect(s.ServiceArguments["kubelet"]).To(Equal("--max-pods=300\n"))
+	})
+
+	t.Run("NoState", func(t *testing.T) {
+		s := &mock.Snap{}
+		result, err := k8sinit.NewLauncher(s, false).Reconcile(context.Background(), k8sinit.ApplyOptions{StatePath: filepath.Join(t.TempDir(), "last-applied.json")})
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+		g.Expect(result.Actions).To(BeEmpty())
+	})
+
+	t.Run("NoStatePath", func(t *testing.T) {
+		_, err := k8sinit.NewLauncher(&mock.Snap{}, false).Reconcile(context.Background(), k8sinit.ApplyOptions{})
+		g := NewWithT(t)
+		g.Expect(err).To(MatchError(ContainSubstring("state path")))
+	})
+}
@@ -0,0 +1,131 @@
+package k8sinit
+
+import (
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// RedactedValue replaces secret values in redacted output.
+const RedactedValue = "<redacted>"
+
+// DefaultSecretPatterns are the patterns used to detect secret values if none are specified. An extra argument or addon
+// argument is secret if its name contains any of the patterns (case-insensitive), e.g. "--token" or "AWS_SECRET_ACCESS_KEY".
+var DefaultSecretPatterns = []string{"token", "key", "password", "secret"}
+
+// secretPatternsOrDefault returns patterns, or DefaultSecretPatterns if patterns is nil.
+func secretPatternsOrDefault(patterns []string) []string {
+	if patterns == nil {
+		return DefaultSecretPatterns
+	}
+	return patterns
+}
+
+// isSecretName returns true if name (e.g. "--bootstrap-token" or "DB_PASSWORD") contains any of the patterns.
+func isSecretName(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if pattern != "" && strings.Contains(name, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactArg masks the value of a "--flag=value" argument if the flag is secret. Other arguments are returned as is.
+func redactArg(arg string, patterns []string) string {
+	if idx := strings.Index(arg, "="); idx != -1 && isSecretName(arg[:idx], patterns) {
+		return arg[:idx+1] + RedactedValue
+	}
+	return arg
+}
+
+// redactArgs masks the values of secret arguments, both in the "--flag=value" and in the "--flag value" form.
+// A new slice is returned if any argument is masked.
+func redactArgs(args []string, patterns []string) []string {
+	var redacted []string
+	for idx, arg := range args {
+		value := redactArg(arg, patterns)
+		if idx > 0 && !strings.HasPrefix(arg, "-") && strings.HasPrefix(args[idx-1], "-") && !strings.Contains(args[idx-1], "=") && isSecretName(args[idx-1], patterns) {
+			value = RedactedValue
+		}
+		if value != arg && redacted == nil {
+			redacted = append(make([]string, 0, len(args)), args[:idx]...)
+		}
+		if redacted != nil {
+			redacted = append(redacted, value)
+		}
+	}
+	if redacted == nil {
+		return args
+	}
+	return redacted
+}
+
+// redactJoinURL masks the token of a join URL, e.g. "10.0.0.10:25000/<redacted>".
+func redactJoinURL(url string) string {
+	if idx := strings.LastIndex(url, "/"); idx != -1 && idx < len(url)-1 {
+		return url[:idx+1] + RedactedValue
+	}
+	return url
+}
+
+// Redacted returns a copy of the configuration where secret values are masked with RedactedValue. Values of extra arguments
+// (and of addon arguments) whose name matches any of the patterns (DefaultSecretPatterns if nil) are masked, as well as
+// the persistent cluster token, the token of the join URL, the encryption keys and the passwords of registry mirrors. Arguments set to null stay null.
+// The configuration itself is not changed.
+func (c *Configuration) Redacted(patterns []string) *Configuration {
+	if c == nil {
+		return nil
+	}
+	patterns = secretPatternsOrDefault(patterns)
+	redacted := c.Clone()
+
+	for _, field := range redacted.serviceArgsFields() {
+		args := *field.args
+		for _, key := range util.SortedKeys(args) {
+			value := args[key]
+			switch {
+			case isVerbatimArg(key):
+				if masked := redactArg(key, patterns); masked != key {
+					delete(args, key)
+					args[masked] = value
+				}
+			case value != nil && isSecretName(key, patterns):
+				v := RedactedValue
+				args[key] = &v
+			}
+		}
+	}
+	for idx, addon := range redacted.Addons {
+		redacted.Addons[idx].Arguments = redactArgs(addon.Arguments, patterns)
+	}
+	for idx, mirror := range redacted.RegistryMirrors {
+		if mirror.Password != "" {
+			redacted.RegistryMirrors[idx].Password = RedactedValue
+		}
+	}
+	if redacted.PersistentClusterToken != "" {
+		redacted.PersistentClusterToken = RedactedValue
+	}
+	if redacted.Join.URL != "" {
+		redacted.Join.URL = redactJoinURL(redacted.Join.URL)
+	}
+	if redacted.Encryption != nil {
+		for _, provider := range redacted.Encryption.Providers {
+			for idx := range provider.Keys {
+				provider.Keys[idx].Secret = RedactedValue
+			}
+		}
+	}
+	return redacted
+}
+
+// redactAction returns a copy of the action where secret arguments and join tokens are masked, so that it can be logged.
+func redactAction(a Action, patterns []string) Action {
+	a.Arguments = redactArgs(a.Arguments, patterns)
+	if a.Kind == ActionJoinCluster {
+		a.Target = redactJoinURL(a.Target)
+	}
+	return a
+}
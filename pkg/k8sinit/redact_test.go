@@ -0,0 +1,101 @@
+package k8sinit_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	c, err := k8sinit.ParseConfiguration([]byte(`
+version: 0.2.0
+persistentClusterToken: my-cluster-token
+join:
+  url: 10.0.0.10:25000/my-join-token
+addons:
+  - name: observability
+    args: [--grafana-password=hunter2, --api-key, my-api-key, --namespace, monitoring]
+extraKubeAPIServerArgs:
+  --token: my-token
+  --oidc-client-id: microk8s
+  --service-account-key-file: null
+extraMicroK8sClusterAgentArgs:
+  - --bind=0.0.0.0:25000
+  - --secret-value=abc
+extraKubeliteEnv:
+  DB_PASSWORD: my-password
+registryMirrors:
+  - host: docker.io
+    mirrors: [https://mirror.example.com]
+    username: user
+    password: my-mirror-password
+`))
+	g := NewWithT(t)
+	g.Expect(err).To(BeNil())
+
+	t.Run("Marshal", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := c.MarshalWithOptions(k8sinit.MarshalOptions{RedactSecrets: true})
+		g.Expect(err).To(BeNil())
+
+		redacted, err := k8sinit.ParseConfiguration(b)
+		g.Expect(err).To(BeNil())
+		g.Expect(*redacted.ExtraKubeAPIServerArgs["--token"]).To(Equal(k8sinit.RedactedValue))
+		g.Expect(*redacted.ExtraKubeAPIServerArgs["--oidc-client-id"]).To(Equal("microk8s"))
+		g.Expect(redacted.ExtraKubeAPIServerArgs).To(HaveKeyWithValue("--service-account-key-file", BeNil()))
+		g.Expect(redacted.ExtraMicroK8sClusterAgentArgs).To(HaveKey("--bind=0.0.0.0:25000"))
+		g.Expect(redacted.ExtraMicroK8sClusterAgentArgs).To(HaveKey("--secret-value=" + k8sinit.RedactedValue))
+		g.Expect(*redacted.ExtraKubeliteEnv["DB_PASSWORD"]).To(Equal(k8sinit.RedactedValue))
+		g.Expect(redacted.PersistentClusterToken).To(Equal(k8sinit.RedactedValue))
+		g.Expect(redacted.Join.URL).To(Equal("10.0.0.10:25000/" + k8sinit.RedactedValue))
+		g.Expect(redacted.Addons[0].Arguments).To(Equal([]string{"--grafana-password=" + k8sinit.RedactedValue, "--api-key", k8sinit.RedactedValue, "--namespace", "monitoring"}))
+		g.Expect(redacted.RegistryMirrors[0].Password).To(Equal(k8sinit.RedactedValue))
+
+		for _, secret := range []string{"my-token", "my-cluster-token", "my-join-token", "hunter2", "my-api-key", "abc", "my-password", "my-mirror-password"} {
+			g.Expect(string(b)).NotTo(ContainSubstring(secret))
+		}
+
+		// the configuration itself is not redacted
+		g.Expect(*c.ExtraKubeAPIServerArgs["--token"]).To(Equal("my-token"))
+		g.Expect(c.PersistentClusterToken).To(Equal("my-cluster-token"))
+		g.Expect(c.Addons[0].Arguments[0]).To(Equal("--grafana-password=hunter2"))
+
+		b, err = c.Marshal()
+		g.Expect(err).To(BeNil())
+		g.Expect(string(b)).To(ContainSubstring("my-token"))
+	})
+
+	t.Run("Patterns", func(t *testing.T) {
+		g := NewWithT(t)
+		redacted := c.Redacted([]string{"CLIENT-ID"})
+		g.Expect(*redacted.ExtraKubeAPIServerArgs["--oidc-client-id"]).To(Equal(k8sinit.RedactedValue))
+		g.Expect(*redacted.ExtraKubeAPIServerArgs["--token"]).To(Equal("my-token"))
+	})
+
+	t.Run("Encryption", func(t *testing.T) {
+		g := NewWithT(t)
+		c := &k8sinit.Configuration{Encryption: &k8sinit.EncryptionConfiguration{Providers: []k8sinit.EncryptionProviderConfiguration{
+			{Type: "aescbc", Keys: []k8sinit.EncryptionKeyConfiguration{{Name: "key1", Secret: "MDEyMzQ1Njc4OWFiY2RlZg=="}}},
+		}}}
+		g.Expect(c.Redacted(nil).Encryption.Providers[0].Keys[0]).To(Equal(k8sinit.EncryptionKeyConfiguration{Name: "key1", Secret: k8sinit.RedactedValue}))
+		g.Expect(c.Encryption.Providers[0].Keys[0].Secret).To(Equal("MDEyMzQ1Njc4OWFiY2RlZg=="))
+	})
+
+	t.Run("DryRunLog", func(t *testing.T) {
+		g := NewWithT(t)
+		logger := &recordingLogger{}
+		l := k8sinit.NewLauncher(&mock.Snap{}, false)
+		_, err := l.ApplyWithOptions(context.Background(), k8sinit.MultiPartConfiguration{Parts: []*k8sinit.Configuration{c}}, k8sinit.ApplyOptions{DryRun: true, Logger: logger})
+		g.Expect(err).To(BeNil())
+		g.Expect(logger.infos).To(ContainElement("[dry-run] enable-addon observability [--grafana-password=<redacted> --api-key <redacted> --namespace monitoring]"))
+		g.Expect(logger.infos).To(ContainElement("[dry-run] join-cluster 10.0.0.10:25000/<redacted>"))
+		for _, info := range logger.infos {
+			g.Expect(info).NotTo(ContainSubstring("hunter2"))
+			g.Expect(info).NotTo(ContainSubstring("my-join-token"))
+		}
+	})
+}
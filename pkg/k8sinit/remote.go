@@ -0,0 +1,145 @@
+package k8sinit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// NodeTarget is a remote node that a configuration is applied to.
+type NodeTarget struct {
+	// Name identifies the node in results and errors, e.g. the node hostname.
+	Name string `json:"name"`
+	// Address is how the transport reaches the node, e.g. "ubuntu@10.0.0.10".
+	Address string `json:"address"`
+}
+
+// RemoteTransport applies configurations on remote nodes, e.g. by running the cluster agent on the node over SSH.
+type RemoteTransport interface {
+	// Apply applies the serialized configuration on the node, and returns the result of the apply on the node.
+	// opts.DryRun and opts.Force must be honored by the agent on the node.
+	Apply(ctx context.Context, node NodeTarget, config []byte, opts RemoteApplyOptions) (*ApplyResult, error)
+}
+
+// NodeApplyResult is the result of applying a configuration on a remote node.
+type NodeApplyResult struct {
+	// Node is the node the configuration was applied on.
+	Node NodeTarget
+	// Result is the result returned by the transport. It may be nil if the node could not be reached.
+	Result *ApplyResult
+	// Err is the error of applying the configuration on the node, or nil if it succeeded.
+	Err error
+}
+
+// RemoteApplyError is returned by RemoteApply when applying the configuration failed on one or more nodes.
+type RemoteApplyError struct {
+	// Errors are the failures of each node.
+	Errors []error
+}
+
+// Error implements the error interface.
+func (e *RemoteApplyError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d node(s) failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the list of node failures.
+func (e *RemoteApplyError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is reports whether any node failure matches target.
+func (e *RemoteApplyError) Is(target error) bool {
+	return isAnyError(e.Errors, target)
+}
+
+// As finds the first node failure that matches target.
+func (e *RemoteApplyError) As(target interface{}) bool {
+	return asAnyError(e.Errors, target)
+}
+
+// errNoRemoteTransport is returned by RemoteApply if no transport is configured.
+var errNoRemoteTransport = errors.New("no remote transport configured")
+
+// RemoteApply validates and serializes the configuration, and applies it on each node using opts.Transport.
+// A failure on one node does not stop the configuration from being applied on the other nodes. The results are
+// returned in the order of nodes, and a *RemoteApplyError is returned if any node failed. If the configuration is
+// invalid, it is not applied on any node. If ctx is cancelled, the nodes that were not started yet fail with the
+// context error.
+func RemoteApply(ctx context.Context, nodes []NodeTarget, cfg *Configuration, opts RemoteApplyOptions) ([]NodeApplyResult, error) {
+	if opts.Transport == nil {
+		return nil, errNoRemoteTransport
+	}
+	if err := cfg.ValidateWithOptions(opts.Validation); err != nil {
+		return nil, err
+	}
+	config, err := cfg.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		logger = loggerOrDefault(opts.Logger)
+		// logMu serializes log messages of nodes applied in parallel, since loggers may not be safe for concurrent use.
+		logMu   sync.Mutex
+		results = make([]NodeApplyResult, len(nodes))
+	)
+	applyNode := func(idx int) {
+		node := nodes[idx]
+		results[idx].Node = node
+		if err := ctx.Err(); err != nil {
+			results[idx].Err = fmt.Errorf("node %s: %w", node.Name, err)
+			return
+		}
+		logMu.Lock()
+		logger.Infof("Applying configuration on node %s", node.Name)
+		logMu.Unlock()
+		result, err := opts.Transport.Apply(ctx, node, config, opts)
+		results[idx].Result = result
+		if err != nil {
+			logMu.Lock()
+			logger.Warnf("failed to apply configuration on node %s: %v", node.Name, err)
+			logMu.Unlock()
+			results[idx].Err = fmt.Errorf("node %s: %w", node.Name, err)
+		}
+	}
+
+	if workers := opts.Workers; workers <= 1 {
+		for idx := range nodes {
+			applyNode(idx)
+		}
+	} else {
+		var (
+			wg  sync.WaitGroup
+			sem = make(chan struct{}, workers)
+		)
+		for idx := range nodes {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(idx int) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				applyNode(idx)
+			}(idx)
+		}
+		wg.Wait()
+	}
+
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	if len(errs) > 0 {
+		return results, &RemoteApplyError{Errors: errs}
+	}
+	return results, nil
+}
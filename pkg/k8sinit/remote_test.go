@@ -0,0 +1,99 @@
+package k8sinit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+type mockTransport struct {
+	mu      sync.Mutex
+	configs map[string]string
+	fail    map[string]error
+}
+
+func (t *mockTransport) Apply(ctx context.Context, node k8sinit.NodeTarget, config []byte, opts k8sinit.RemoteApplyOptions) (*k8sinit.ApplyResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.configs == nil {
+		t.configs = make(map[string]string)
+	}
+	t.configs[node.Name] = string(config)
+	if err := t.fail[node.Name]; err != nil {
+		return nil, err
+	}
+	return &k8sinit.ApplyResult{Actions: []k8sinit.Action{{Kind: k8sinit.ActionEnableAddon, Target: "dns"}}}, nil
+}
+
+func TestRemoteApply(t *testing.T) {
+	nodes := []k8sinit.NodeTarget{
+		{Name: "node-1", Address: "ubuntu@10.0.0.1"},
+		{Name: "node-2", Address: "ubuntu@10.0.0.2"},
+		{Name: "node-3", Address: "ubuntu@10.0.0.3"},
+	}
+	cfg := &k8sinit.Configuration{Version: "0.1.0", Addons: []k8sinit.AddonConfiguration{{Name: "dns"}}}
+	errUnreachable := errors.New("connection refused")
+
+	for _, workers := range []int{0, 3} {
+		t.Run(fmt.Sprintf("Workers%d", workers), func(t *testing.T) {
+			g := NewWithT(t)
+			transport := &mockTransport{fail: map[string]error{"node-2": errUnreachable}}
+			logger := &recordingLogger{}
+
+			results, err := k8sinit.RemoteApply(context.Background(), nodes, cfg, k8sinit.RemoteApplyOptions{Transport: transport, Workers: workers, Logger: logger})
+			g.Expect(err).To(MatchError(errUnreachable))
+			g.Expect(err).To(MatchError(ContainSubstring("1 node(s) failed: node node-2: connection refused")))
+
+			g.Expect(results).To(HaveLen(3))
+			for idx, result := range results {
+				g.Expect(result.Node).To(Equal(nodes[idx]))
+			}
+			g.Expect(results[0].Err).To(BeNil())
+			g.Expect(results[0].Result.Actions).To(HaveLen(1))
+			g.Expect(results[1].Err).To(MatchError(errUnreachable))
+			g.Expect(results[1].Result).To(BeNil())
+			g.Expect(results[2].Err).To(BeNil())
+			g.Expect(results[2].Result.Actions).To(HaveLen(1))
+
+			// the same serialized configuration is sent to all nodes
+			g.Expect(transport.configs).To(HaveLen(3))
+			c, err := k8sinit.ParseConfiguration([]byte(transport.configs["node-1"]))
+			g.Expect(err).To(BeNil())
+			g.Expect(c.Addons).To(Equal(cfg.Addons))
+			g.Expect(transport.configs["node-3"]).To(Equal(transport.configs["node-1"]))
+
+			g.Expect(logger.warnings).To(ConsistOf("failed to apply configuration on node node-2: connection refused"))
+		})
+	}
+
+	t.Run("InvalidConfiguration", func(t *testing.T) {
+		g := NewWithT(t)
+		transport := &mockTransport{}
+		_, err := k8sinit.RemoteApply(context.Background(), nodes, &k8sinit.Configuration{}, k8sinit.RemoteApplyOptions{Transport: transport})
+		g.Expect(err).To(MatchError(k8sinit.ErrMissingVersion))
+		g.Expect(transport.configs).To(BeEmpty())
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		g := NewWithT(t)
+		transport := &mockTransport{}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		results, err := k8sinit.RemoteApply(ctx, nodes, cfg, k8sinit.RemoteApplyOptions{Transport: transport})
+		g.Expect(err).To(MatchError(context.Canceled))
+		g.Expect(results).To(HaveLen(3))
+		g.Expect(transport.configs).To(BeEmpty())
+	})
+
+	t.Run("NoTransport", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.RemoteApply(context.Background(), nodes, cfg, k8sinit.RemoteApplyOptions{})
+		g.Expect(err).To(MatchError(ContainSubstring("no remote transport")))
+	})
+}
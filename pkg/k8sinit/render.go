@@ -0,0 +1,72 @@
+package k8sinit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	// RenderFormatYAML renders a configuration as YAML.
+	RenderFormatYAML = "yaml"
+	// RenderFormatJSON renders a configuration as indented JSON.
+	RenderFormatJSON = "json"
+)
+
+// Resolved returns a copy of the configuration without the extra arguments that are set to null (including ResetArgsKey).
+// In a merged configuration, null arguments only record that an argument of an earlier part was removed, so the resolved
+// configuration describes the same final state. Note that applying the resolved configuration does not remove any arguments.
+func (c *Configuration) Resolved() *Configuration {
+	resolved := c.Clone()
+	for _, field := range resolved.serviceArgsFields() {
+		for key, value := range *field.args {
+			if value == nil {
+				delete(*field.args, key)
+			}
+		}
+		if len(*field.args) == 0 {
+			*field.args = nil
+		}
+	}
+	return resolved
+}
+
+// RenderConfiguration merges all configuration parts (see Merge) and serializes the effective configuration in the
+// given format (RenderFormatYAML or RenderFormatJSON). Arguments set to null are resolved away (see Resolved).
+func RenderConfiguration(m MultiPartConfiguration, format string) ([]byte, error) {
+	return RenderConfigurationWithOptions(m, format, MarshalOptions{})
+}
+
+// RenderConfigurationWithOptions is like RenderConfiguration, but secret values are masked in the output if
+// opts.RedactSecrets is set (see Redacted).
+func RenderConfigurationWithOptions(m MultiPartConfiguration, format string, opts MarshalOptions) ([]byte, error) {
+	if format != RenderFormatYAML && format != RenderFormatJSON {
+		return nil, fmt.Errorf("unknown output format %q, must be one of %q or %q", format, RenderFormatYAML, RenderFormatJSON)
+	}
+
+	merged, err := m.Merge()
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge configuration: %w", err)
+	}
+	b, err := merged.Resolved().MarshalWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if format == RenderFormatYAML {
+		return b, nil
+	}
+
+	// convert the canonical YAML, so that fields are named the same in both formats
+	j, err := k8syaml.ToJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert configuration to JSON: %w", err)
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, j, "", "  "); err != nil {
+		return nil, fmt.Errorf("failed to format configuration as JSON: %w", err)
+	}
+	indented.WriteString("\n")
+	return indented.Bytes(), nil
+}
@@ -0,0 +1,85 @@
+package k8sinit_test
+
+import (
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRenderConfiguration(t *testing.T) {
+	var parts k8sinit.MultiPartConfiguration
+	for _, file := range []string{"testdata/render/base.yaml", "testdata/render/override.yaml"} {
+		b, err := testdata.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", file, err)
+		}
+		c, err := k8sinit.ParseMultiPartConfigurationWithOptions(b, k8sinit.ParseOptions{SourceName: file})
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", file, err)
+		}
+		parts.Parts = append(parts.Parts, c.Parts...)
+	}
+
+	t.Run("YAML", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := k8sinit.RenderConfiguration(parts, k8sinit.RenderFormatYAML)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(b)).To(Equal(`version: 0.2.0
+addons:
+- name: dns
+- name: ingress
+  disable: true
+extraKubeletArgs:
+  --max-pods: "200"
+extraKubeAPIServerArgs:
+  --authorization-mode: RBAC,Node
+extraSANs:
+- my.cluster.local
+`))
+
+		// the rendered configuration can be parsed again
+		c, err := k8sinit.ParseConfiguration(b)
+		g.Expect(err).To(BeNil())
+		g.Expect(c.ExtraKubeletArgs).To(Equal(k8sinit.ExtraArgs{"--max-pods": &[]string{"200"}[0]}))
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := k8sinit.RenderConfiguration(parts, k8sinit.RenderFormatJSON)
+		g.Expect(err).To(BeNil())
+		g.Expect(string(b)).To(Equal(`{
+  "addons": [
+    {
+      "name": "dns"
+    },
+    {
+      "disable": true,
+      "name": "ingress"
+    }
+  ],
+  "extraKubeAPIServerArgs": {
+    "--authorization-mode": "RBAC,Node"
+  },
+  "extraKubeletArgs": {
+    "--max-pods": "200"
+  },
+  "extraSANs": [
+    "my.cluster.local"
+  ],
+  "version": "0.2.0"
+}
+`))
+
+		c, err := k8sinit.ParseConfigurationJSON(b)
+		g.Expect(err).To(BeNil())
+		g.Expect(c.Version).To(Equal("0.2.0"))
+	})
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.RenderConfiguration(parts, "toml")
+		g.Expect(err).To(MatchError(ContainSubstring(`unknown output format "toml"`)))
+	})
+}
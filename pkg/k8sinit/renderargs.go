@@ -0,0 +1,159 @@
+package k8sinit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+)
+
+// BaseArgsSource returns the current contents of an arguments file in $SNAP_DATA/args, e.g. "kubelet". A missing file
+// is reported with an error wrapping os.ErrNotExist.
+type BaseArgsSource func(configFile string) (string, error)
+
+// NewFileBaseArgsSource returns a BaseArgsSource that reads the arguments files from argsDir, e.g. "$SNAP_DATA/args".
+func NewFileBaseArgsSource(argsDir string) BaseArgsSource {
+	return func(configFile string) (string, error) {
+		b, err := os.ReadFile(filepath.Join(argsDir, configFile))
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// ArgsRenderer renders the arguments that services run with after a configuration is applied, without writing anything.
+type ArgsRenderer struct {
+	config   MultiPartConfiguration
+	baseArgs BaseArgsSource
+	role     NodeRole
+}
+
+// NewArgsRenderer returns an ArgsRenderer for a configuration. baseArgs is used to read the current arguments files.
+// If nil, the arguments files are read from $SNAP_DATA/args. role is the role of the node, which must be set if any
+// part of the configuration only applies to specific roles.
+func NewArgsRenderer(c MultiPartConfiguration, baseArgs BaseArgsSource, role NodeRole) *ArgsRenderer {
+	if baseArgs == nil {
+		baseArgs = NewFileBaseArgsSource(filepath.Join(os.Getenv("SNAP_DATA"), "args"))
+	}
+	return &ArgsRenderer{config: c, baseArgs: baseArgs, role: role}
+}
+
+// argsFileSnap is a snap that only reads and writes arguments files, which are kept in memory. Files that are not written
+// are read from a BaseArgsSource.
+type argsFileSnap struct {
+	snap.Snap
+
+	baseArgs BaseArgsSource
+	files    map[string]string
+}
+
+// ReadServiceArguments implements snap.Snap.
+func (s *argsFileSnap) ReadServiceArguments(configFile string) (string, error) {
+	if contents, ok := s.files[configFile]; ok {
+		return contents, nil
+	}
+	return s.baseArgs(configFile)
+}
+
+// WriteServiceArguments implements snap.Snap.
+func (s *argsFileSnap) WriteServiceArguments(configFile string, contents []byte) error {
+	s.files[configFile] = string(contents)
+	return nil
+}
+
+// RenderArgs returns the arguments of a service after the configuration is applied to its current arguments file, e.g.
+// []string{"--max-pods=200", "--node-labels=zone=a"}, in the order they appear in the arguments file. component is the
+// name of the arguments file of the service, e.g. "kubelet" or "kube-apiserver". Arguments of the configuration are
+// applied as they would be by ApplyWithOptions, including arguments derived from other sections (e.g. node labels or
+// feature gates), reset arguments (see ResetArgsKey) and null arguments, which remove the argument. Extra argument values
+// read from files (see ApplyOptions.ArgFileDirs) are returned as-is.
+func (r *ArgsRenderer) RenderArgs(component string) ([]string, error) {
+	known := false
+	for _, field := range (&Configuration{}).serviceArgsFields() {
+		if field.configFile == component {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown component %q", component)
+	}
+
+	files := &argsFileSnap{baseArgs: r.baseArgs, files: make(map[string]string)}
+	s := &launcherScope{
+		launcher:            &Launcher{snap: files},
+		snap:                files,
+		logger:              loggerOrDefault(nil),
+		result:              &ApplyResult{},
+		mustRestartServices: make(map[string]struct{}),
+		affectedServices:    make(map[string]struct{}),
+		appliedSections:     make(map[Section]struct{}),
+	}
+	if r.role != "" {
+		s.opts.NodeRole = func() (NodeRole, error) { return r.role, nil }
+	} else if r.config.hasRoles() {
+		return nil, fmt.Errorf("the node role must be set, since parts of the configuration only apply to specific roles")
+	}
+
+	c, err := s.partsForRole(r.config)
+	if err != nil {
+		return nil, err
+	}
+	for idx, part := range c.Parts {
+		if part == nil {
+			continue
+		}
+		if err := s.renderPartArgs(part, component); err != nil {
+			return nil, fmt.Errorf("failed to render config part %d: %w", idx, err)
+		}
+	}
+
+	contents, err := files.ReadServiceArguments(component)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read arguments of %s: %w", component, err)
+	}
+	return argLines(contents), nil
+}
+
+// argLines returns the non-empty lines of an arguments file, without comments (e.g. snaputil.ManagedArgsHeader).
+func argLines(contents string) []string {
+	var lines []string
+	for _, line := range strings.Split(contents, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// renderPartArgs applies the arguments of a configuration part to the in-memory arguments file of a component, like applyPart.
+func (s *launcherScope) renderPartArgs(c *Configuration, component string) error {
+	c = s.withDerivedArgs(c)
+	resetArgs := s.resetArgs(c)
+	for _, field := range c.serviceArgsFields() {
+		if field.configFile != component {
+			continue
+		}
+		args := *field.args
+		if keys, ok := resetArgs[field.configFile]; ok || hasResetArgs(args) {
+			args = withResetArgs(args, keys)
+			delete(resetArgs, field.configFile)
+		}
+		if _, err := s.reconcileServiceArgs(context.Background(), field.configFile, args); err != nil {
+			return err
+		} else if len(args) > 0 {
+			s.trackAppliedArgs(field.configFile, args)
+		}
+	}
+	if component == "kubelet" && c.ContainerRuntime != "" {
+		if _, err := s.reconcileContainerRuntime(context.Background(), c.ContainerRuntime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
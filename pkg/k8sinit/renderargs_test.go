@@ -0,0 +1,110 @@
+package k8sinit_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRenderArgs(t *testing.T) {
+	baseArgs := map[string]string{
+		"kubelet":        "--kubeconfig=${SNAP_DATA}/credentials/kubelet.config\n--max-pods=110\n--cluster-dns=10.152.183.10\n",
+		"kube-apiserver": "--secure-port=16443\n--feature-gates=A=true\n",
+	}
+	source := func(configFile string) (string, error) {
+		if contents, ok := baseArgs[configFile]; ok {
+			return contents, nil
+		}
+		return "", fmt.Errorf("no arguments file %s: %w", configFile, os.ErrNotExist)
+	}
+
+	c, err := k8sinit.ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+extraKubeletArgs:
+  --max-pods: "200"
+  --cluster-dns: null
+  --eviction-hard: memory.available<100Mi
+nodeLabels:
+  zone: a
+---
+version: 0.2.0
+extraKubeletArgs:
+  --max-pods: "250"
+featureGates:
+  kubeAPIServer:
+    B: true
+`))
+	if err != nil {
+		t.Fatalf("failed to parse configuration: %v", err)
+	}
+	r := k8sinit.NewArgsRenderer(c, source, "")
+
+	for _, tc := range []struct {
+		component    string
+		expectedArgs []string
+	}{
+		{
+			component: "kubelet",
+			expectedArgs: []string{
+				"--kubeconfig=${SNAP_DATA}/credentials/kubelet.config",
+				"--max-pods=250",
+				"--eviction-hard=memory.available<100Mi",
+				"--node-labels=zone=a",
+			},
+		},
+		{
+			component:    "kube-apiserver",
+			expectedArgs: []string{"--secure-port=16443", "--feature-gates=A=true,B=true"},
+		},
+		{
+			component: "kube-proxy",
+		},
+	} {
+		t.Run(tc.component, func(t *testing.T) {
+			g := NewWithT(t)
+			args, err := r.RenderArgs(tc.component)
+			g.Expect(err).To(BeNil())
+			g.Expect(args).To(Equal(tc.expectedArgs))
+		})
+	}
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := r.RenderArgs("kubelet")
+		g.Expect(err).To(BeNil())
+		g.Expect(baseArgs["kubelet"]).To(Equal("--kubeconfig=${SNAP_DATA}/credentials/kubelet.config\n--max-pods=110\n--cluster-dns=10.152.183.10\n"))
+	})
+
+	t.Run("UnknownComponent", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := r.RenderArgs("kubectl")
+		g.Expect(err).To(MatchError(`unknown component "kubectl"`))
+	})
+
+	t.Run("Roles", func(t *testing.T) {
+		c, err := k8sinit.ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+roles: [control-plane]
+extraKubeletArgs:
+  --max-pods: "200"
+---
+version: 0.2.0
+roles: [worker]
+extraKubeletArgs:
+  --max-pods: "50"
+`))
+		g := NewWithT(t)
+		g.Expect(err).To(BeNil())
+
+		args, err := k8sinit.NewArgsRenderer(c, source, k8sinit.NodeRoleWorker).RenderArgs("kubelet")
+		g.Expect(err).To(BeNil())
+		g.Expect(args).To(ContainElement("--max-pods=50"))
+
+		_, err = k8sinit.NewArgsRenderer(c, source, "").RenderArgs("kubelet")
+		g.Expect(err).To(MatchError(ContainSubstring("the node role must be set")))
+	})
+}
@@ -0,0 +1,154 @@
+package k8sinit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// PartError is a problem found in a single part of a multi-part configuration.
+type PartError struct {
+	// Part is the zero-based index of the YAML document, or -1 if the problem is not specific to a single part.
+	Part int
+	// Err is the problem found.
+	Err error
+}
+
+// Error implements the error interface.
+func (e PartError) Error() string {
+	if e.Part < 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("part %d: %v", e.Part, e.Err)
+}
+
+// ValidationReport is the result of checking a multi-part configuration with CheckConfiguration.
+type ValidationReport struct {
+	// Parts is the number of non-empty configuration parts.
+	Parts int
+	// SkippedEmptyParts is the number of empty YAML documents.
+	SkippedEmptyParts int
+	// Warnings are non-fatal problems, e.g. unknown fields.
+	Warnings []string
+	// Errors are all problems found in all configuration parts.
+	Errors []PartError
+	// Configuration is the merged configuration. It is nil if any errors were found.
+	Configuration *Configuration
+}
+
+// OK returns true if no errors were found.
+func (r *ValidationReport) OK() bool {
+	return len(r.Errors) == 0
+}
+
+// String returns a human-readable report. A valid configuration is reported as "OK" with a summary of its contents,
+// otherwise all errors are listed.
+func (r *ValidationReport) String() string {
+	var lines []string
+	for _, warning := range r.Warnings {
+		lines = append(lines, fmt.Sprintf("warning: %s", warning))
+	}
+	if r.OK() {
+		lines = append(lines, fmt.Sprintf("OK: %s", r.summary()))
+		return strings.Join(lines, "\n")
+	}
+	lines = append(lines, fmt.Sprintf("%d error(s):", len(r.Errors)))
+	for idx, err := range r.Errors {
+		lines = append(lines, fmt.Sprintf("%d. %v", idx+1, err))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// summary describes the contents of the merged configuration, e.g. "2 part(s), 1 addon(s), 3 extra argument(s), 0 SAN(s)".
+func (r *ValidationReport) summary() string {
+	var addons, args, sans int
+	if c := r.Configuration; c != nil {
+		addons = len(c.Addons)
+		for _, field := range c.serviceArgsFields() {
+			args += len(*field.args)
+		}
+		if c.ExtraSANs != nil {
+			sans = len(*c.ExtraSANs)
+		}
+	}
+	return fmt.Sprintf("%d part(s), %d addon(s), %d extra argument(s), %d SAN(s)", r.Parts, addons, args, sans)
+}
+
+// CheckConfiguration parses and validates all parts of a multi-part configuration. Unlike ParseMultiPartConfiguration,
+// CheckConfiguration does not stop at the first invalid part, so that all problems are reported at once.
+// If all parts are valid, they are also merged (see Merge) and the merged configuration is validated.
+func CheckConfiguration(b []byte, opts ParseOptions) *ValidationReport {
+	report := &ValidationReport{}
+
+	maxSize := opts.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxConfigSize
+	}
+	if len(b) > maxSize {
+		report.Errors = append(report.Errors, PartError{Part: -1, Err: fmt.Errorf("configuration is %d bytes, but the maximum size is %d bytes", len(b), maxSize)})
+		return report
+	}
+
+	maxParts := opts.MaxParts
+	if maxParts == 0 {
+		maxParts = DefaultMaxConfigParts
+	}
+
+	var parts MultiPartConfiguration
+	reader := k8syaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(b)))
+	for idx := 0; ; idx++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			report.Errors = append(report.Errors, PartError{Part: idx, Err: err})
+			break
+		}
+		if idx >= maxParts {
+			report.Errors = append(report.Errors, PartError{Part: -1, Err: fmt.Errorf("configuration has more than the maximum of %d parts", maxParts)})
+			break
+		}
+
+		part, warnings, err := parseConfiguration(doc, opts)
+		for _, warning := range warnings {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("part %d: %s", idx, warning))
+		}
+		var validationErr *ValidationError
+		switch {
+		case errors.Is(err, errEmptyConfig):
+			report.SkippedEmptyParts++
+		case errors.As(err, &validationErr):
+			for _, err := range validationErr.Errors {
+				report.Errors = append(report.Errors, PartError{Part: idx, Err: err})
+			}
+		case err != nil:
+			report.Errors = append(report.Errors, PartError{Part: idx, Err: err})
+		default:
+			report.Parts++
+			parts.Parts = append(parts.Parts, part)
+		}
+	}
+
+	if !report.OK() {
+		return report
+	}
+	if report.Parts == 0 {
+		report.Errors = append(report.Errors, PartError{Part: -1, Err: ErrEmptyConfiguration})
+		return report
+	}
+	merged, err := parts.Merge()
+	if err == nil {
+		err = merged.ValidateWithOptions(opts.Validation)
+	}
+	if err != nil {
+		report.Errors = append(report.Errors, PartError{Part: -1, Err: fmt.Errorf("merged configuration: %w", err)})
+		return report
+	}
+	report.Configuration = merged
+	return report
+}
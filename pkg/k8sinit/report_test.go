@@ -0,0 +1,55 @@
+package k8sinit_test
+
+import (
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCheckConfiguration(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := testdata.ReadFile("testdata/schema/multi-part.yaml")
+		g.Expect(err).To(BeNil())
+
+		report := k8sinit.CheckConfiguration(b, k8sinit.ParseOptions{})
+		g.Expect(report.OK()).To(BeTrue())
+		g.Expect(report.Parts).To(Equal(2))
+		g.Expect(report.Configuration).NotTo(BeNil())
+		g.Expect(report.String()).To(Equal("OK: 2 part(s), 2 addon(s), 0 extra argument(s), 0 SAN(s)"))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := testdata.ReadFile("testdata/check/invalid.yaml")
+		g.Expect(err).To(BeNil())
+
+		report := k8sinit.CheckConfiguration(b, k8sinit.ParseOptions{})
+		g.Expect(report.OK()).To(BeFalse())
+		g.Expect(report.Configuration).To(BeNil())
+		g.Expect(report.Errors).To(HaveLen(3))
+		g.Expect(report.Errors[0].Part).To(Equal(1))
+		g.Expect(report.Errors[1].Part).To(Equal(1))
+		g.Expect(report.Errors[2].Part).To(Equal(2))
+		g.Expect(report.String()).To(Equal(`3 error(s):
+1. part 1: field "addons[].timeout" requires config file version 0.2.0 or newer, but version is 0.1.0
+2. part 1: extraSANs[0] "not a valid SAN!" is not a valid IP address or DNS name
+3. part 2: config file version is 0.3.0 but the maximum version supported is 0.2.0`))
+	})
+
+	t.Run("Warnings", func(t *testing.T) {
+		g := NewWithT(t)
+		report := k8sinit.CheckConfiguration([]byte("version: 0.1.0\nunknownField: true\n"), k8sinit.ParseOptions{})
+		g.Expect(report.OK()).To(BeTrue())
+		g.Expect(report.Warnings).To(ConsistOf(`part 0: unknown field "unknownField" will be ignored`))
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		g := NewWithT(t)
+		report := k8sinit.CheckConfiguration([]byte("---\n---\n"), k8sinit.ParseOptions{})
+		g.Expect(report.OK()).To(BeFalse())
+		g.Expect(report.String()).To(Equal("1 error(s):\n1. " + k8sinit.ErrEmptyConfiguration.Error()))
+	})
+}
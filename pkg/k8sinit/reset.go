@@ -0,0 +1,130 @@
+package k8sinit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// ResetArgsKey is a reserved extra arguments key. Setting it to null removes all arguments that were previously set by
+// launch configurations for the same service, e.g. `extraKubeletArgs: {"*": null}`.
+//
+// Arguments are reset first, then any other arguments of the same configuration part are set. Only arguments set
+// by launch configurations are removed, the default arguments of the service are left untouched.
+const ResetArgsKey = "*"
+
+// appliedArgsFile is the file in $SNAP_DATA/args with the arguments set by launch configurations.
+// Each line is the name of an arguments file followed by the name of an argument, e.g. "kubelet --max-pods".
+const appliedArgsFile = "launch-configuration-args"
+
+// hasResetArgs returns true if the extra arguments map contains the reset key.
+func hasResetArgs(args map[string]*string) bool {
+	value, ok := args[ResetArgsKey]
+	return ok && value == nil
+}
+
+// loadAppliedArgs reads the arguments previously set by launch configurations. A missing file means no arguments are known.
+func (s *launcherScope) loadAppliedArgs() map[string]map[string]struct{} {
+	if s.appliedArgs != nil {
+		return s.appliedArgs
+	}
+	s.appliedArgs = make(map[string]map[string]struct{})
+	contents, err := s.snap.ReadServiceArguments(appliedArgsFile)
+	if err != nil {
+		return s.appliedArgs
+	}
+	for _, line := range strings.Split(contents, "\n") {
+		// arguments of the list form (see ExtraArgs) may contain spaces
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		if s.appliedArgs[fields[0]] == nil {
+			s.appliedArgs[fields[0]] = make(map[string]struct{})
+		}
+		s.appliedArgs[fields[0]][fields[1]] = struct{}{}
+	}
+	return s.appliedArgs
+}
+
+// resetArgs returns, for each arguments file reset by the configuration part, the previously applied arguments to remove.
+// Arguments that are set again by the same configuration part are not removed.
+func (s *launcherScope) resetArgs(c *Configuration) map[string][]string {
+	reset := make(map[string]map[string]struct{})
+	fields := c.serviceArgsFields()
+	for _, field := range fields {
+		if !hasResetArgs(*field.args) {
+			continue
+		}
+		if reset[field.configFile] == nil {
+			reset[field.configFile] = make(map[string]struct{})
+		}
+		for key := range s.loadAppliedArgs()[field.configFile] {
+			reset[field.configFile][key] = struct{}{}
+		}
+	}
+	if len(reset) == 0 {
+		return nil
+	}
+
+	for _, field := range fields {
+		for key := range *field.args {
+			delete(reset[field.configFile], key)
+		}
+	}
+	result := make(map[string][]string, len(reset))
+	for configFile, keys := range reset {
+		result[configFile] = util.SortedKeys(keys)
+	}
+	return result
+}
+
+// withResetArgs returns a copy of the extra arguments without the reset key, removing the arguments in reset.
+func withResetArgs(args map[string]*string, reset []string) map[string]*string {
+	result := make(map[string]*string, len(args)+len(reset))
+	for _, key := range reset {
+		result[key] = nil
+	}
+	for key, value := range args {
+		if key != ResetArgsKey {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// trackAppliedArgs records the arguments set and removed in an arguments file.
+func (s *launcherScope) trackAppliedArgs(configFile string, args map[string]*string) {
+	applied := s.loadAppliedArgs()
+	for key, value := range args {
+		if key == ResetArgsKey {
+			continue
+		}
+		if value == nil {
+			delete(applied[configFile], key)
+			continue
+		}
+		if applied[configFile] == nil {
+			applied[configFile] = make(map[string]struct{})
+		}
+		applied[configFile][key] = struct{}{}
+	}
+	s.appliedArgsChanged = true
+}
+
+// writeAppliedArgs persists the arguments set by launch configurations, if they changed.
+func (s *launcherScope) writeAppliedArgs() error {
+	if !s.appliedArgsChanged {
+		return nil
+	}
+	var lines []string
+	for configFile, keys := range s.appliedArgs {
+		for key := range keys {
+			lines = append(lines, fmt.Sprintf("%s %s\n", configFile, key))
+		}
+	}
+	sort.Strings(lines)
+	return s.snap.WriteServiceArguments(appliedArgsFile, []byte(strings.Join(lines, "")))
+}
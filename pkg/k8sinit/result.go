@@ -0,0 +1,170 @@
+package k8sinit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ActionKind is the kind of an action performed while applying a configuration.
+type ActionKind string
+
+const (
+	// ActionAddAddonRepository adds an addon repository.
+	ActionAddAddonRepository ActionKind = "add-addon-repository"
+	// ActionRemoveAddonRepository removes an addon repository.
+	ActionRemoveAddonRepository ActionKind = "remove-addon-repository"
+	// ActionEnableAddon enables an addon.
+	ActionEnableAddon ActionKind = "enable-addon"
+	// ActionDisableAddon disables an addon.
+	ActionDisableAddon ActionKind = "disable-addon"
+	// ActionAddPersistentClusterToken adds a persistent cluster token.
+	ActionAddPersistentClusterToken ActionKind = "add-persistent-cluster-token"
+	// ActionWriteConfigFile writes a configuration file in $SNAP_DATA/args.
+	ActionWriteConfigFile ActionKind = "write-config-file"
+	// ActionWriteServiceArguments rewrites the arguments file of a service.
+	ActionWriteServiceArguments ActionKind = "write-service-arguments"
+	// ActionWriteCSRConfig writes the csr.conf.template file with the SANs of the API server certificate.
+	ActionWriteCSRConfig ActionKind = "write-csr-config"
+	// ActionWriteContainerdRegistryConfig writes the hosts.toml file of a containerd registry.
+	ActionWriteContainerdRegistryConfig ActionKind = "write-containerd-registry-config"
+	// ActionRemoveContainerdRegistryConfig removes the hosts.toml file of a containerd registry.
+	ActionRemoveContainerdRegistryConfig ActionKind = "remove-containerd-registry-config"
+	// ActionWriteCNIConfig writes the CNI manifest.
+	ActionWriteCNIConfig ActionKind = "write-cni-config"
+	// ActionApplyCNI applies the CNI manifest to the cluster.
+	ActionApplyCNI ActionKind = "apply-cni"
+	// ActionJoinCluster joins an existing cluster.
+	ActionJoinCluster ActionKind = "join-cluster"
+	// ActionRestartService restarts a service.
+	ActionRestartService ActionKind = "restart-service"
+	// ActionRunHook runs a hook command.
+	ActionRunHook ActionKind = "run-hook"
+)
+
+// Action is a single action performed while applying a configuration.
+type Action struct {
+	// Kind is the kind of action.
+	Kind ActionKind `json:"kind"`
+	// Target is what the action applies to, e.g. the addon name, the service name or the arguments file.
+	Target string `json:"target,omitempty"`
+	// Arguments are extra details of the action, e.g. the addon arguments.
+	Arguments []string `json:"arguments,omitempty"`
+
+	// Duration is how long the action took.
+	Duration time.Duration `json:"duration"`
+	// Error is the error message if the action failed, or empty if the action succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// Failed returns true if the action failed.
+func (a Action) Failed() bool {
+	return a.Error != ""
+}
+
+// String returns a human-readable description of the action.
+func (a Action) String() string {
+	s := string(a.Kind)
+	if a.Target != "" {
+		s = fmt.Sprintf("%s %s", s, a.Target)
+	}
+	if len(a.Arguments) > 0 {
+		s = fmt.Sprintf("%s [%s]", s, strings.Join(a.Arguments, " "))
+	}
+	if a.Error != "" {
+		s = fmt.Sprintf("%s (failed: %s)", s, a.Error)
+	}
+	return s
+}
+
+// ApplyResult is the result of applying a configuration.
+type ApplyResult struct {
+	// Actions is the list of actions performed, in order.
+	// In dry-run mode, this is the list of actions that would be performed.
+	Actions []Action `json:"actions"`
+
+	// Hooks is the output of the hook commands that were run, in order.
+	Hooks []HookResult `json:"hooks,omitempty"`
+
+	// DeferredAddons are the addons that were not enabled or disabled because of ApplyOptions.SkipAddons, by qualified
+	// name (see AddonConfiguration.QualifiedName).
+	DeferredAddons []string `json:"deferredAddons,omitempty"`
+
+	// Sections summarizes which sections of the configuration were applied. It is nil if applying the configuration
+	// failed before all parts were applied.
+	Sections *SectionSummary `json:"sections,omitempty"`
+}
+
+// SectionSummary summarizes which sections of a configuration were applied, for auditing.
+type SectionSummary struct {
+	// Present are the sections set in any part of the configuration.
+	Present []Section `json:"present,omitempty"`
+	// Applied are the sections that changed the node. In dry-run mode, these are the sections that would change it.
+	Applied []Section `json:"applied,omitempty"`
+	// Unchanged are the sections that were already applied, so that nothing had to be done for them.
+	Unchanged []Section `json:"unchanged,omitempty"`
+	// Skipped are the sections that were not applied, because they are only set in parts that do not apply to the role of
+	// the local node, because they are not applied in pre-init mode, or because of ApplyOptions.SkipAddons or OnlyAddons.
+	Skipped []Section `json:"skipped,omitempty"`
+}
+
+// HookResult is the output of a hook command.
+type HookResult struct {
+	// Phase is when the command was run.
+	Phase HookPhase `json:"phase"`
+	// Command is the hook command.
+	Command string `json:"command"`
+	// Stdout is the standard output of the command.
+	Stdout string `json:"stdout,omitempty"`
+	// Stderr is the standard error of the command.
+	Stderr string `json:"stderr,omitempty"`
+}
+
+// AddonsError is returned when applying a configuration where one or more addons with the "continue" failure policy failed,
+// or when multiple addons enabled in parallel failed.
+type AddonsError struct {
+	// Errors are the failures of each addon.
+	Errors []error
+}
+
+// Error implements the error interface.
+func (e *AddonsError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d addon(s) failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the list of addon failures.
+func (e *AddonsError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is reports whether any addon failure matches target.
+func (e *AddonsError) Is(target error) bool {
+	return isAnyError(e.Errors, target)
+}
+
+// As finds the first addon failure that matches target.
+func (e *AddonsError) As(target interface{}) bool {
+	return asAnyError(e.Errors, target)
+}
+
+// CanceledError is returned when applying a configuration is stopped because the context was cancelled or timed out.
+type CanceledError struct {
+	// Actions are the actions performed before applying the configuration was stopped.
+	Actions []Action
+	// Err is the error of the interrupted step, which wraps the context error.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("applying configuration was stopped after %d action(s): %v", len(e.Actions), e.Err)
+}
+
+// Unwrap returns the error of the interrupted step.
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,86 @@
+package k8sinit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// DefaultRetryBackoff is the default delay before the first retry of a failed operation.
+const DefaultRetryBackoff = time.Second
+
+// exitCoder is implemented by errors of commands that exited with a non-zero exit code, e.g. *exec.ExitError.
+type exitCoder interface {
+	ExitCode() int
+}
+
+// compileRetryPatterns compiles the patterns of retriable error messages.
+func compileRetryPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// isRetriable returns true if a failed operation may succeed when retried: the command exited with a non-zero exit
+// code and its error message matches one of the retry patterns (if any), and ctx is not done (e.g. the addon timeout
+// did not expire).
+func (s *launcherScope) isRetriable(ctx context.Context, err error) bool {
+	var exitErr exitCoder
+	if ctx.Err() != nil || !errors.As(err, &exitErr) || exitErr.ExitCode() <= 0 {
+		return false
+	}
+	if len(s.retryPatterns) == 0 {
+		return true
+	}
+	for _, re := range s.retryPatterns {
+		if re.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// retry performs an operation, retrying it up to opts.MaxRetries times with exponential backoff while it fails with
+// a retriable error (see isRetriable). The error of the last attempt is returned.
+func (s *launcherScope) retry(ctx context.Context, action Action, perform func() error) error {
+	backoff := s.opts.Backoff
+	if backoff == 0 {
+		backoff = DefaultRetryBackoff
+	}
+	sleep := s.opts.RetrySleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := perform()
+		if err == nil || attempt > s.opts.MaxRetries || !s.isRetriable(ctx, err) {
+			return err
+		}
+		s.logger.Warnf("%s failed, retrying in %v (retry %d of %d): %v", redactAction(action, secretPatternsOrDefault(s.opts.SecretPatterns)), backoff, attempt, s.opts.MaxRetries, err)
+		if sleepErr := sleep(ctx, backoff); sleepErr != nil {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// sleepContext waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,120 @@
+package k8sinit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NodeRole is the role of a MicroK8s node in the cluster.
+type NodeRole string
+
+const (
+	// NodeRoleControlPlane is a node that runs the Kubernetes control plane services.
+	NodeRoleControlPlane NodeRole = "control-plane"
+	// NodeRoleWorker is a node that joined the cluster as a worker-only node.
+	NodeRoleWorker NodeRole = "worker"
+)
+
+// appliesTo returns true if the configuration applies to nodes with the given role. Configurations without roles apply
+// to all nodes.
+func (c *Configuration) appliesTo(role NodeRole) bool {
+	if len(c.Roles) == 0 {
+		return true
+	}
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRoles returns true if any part of the configuration only applies to nodes with specific roles.
+func (m MultiPartConfiguration) hasRoles() bool {
+	for _, part := range m.Parts {
+		if part != nil && len(part.Roles) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeRole returns the role of the local node, using opts.NodeRole if set. By default, nodes that joined a cluster as
+// worker-only nodes are workers, and all other nodes are control plane nodes.
+func (s *launcherScope) nodeRole() (NodeRole, error) {
+	if s.opts.NodeRole != nil {
+		return s.opts.NodeRole()
+	}
+	if s.snap.HasClusteredLock() {
+		return NodeRoleWorker, nil
+	}
+	return NodeRoleControlPlane, nil
+}
+
+// partsForRole returns the configuration parts that apply to the role of the local node. Skipped parts are logged.
+// The configuration is returned as-is if none of its parts have roles, so that the node role is not detected needlessly.
+func (s *launcherScope) partsForRole(c MultiPartConfiguration) (MultiPartConfiguration, error) {
+	if !c.hasRoles() {
+		return c, nil
+	}
+	role, err := s.nodeRole()
+	if err != nil {
+		return c, fmt.Errorf("failed to detect node role: %w", err)
+	}
+
+	filtered := c
+	filtered.Parts = make([]*Configuration, 0, len(c.Parts))
+	for idx, part := range c.Parts {
+		if part != nil && !part.appliesTo(role) {
+			source := part.Source
+			if source == "" {
+				source = fmt.Sprintf("part %d", idx)
+			}
+			s.logger.Infof("Skipping config %s, since it only applies to %s nodes and the local node is a %s node", source, joinRoles(part.Roles), role)
+			continue
+		}
+		filtered.Parts = append(filtered.Parts, part)
+	}
+	return filtered, nil
+}
+
+// joinRoles formats a list of roles, e.g. "control-plane, worker".
+func joinRoles(roles []NodeRole) string {
+	s := make([]string, 0, len(roles))
+	for _, role := range roles {
+		s = append(s, string(role))
+	}
+	return strings.Join(s, ", ")
+}
+
+// validateRoles checks that all roles are known, and listed at most once.
+func validateRoles(c *Configuration) []error {
+	var errs []error
+	seen := make(map[NodeRole]struct{}, len(c.Roles))
+	for idx, role := range c.Roles {
+		switch role {
+		case NodeRoleControlPlane, NodeRoleWorker:
+		default:
+			errs = append(errs, fmt.Errorf("roles[%d] %q must be one of %q or %q", idx, role, NodeRoleControlPlane, NodeRoleWorker))
+		}
+		if _, ok := seen[role]; ok {
+			errs = append(errs, fmt.Errorf("roles[%d] %q is listed more than once", idx, role))
+		}
+		seen[role] = struct{}{}
+	}
+	return errs
+}
+
+// RoleWarnings returns a warning for each field of the configuration that has no effect on nodes with the given role,
+// e.g. extraSANs on worker nodes, which do not run the API server. Configurations that do not apply to the role are
+// not checked. If role is empty, no warnings are returned.
+func (c *Configuration) RoleWarnings(role NodeRole) []string {
+	if role == "" || !c.appliesTo(role) {
+		return nil
+	}
+	var warnings []string
+	if role == NodeRoleWorker && c.ExtraSANs != nil && len(*c.ExtraSANs) > 0 {
+		warnings = append(warnings, "extraSANs has no effect on worker nodes, since they do not run the API server")
+	}
+	return warnings
+}
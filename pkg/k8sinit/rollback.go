@@ -0,0 +1,193 @@
+package k8sinit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// fileSnapshot is the contents of a file before it was first changed while applying a configuration.
+type fileSnapshot struct {
+	// name is the name of the arguments file, or empty for the CNI manifest and registry configurations.
+	name string
+	// registry is the registry of a containerd hosts.toml registry configuration, e.g. "docker.io".
+	registry string
+	// contents are the previous contents of the file.
+	contents string
+	// missing is true if the file did not exist, so that it is removed again.
+	missing bool
+}
+
+// rollbackSnap wraps a snap.Snap and keeps a snapshot of every file before it is changed, so that the changes can
+// be rolled back if applying the configuration fails.
+type rollbackSnap struct {
+	snap.Snap
+
+	snapshots []fileSnapshot
+	seen      map[string]struct{}
+
+	restartedServices []string
+
+	// rolledBack is true if the changes were rolled back after applying the configuration failed.
+	rolledBack bool
+}
+
+// newRollbackSnap wraps s to keep snapshots of changed files.
+func newRollbackSnap(s snap.Snap) *rollbackSnap {
+	return &rollbackSnap{Snap: s, seen: make(map[string]struct{})}
+}
+
+// WriteServiceArguments snapshots the previous contents of the arguments file before writing it.
+func (s *rollbackSnap) WriteServiceArguments(serviceName string, b []byte) error {
+	if _, ok := s.seen["args/"+serviceName]; !ok {
+		contents, err := s.Snap.ReadServiceArguments(serviceName)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to snapshot arguments of service %s: %w", serviceName, err)
+		}
+		s.seen["args/"+serviceName] = struct{}{}
+		s.snapshots = append(s.snapshots, fileSnapshot{name: serviceName, contents: contents, missing: err != nil})
+	}
+	return s.Snap.WriteServiceArguments(serviceName, b)
+}
+
+// WriteCNIYaml snapshots the previous contents of the CNI manifest before writing it.
+func (s *rollbackSnap) WriteCNIYaml(b []byte) error {
+	if _, ok := s.seen["cni"]; !ok {
+		contents, err := s.Snap.ReadCNIYaml()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to snapshot cni manifest: %w", err)
+		}
+		s.seen["cni"] = struct{}{}
+		s.snapshots = append(s.snapshots, fileSnapshot{contents: contents, missing: err != nil})
+	}
+	return s.Snap.WriteCNIYaml(b)
+}
+
+// UpdateContainerdRegistryConfigs snapshots the previous registry configurations before writing them.
+func (s *rollbackSnap) UpdateContainerdRegistryConfigs(configs map[string][]byte) error {
+	for _, registry := range util.SortedKeys(configs) {
+		if err := s.snapshotContainerdRegistryConfig(registry); err != nil {
+			return err
+		}
+	}
+	return s.Snap.UpdateContainerdRegistryConfigs(configs)
+}
+
+// RemoveContainerdRegistryConfig snapshots the previous registry configuration before removing it.
+func (s *rollbackSnap) RemoveContainerdRegistryConfig(registry string) error {
+	if err := s.snapshotContainerdRegistryConfig(registry); err != nil {
+		return err
+	}
+	return s.Snap.RemoveContainerdRegistryConfig(registry)
+}
+
+// snapshotContainerdRegistryConfig snapshots the registry configuration of a registry, unless it was already changed.
+func (s *rollbackSnap) snapshotContainerdRegistryConfig(registry string) error {
+	if _, ok := s.seen["certs.d/"+registry]; ok {
+		return nil
+	}
+	contents, err := s.Snap.ReadContainerdRegistryConfig(registry)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to snapshot registry configuration of %s: %w", registry, err)
+	}
+	s.seen["certs.d/"+registry] = struct{}{}
+	s.snapshots = append(s.snapshots, fileSnapshot{registry: registry, contents: string(contents), missing: err != nil})
+	return nil
+}
+
+// RestartService keeps track of restarted services, so that they can be restarted again after a rollback.
+func (s *rollbackSnap) RestartService(ctx context.Context, serviceName string) error {
+	s.restartedServices = append(s.restartedServices, serviceName)
+	return s.Snap.RestartService(ctx, serviceName)
+}
+
+// rollback restores all snapshots in reverse order, and restarts any services that were already restarted.
+// rollback is best-effort, and continues after errors.
+func (s *rollbackSnap) rollback(ctx context.Context, logger Logger) error {
+	var errs []string
+	for idx := len(s.snapshots) - 1; idx >= 0; idx-- {
+		snapshot := s.snapshots[idx]
+		switch {
+		case snapshot.registry != "" && snapshot.missing:
+			if err := s.Snap.RemoveContainerdRegistryConfig(snapshot.registry); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to remove registry configuration of %s: %v", snapshot.registry, err))
+				continue
+			}
+			logger.Infof("Removed registry configuration of %s", snapshot.registry)
+		case snapshot.registry != "":
+			if err := s.Snap.UpdateContainerdRegistryConfigs(map[string][]byte{snapshot.registry: []byte(snapshot.contents)}); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to restore registry configuration of %s: %v", snapshot.registry, err))
+				continue
+			}
+			logger.Infof("Restored registry configuration of %s", snapshot.registry)
+		case snapshot.name == "" && snapshot.missing:
+			if err := s.Snap.RemoveCNIYaml(); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to remove cni manifest: %v", err))
+				continue
+			}
+			logger.Infof("Removed cni manifest")
+		case snapshot.name == "":
+			if err := s.Snap.WriteCNIYaml([]byte(snapshot.contents)); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to restore cni manifest: %v", err))
+				continue
+			}
+			logger.Infof("Restored cni manifest")
+		case snapshot.missing:
+			if err := s.Snap.RemoveServiceArguments(snapshot.name); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to remove arguments of service %s: %v", snapshot.name, err))
+				continue
+			}
+			logger.Infof("Removed arguments of service %s", snapshot.name)
+		default:
+			if err := s.Snap.WriteServiceArguments(snapshot.name, []byte(snapshot.contents)); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to restore arguments of service %s: %v", snapshot.name, err))
+				continue
+			}
+			logger.Infof("Restored arguments of service %s", snapshot.name)
+		}
+	}
+	for _, service := range s.restartedServices {
+		if err := s.Snap.RestartService(ctx, service); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to restart service %s: %v", service, err))
+			continue
+		}
+		logger.Infof("Restarted service %s with restored configuration", service)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// RollbackError is returned when applying a configuration fails, and rolling back the changes also fails.
+type RollbackError struct {
+	// Err is the error that caused the rollback.
+	Err error
+	// RollbackErr is the error that occurred while rolling back.
+	RollbackErr error
+}
+
+// Error implements the error interface.
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("%v (rollback failed: %v)", e.Err, e.RollbackErr)
+}
+
+// Unwrap returns the original error and the rollback error.
+func (e *RollbackError) Unwrap() []error {
+	return []error{e.Err, e.RollbackErr}
+}
+
+// Is reports whether the original error or the rollback error matches target.
+func (e *RollbackError) Is(target error) bool {
+	return isAnyError(e.Unwrap(), target)
+}
+
+// As finds the first of the original error and the rollback error that matches target.
+func (e *RollbackError) As(target interface{}) bool {
+	return asAnyError(e.Unwrap(), target)
+}
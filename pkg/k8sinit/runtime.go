@@ -0,0 +1,65 @@
+package k8sinit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
+)
+
+// defaultContainerRuntime is the container runtime used by MicroK8s if the kubelet does not specify one.
+const defaultContainerRuntime = "containerd"
+
+// containerRuntime describes how the local node kubelet is configured to use a container runtime.
+type containerRuntime struct {
+	// endpoint is the value of the kubelet --container-runtime-endpoint argument.
+	endpoint string
+	// restartServices are the services that must be restarted after switching to the container runtime.
+	restartServices []string
+}
+
+// containerRuntimes are the supported container runtimes.
+var containerRuntimes = map[string]containerRuntime{
+	"containerd": {
+		endpoint:        "${SNAP_COMMON}/run/containerd.sock",
+		restartServices: []string{"containerd", "kubelite"},
+	},
+}
+
+// supportedContainerRuntimes returns the sorted names of the supported container runtimes.
+func supportedContainerRuntimes() []string {
+	names := make([]string, 0, len(containerRuntimes))
+	for name := range containerRuntimes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateContainerRuntime checks that the container runtime is supported.
+func validateContainerRuntime(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := containerRuntimes[name]; !ok {
+		return fmt.Errorf("containerRuntime %q is not supported (supported runtimes are %s)", name, strings.Join(supportedContainerRuntimes(), ", "))
+	}
+	return nil
+}
+
+// currentContainerRuntime returns the name of the container runtime the local node kubelet is configured to use.
+// An empty string is returned if the kubelet uses an unknown container runtime endpoint.
+func currentContainerRuntime(s snap.Snap) string {
+	endpoint := snaputil.GetServiceArgument(s, "kubelet", "--container-runtime-endpoint")
+	if endpoint == "" {
+		return defaultContainerRuntime
+	}
+	for name, runtime := range containerRuntimes {
+		if runtime.endpoint == endpoint {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package k8sinit
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// extraSANRemovePrefix is the prefix of ExtraSANs entries that remove a SAN instead of adding one.
+const extraSANRemovePrefix = "-"
+
+// extraSANInterfacePrefix is the prefix of ExtraSANs entries that are expanded to the addresses of a network interface,
+// e.g. "interface:eth0" (all addresses), "interface:eth0:ipv4" or "interface:eth0:ipv6".
+const extraSANInterfacePrefix = "interface:"
+
+// canonicalSAN returns the canonical form of a SAN, used to compare SANs. DNS names are compared case-insensitively,
+// and IP addresses are compared by value (e.g. "::1" and "0:0:0:0:0:0:0:1" are the same SAN).
+func canonicalSAN(san string) string {
+	if ip := net.ParseIP(san); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(san)
+}
+
+// indexOfSAN returns the index of a SAN in a list of SANs (see canonicalSAN), or -1 if it is not present.
+func indexOfSAN(sans []string, san string) int {
+	canonical := canonicalSAN(san)
+	for idx, existing := range sans {
+		if canonicalSAN(existing) == canonical {
+			return idx
+		}
+	}
+	return -1
+}
+
+// mergeExtraSANs applies a list of ExtraSANs entries on top of an existing list of SANs.
+// Entries prefixed with "-" remove a matching SAN (removing a SAN that is not present is a no-op).
+// Any other entry is appended, unless it is already present. SANs are compared in canonical form (see canonicalSAN),
+// and the first-seen form of duplicate SANs is kept.
+func mergeExtraSANs(sans []string, entries []string) []string {
+	result := make([]string, 0, len(sans)+len(entries))
+	for _, san := range sans {
+		if indexOfSAN(result, san) == -1 {
+			result = append(result, san)
+		}
+	}
+	for _, entry := range entries {
+		if san := strings.TrimPrefix(entry, extraSANRemovePrefix); san != entry {
+			if idx := indexOfSAN(result, san); idx != -1 {
+				result = append(result[:idx], result[idx+1:]...)
+			}
+			continue
+		}
+		if indexOfSAN(result, entry) == -1 {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// parseInterfaceSAN parses an ExtraSANs interface entry (without removal prefix), e.g. "interface:eth0:ipv6".
+// ok is false if the entry is not an interface entry.
+func parseInterfaceSAN(entry string) (name string, family string, ok bool, err error) {
+	if !strings.HasPrefix(entry, extraSANInterfacePrefix) {
+		return "", "", false, nil
+	}
+	name = strings.TrimPrefix(entry, extraSANInterfacePrefix)
+	if idx := strings.Index(name, ":"); idx != -1 {
+		name, family = name[:idx], name[idx+1:]
+		if family != "ipv4" && family != "ipv6" {
+			return "", "", true, fmt.Errorf("unknown address family %q, must be one of \"ipv4\" or \"ipv6\"", family)
+		}
+	}
+	if name == "" {
+		return "", "", true, fmt.Errorf("interface name must not be empty")
+	}
+	return name, family, true, nil
+}
+
+// localInterfaceAddrs returns the IP addresses of a local network interface.
+func localInterfaceAddrs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown network interface %q: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of network interface %q: %w", name, err)
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// expandInterfaceSANs replaces ExtraSANs interface entries (e.g. "interface:eth0") with the current addresses of the
+// interface, as returned by interfaceAddrs. Link-local addresses are skipped. A removal marker (e.g. "-interface:eth0")
+// expands to a removal marker for each address. Other entries are returned as is.
+func expandInterfaceSANs(entries []string, interfaceAddrs func(name string) ([]net.IP, error)) ([]string, error) {
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		san := strings.TrimPrefix(entry, extraSANRemovePrefix)
+		prefix := entry[:len(entry)-len(san)]
+		name, family, ok, err := parseInterfaceSAN(san)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SAN %q: %w", entry, err)
+		}
+		if !ok {
+			result = append(result, entry)
+			continue
+		}
+
+		ips, err := interfaceAddrs(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand SAN %q: %w", entry, err)
+		}
+		for _, ip := range ips {
+			isIPv4 := ip.To4() != nil
+			switch {
+			case ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast():
+			case family == "ipv4" && !isIPv4, family == "ipv6" && isIPv4:
+			default:
+				result = append(result, prefix+ip.String())
+			}
+		}
+	}
+	return result, nil
+}
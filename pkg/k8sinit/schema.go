@@ -3,287 +3,1050 @@ package k8sinit
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/util/version"
 	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
 )
 
+// CurrentVersion is the newest config file version supported by the agent. Tools generating configurations should use
+// it instead of a string literal, so that generated configurations always match what the agent expects.
+const CurrentVersion = "0.2.0"
+
+// SupportedVersions are the released config file versions supported by the agent, oldest first.
+var SupportedVersions = []string{"0.1.0", CurrentVersion}
+
+// IsVersionSupported returns true if version is a valid semantic version within the range of supported config file
+// versions, e.g. "0.1.0" or "0.1.5".
+func IsVersionSupported(configVersion string) bool {
+	_, err := validateVersion(configVersion)
+	return err == nil
+}
+
 var (
-	minimumConfigFileVersionRequired  = version.MustParseSemantic("0.1.0")
-	maximumConfigFileVersionSupported = version.MustParseSemantic("0.2.0")
+	minimumConfigFileVersionRequired  = version.MustParseSemantic(SupportedVersions[0])
+	maximumConfigFileVersionSupported = version.MustParseSemantic(CurrentVersion)
 
 	// errEmptyConfig is an ignorable error when parsing empty YAML documents
 	errEmptyConfig = fmt.Errorf("empty configuration object")
+
+	// ErrEmptyConfiguration is returned when all documents of a multi-part configuration are empty.
+	ErrEmptyConfiguration = errors.New("configuration does not contain any non-empty documents")
+
+	// ErrMissingVersion is returned when a configuration does not set a version.
+	ErrMissingVersion = errors.New("configuration is missing required field 'version'")
 )
 
+// ConfigParseError is returned when a document of a multi-part configuration fails to parse.
+type ConfigParseError struct {
+	// Part is the zero-based index of the YAML document that failed to parse.
+	Part int
+	// Line is the line number of the error within the YAML document, or 0 if not known.
+	Line int
+	// Err is the underlying parse error.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *ConfigParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("config part %d (line %d): %v", e.Part, e.Line, e.Err)
+	}
+	return fmt.Sprintf("config part %d: %v", e.Part, e.Err)
+}
+
+// Unwrap returns the underlying parse error.
+func (e *ConfigParseError) Unwrap() error {
+	return e.Err
+}
+
+// yamlErrorLineRegexp matches the line number reported by yaml.v2 errors.
+var yamlErrorLineRegexp = regexp.MustCompile(`line (\d+)`)
+
+// newConfigParseError wraps err in a *ConfigParseError, extracting the line number if present.
+func newConfigParseError(part int, err error) *ConfigParseError {
+	parseErr := &ConfigParseError{Part: part, Err: err}
+	if m := yamlErrorLineRegexp.FindStringSubmatch(err.Error()); len(m) == 2 {
+		parseErr.Line, _ = strconv.Atoi(m[1])
+	}
+	return parseErr
+}
+
 // JoinConfiguration is configuration to join the local node to an existing MicroK8s cluster.
 type JoinConfiguration struct {
 	// URL is the URL passed to the microk8s join command.
-	URL string `yaml:"url"`
+	URL string `yaml:"url,omitempty"`
 
 	// Worker is true when joining the cluster as a worker-only node.
-	Worker bool `yaml:"worker"`
+	Worker bool `yaml:"worker,omitempty"`
 }
 
 // AddonConfiguration specifies an addon to be enabled or disabled.
 type AddonConfiguration struct {
-	// Name of the addon to configure.
-	Name string `yaml:"name"`
+	// Name of the addon to configure. The name may be qualified with the addon repository, e.g. "core/dns".
+	Name string `yaml:"name,omitempty"`
+
+	// Repository is an optional addon repository of the addon, e.g. "community". Must not be set if Name is already qualified.
+	Repository string `yaml:"repository,omitempty"`
 
 	// Disable the addon.
-	Disable bool `yaml:"disable"`
+	Disable bool `yaml:"disable,omitempty"`
 
 	// Arguments is optional arguments passed to the addon enable or disable operation.
-	Arguments []string `yaml:"args"`
+	// Arguments may reference information about the local node, e.g. "{{.NodeName}}" or "{{.NodeIP}}" (see NodeInfo).
+	Arguments []string `yaml:"args,omitempty"`
+
+	// Priority is an optional priority for enabling the addon. Addons are enabled by ascending priority.
+	// Addons without a priority have priority 0. Addons are always disabled before any addons are enabled.
+	Priority int `yaml:"priority,omitempty"`
+
+	// Timeout is an optional timeout for the addon enable or disable operation, e.g. "5m".
+	Timeout string `yaml:"timeout,omitempty"`
+
+	// FailurePolicy is what happens when the addon enable or disable operation fails or times out.
+	// Defaults to "abort", which stops applying the configuration. With "continue", the failure is reported after the
+	// rest of the configuration is applied.
+	FailurePolicy AddonFailurePolicy `yaml:"failurePolicy,omitempty"`
 }
 
-// AddonRepositoryConfiguration specifies an addon repository to be added.
+// AddonFailurePolicy is what happens when enabling or disabling an addon (or running a hook command) fails.
+type AddonFailurePolicy string
+
+const (
+	// AddonFailurePolicyAbort stops applying the configuration.
+	AddonFailurePolicyAbort AddonFailurePolicy = "abort"
+	// AddonFailurePolicyContinue continues applying the configuration, and reports the failure at the end.
+	AddonFailurePolicyContinue AddonFailurePolicy = "continue"
+)
+
+// QualifiedName returns the name of the addon, qualified with its repository (if any), e.g. "community/istio".
+func (a AddonConfiguration) QualifiedName() string {
+	if a.Repository != "" {
+		return fmt.Sprintf("%s/%s", a.Repository, a.Name)
+	}
+	return a.Name
+}
+
+// AddonRepositoryConfiguration specifies an addon repository to be added or removed.
 type AddonRepositoryConfiguration struct {
 	// Name of the addon repository.
-	Name string `yaml:"name"`
-	// URL of the addon repository.
-	URL string `yaml:"url"`
+	Name string `yaml:"name,omitempty"`
+	// URL of the addon repository, e.g. "https://github.com/canonical/microk8s-community-addons", or a local path.
+	// URL is not required if the repository is removed.
+	URL string `yaml:"url,omitempty"`
 	// Reference is an optional reference to check out instead of the default branch.
-	Reference string `yaml:"reference"`
+	Reference string `yaml:"reference,omitempty"`
+	// Disable removes the addon repository from the local node, if it is configured.
+	Disable bool `yaml:"disable,omitempty"`
+}
+
+// RegistryMirrorConfiguration configures the mirrors of a container registry. The mirrors are written to the containerd
+// hosts.toml file of the registry, in $SNAP_DATA/args/certs.d.
+type RegistryMirrorConfiguration struct {
+	// Host is the registry, e.g. "docker.io" or "registry.example.com:5000".
+	Host string `yaml:"host,omitempty"`
+	// Mirrors are the URLs of the mirrors, e.g. "https://mirror.example.com". Images are pulled from the mirrors in order,
+	// and from the registry itself if no mirror has the image.
+	Mirrors []string `yaml:"mirrors,omitempty"`
+	// Username and Password are optional credentials, used for basic authentication with the mirrors.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Remove removes the hosts.toml file of the registry, so that images are pulled from the registry itself.
+	Remove bool `yaml:"remove,omitempty"`
 }
 
+// ContainerdConfiguration is configuration for the local node containerd.
+type ContainerdConfiguration struct {
+	// ExtraArgs is a list of extra arguments to add to the local node containerd.
+	// Set a value to null to remove it from the arguments.
+	ExtraArgs ExtraArgs `yaml:"extraArgs,omitempty"`
+
+	// ConfigToml is the containerd configuration. It is written verbatim to $SNAP_DATA/args/containerd-template.toml.
+	ConfigToml string `yaml:"configToml,omitempty"`
+}
+
+// DatastoreConfiguration is configuration for the local node datastore (k8s-dqlite).
+type DatastoreConfiguration struct {
+	// ExtraArgs is a list of extra arguments to add to the local node k8s-dqlite.
+	// Set a value to null to remove it from the arguments.
+	ExtraArgs ExtraArgs `yaml:"extraArgs,omitempty"`
+
+	// AllowUnsafe allows changing arguments that are unsafe to change on a running datastore (e.g. "--storage-dir").
+	AllowUnsafe bool `yaml:"allowUnsafe,omitempty"`
+}
+
+// CNIConfiguration is configuration for the cluster CNI. At most one of Config, Calico and Flannel may be set.
+type CNIConfiguration struct {
+	// Config is an inline CNI manifest (YAML or JSON). It replaces the default CNI manifest ($SNAP_DATA/args/cni-network/cni.yaml).
+	Config string `yaml:"config,omitempty"`
+
+	// Calico is configuration for the default Calico CNI.
+	Calico *CalicoConfiguration `yaml:"calico,omitempty"`
+
+	// Flannel is configuration for the Flannel CNI, used when the cluster is not highly-available.
+	Flannel *FlannelConfiguration `yaml:"flannel,omitempty"`
+}
+
+// AuditPolicyConfiguration is configuration for API server audit logging. The policy is written to
+// $SNAP_DATA/args/audit-policy.yaml, and set with the kube-apiserver "--audit-policy-file" and "--audit-log-path" arguments,
+// which must not also be set in ExtraKubeAPIServerArgs. An empty policy removes these arguments, which disables audit logging.
+type AuditPolicyConfiguration struct {
+	// Policy is an inline Kubernetes audit Policy document (audit.k8s.io/v1), in YAML.
+	Policy string `yaml:"policy,omitempty"`
+
+	// LogPath is the file that audit events are written to, or "-" for standard output.
+	// Defaults to "${SNAP_COMMON}/var/log/kube-apiserver-audit.log".
+	LogPath string `yaml:"logPath,omitempty"`
+}
+
+// FeatureGatesConfiguration are feature gates of the Kubernetes components, e.g. `kubelet: {GracefulNodeShutdown: true}`.
+// Feature gates are merged into the existing "--feature-gates" argument of the component, which must not also be set in
+// the extra arguments of the component. Gates that are not mentioned keep their current value.
+type FeatureGatesConfiguration struct {
+	// KubeAPIServer are feature gates of kube-apiserver.
+	KubeAPIServer map[string]bool `yaml:"kubeAPIServer,omitempty"`
+
+	// Kubelet are feature gates of kubelet.
+	Kubelet map[string]bool `yaml:"kubelet,omitempty"`
+
+	// KubeControllerManager are feature gates of kube-controller-manager.
+	KubeControllerManager map[string]bool `yaml:"kubeControllerManager,omitempty"`
+
+	// KubeScheduler are feature gates of kube-scheduler.
+	KubeScheduler map[string]bool `yaml:"kubeScheduler,omitempty"`
+
+	// KubeProxy are feature gates of kube-proxy.
+	KubeProxy map[string]bool `yaml:"kubeProxy,omitempty"`
+}
+
+// AdmissionPluginsConfiguration are admission plugins of kube-apiserver to enable or disable, e.g.
+// `enable: [NodeRestriction]`. They are merged into the existing "--enable-admission-plugins" and
+// "--disable-admission-plugins" arguments of kube-apiserver, which must not also be set in ExtraKubeAPIServerArgs.
+// Plugins that are not mentioned keep their current state.
+type AdmissionPluginsConfiguration struct {
+	// Enable are admission plugins to enable.
+	Enable []string `yaml:"enable,omitempty"`
+
+	// Disable are admission plugins to disable. A plugin cannot be both enabled and disabled.
+	Disable []string `yaml:"disable,omitempty"`
+}
+
+// EncryptionConfiguration is configuration for encryption at rest of API server resources. It is written to
+// $SNAP_DATA/args/encryption-config.yaml, and set with the kube-apiserver "--encryption-provider-config" argument, which
+// must not also be set in ExtraKubeAPIServerArgs.
+type EncryptionConfiguration struct {
+	// Resources are the resources to encrypt, e.g. "secrets" or "configmaps". Defaults to "secrets".
+	Resources []string `yaml:"resources,omitempty"`
+
+	// Providers are the encryption providers, in order. The first provider encrypts new data, all providers are used to
+	// decrypt existing data. Add an "identity" provider last to read resources that are not encrypted yet.
+	Providers []EncryptionProviderConfiguration `yaml:"providers,omitempty"`
+}
+
+// EncryptionProviderConfiguration is configuration for a single encryption provider.
+type EncryptionProviderConfiguration struct {
+	// Type is the provider, one of "aescbc", "secretbox", "kms" or "identity".
+	Type string `yaml:"type"`
+
+	// Keys are the encryption keys of the "aescbc" and "secretbox" providers. The first key is used for encryption.
+	Keys []EncryptionKeyConfiguration `yaml:"keys,omitempty"`
+
+	// Name is the name of the KMS plugin, for the "kms" provider.
+	Name string `yaml:"name,omitempty"`
+
+	// Endpoint is the unix socket of the KMS plugin, for the "kms" provider, e.g. "unix:///var/run/kms.sock".
+	Endpoint string `yaml:"endpoint,omitempty"`
+
+	// Timeout is the timeout of calls to the KMS plugin, for the "kms" provider, e.g. "3s".
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// OIDCConfiguration is configuration for authenticating API server users with OpenID Connect tokens. It is set with the
+// kube-apiserver "--oidc-*" arguments, which must not also be set in ExtraKubeAPIServerArgs.
+type OIDCConfiguration struct {
+	// IssuerURL is the https URL of the OpenID provider, e.g. "https://accounts.example.com". Required.
+	IssuerURL string `yaml:"issuerURL"`
+
+	// ClientID is the client ID that tokens must be issued for, e.g. "kubernetes". Required.
+	ClientID string `yaml:"clientID"`
+
+	// UsernameClaim is the token claim used as the user name, e.g. "email". Defaults to "sub".
+	UsernameClaim string `yaml:"usernameClaim,omitempty"`
+
+	// GroupsClaim is the token claim used as the groups of the user, e.g. "groups". If empty, groups are not read from tokens.
+	GroupsClaim string `yaml:"groupsClaim,omitempty"`
+
+	// CAFile is the path of the CA certificate that signed the certificate of the OpenID provider, e.g.
+	// "/etc/ssl/certs/oidc-ca.pem". If neither CAFile nor CA is set, the CA certificates of the host are used.
+	CAFile string `yaml:"caFile,omitempty"`
+
+	// CA is an inline PEM-encoded CA certificate that signed the certificate of the OpenID provider. It is written to
+	// $SNAP_DATA/args/oidc-ca.crt. CA cannot be used together with CAFile.
+	CA string `yaml:"ca,omitempty"`
+}
+
+// EncryptionKeyConfiguration is an encryption key.
+type EncryptionKeyConfiguration struct {
+	// Name identifies the key, e.g. "key1".
+	Name string `yaml:"name"`
+
+	// Secret is the base64-encoded key material.
+	Secret string `yaml:"secret"`
+}
+
+// CalicoConfiguration is configuration for the Calico CNI. Unset values keep the existing manifest values.
+type CalicoConfiguration struct {
+	// PodCIDR is the IPv4 pool CIDR used for pod IPs, e.g. "10.1.0.0/16".
+	PodCIDR string `yaml:"podCIDR,omitempty"`
+
+	// MTU is the MTU of the pod network interfaces, e.g. 1440.
+	MTU int `yaml:"mtu,omitempty"`
+}
+
+// FlannelConfiguration is configuration for the Flannel CNI.
+type FlannelConfiguration struct {
+	// PodCIDR is the CIDR used for pod IPs, e.g. "10.1.0.0/16".
+	PodCIDR string `yaml:"podCIDR,omitempty"`
+}
+
+// HooksConfiguration is commands that are run on the local node before and after the configuration is applied.
+// Each command is run with "/bin/sh -c", and its output is captured in the ApplyResult.
+type HooksConfiguration struct {
+	// PreApply are commands that are run, in order, before any changes are made.
+	PreApply []string `yaml:"preApply,omitempty"`
+
+	// PostApply are commands that are run, in order, after the configuration was applied successfully.
+	PostApply []string `yaml:"postApply,omitempty"`
+
+	// Timeout is an optional timeout for each command, e.g. "1m". Defaults to DefaultHookTimeout.
+	Timeout string `yaml:"timeout,omitempty"`
+
+	// FailurePolicy is what happens when a command fails or times out. Defaults to "abort", which stops applying the
+	// configuration (before any changes are made, for pre-apply commands). With "continue", the failure is logged and
+	// recorded in the ApplyResult.
+	FailurePolicy AddonFailurePolicy `yaml:"failurePolicy,omitempty"`
+}
+
+// RestartPolicy controls which services are restarted after a configuration is applied.
+type RestartPolicy string
+
+const (
+	// RestartPolicyAlways restarts all services affected by the configuration, even if their configuration did not change.
+	RestartPolicyAlways RestartPolicy = "always"
+	// RestartPolicyOnChange restarts only services whose configuration changed.
+	RestartPolicyOnChange RestartPolicy = "onChange"
+	// RestartPolicyNever does not restart any services. Services must be restarted manually to apply the configuration.
+	RestartPolicyNever RestartPolicy = "never"
+)
+
 // MultiPartConfiguration is a configuration split into multiple parts.
 type MultiPartConfiguration struct {
 	// Parts are configuration objects that are meant to be applied in order.
 	Parts []*Configuration
+
+	// SkippedEmptyParts is the number of empty YAML documents that were skipped while parsing.
+	SkippedEmptyParts int
 }
 
 // Configuration is the top-level definition for MicroK8s configuration files.
+//
+// Keys of extra arguments maps are flag names with two leading dashes, e.g. "--max-pods". Single-letter flags may also be
+// written with a single leading dash, e.g. "-l". Keys of extra environment maps are
+// environment variable names, e.g. "HTTP_PROXY".
+//
+// Values of extra arguments and environment maps may be any YAML scalar, e.g. `--max-pods: 110` or `--feature: true`. Numbers
+// and booleans are used exactly as written (e.g. 1.50 is not changed to 1.5). Null values remove the argument.
+//
+// Extra arguments may also be a list of "--flag=value" arguments, for flags that must appear multiple times (see ExtraArgs).
 type Configuration struct {
 	// Version is the semantic version of the configuration file format.
-	Version string `yaml:"version"`
+	Version string `yaml:"version,omitempty"`
+
+	// Roles are the node roles (e.g. "control-plane" or "worker") that this configuration applies to. Configurations
+	// without roles apply to all nodes. This allows shipping the same multi-part configuration fleet-wide, with parts
+	// (e.g. ExtraKubeAPIServerArgs) that are only applied to control plane nodes.
+	Roles []NodeRole `yaml:"roles,omitempty"`
+
+	// Include is a list of configuration files to parse and merge before the fields of this configuration.
+	// Paths are relative to the include directory (see ParseOptions.IncludeFS). Includes are resolved while parsing.
+	Include []string `yaml:"include,omitempty"`
+
+	// Annotations are free-form notes about the configuration, e.g. who changed it and why. Annotations are kept when the
+	// configuration is parsed, merged and marshaled, but are not applied to the node and do not affect Equal or whether the
+	// configuration is considered already applied.
+	Annotations map[string]string `yaml:"annotations,omitempty"`
 
 	// AddonRepositories is extra addon repositories to configure on the local node.
-	AddonRepositories []AddonRepositoryConfiguration `yaml:"addonRepositories"`
+	AddonRepositories []AddonRepositoryConfiguration `yaml:"addonRepositories,omitempty"`
 
 	// Addons is a list of addons to enable and/or disable.
-	Addons []AddonConfiguration `yaml:"addons"`
+	Addons []AddonConfiguration `yaml:"addons,omitempty"`
 
 	// ExtraKubeletArgs is a list of extra arguments to add to the local node kubelet.
-	// Set a value to null to remove it from the arguments.
-	ExtraKubeletArgs map[string]*string `yaml:"extraKubeletArgs"`
+	// Set a value to null to remove it from the arguments. Set a value to "" to set the argument with an empty value.
+	ExtraKubeletArgs ExtraArgs `yaml:"extraKubeletArgs,omitempty"`
 
 	// ExtraKubeAPIServerArgs is a list of extra arguments to add to the local node kube-apiserver.
-	// Set a value to null to remove it from the arguments.
-	ExtraKubeAPIServerArgs map[string]*string `yaml:"extraKubeAPIServerArgs"`
+	// Set a value to null to remove it from the arguments. Set a value to "" to set the argument with an empty value.
+	ExtraKubeAPIServerArgs ExtraArgs `yaml:"extraKubeAPIServerArgs,omitempty"`
 
 	// ExtraKubeProxyArgs is a list of extra arguments to add to the local node kube-proxy.
 	// Set a value to null to remove it from the arguments.
-	ExtraKubeProxyArgs map[string]*string `yaml:"extraKubeProxyArgs"`
+	ExtraKubeProxyArgs ExtraArgs `yaml:"extraKubeProxyArgs,omitempty"`
 
 	// ExtraKubeControllerManagerArgs is a list of extra arguments to add to the local node kube-controller-manager.
 	// Set a value to null to remove it from the arguments.
-	ExtraKubeControllerManagerArgs map[string]*string `yaml:"extraKubeControllerManagerArgs"`
+	ExtraKubeControllerManagerArgs ExtraArgs `yaml:"extraKubeControllerManagerArgs,omitempty"`
 
 	// ExtraKubeSchedulerArgs is a list of extra arguments to add to the local node kube-scheduler.
 	// Set a value to null to remove it from the arguments.
-	ExtraKubeSchedulerArgs map[string]*string `yaml:"extraKubeSchedulerArgs"`
+	ExtraKubeSchedulerArgs ExtraArgs `yaml:"extraKubeSchedulerArgs,omitempty"`
 
 	// ExtraKubeliteEnv is extra environment variables (e.g. GOFIPS) for the local node Kubernetes services.
-	ExtraKubeliteEnv map[string]*string `yaml:"extraKubeliteEnv"`
+	ExtraKubeliteEnv ExtraArgs `yaml:"extraKubeliteEnv,omitempty"`
 
 	// ExtraSANs are a list of extra Subject Alternate Names to add to the local API server.
-	ExtraSANs *[]string `yaml:"extraSANs"`
+	// SANs are accumulated across configuration parts. Prefix an entry with "-" (e.g. "-10.0.0.5") to remove a SAN added by a previous part.
+	// Entries like "interface:eth0" (or "interface:eth0:ipv4", "interface:eth0:ipv6") are expanded to the current addresses of
+	// the network interface when the configuration is applied.
+	ExtraSANs *[]string `yaml:"extraSANs,omitempty"`
 
 	// ContainerdRegistryConfigs is containerd hosts.toml configurations to configure registries.
-	ContainerdRegistryConfigs map[string]string `yaml:"containerdRegistryConfigs"`
+	ContainerdRegistryConfigs map[string]string `yaml:"containerdRegistryConfigs,omitempty"`
+
+	// RegistryMirrors are mirrors of container registries, e.g. for air-gapped environments or to avoid rate limits.
+	// Mirrors of the same registry in a later configuration part override earlier ones. Changing the mirrors restarts containerd.
+	RegistryMirrors []RegistryMirrorConfiguration `yaml:"registryMirrors,omitempty"`
 
 	// ExtraContainerdArgs is a list of extra arguments to add to the local node containerd.
 	// Set a value to null to remove it from the arguments.
-	ExtraContainerdArgs map[string]*string `yaml:"extraContainerdArgs"`
+	// Deprecated: use Containerd.ExtraArgs with config file version 0.2.0 or newer.
+	ExtraContainerdArgs ExtraArgs `yaml:"extraContainerdArgs,omitempty"`
 
 	// ExtraContainerdEnv is extra environment variables (e.g. proxy configuration) for the local node containerd.
 	// Set a value to null to remove it from the environment.
-	ExtraContainerdEnv map[string]*string `yaml:"extraContainerdEnv"`
+	ExtraContainerdEnv ExtraArgs `yaml:"extraContainerdEnv,omitempty"`
+
+	// ContainerRuntime is the container runtime used by the local node kubelet. Defaults to "containerd".
+	// Switching the container runtime updates the kubelet arguments and restarts the affected services.
+	ContainerRuntime string `yaml:"containerRuntime,omitempty"`
+
+	// Containerd is configuration for the local node containerd.
+	Containerd ContainerdConfiguration `yaml:"containerd,omitempty"`
 
 	// ExtraDqliteArgs is a list of extra arguments to add to the local node Dqlite.
 	// Set a value to null to remove it from the arguments.
-	ExtraDqliteArgs map[string]*string `yaml:"extraDqliteArgs"`
+	// Deprecated: use Datastore.ExtraArgs with config file version 0.2.0 or newer.
+	ExtraDqliteArgs ExtraArgs `yaml:"extraDqliteArgs,omitempty"`
+
+	// Datastore is configuration for the local node datastore (k8s-dqlite).
+	Datastore DatastoreConfiguration `yaml:"datastore,omitempty"`
 
 	// ExtraDqliteEnv is extra environment variables (e.g. dqlite debug flags) for the local node dqlite.
 	// Set a value to null to remove it from the environment.
-	ExtraDqliteEnv map[string]*string `yaml:"extraDqliteEnv"`
+	ExtraDqliteEnv ExtraArgs `yaml:"extraDqliteEnv,omitempty"`
 
 	// ExtraMicroK8sClusterAgentArgs is a list of extra arguments to add to the local node cluster-agent.
 	// Set a value to null to remove it from the arguments.
-	ExtraMicroK8sClusterAgentArgs map[string]*string `yaml:"extraMicroK8sClusterAgentArgs"`
+	ExtraMicroK8sClusterAgentArgs ExtraArgs `yaml:"extraMicroK8sClusterAgentArgs,omitempty"`
 
 	// ExtraMicroK8sClusterAgentEnv is extra environment variables (e.g. GOFIPS) for the local node cluster-agent.
 	// Set a value to null to remove it from the environment.
-	ExtraMicroK8sClusterAgentEnv map[string]*string `yaml:"extraMicroK8sClusterAgentEnv"`
+	ExtraMicroK8sClusterAgentEnv ExtraArgs `yaml:"extraMicroK8sClusterAgentEnv,omitempty"`
 
 	// ExtraMicroK8sAPIServerProxyArgs is a list of extra arguments (e.g. --refresh-interval) to add to the local node apiserver-proxy used by worker nodes.
 	// Set a value to null to remove it from the arguments.
-	ExtraMicroK8sAPIServerProxyArgs map[string]*string `yaml:"extraMicroK8sAPIServerProxyArgs"`
+	ExtraMicroK8sAPIServerProxyArgs ExtraArgs `yaml:"extraMicroK8sAPIServerProxyArgs,omitempty"`
 
 	// ExtraMicroK8sAPIServerProxyEnv is extra environment variables (e.g. GOFIPS) for the local node apiserver-proxy.
 	// Set a value to null to remove it from the environment.
-	ExtraMicroK8sAPIServerProxyEnv map[string]*string `yaml:"extraMicroK8sAPIServerProxyEnv"`
+	ExtraMicroK8sAPIServerProxyEnv ExtraArgs `yaml:"extraMicroK8sAPIServerProxyEnv,omitempty"`
 
 	// ExtraEtcdArgs is a list of extra arguments to add to the local node etcd.
 	// Set a value to null to remove it from the arguments.
-	ExtraEtcdArgs map[string]*string `yaml:"extraEtcdArgs"`
+	ExtraEtcdArgs ExtraArgs `yaml:"extraEtcdArgs,omitempty"`
 
 	// ExtraEtcdEnv is extra environment variables (e.g. GOFIPS) for the local node etcd.
 	// Set a value to null to remove it from the environment.
-	ExtraEtcdEnv map[string]*string `yaml:"extraEtcdEnv"`
+	ExtraEtcdEnv ExtraArgs `yaml:"extraEtcdEnv,omitempty"`
 
 	// ExtraFlanneldArgs is a list of extra arguments to add to the local node flanneld.
 	// Set a value to null to remove it from the arguments.
-	ExtraFlanneldArgs map[string]*string `yaml:"extraFlanneldArgs"`
+	ExtraFlanneldArgs ExtraArgs `yaml:"extraFlanneldArgs,omitempty"`
 
 	// ExtraFlanneldEnv is extra environment variables (e.g. GOFIPS) for the local node flanneld.
 	// Set a value to null to remove it from the environment.
-	ExtraFlanneldEnv map[string]*string `yaml:"extraFlanneldEnv"`
+	ExtraFlanneldEnv ExtraArgs `yaml:"extraFlanneldEnv,omitempty"`
 
 	// ExtraConfigFiles is extra service configuration files to create (e.g. for configuring kube-apiserver encryption at rest).
 	// These files will be written at $SNAP_DATA/args/<filename>.
-	ExtraConfigFiles map[string]string `yaml:"extraConfigFiles"`
+	ExtraConfigFiles map[string]string `yaml:"extraConfigFiles,omitempty"`
 
 	// PersistentClusterToken is a token that may be used to authentication join requests to the local node.
-	PersistentClusterToken string `yaml:"persistentClusterToken"`
+	PersistentClusterToken string `yaml:"persistentClusterToken,omitempty"`
+
+	// RestartServices controls which services are restarted after the configuration is applied. Defaults to "onChange".
+	RestartServices RestartPolicy `yaml:"restartServices,omitempty"`
 
 	// Join configuration. Setting this will attempt to join the local node to an already existing MicroK8s cluster.
-	Join JoinConfiguration `yaml:"join"`
+	Join JoinConfiguration `yaml:"join,omitempty"`
+
+	// NodeLabels are labels of the local node, e.g. `node-role.kubernetes.io/worker: ""`. They are set with the kubelet
+	// "--node-labels" argument, which must not also be set in ExtraKubeletArgs.
+	NodeLabels map[string]string `yaml:"nodeLabels,omitempty"`
+
+	// NodeTaints are taints of the local node in the form "key[=value]:effect", e.g. "dedicated=gpu:NoSchedule". They are
+	// set with the kubelet "--register-with-taints" argument, which must not also be set in ExtraKubeletArgs.
+	NodeTaints []string `yaml:"nodeTaints,omitempty"`
+
+	// AuditPolicy enables audit logging of the API server. Set to null to disable audit logging again.
+	AuditPolicy *AuditPolicyConfiguration `yaml:"auditPolicy,omitempty"`
+
+	// KubeletConfig is an inline KubeletConfiguration (kubelet.config.k8s.io/v1beta1) document, for kubelet settings
+	// that cannot be set with flags. It is merged shallowly (by top-level field) into the kubelet config file written by
+	// previous configurations, and passed to the kubelet with the "--config" argument, which must not also be set in
+	// ExtraKubeletArgs. Set to null to remove the "--config" argument again.
+	KubeletConfig *string `yaml:"kubeletConfig,omitempty"`
+
+	// FeatureGates are feature gates of the Kubernetes components. They are merged into the existing "--feature-gates"
+	// argument of each component, instead of replacing it.
+	FeatureGates FeatureGatesConfiguration `yaml:"featureGates,omitempty"`
+
+	// AdmissionPlugins are admission plugins of kube-apiserver to enable or disable. They are merged into the existing
+	// admission plugin arguments of kube-apiserver, instead of replacing them.
+	AdmissionPlugins AdmissionPluginsConfiguration `yaml:"admissionPlugins,omitempty"`
+
+	// Encryption configures encryption at rest of API server resources.
+	Encryption *EncryptionConfiguration `yaml:"encryption,omitempty"`
+
+	// OIDC configures authentication of API server users with OpenID Connect tokens.
+	OIDC *OIDCConfiguration `yaml:"oidc,omitempty"`
+
+	// CNI is configuration for the cluster CNI.
+	CNI CNIConfiguration `yaml:"cni,omitempty"`
+
+	// Hooks are commands that are run before and after the configuration is applied.
+	Hooks HooksConfiguration `yaml:"hooks,omitempty"`
 
 	// ExtraCNIEnv is configuration of network such us IPv4/v6 cluster and service CIDRs.
-	ExtraCNIEnv map[string]*string `yaml:"extraCNIEnv"`
+	ExtraCNIEnv ExtraArgs `yaml:"extraCNIEnv,omitempty"`
 
 	// ExtraFIPSEnv is configuration for MicroK8s to run in FIPS mode.
-	ExtraFIPSEnv map[string]*string `yaml:"extraFIPSEnv"`
+	ExtraFIPSEnv ExtraArgs `yaml:"extraFIPSEnv,omitempty"`
+
+	// Source identifies the document the configuration was parsed from, e.g. "part 1" or "init.yaml part 1".
+	// It is set by ParseMultiPartConfiguration, and is not part of the configuration file format.
+	Source string `yaml:"-"`
 }
 
 // ParseConfiguration tries to parse a Configuration object from YAML data.
+// Since YAML is a superset of JSON, ParseConfiguration also accepts JSON data.
+//
+// YAML anchors, aliases and "<<" merge keys are supported within a single document, e.g. to reuse a block of
+// extra arguments for multiple services. Extra arguments set explicitly override the ones of a merge key. Anchors cannot
+// be referenced from other documents of a multi-part configuration.
+//
+// A configuration parses to the same Configuration as the only part of a multi-part configuration (see
+// ParseMultiPartConfiguration), except that Source is not set: empty documents are skipped, ErrEmptyConfiguration is
+// returned if all documents are empty, and more than one non-empty document is rejected.
 func ParseConfiguration(input []byte) (*Configuration, error) {
+	return ParseConfigurationWithOptions(input, ParseOptions{})
+}
+
+// ParseConfigurationWithOptions tries to parse a Configuration object from YAML data.
+// Any warnings (e.g. unknown fields) are logged to opts.Logger.
+func ParseConfigurationWithOptions(input []byte, opts ParseOptions) (*Configuration, error) {
+	c, warnings, err := parseSingleDocument(input, opts)
+	logWarnings(opts, warnings)
+	observeParse(metricsOrDefault(opts.Metrics), err)
+	return c, err
+}
+
+// ParseConfigurationWithWarnings tries to parse a Configuration object from YAML data.
+// Unknown fields are ignored, and are returned as warnings instead of being logged.
+func ParseConfigurationWithWarnings(input []byte) (*Configuration, []string, error) {
+	c, warnings, err := parseSingleDocument(input, ParseOptions{})
+	observeParse(DefaultMetrics, err)
+	return c, warnings, err
+}
+
+// logWarnings logs parse warnings to opts.Logger.
+func logWarnings(opts ParseOptions, warnings []string) {
+	logger := loggerOrDefault(opts.Logger)
+	for _, warning := range warnings {
+		logger.Warnf("%s", warning)
+	}
+}
+
+// parseSingleDocument parses YAML data with a single configuration document. Documents are parsed with
+// parseConfiguration and empty documents are skipped, the same as parts of a multi-part configuration, so that a
+// document parses to the same Configuration either way (apart from its Source). ErrEmptyConfiguration is returned if all
+// documents are empty, and more than one non-empty document is rejected instead of silently ignoring all but the first.
+func parseSingleDocument(input []byte, opts ParseOptions) (*Configuration, []string, error) {
+	reader := k8syaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(input)))
+
+	var c *Configuration
+	var warnings []string
+	for idx := 0; ; idx++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, warnings, &categorizedError{category: ParseErrorSyntax, err: fmt.Errorf("could not parse configuration: %w", err)}
+		}
+
+		part, partWarnings, err := parseConfiguration(doc, opts)
+		warnings = append(warnings, partWarnings...)
+		switch {
+		case errors.Is(err, errEmptyConfig):
+			continue
+		case err != nil:
+			return nil, warnings, err
+		case c != nil:
+			return nil, warnings, &categorizedError{category: ParseErrorSyntax, err: fmt.Errorf("configuration has more than one non-empty document, it must be parsed as a multi-part configuration")}
+		}
+		c = part
+	}
+	if c == nil {
+		return nil, warnings, ErrEmptyConfiguration
+	}
+	return c, warnings, nil
+}
+
+// parseConfiguration parses a Configuration object from YAML data, and returns it along with any warnings.
+func parseConfiguration(input []byte, opts ParseOptions) (*Configuration, []string, error) {
 	c := &Configuration{}
 
+	var warnings []string
 	if strictParseErr := yaml.UnmarshalStrict(input, c); strictParseErr != nil {
 		// If non-strict parsing also fails, then raise the error
 		if err := yaml.Unmarshal(input, c); err != nil {
-			return nil, fmt.Errorf("could not parse configuration: %w", err)
+			return nil, nil, &categorizedError{category: ParseErrorSyntax, err: fmt.Errorf("could not parse configuration: %w", err)}
+		}
+
+		warnings = append(warnings, strictParseWarnings(strictParseErr, mergeKeyRegexp.Match(input))...)
+		if opts.Strict && len(warnings) > 0 {
+			return nil, warnings, &categorizedError{category: ParseErrorSyntax, err: fmt.Errorf("could not parse configuration in strict mode: %w", strictParseErr)}
 		}
+	}
 
-		log.Printf("WARNING: configuration may contain unknown fields (error was %q).", strictParseErr)
-		log.Printf("Any unknown fields will be ignored")
+	if c.AuditPolicy == nil && hasNullField(input, "auditPolicy") {
+		// an explicit null disables audit logging, see AuditPolicyConfiguration
+		c.AuditPolicy = &AuditPolicyConfiguration{}
+	}
+	if c.KubeletConfig == nil && hasNullField(input, "kubeletConfig") {
+		// an explicit null removes the kubelet config file override
+		empty := ""
+		c.KubeletConfig = &empty
 	}
 
 	if c.isZero() {
-		return nil, errEmptyConfig
+		return nil, warnings, errEmptyConfig
+	}
+
+	if opts.ExpandEnv {
+		lookupEnv := opts.LookupEnv
+		if lookupEnv == nil {
+			lookupEnv = os.LookupEnv
+		}
+		if err := c.expandEnv(lookupEnv, opts.AllowEmptyEnv); err != nil {
+			return nil, warnings, &categorizedError{category: ParseErrorEnv, err: fmt.Errorf("failed to expand environment variables: %w", err)}
+		}
 	}
 
-	v, err := version.ParseSemantic(c.Version)
-	switch {
-	case err != nil:
-		return nil, fmt.Errorf("could not parse config file version %q: %w", c.Version, err)
-	case maximumConfigFileVersionSupported.LessThan(v):
-		return nil, fmt.Errorf("config file version is %v but the maximum version supported is %v", c.Version, maximumConfigFileVersionSupported)
-	case v.LessThan(minimumConfigFileVersionRequired):
-		return nil, fmt.Errorf("config file version is %v but the minimum version required is %v", c.Version, minimumConfigFileVersionRequired)
+	if err := c.ValidateWithOptions(opts.Validation); err != nil {
+		return nil, warnings, err
+	}
+	warnings = append(warnings, deprecationWarnings(c)...)
+	warnings = append(warnings, c.RoleWarnings(opts.Validation.NodeRole)...)
+	warnings = append(warnings, c.AddonConflictWarnings(opts.Validation.AddonArgConflicts)...)
+	if opts.Validation.ResolveDuplicateAddons {
+		var addonWarnings []string
+		c.Addons, addonWarnings = resolveDuplicateAddons(c.Addons)
+		warnings = append(warnings, addonWarnings...)
 	}
+	if len(opts.ExactVersions) > 0 {
+		if err := validateExactVersion(c.Version, opts.ExactVersions); err != nil {
+			return nil, warnings, &ValidationError{Errors: []error{err}}
+		}
+	}
+
+	if len(c.Include) > 0 {
+		merged, includeWarnings, err := c.resolveIncludes(opts)
+		warnings = append(warnings, includeWarnings...)
+		if err != nil {
+			return nil, warnings, err
+		}
+		return merged, warnings, nil
+	}
+
+	return c, warnings, nil
+}
+
+// Marshal serializes the configuration to canonical YAML. Empty sections are omitted. Arguments set to null are
+// rendered explicitly (as "key: null"), so that they are still removed after the configuration is parsed again.
+func (c *Configuration) Marshal() ([]byte, error) {
+	return c.MarshalWithOptions(MarshalOptions{})
+}
+
+// MarshalWithOptions serializes the configuration to canonical YAML (see Marshal). If opts.RedactSecrets is set, secret
+// values are masked in the output (see Redacted).
+func (c *Configuration) MarshalWithOptions(opts MarshalOptions) ([]byte, error) {
+	if opts.RedactSecrets {
+		c = c.Redacted(opts.SecretPatterns)
+	}
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal configuration: %w", err)
+	}
+	return b, nil
+}
+
+// unknownFieldRegexp matches yaml.v2 strict parsing errors for unknown fields.
+var unknownFieldRegexp = regexp.MustCompile(`field (\S+) not found in type k8sinit\.(\w+)`)
 
-	return c, nil
+// unknownFieldPrefixes maps configuration types to the path they appear at.
+var unknownFieldPrefixes = map[string]string{
+	"Configuration":                   "",
+	"AddonConfiguration":              "addons[].",
+	"AddonRepositoryConfiguration":    "addonRepositories[].",
+	"RegistryMirrorConfiguration":     "registryMirrors[].",
+	"JoinConfiguration":               "join.",
+	"ContainerdConfiguration":         "containerd.",
+	"AuditPolicyConfiguration":        "auditPolicy.",
+	"FeatureGatesConfiguration":       "featureGates.",
+	"AdmissionPluginsConfiguration":   "admissionPlugins.",
+	"EncryptionConfiguration":         "encryption.",
+	"EncryptionProviderConfiguration": "encryption.providers[].",
+	"EncryptionKeyConfiguration":      "encryption.providers[].keys[].",
+	"OIDCConfiguration":               "oidc.",
+}
+
+// duplicateKeyRegexp matches yaml.v2 strict parsing errors for duplicate keys.
+var duplicateKeyRegexp = regexp.MustCompile(`key (".*") already set in map`)
+
+// mergeKeyRegexp matches YAML "<<" merge keys.
+var mergeKeyRegexp = regexp.MustCompile(`(?m)^\s*(- )?<<\s*:`)
+
+// strictParseWarnings converts a strict parsing error to a list of warnings, one for each unknown field or duplicate key.
+// Strict parsing rejects keys that override values of a "<<" merge key as duplicates. If the document uses merge keys,
+// duplicate keys are therefore expected and not reported.
+func strictParseWarnings(strictParseErr error, hasMergeKeys bool) []string {
+	var typeErr *yaml.TypeError
+	if !errors.As(strictParseErr, &typeErr) {
+		return []string{fmt.Sprintf("configuration may contain unknown fields, which will be ignored (error was %q)", strictParseErr)}
+	}
+	warnings := make([]string, 0, len(typeErr.Errors))
+	for _, msg := range typeErr.Errors {
+		if m := unknownFieldRegexp.FindStringSubmatch(msg); len(m) == 3 {
+			warnings = append(warnings, fmt.Sprintf("unknown field %q will be ignored", unknownFieldPrefixes[m[2]]+m[1]))
+		} else if m := duplicateKeyRegexp.FindStringSubmatch(msg); len(m) == 2 {
+			if !hasMergeKeys {
+				warnings = append(warnings, fmt.Sprintf("duplicate key %s, the last value will be used", m[1]))
+			}
+		} else {
+			warnings = append(warnings, fmt.Sprintf("configuration may contain unknown fields, which will be ignored (error was %q)", msg))
+		}
+	}
+	return warnings
+}
+
+// ParseConfigurationJSON tries to parse a Configuration object from JSON data.
+// JSON is decoded using the same rules as YAML, so setting an extra argument to null removes it.
+func ParseConfigurationJSON(input []byte) (*Configuration, error) {
+	if !json.Valid(input) {
+		err := &categorizedError{category: ParseErrorSyntax, err: fmt.Errorf("could not parse configuration: input is not valid JSON")}
+		observeParse(DefaultMetrics, err)
+		return nil, err
+	}
+	return ParseConfiguration(input)
 }
 
 // ParseMultiPartConfiguration parses a multiple YAML configuration objects into a MultiPartConfiguration.
+// Empty documents are skipped. ErrEmptyConfiguration is returned if all documents are empty. Gzip-compressed input (e.g.
+// size-limited cloud-init user data) is decompressed before parsing.
+// Errors are returned as a *ConfigParseError, with the index of the YAML document that failed to parse.
 func ParseMultiPartConfiguration(b []byte) (MultiPartConfiguration, error) {
-	reader := k8syaml.NewYAMLReader(bufio.NewReader(bytes.NewBuffer(b)))
+	return ParseMultiPartConfigurationWithOptions(b, ParseOptions{})
+}
+
+// ParseMultiPartConfigurationWithOptions parses a multiple YAML configuration objects into a MultiPartConfiguration.
+// Configurations larger than the maximum size, or with more than the maximum number of parts, are rejected. For
+// gzip-compressed input, the maximum size applies to both the compressed and the decompressed configuration.
+// Errors are returned as a *ConfigParseError, with the index of the YAML document that failed to parse.
+func ParseMultiPartConfigurationWithOptions(b []byte, opts ParseOptions) (MultiPartConfiguration, error) {
+	maxSize := opts.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxConfigSize
+	}
+	if len(b) > maxSize {
+		err := &categorizedError{category: ParseErrorLimit, err: fmt.Errorf("configuration is %d bytes, but the maximum size is %d bytes", len(b), maxSize)}
+		observeParse(metricsOrDefault(opts.Metrics), err)
+		return MultiPartConfiguration{}, err
+	}
+
+	c, err := parseMultiPartConfiguration(bytes.NewReader(b), opts, nil)
+	observeParse(metricsOrDefault(opts.Metrics), err)
+	return c, err
+}
+
+// ParseMultiPartConfigurationLenient parses multiple YAML configuration objects into a MultiPartConfiguration, skipping
+// any parts that fail to parse. Each skipped part is returned as a PartError, and the remaining parts are parsed as usual.
+// Errors that affect the whole configuration (e.g. exceeding the maximum size or number of parts) are still returned as
+// an error. ErrEmptyConfiguration is returned if no parts could be parsed.
+func ParseMultiPartConfigurationLenient(b []byte, opts ParseOptions) (MultiPartConfiguration, []PartError, error) {
+	maxSize := opts.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxConfigSize
+	}
+	metrics := metricsOrDefault(opts.Metrics)
+	if len(b) > maxSize {
+		err := &categorizedError{category: ParseErrorLimit, err: fmt.Errorf("configuration is %d bytes, but the maximum size is %d bytes", len(b), maxSize)}
+		observeParse(metrics, err)
+		return MultiPartConfiguration{}, nil, err
+	}
+
+	var partErrors []PartError
+	c, err := parseMultiPartConfiguration(bytes.NewReader(b), opts, &partErrors)
+	for _, partErr := range partErrors {
+		metrics.ParseFailed(CategorizeParseError(partErr.Err))
+	}
+	observeParse(metrics, err)
+	return c, partErrors, err
+}
+
+// ParseMultiPartConfigurationReader parses multiple YAML configuration objects from a reader into a MultiPartConfiguration.
+// Documents are parsed incrementally as they are read, so the input does not have to be buffered in memory.
+// Errors are returned as a *ConfigParseError, with the index of the YAML document that failed to parse.
+func ParseMultiPartConfigurationReader(r io.Reader) (MultiPartConfiguration, error) {
+	return ParseMultiPartConfigurationReaderWithOptions(r, ParseOptions{})
+}
+
+// ParseMultiPartConfigurationReaderWithOptions is like ParseMultiPartConfigurationReader, but allows to configure how
+// the documents are parsed. The options apply to every document, e.g. opts.Strict rejects unknown fields in any part.
+func ParseMultiPartConfigurationReaderWithOptions(r io.Reader, opts ParseOptions) (MultiPartConfiguration, error) {
+	c, err := parseMultiPartConfiguration(r, opts, nil)
+	observeParse(metricsOrDefault(opts.Metrics), err)
+	return c, err
+}
+
+// maxSizeReader is an io.Reader that fails after more than max bytes are read.
+type maxSizeReader struct {
+	r         io.Reader
+	max       int
+	remaining int
+}
+
+// Read implements io.Reader.
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	if len(p) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.r.Read(p)
+	r.remaining -= n
+	if r.remaining < 0 {
+		return 0, &categorizedError{category: ParseErrorLimit, err: fmt.Errorf("configuration is larger than the maximum size of %d bytes", r.max)}
+	}
+	return n, err
+}
+
+// gzipMagic is the header of gzip-compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompressReader returns a reader of the decompressed data if r is gzip-compressed, and a reader of r otherwise.
+func decompressReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(gzipMagic)); err != nil || !bytes.Equal(magic, gzipMagic) {
+		// read errors are returned again when reading the configuration
+		return br, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, &categorizedError{category: ParseErrorSyntax, err: fmt.Errorf("failed to decompress configuration: %w", err)}
+	}
+	return zr, nil
+}
+
+// parseMultiPartConfiguration parses multiple YAML configuration objects from a reader into a MultiPartConfiguration.
+// Gzip-compressed input is decompressed, and the maximum size applies to the decompressed configuration.
+// If partErrors is not nil, parts that fail to parse are skipped and recorded in partErrors, instead of failing.
+func parseMultiPartConfiguration(r io.Reader, opts ParseOptions, partErrors *[]PartError) (MultiPartConfiguration, error) {
+	maxSize := opts.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxConfigSize
+	}
+	maxParts := opts.MaxParts
+	if maxParts == 0 {
+		maxParts = DefaultMaxConfigParts
+	}
+
+	r, err := decompressReader(r)
+	if err != nil {
+		return MultiPartConfiguration{}, err
+	}
+	reader := k8syaml.NewYAMLReader(bufio.NewReader(&maxSizeReader{r: r, max: maxSize, remaining: maxSize}))
 
 	cfg := MultiPartConfiguration{}
-	for {
+	for idx := 0; ; idx++ {
 		doc, err := reader.Read()
 		if err != nil {
 			if err == io.EOF {
 				break
 			} else if err != nil {
-				return MultiPartConfiguration{}, err
+				return MultiPartConfiguration{}, newConfigParseError(idx, err)
 			}
 		}
+		if idx >= maxParts {
+			return MultiPartConfiguration{}, &categorizedError{category: ParseErrorLimit, err: fmt.Errorf("configuration has more than the maximum of %d parts", maxParts)}
+		}
 
-		part, err := ParseConfiguration(doc)
+		part, warnings, err := parseConfiguration(doc, opts)
+		logWarnings(opts, warnings)
 		if err != nil {
 			if errors.Is(err, errEmptyConfig) {
+				cfg.SkippedEmptyParts++
+				continue
+			}
+			if partErrors != nil {
+				*partErrors = append(*partErrors, PartError{Part: idx, Err: err})
 				continue
 			}
-			return MultiPartConfiguration{}, err
+			return MultiPartConfiguration{}, newConfigParseError(idx, err)
+		}
+		part.Source = fmt.Sprintf("part %d", idx)
+		if opts.SourceName != "" {
+			part.Source = fmt.Sprintf("%s part %d", opts.SourceName, idx)
 		}
 		cfg.Parts = append(cfg.Parts, part)
 	}
 
+	if len(cfg.Parts) == 0 {
+		return cfg, ErrEmptyConfiguration
+	}
 	return cfg, nil
 }
 
-// isZero returns true if all configuration values are zero/empty.
-// NOTE(neoaggelos): this needs to be updated when new fields are added to the Configuration struct.
+// serviceArgsField is an extra arguments (or environment) map of the configuration, along with the service arguments file it configures.
+type serviceArgsField struct {
+	// name is the name of the configuration field.
+	name string
+	// configFile is the name of the arguments file in $SNAP_DATA/args.
+	configFile string
+	// restartServices are the services that must be restarted when the arguments file changes.
+	restartServices []string
+	// args points to the extra arguments map of the configuration.
+	args *ExtraArgs
+}
+
+// isEnv returns true if the field configures environment variables instead of command line arguments.
+func (f serviceArgsField) isEnv() bool {
+	return strings.HasSuffix(f.configFile, "-env")
+}
+
+// serviceArgsFields returns all extra arguments (and environment) maps of the configuration, in the order they are applied.
+// NOTE: this needs to be updated when new extra arguments fields are added to the Configuration struct.
+func (c *Configuration) serviceArgsFields() []serviceArgsField {
+	return []serviceArgsField{
+		{name: "extraKubeAPIServerArgs", configFile: "kube-apiserver", restartServices: []string{"kubelite"}, args: &c.ExtraKubeAPIServerArgs},
+		{name: "extraKubeletArgs", configFile: "kubelet", restartServices: []string{"kubelite"}, args: &c.ExtraKubeletArgs},
+		{name: "extraKubeProxyArgs", configFile: "kube-proxy", restartServices: []string{"kubelite"}, args: &c.ExtraKubeProxyArgs},
+		{name: "extraKubeControllerManagerArgs", configFile: "kube-controller-manager", restartServices: []string{"kubelite"}, args: &c.ExtraKubeControllerManagerArgs},
+		{name: "extraKubeSchedulerArgs", configFile: "kube-scheduler", restartServices: []string{"kubelite"}, args: &c.ExtraKubeSchedulerArgs},
+		{name: "extraKubeliteEnv", configFile: "kubelite-env", restartServices: []string{"kubelite"}, args: &c.ExtraKubeliteEnv},
+		{name: "extraContainerdArgs", configFile: "containerd", restartServices: []string{"containerd"}, args: &c.ExtraContainerdArgs},
+		{name: "containerd.extraArgs", configFile: "containerd", restartServices: []string{"containerd"}, args: &c.Containerd.ExtraArgs},
+		{name: "extraContainerdEnv", configFile: "containerd-env", restartServices: []string{"containerd"}, args: &c.ExtraContainerdEnv},
+		{name: "extraDqliteArgs", configFile: "k8s-dqlite", restartServices: []string{"k8s-dqlite"}, args: &c.ExtraDqliteArgs},
+		{name: "datastore.extraArgs", configFile: "k8s-dqlite", restartServices: []string{"k8s-dqlite"}, args: &c.Datastore.ExtraArgs},
+		{name: "extraDqliteEnv", configFile: "k8s-dqlite-env", restartServices: []string{"k8s-dqlite"}, args: &c.ExtraDqliteEnv},
+		{name: "extraMicroK8sClusterAgentArgs", configFile: "cluster-agent", restartServices: []string{"cluster-agent"}, args: &c.ExtraMicroK8sClusterAgentArgs},
+		{name: "extraMicroK8sClusterAgentEnv", configFile: "cluster-agent-env", restartServices: []string{"cluster-agent"}, args: &c.ExtraMicroK8sClusterAgentEnv},
+		{name: "extraMicroK8sAPIServerProxyArgs", configFile: "apiserver-proxy", restartServices: []string{"apiserver-proxy"}, args: &c.ExtraMicroK8sAPIServerProxyArgs},
+		{name: "extraMicroK8sAPIServerProxyEnv", configFile: "apiserver-proxy-env", restartServices: []string{"apiserver-proxy"}, args: &c.ExtraMicroK8sAPIServerProxyEnv},
+		{name: "extraEtcdArgs", configFile: "etcd", restartServices: []string{"etcd"}, args: &c.ExtraEtcdArgs},
+		{name: "extraEtcdEnv", configFile: "etcd-env", restartServices: []string{"etcd"}, args: &c.ExtraEtcdEnv},
+		{name: "extraFlanneldArgs", configFile: "flanneld", restartServices: []string{"flanneld"}, args: &c.ExtraFlanneldArgs},
+		{name: "extraFlanneldEnv", configFile: "flanneld-env", restartServices: []string{"flanneld"}, args: &c.ExtraFlanneldEnv},
+		{name: "extraCNIEnv", configFile: "cni-env", args: &c.ExtraCNIEnv},
+		{name: "extraFIPSEnv", configFile: "fips-env", restartServices: []string{"kubelite", "k8s-dqlite", "cluster-agent"}, args: &c.ExtraFIPSEnv},
+	}
+}
+
+// isZero returns true if all configuration values are zero/empty, see IsEmpty for the sections.
 func (c *Configuration) isZero() bool {
-	switch {
-	case c.Version != "":
-		return false
-	case c.PersistentClusterToken != "":
-		return false
-	case c.Join.URL != "":
-		return false
-	case c.Join.Worker:
-		return false
-	case len(c.AddonRepositories) > 0:
-		return false
-	case len(c.Addons) > 0:
-		return false
-	case len(c.ExtraKubeletArgs) > 0:
-		return false
-	case len(c.ExtraKubeAPIServerArgs) > 0:
-		return false
-	case len(c.ExtraKubeProxyArgs) > 0:
-		return false
-	case len(c.ExtraKubeControllerManagerArgs) > 0:
-		return false
-	case len(c.ExtraKubeSchedulerArgs) > 0:
-		return false
-	case len(c.ExtraKubeliteEnv) > 0:
-		return false
-	case c.ExtraSANs != nil && len(*c.ExtraSANs) > 0:
-		return false
-	case len(c.ContainerdRegistryConfigs) > 0:
-		return false
-	case len(c.ExtraContainerdArgs) > 0:
-		return false
-	case len(c.ExtraContainerdEnv) > 0:
-		return false
-	case len(c.ExtraDqliteArgs) > 0:
-		return false
-	case len(c.ExtraDqliteEnv) > 0:
-		return false
-	case len(c.ExtraMicroK8sClusterAgentArgs) > 0:
-		return false
-	case len(c.ExtraMicroK8sClusterAgentEnv) > 0:
-		return false
-	case len(c.ExtraMicroK8sAPIServerProxyArgs) > 0:
-		return false
-	case len(c.ExtraMicroK8sAPIServerProxyEnv) > 0:
-		return false
-	case len(c.ExtraEtcdArgs) > 0:
-		return false
-	case len(c.ExtraEtcdEnv) > 0:
-		return false
-	case len(c.ExtraFlanneldArgs) > 0:
-		return false
-	case len(c.ExtraFlanneldEnv) > 0:
-		return false
-	case len(c.ExtraConfigFiles) > 0:
-		return false
-	case len(c.ExtraCNIEnv) > 0:
-		return false
-	case len(c.ExtraFIPSEnv) > 0:
+	return c.Version == "" && len(c.Include) == 0 && len(c.Roles) == 0 && len(c.Annotations) == 0 && c.IsEmpty()
+}
+
+// configSection is a part of a configuration that is applied independently of the other parts.
+type configSection string
+
+const (
+	sectionAddonRepositories         configSection = "addonRepositories"
+	sectionAddons                    configSection = "addons"
+	sectionPersistentClusterToken    configSection = "persistentClusterToken"
+	sectionExtraConfigFiles          configSection = "extraConfigFiles"
+	sectionEncryption                configSection = "encryption"
+	sectionOIDCCA                    configSection = "oidc.ca"
+	sectionKubeletConfig             configSection = "kubeletConfig"
+	sectionServiceArgs               configSection = "serviceArgs"
+	sectionContainerRuntime          configSection = "containerRuntime"
+	sectionExtraSANs                 configSection = "extraSANs"
+	sectionContainerdConfig          configSection = "containerd.configToml"
+	sectionContainerdRegistryConfigs configSection = "containerdRegistryConfigs"
+	sectionRegistryMirrors           configSection = "registryMirrors"
+	sectionCNI                       configSection = "cni"
+	sectionJoin                      configSection = "join"
+)
+
+// sectionPresent returns true if the configuration sets any values of a section. Sections that are not present are not
+// applied, so that the files and services they configure are left untouched.
+// NOTE: this needs to be updated when new fields are added to the Configuration struct, similar to isZero.
+func (c *Configuration) sectionPresent(section configSection) bool {
+	switch section {
+	case sectionAddonRepositories:
+		return len(c.AddonRepositories) > 0
+	case sectionAddons:
+		return len(c.Addons) > 0
+	case sectionPersistentClusterToken:
+		return c.PersistentClusterToken != ""
+	case sectionExtraConfigFiles:
+		return len(c.ExtraConfigFiles) > 0
+	case sectionEncryption:
+		return c.Encryption != nil
+	case sectionOIDCCA:
+		return c.OIDC != nil && c.OIDC.CA != ""
+	case sectionKubeletConfig:
+		return c.KubeletConfig != nil && *c.KubeletConfig != ""
+	case sectionServiceArgs:
+		for _, field := range c.serviceArgsFields() {
+			if len(*field.args) > 0 {
+				return true
+			}
+		}
 		return false
+	case sectionContainerRuntime:
+		return c.ContainerRuntime != ""
+	case sectionExtraSANs:
+		return c.ExtraSANs != nil
+	case sectionContainerdConfig:
+		return c.Containerd.ConfigToml != ""
+	case sectionContainerdRegistryConfigs:
+		return len(c.ContainerdRegistryConfigs) > 0
+	case sectionRegistryMirrors:
+		return len(c.RegistryMirrors) > 0
+	case sectionCNI:
+		return c.CNI.Config != "" || c.CNI.Calico != nil || c.CNI.Flannel != nil
+	case sectionJoin:
+		return c.Join.URL != ""
 	}
-	return true
+	return false
 }
@@ -1,9 +1,18 @@
 package k8sinit_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
 
 	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
 
@@ -24,6 +33,7 @@ func TestParse(t *testing.T) {
 			expectConfiguration: k8sinit.MultiPartConfiguration{
 				Parts: []*k8sinit.Configuration{{
 					Version: "0.2.0",
+					Source:  "part 0",
 					ExtraSANs: &[]string{
 						"10.10.10.10",
 						"microk8s.example.com",
@@ -95,8 +105,8 @@ func TestParse(t *testing.T) {
 			name: "multi-part.yaml",
 			expectConfiguration: k8sinit.MultiPartConfiguration{
 				Parts: []*k8sinit.Configuration{
-					{Version: "0.1.0", Addons: []k8sinit.AddonConfiguration{{Name: "dns"}}},
-					{Version: "0.1.0", Addons: []k8sinit.AddonConfiguration{{Name: "rbac"}}},
+					{Version: "0.1.0", Source: "part 0", Addons: []k8sinit.AddonConfiguration{{Name: "dns"}}},
+					{Version: "0.1.0", Source: "part 1", Addons: []k8sinit.AddonConfiguration{{Name: "rbac"}}},
 				},
 			},
 		},
@@ -104,9 +114,10 @@ func TestParse(t *testing.T) {
 			name: "multi-part-with-header.yaml",
 			expectConfiguration: k8sinit.MultiPartConfiguration{
 				Parts: []*k8sinit.Configuration{
-					{Version: "0.1.0", Addons: []k8sinit.AddonConfiguration{{Name: "dns"}}},
-					{Version: "0.1.0", Addons: []k8sinit.AddonConfiguration{{Name: "rbac"}}},
+					{Version: "0.1.0", Source: "part 1", Addons: []k8sinit.AddonConfiguration{{Name: "dns"}}},
+					{Version: "0.1.0", Source: "part 2", Addons: []k8sinit.AddonConfiguration{{Name: "rbac"}}},
 				},
+				SkippedEmptyParts: 1,
 			},
 		},
 		{
@@ -114,6 +125,7 @@ func TestParse(t *testing.T) {
 			expectConfiguration: k8sinit.MultiPartConfiguration{
 				Parts: []*k8sinit.Configuration{{
 					Version: "0.1.0",
+					Source:  "part 0",
 				}},
 			},
 		},
@@ -122,18 +134,78 @@ func TestParse(t *testing.T) {
 			expectConfiguration: k8sinit.MultiPartConfiguration{
 				Parts: []*k8sinit.Configuration{{
 					Version:   "0.1.0",
+					Source:    "part 0",
 					ExtraSANs: nil,
 				}, {
 					Version:   "0.1.0",
+					Source:    "part 1",
 					ExtraSANs: &[]string{},
 				}},
 			},
 		},
+		{
+			name: "kube-controller-manager-only.yaml",
+			expectConfiguration: k8sinit.MultiPartConfiguration{
+				Parts: []*k8sinit.Configuration{{
+					Version: "0.1.0",
+					Source:  "part 0",
+					ExtraKubeControllerManagerArgs: map[string]*string{
+						"--leader-elect-lease-duration": &[]string{"30s"}[0],
+						"--node-monitor-grace-period":   nil,
+					},
+				}},
+			},
+		},
+		{
+			name: "kube-scheduler-only.yaml",
+			expectConfiguration: k8sinit.MultiPartConfiguration{
+				Parts: []*k8sinit.Configuration{{
+					Version: "0.1.0",
+					Source:  "part 0",
+					ExtraKubeSchedulerArgs: map[string]*string{
+						"--config":                      &[]string{"/var/snap/microk8s/current/args/kube-scheduler-config.yaml"}[0],
+						"--leader-elect-lease-duration": nil,
+					},
+				}},
+			},
+		},
+		{
+			name: "kube-proxy-only.yaml",
+			expectConfiguration: k8sinit.MultiPartConfiguration{
+				Parts: []*k8sinit.Configuration{{
+					Version: "0.1.0",
+					Source:  "part 0",
+					ExtraKubeProxyArgs: map[string]*string{
+						"--proxy-mode":      &[]string{"ipvs"}[0],
+						"--ipvs-strict-arp": &[]string{"true"}[0],
+						"--cluster-cidr":    nil,
+					},
+				}},
+			},
+		},
+		{
+			name: "containerd.yaml",
+			expectConfiguration: k8sinit.MultiPartConfiguration{
+				Parts: []*k8sinit.Configuration{{
+					Version: "0.2.0",
+					Source:  "part 0",
+					Containerd: k8sinit.ContainerdConfiguration{
+						ExtraArgs: map[string]*string{
+							"--log-level": &[]string{"debug"}[0],
+							"--state":     nil,
+						},
+						ConfigToml: "version = 2\n[plugins.\"io.containerd.grpc.v1.cri\"]\n  sandbox_image = \"registry.k8s.io/pause:3.7\"\n",
+					},
+				}},
+			},
+		},
 		{name: "invalid-yaml.yaml", expectErr: true},
+		{name: "containerd-invalid-toml.yaml", expectErr: true},
 		{name: "invalid-schema.yaml", expectErr: true},
 		{name: "version/newer.yaml", expectErr: true},
 		{name: "version/non-semantic.yaml", expectErr: true},
 		{name: "version/unsupported.yaml", expectErr: true},
+		{name: "version/field-too-new.yaml", expectErr: true},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			g := NewWithT(t)
@@ -153,3 +225,1205 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestExtraSANsValidation(t *testing.T) {
+	for _, tc := range []struct {
+		san       string
+		version   string
+		expectErr bool
+	}{
+		{san: "10.10.10.10"},
+		{san: "fd00::10"},
+		{san: "::1"},
+		{san: "microk8s.example.com"},
+		{san: "MicroK8s.Example.com"},
+		{san: "localhost"},
+		{san: "*.example.com"},
+		{san: "-10.10.10.10"},
+		{san: "-microk8s.example.com"},
+		{san: "https://node", expectErr: true},
+		{san: "node:6443", expectErr: true},
+		{san: "under_score.example.com", expectErr: true},
+		{san: "*.*.example.com", expectErr: true},
+		{san: "example..com", expectErr: true},
+		{san: "", expectErr: true},
+		{san: "-", expectErr: true},
+		{san: "interface:eth0", version: "0.2.0"},
+		{san: "interface:eth0:ipv4", version: "0.2.0"},
+		{san: "-interface:eth0:ipv6", version: "0.2.0"},
+		{san: "interface:eth0:ipv5", version: "0.2.0", expectErr: true},
+		{san: "interface:", version: "0.2.0", expectErr: true},
+	} {
+		t.Run(tc.san, func(t *testing.T) {
+			g := NewWithT(t)
+			version := tc.version
+			if version == "" {
+				version = "0.1.0"
+			}
+			c, err := k8sinit.ParseConfiguration([]byte(fmt.Sprintf("version: %s\nextraSANs: [\"127.0.0.1\", %q]\n", version, tc.san)))
+			if tc.expectErr {
+				g.Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("extraSANs[1] %q", tc.san))))
+				g.Expect(c).To(BeNil())
+			} else {
+				g.Expect(err).To(BeNil())
+				g.Expect(*c.ExtraSANs).To(Equal([]string{"127.0.0.1", tc.san}))
+			}
+		})
+	}
+}
+
+func TestParseErrorPart(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		input      string
+		expectPart int
+		expectLine int
+	}{
+		{
+			name:       "invalid-yaml",
+			input:      "version: 0.1.0\n---\nversion: 0.1.0\naddons:\n  - name: dns\n  - [invalid\n",
+			expectPart: 1,
+			expectLine: 4,
+		},
+		{
+			name:       "invalid-schema",
+			input:      "version: 0.1.0\n---\nversion: 0.1.0\naddons:\n  - dns\n",
+			expectPart: 1,
+			expectLine: 3,
+		},
+		{
+			name:       "invalid-version",
+			input:      "version: 0.1.0\n---\n# empty document\n---\nversion: 0.0.1\n",
+			expectPart: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			_, err := k8sinit.ParseMultiPartConfiguration([]byte(tc.input))
+			g.Expect(err).NotTo(BeNil())
+
+			var parseErr *k8sinit.ConfigParseError
+			g.Expect(errors.As(err, &parseErr)).To(BeTrue())
+			g.Expect(parseErr.Part).To(Equal(tc.expectPart))
+			g.Expect(parseErr.Line).To(Equal(tc.expectLine))
+		})
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	part := "---\nversion: 0.1.0\n"
+
+	t.Run("Size", func(t *testing.T) {
+		input := []byte(strings.Repeat(part, 10))
+
+		g := NewWithT(t)
+		c, err := k8sinit.ParseMultiPartConfigurationWithOptions(input, k8sinit.ParseOptions{MaxSize: len(input)})
+		g.Expect(err).To(BeNil())
+		g.Expect(c.Parts).To(HaveLen(10))
+
+		_, err = k8sinit.ParseMultiPartConfigurationWithOptions(input, k8sinit.ParseOptions{MaxSize: len(input) - 1})
+		g.Expect(err).To(MatchError(fmt.Sprintf("configuration is %d bytes, but the maximum size is %d bytes", len(input), len(input)-1)))
+	})
+
+	t.Run("Parts", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseMultiPartConfiguration([]byte(strings.Repeat(part, k8sinit.DefaultMaxConfigParts)))
+		g.Expect(err).To(BeNil())
+		g.Expect(c.Parts).To(HaveLen(k8sinit.DefaultMaxConfigParts))
+
+		_, err = k8sinit.ParseMultiPartConfiguration([]byte(strings.Repeat(part, k8sinit.DefaultMaxConfigParts+1)))
+		g.Expect(err).To(MatchError("configuration has more than the maximum of 64 parts"))
+	})
+
+	t.Run("DefaultSize", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.ParseMultiPartConfiguration(make([]byte, k8sinit.DefaultMaxConfigSize+1))
+		g.Expect(err).To(MatchError(ContainSubstring("maximum size is 1048576 bytes")))
+	})
+}
+
+func TestParseReader(t *testing.T) {
+	b, err := testdata.ReadFile("testdata/schema/multi-part.yaml")
+	if err != nil {
+		t.Fatalf("failed to read testdata: %v", err)
+	}
+	expected, err := k8sinit.ParseMultiPartConfiguration(b)
+	if err != nil {
+		t.Fatalf("failed to parse testdata: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		reader io.Reader
+	}{
+		{name: "full", reader: bytes.NewReader(b)},
+		{name: "one-byte", reader: iotest.OneByteReader(bytes.NewReader(b))},
+		{name: "half", reader: iotest.HalfReader(bytes.NewReader(b))},
+		{name: "data-err", reader: iotest.DataErrReader(bytes.NewReader(b))},
+		{name: "no-trailing-newline", reader: iotest.OneByteReader(bytes.NewReader(bytes.TrimSuffix(b, []byte("\n"))))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			c, err := k8sinit.ParseMultiPartConfigurationReader(tc.reader)
+			g.Expect(err).To(BeNil())
+			g.Expect(c).To(Equal(expected))
+		})
+	}
+
+	t.Run("ReadError", func(t *testing.T) {
+		g := NewWithT(t)
+		r := io.MultiReader(strings.NewReader("version: 0.1.0\n---\n"), iotest.ErrReader(errors.New("connection reset")))
+		_, err := k8sinit.ParseMultiPartConfigurationReader(r)
+		g.Expect(err).To(MatchError(ContainSubstring("connection reset")))
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		g := NewWithT(t)
+		r := iotest.OneByteReader(strings.NewReader(strings.Repeat("# comment\n", k8sinit.DefaultMaxConfigSize/10+1)))
+		_, err := k8sinit.ParseMultiPartConfigurationReader(r)
+		g.Expect(err).To(MatchError(ContainSubstring("configuration is larger than the maximum size of 1048576 bytes")))
+	})
+}
+
+func TestParseGzip(t *testing.T) {
+	b, err := testdata.ReadFile("testdata/schema/multi-part.yaml")
+	if err != nil {
+		t.Fatalf("failed to read testdata: %v", err)
+	}
+	expected, err := k8sinit.ParseMultiPartConfiguration(b)
+	if err != nil {
+		t.Fatalf("failed to parse testdata: %v", err)
+	}
+	compress := func(t *testing.T, b []byte) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			t.Fatalf("failed to compress: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("failed to compress: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	t.Run("Gzip", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseMultiPartConfiguration(compress(t, b))
+		g.Expect(err).To(BeNil())
+		g.Expect(c).To(Equal(expected))
+	})
+
+	t.Run("GzipReader", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseMultiPartConfigurationReader(iotest.OneByteReader(bytes.NewReader(compress(t, b))))
+		g.Expect(err).To(BeNil())
+		g.Expect(c).To(Equal(expected))
+	})
+
+	t.Run("Plain", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseMultiPartConfiguration(b)
+		g.Expect(err).To(BeNil())
+		g.Expect(c).To(Equal(expected))
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		input := compress(t, []byte("version: 0.1.0\n"+strings.Repeat("# comment\n", 1000)))
+
+		g := NewWithT(t)
+		_, err := k8sinit.ParseMultiPartConfigurationWithOptions(input, k8sinit.ParseOptions{MaxSize: 1000})
+		g.Expect(len(input)).To(BeNumerically("<", 1000))
+		g.Expect(err).To(MatchError(ContainSubstring("configuration is larger than the maximum size of 1000 bytes")))
+		g.Expect(k8sinit.CategorizeParseError(err)).To(Equal(k8sinit.ParseErrorLimit))
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.ParseMultiPartConfiguration([]byte{0x1f, 0x8b, 0x00})
+		g.Expect(err).To(MatchError(ContainSubstring("failed to decompress configuration")))
+	})
+}
+
+func TestParseEmptyParts(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		input              string
+		expectParts        int
+		expectSkippedEmpty int
+		expectErr          error
+	}{
+		{name: "no-documents", input: "", expectErr: k8sinit.ErrEmptyConfiguration},
+		{name: "all-empty", input: "# header\n---\n---\n# comment\n---\n{}\n", expectSkippedEmpty: 3, expectErr: k8sinit.ErrEmptyConfiguration},
+		{name: "mixed", input: "# header\n---\nversion: 0.1.0\n---\n# comment\n---\nversion: 0.1.0\n", expectParts: 2, expectSkippedEmpty: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			c, err := k8sinit.ParseMultiPartConfiguration([]byte(tc.input))
+			if tc.expectErr != nil {
+				g.Expect(err).To(MatchError(tc.expectErr))
+			} else {
+				g.Expect(err).To(BeNil())
+			}
+			g.Expect(c.Parts).To(HaveLen(tc.expectParts))
+			g.Expect(c.SkippedEmptyParts).To(Equal(tc.expectSkippedEmpty))
+		})
+	}
+}
+
+func TestParseSingleDocument(t *testing.T) {
+	t.Run("Equivalent", func(t *testing.T) {
+		for _, tc := range []struct {
+			name  string
+			input string
+			opts  k8sinit.ParseOptions
+		}{
+			{name: "plain", input: "version: 0.1.0\nextraSANs: [10.10.10.10]\nextraKubeletArgs:\n  --max-pods: 200\n"},
+			{name: "leading-separator", input: "---\nversion: 0.1.0\naddons:\n- name: dns\n"},
+			{name: "empty-documents", input: "# header\n---\nversion: 0.1.0\nextraSANs: [10.10.10.10]\n---\n{}\n"},
+			{name: "unknown-field", input: "version: 0.1.0\nunknownField: 1\n"},
+			{name: "null-audit-policy", input: "version: 0.2.0\nauditPolicy: null\n"},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				g := NewWithT(t)
+				c, err := k8sinit.ParseConfigurationWithOptions([]byte(tc.input), tc.opts)
+				g.Expect(err).To(BeNil())
+
+				m, err := k8sinit.ParseMultiPartConfigurationWithOptions([]byte(tc.input), tc.opts)
+				g.Expect(err).To(BeNil())
+				g.Expect(m.Parts).To(HaveLen(1))
+				g.Expect(m.Parts[0].Source).To(HavePrefix("part "))
+				m.Parts[0].Source = ""
+				g.Expect(m.Parts[0]).To(Equal(c))
+			})
+		}
+	})
+
+	t.Run("SameErrors", func(t *testing.T) {
+		for _, tc := range []struct {
+			name  string
+			input string
+			opts  k8sinit.ParseOptions
+		}{
+			{name: "missing-version", input: "extraSANs: [10.10.10.10]\n"},
+			{name: "unsupported-version", input: "version: 9.0.0\n"},
+			{name: "strict", input: "version: 0.1.0\nunknownField: 1\n", opts: k8sinit.ParseOptions{Strict: true}},
+			{name: "syntax", input: "version: [\n"},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				g := NewWithT(t)
+				_, err := k8sinit.ParseConfigurationWithOptions([]byte(tc.input), tc.opts)
+				g.Expect(err).NotTo(BeNil())
+
+				_, multiPartErr := k8sinit.ParseMultiPartConfigurationWithOptions([]byte(tc.input), tc.opts)
+				var parseErr *k8sinit.ConfigParseError
+				g.Expect(errors.As(multiPartErr, &parseErr)).To(BeTrue())
+				g.Expect(parseErr.Err).To(MatchError(err.Error()))
+			})
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		for _, input := range []string{"", "# comment\n", "{}\n", "---\n---\n"} {
+			g := NewWithT(t)
+			_, err := k8sinit.ParseConfiguration([]byte(input))
+			g.Expect(err).To(MatchError(k8sinit.ErrEmptyConfiguration))
+
+			_, err = k8sinit.ParseMultiPartConfiguration([]byte(input))
+			g.Expect(err).To(MatchError(k8sinit.ErrEmptyConfiguration))
+		}
+	})
+
+	t.Run("MultipleDocuments", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.ParseConfiguration([]byte("version: 0.1.0\n---\nversion: 0.1.0\n"))
+		g.Expect(err).To(MatchError(ContainSubstring("more than one non-empty document")))
+	})
+}
+
+func TestParseJSON(t *testing.T) {
+	expectConfiguration := &k8sinit.Configuration{
+		Version:   "0.1.0",
+		ExtraSANs: &[]string{"10.10.10.10"},
+		ExtraKubeletArgs: map[string]*string{
+			"--cluster-dns": &[]string{"10.152.183.10"}[0],
+			"--max-pods":    nil,
+		},
+		Addons: []k8sinit.AddonConfiguration{
+			{Name: "dns"},
+			{Name: "registry", Disable: true},
+		},
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := testdata.ReadFile("testdata/schema/json/remove-kubelet-arg.json")
+		g.Expect(err).To(BeNil())
+
+		c, err := k8sinit.ParseConfigurationJSON(b)
+		g.Expect(err).To(BeNil())
+		g.Expect(c).To(Equal(expectConfiguration))
+
+		m, err := k8sinit.ParseMultiPartConfiguration(b)
+		g.Expect(err).To(BeNil())
+		g.Expect(m.Parts).To(HaveLen(1))
+		g.Expect(m.Parts[0].Source).To(Equal("part 0"))
+		m.Parts[0].Source = ""
+		g.Expect(m.Parts).To(ConsistOf(expectConfiguration))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := testdata.ReadFile("testdata/schema/json/invalid.json")
+		g.Expect(err).To(BeNil())
+
+		c, err := k8sinit.ParseConfigurationJSON(b)
+		g.Expect(err).NotTo(BeNil())
+		g.Expect(c).To(BeNil())
+	})
+}
+
+func TestParseExpandEnv(t *testing.T) {
+	env := map[string]string{
+		"NODE_IP":   "10.0.0.10",
+		"NODE_NAME": "node-1",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	for _, tc := range []struct {
+		name                string
+		input               string
+		allowEmpty          bool
+		expectConfiguration *k8sinit.Configuration
+		expectErr           string
+	}{
+		{
+			name:  "san",
+			input: "version: 0.1.0\nextraSANs: [\"${NODE_IP}\", $NODE_NAME.example.com]\n",
+			expectConfiguration: &k8sinit.Configuration{
+				Version:   "0.1.0",
+				ExtraSANs: &[]string{"10.0.0.10", "node-1.example.com"},
+			},
+		},
+		{
+			name:  "arg",
+			input: "version: 0.1.0\nextraKubeletArgs:\n  --node-ip: ${NODE_IP}\n  --literal: $$NODE_IP\n  --removed: null\n",
+			expectConfiguration: &k8sinit.Configuration{
+				Version: "0.1.0",
+				ExtraKubeletArgs: map[string]*string{
+					"--node-ip": &[]string{"10.0.0.10"}[0],
+					"--literal": &[]string{"$NODE_IP"}[0],
+					"--removed": nil,
+				},
+			},
+		},
+		{
+			name:      "undefined",
+			input:     "version: 0.1.0\nextraKubeletArgs:\n  --node-ip: ${UNDEFINED}\n",
+			expectErr: `extraKubeletArgs[--node-ip]: environment variable "UNDEFINED" is not set`,
+		},
+		{
+			name:       "undefined-allow-empty",
+			input:      "version: 0.1.0\nextraKubeletArgs:\n  --node-ip: ${UNDEFINED}\n",
+			allowEmpty: true,
+			expectConfiguration: &k8sinit.Configuration{
+				Version:          "0.1.0",
+				ExtraKubeletArgs: map[string]*string{"--node-ip": &[]string{""}[0]},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			c, err := k8sinit.ParseConfigurationWithOptions([]byte(tc.input), k8sinit.ParseOptions{
+				ExpandEnv:     true,
+				AllowEmptyEnv: tc.allowEmpty,
+				LookupEnv:     lookupEnv,
+			})
+			if tc.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectErr)))
+				g.Expect(c).To(BeNil())
+			} else {
+				g.Expect(err).To(BeNil())
+				g.Expect(c).To(Equal(tc.expectConfiguration))
+			}
+		})
+	}
+}
+
+func TestParseWarnings(t *testing.T) {
+	g := NewWithT(t)
+	b, err := testdata.ReadFile("testdata/schema/unknown-fields-nested.yaml")
+	g.Expect(err).To(BeNil())
+
+	c, warnings, err := k8sinit.ParseConfigurationWithWarnings(b)
+	g.Expect(err).To(BeNil())
+	g.Expect(c).To(Equal(&k8sinit.Configuration{
+		Version: "0.1.0",
+		Addons:  []k8sinit.AddonConfiguration{{Name: "dns"}},
+	}))
+	g.Expect(warnings).To(ConsistOf(
+		`unknown field "x-unknown-field" will be ignored`,
+		`unknown field "addons[].x-unknown-addon-field" will be ignored`,
+	))
+
+	t.Run("NoWarnings", func(t *testing.T) {
+		g := NewWithT(t)
+		c, warnings, err := k8sinit.ParseConfigurationWithWarnings([]byte("version: 0.1.0\naddons: [{name: dns}]\n"))
+		g.Expect(err).To(BeNil())
+		g.Expect(c).NotTo(BeNil())
+		g.Expect(warnings).To(BeEmpty())
+	})
+}
+
+func TestParseDeprecatedFields(t *testing.T) {
+	input := []byte("version: 0.2.0\nx-unknown-field: test\nextraContainerdArgs:\n  --log-level: debug\n")
+
+	t.Run("Warnings", func(t *testing.T) {
+		g := NewWithT(t)
+		c, warnings, err := k8sinit.ParseConfigurationWithWarnings(input)
+		g.Expect(err).To(BeNil())
+		g.Expect(*c.ExtraContainerdArgs["--log-level"]).To(Equal("debug"))
+		g.Expect(warnings).To(ConsistOf(
+			`unknown field "x-unknown-field" will be ignored`,
+			`field "extraContainerdArgs" is deprecated, use "containerd.extraArgs" instead`,
+		))
+		g.Expect(c.Deprecations()).To(HaveLen(1))
+		g.Expect(c.Deprecations()[0].Field).To(Equal("extraContainerdArgs"))
+	})
+
+	t.Run("Logger", func(t *testing.T) {
+		g := NewWithT(t)
+		logger := &recordingLogger{}
+		_, err := k8sinit.ParseConfigurationWithOptions(input, k8sinit.ParseOptions{Logger: logger})
+		g.Expect(err).To(BeNil())
+		g.Expect(logger.warnings).To(ContainElement(`field "extraContainerdArgs" is deprecated, use "containerd.extraArgs" instead`))
+	})
+
+	t.Run("OlderVersion", func(t *testing.T) {
+		// the replacement requires version 0.2.0, so older configurations are not warned
+		g := NewWithT(t)
+		_, warnings, err := k8sinit.ParseConfigurationWithWarnings([]byte("version: 0.1.0\nextraDqliteArgs:\n  --debug: \"true\"\n"))
+		g.Expect(err).To(BeNil())
+		g.Expect(warnings).To(BeEmpty())
+	})
+}
+
+func TestParseRoleWarnings(t *testing.T) {
+	const warning = "extraSANs has no effect on worker nodes, since they do not run the API server"
+	input := []byte("version: 0.2.0\nextraSANs:\n  - 10.0.0.10\n")
+
+	for _, tc := range []struct {
+		name     string
+		input    []byte
+		role     k8sinit.NodeRole
+		warnings []string
+	}{
+		{name: "Worker", input: input, role: k8sinit.NodeRoleWorker, warnings: []string{warning}},
+		{name: "ControlPlane", input: input, role: k8sinit.NodeRoleControlPlane},
+		{name: "UnknownRole", input: input},
+		{name: "WorkerWithoutExtraSANs", input: []byte("version: 0.2.0\nextraKubeletArgs:\n  --max-pods: \"200\"\n"), role: k8sinit.NodeRoleWorker},
+		{name: "ControlPlaneOnlyConfig", input: []byte("version: 0.2.0\nroles: [control-plane]\nextraSANs:\n  - 10.0.0.10\n"), role: k8sinit.NodeRoleWorker},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			logger := &recordingLogger{}
+			c, err := k8sinit.ParseConfigurationWithOptions(tc.input, k8sinit.ParseOptions{Logger: logger, Validation: k8sinit.ValidateOptions{NodeRole: tc.role}})
+			g.Expect(err).To(BeNil())
+			g.Expect(logger.warnings).To(Equal(tc.warnings))
+			g.Expect(c.RoleWarnings(tc.role)).To(Equal(tc.warnings))
+		})
+	}
+}
+
+func TestParseAddonConflictWarnings(t *testing.T) {
+	const warning = `extraKubeletArgs[--cluster-dns] is managed by the "dns" addon, which is also enabled by the configuration`
+	custom := []k8sinit.AddonArgConflict{{Addon: "metallb", ConfigFile: "kube-proxy", Arg: "--proxy-mode"}}
+
+	for _, tc := range []struct {
+		name      string
+		input     string
+		conflicts []k8sinit.AddonArgConflict
+		warnings  []string
+	}{
+		{name: "DNS", input: "version: 0.1.0\naddons:\n- name: dns\nextraKubeletArgs:\n  --cluster-dns: 10.152.183.10\n", warnings: []string{warning}},
+		{name: "DNSWithRepository", input: "version: 0.2.0\naddons:\n- name: core/dns\nextraKubeletArgs:\n  --cluster-dns: 10.152.183.10\n", warnings: []string{warning}},
+		{name: "DNSDisabled", input: "version: 0.1.0\naddons:\n- name: dns\n  disable: true\nextraKubeletArgs:\n  --cluster-dns: 10.152.183.10\n"},
+		{name: "DNSNotEnabled", input: "version: 0.1.0\naddons:\n- name: ingress\nextraKubeletArgs:\n  --cluster-dns: 10.152.183.10\n"},
+		{name: "UnrelatedArgs", input: "version: 0.1.0\naddons:\n- name: dns\nextraKubeletArgs:\n  --max-pods: \"200\"\nextraKubeAPIServerArgs:\n  --cluster-dns: 10.152.183.10\n"},
+		{name: "CustomConflicts", input: "version: 0.1.0\naddons:\n- name: dns\n- name: metallb\nextraKubeletArgs:\n  --cluster-dns: 10.152.183.10\nextraKubeProxyArgs:\n  --proxy-mode: ipvs\n", conflicts: custom, warnings: []string{
+			`extraKubeProxyArgs[--proxy-mode] is managed by the "metallb" addon, which is also enabled by the configuration`,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			logger := &recordingLogger{}
+			c, err := k8sinit.ParseConfigurationWithOptions([]byte(tc.input), k8sinit.ParseOptions{Logger: logger, Validation: k8sinit.ValidateOptions{AddonArgConflicts: tc.conflicts}})
+			g.Expect(err).To(BeNil())
+			g.Expect(logger.warnings).To(Equal(tc.warnings))
+			g.Expect(c.AddonConflictWarnings(tc.conflicts)).To(Equal(tc.warnings))
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	for _, file := range []string{"full.yaml", "containerd.yaml", "kube-proxy-only.yaml"} {
+		t.Run(file, func(t *testing.T) {
+			g := NewWithT(t)
+			b, err := testdata.ReadFile(filepath.Join("testdata", "schema", file))
+			g.Expect(err).To(BeNil())
+
+			c, err := k8sinit.ParseConfiguration(b)
+			g.Expect(err).To(BeNil())
+
+			out, err := c.Marshal()
+			g.Expect(err).To(BeNil())
+			g.Expect(string(out)).To(ContainSubstring(": null\n"))
+
+			roundTrip, err := k8sinit.ParseConfiguration(out)
+			g.Expect(err).To(BeNil())
+			g.Expect(roundTrip).To(Equal(c))
+		})
+	}
+
+	t.Run("OmitEmpty", func(t *testing.T) {
+		g := NewWithT(t)
+		out, err := (&k8sinit.Configuration{
+			Version:          "0.1.0",
+			ExtraKubeletArgs: map[string]*string{"--max-pods": nil},
+		}).Marshal()
+		g.Expect(err).To(BeNil())
+		g.Expect(string(out)).To(Equal("version: 0.1.0\nextraKubeletArgs:\n  --max-pods: null\n"))
+	})
+	t.Run("Deterministic", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := testdata.ReadFile(filepath.Join("testdata", "schema", "full.yaml"))
+		g.Expect(err).To(BeNil())
+		c, err := k8sinit.ParseConfiguration(b)
+		g.Expect(err).To(BeNil())
+		c.ExtraKubeletArgs = make(k8sinit.ExtraArgs)
+		for i := 0; i < 50; i++ {
+			value := fmt.Sprintf("%d", i)
+			c.ExtraKubeletArgs[fmt.Sprintf("--arg-%d", i)] = &value
+		}
+
+		out, err := c.Marshal()
+		g.Expect(err).To(BeNil())
+		for i := 0; i < 20; i++ {
+			again, err := c.Clone().Marshal()
+			g.Expect(err).To(BeNil())
+			g.Expect(again).To(Equal(out))
+		}
+	})
+}
+
+func TestParseAnnotations(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseMultiPartConfiguration([]byte(`
+version: 0.2.0
+annotations:
+  owner: team-a
+  reason: raise the pod limit for batch nodes
+extraKubeletArgs:
+  --max-pods: 250
+---
+version: 0.2.0
+annotations:
+  owner: team-b
+addons:
+  - name: dns
+`))
+		g.Expect(err).To(BeNil())
+		g.Expect(c.Parts[0].Annotations).To(Equal(map[string]string{"owner": "team-a", "reason": "raise the pod limit for batch nodes"}))
+
+		merged, provenance, err := c.MergeWithProvenance(k8sinit.MergeOptions{})
+		g.Expect(err).To(BeNil())
+		g.Expect(merged.Annotations).To(Equal(map[string]string{"owner": "team-b", "reason": "raise the pod limit for batch nodes"}))
+		g.Expect(provenance).To(HaveKeyWithValue("annotations[owner]", "part 1"))
+		g.Expect(provenance).To(HaveKeyWithValue("annotations[reason]", "part 0"))
+
+		out, err := merged.Marshal()
+		g.Expect(err).To(BeNil())
+		g.Expect(string(out)).To(ContainSubstring("annotations:\n  owner: team-b\n  reason: raise the pod limit for batch nodes\n"))
+
+		roundTrip, err := k8sinit.ParseConfiguration(out)
+		g.Expect(err).To(BeNil())
+		g.Expect(roundTrip.Annotations).To(Equal(merged.Annotations))
+		g.Expect(roundTrip).To(Equal(merged))
+	})
+
+	t.Run("RequiresVersion", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.ParseConfiguration([]byte("version: 0.1.0\nannotations:\n  owner: team-a\n"))
+		g.Expect(err).To(MatchError(ContainSubstring(`field "annotations" requires config file version 0.2.0 or newer`)))
+	})
+}
+
+func TestParseAnchors(t *testing.T) {
+	t.Run("Aliases", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := testdata.ReadFile("testdata/schema/anchors.yaml")
+		g.Expect(err).To(BeNil())
+
+		c, warnings, err := k8sinit.ParseConfigurationWithWarnings(b)
+		g.Expect(err).To(BeNil())
+		g.Expect(warnings).To(BeEmpty())
+		g.Expect(c).To(Equal(&k8sinit.Configuration{
+			Version:   "0.1.0",
+			ExtraSANs: &[]string{"10.0.0.10", "node.example.com"},
+			ExtraKubeletArgs: map[string]*string{
+				"--node-ip": &[]string{"10.0.0.10"}[0],
+				"--v":       &[]string{"2"}[0],
+			},
+			ExtraKubeProxyArgs: map[string]*string{
+				"--node-ip": &[]string{"10.0.0.10"}[0],
+				"--v":       &[]string{"2"}[0],
+			},
+			ExtraKubeAPIServerArgs: map[string]*string{
+				"--node-ip":           &[]string{"10.0.0.10"}[0],
+				"--advertise-address": &[]string{"10.0.0.10"}[0],
+				"--v":                 nil,
+			},
+		}))
+	})
+
+	t.Run("MergeKey", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := testdata.ReadFile("testdata/schema/anchors-merge-key.yaml")
+		g.Expect(err).To(BeNil())
+
+		c, warnings, err := k8sinit.ParseConfigurationWithWarnings(b)
+		g.Expect(err).To(BeNil())
+		g.Expect(warnings).To(BeEmpty())
+		g.Expect(c).To(Equal(&k8sinit.Configuration{
+			Version:   "0.1.0",
+			ExtraSANs: &[]string{"10.0.0.10", "node.example.com"},
+			ExtraKubeletArgs: map[string]*string{
+				"--max-pods": &[]string{"200"}[0],
+				"--v":        &[]string{"2"}[0],
+			},
+		}))
+	})
+
+	t.Run("DuplicateKey", func(t *testing.T) {
+		g := NewWithT(t)
+		c, warnings, err := k8sinit.ParseConfigurationWithWarnings([]byte("version: 0.1.0\nextraKubeletArgs:\n  --v: \"2\"\n  --v: \"4\"\n"))
+		g.Expect(err).To(BeNil())
+		g.Expect(warnings).To(ConsistOf(`duplicate key "--v", the last value will be used`))
+		g.Expect(c.ExtraKubeletArgs).To(Equal(k8sinit.ExtraArgs{"--v": &[]string{"4"}[0]}))
+	})
+}
+
+func TestParseStrict(t *testing.T) {
+	b, err := testdata.ReadFile("testdata/schema/unknown-fields.yaml")
+	if err != nil {
+		t.Fatalf("failed to read testdata: %v", err)
+	}
+
+	t.Run("Lenient", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfigurationWithOptions(b, k8sinit.ParseOptions{})
+		g.Expect(err).To(BeNil())
+		g.Expect(c.Version).To(Equal("0.1.0"))
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfigurationWithOptions(b, k8sinit.ParseOptions{Strict: true})
+		g.Expect(err).To(MatchError(ContainSubstring("field x-unknown-field not found")))
+		g.Expect(c).To(BeNil())
+	})
+
+	t.Run("StrictMultiPart", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.ParseMultiPartConfigurationWithOptions([]byte("version: 0.1.0\n---\nversion: 0.1.0\nx-unknown-field: test\n"), k8sinit.ParseOptions{Strict: true})
+
+		var parseErr *k8sinit.ConfigParseError
+		g.Expect(errors.As(err, &parseErr)).To(BeTrue())
+		g.Expect(parseErr.Part).To(Equal(1))
+		g.Expect(parseErr.Line).To(Equal(2))
+	})
+
+	multiPart := []byte("version: 0.1.0\naddons:\n  - name: dns\n---\nversion: 0.1.0\nextraKubeletArgs:\n  --max-pods: \"200\"\n---\nversion: 0.1.0\nadons:\n  - name: rbac\n")
+
+	t.Run("LenientMultiPart", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseMultiPartConfigurationWithOptions(multiPart, k8sinit.ParseOptions{Logger: &recordingLogger{}})
+		g.Expect(err).To(BeNil())
+		g.Expect(c.Parts).To(HaveLen(3))
+	})
+
+	t.Run("StrictMultiPartEveryPart", func(t *testing.T) {
+		for name, parse := range map[string]func(opts k8sinit.ParseOptions) error{
+			"Bytes": func(opts k8sinit.ParseOptions) error {
+				_, err := k8sinit.ParseMultiPartConfigurationWithOptions(multiPart, opts)
+				return err
+			},
+			"Reader": func(opts k8sinit.ParseOptions) error {
+				_, err := k8sinit.ParseMultiPartConfigurationReaderWithOptions(bytes.NewReader(multiPart), opts)
+				return err
+			},
+			"Dir": func(opts k8sinit.ParseOptions) error {
+				fsys := fstest.MapFS{"10-base.yaml": {Data: []byte("version: 0.1.0\n")}, "20-node.yaml": {Data: multiPart}}
+				_, err := k8sinit.ParseConfigurationDirWithOptions(fsys, ".", opts)
+				return err
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				g := NewWithT(t)
+				g.Expect(parse(k8sinit.ParseOptions{Logger: &recordingLogger{}})).To(Succeed())
+
+				err := parse(k8sinit.ParseOptions{Strict: true})
+				var parseErr *k8sinit.ConfigParseError
+				g.Expect(errors.As(err, &parseErr)).To(BeTrue())
+				g.Expect(parseErr.Part).To(Equal(2))
+				g.Expect(err).To(MatchError(ContainSubstring("field adons not found")))
+			})
+		}
+	})
+
+	t.Run("StrictMergeKey", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := testdata.ReadFile("testdata/schema/anchors.yaml")
+		g.Expect(err).To(BeNil())
+		_, err = k8sinit.ParseConfigurationWithOptions(b, k8sinit.ParseOptions{Strict: true})
+		g.Expect(err).To(BeNil())
+	})
+}
+
+func TestParseNullAndEmptyArgs(t *testing.T) {
+	g := NewWithT(t)
+	c, err := k8sinit.ParseConfiguration([]byte(`
+version: 0.1.0
+extraKubeletArgs:
+  --max-pods: "200"
+  --resolv-conf: ""
+  --cluster-domain: null
+  --node-labels:
+extraKubeAPIServerArgs:
+  --event-ttl: 1h
+  --audit-log-path: ""
+  --authorization-webhook: ~
+`))
+	g.Expect(err).To(BeNil())
+	g.Expect(c.ExtraKubeletArgs).To(Equal(k8sinit.ExtraArgs{
+		"--max-pods":       &[]string{"200"}[0],
+		"--resolv-conf":    &[]string{""}[0],
+		"--cluster-domain": nil,
+		"--node-labels":    nil,
+	}))
+	g.Expect(c.ExtraKubeAPIServerArgs).To(Equal(k8sinit.ExtraArgs{
+		"--event-ttl":             &[]string{"1h"}[0],
+		"--audit-log-path":        &[]string{""}[0],
+		"--authorization-webhook": nil,
+	}))
+}
+
+func TestParseInclude(t *testing.T) {
+	includeFS, err := fs.Sub(testdata, "testdata/include")
+	if err != nil {
+		t.Fatalf("failed to open testdata: %v", err)
+	}
+
+	t.Run("TwoLevels", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := testdata.ReadFile("testdata/include/config.yaml")
+		g.Expect(err).To(BeNil())
+
+		c, err := k8sinit.ParseConfigurationWithOptions(b, k8sinit.ParseOptions{IncludeFS: includeFS})
+		g.Expect(err).To(BeNil())
+		g.Expect(c).To(Equal(&k8sinit.Configuration{
+			Version:   "0.2.0",
+			ExtraSANs: &[]string{"10.10.10.10"},
+			ExtraKubeletArgs: map[string]*string{
+				"--cluster-dns": &[]string{"10.152.183.10"}[0],
+				"--max-pods":    &[]string{"200"}[0],
+			},
+			Addons: []k8sinit.AddonConfiguration{{Name: "dns"}},
+		}))
+	})
+
+	for _, tc := range []struct {
+		name      string
+		input     string
+		opts      k8sinit.ParseOptions
+		expectErr string
+	}{
+		{
+			name:      "Cycle",
+			input:     "version: 0.2.0\ninclude: [cycle/a.yaml]\n",
+			opts:      k8sinit.ParseOptions{IncludeFS: includeFS},
+			expectErr: `circular include of "cycle/a.yaml" (cycle/a.yaml -> cycle/b.yaml -> cycle/a.yaml)`,
+		},
+		{
+			name:      "Traversal",
+			input:     "version: 0.2.0\ninclude: [../schema/full.yaml]\n",
+			opts:      k8sinit.ParseOptions{IncludeFS: includeFS},
+			expectErr: `include path "../schema/full.yaml" is outside of the include directory`,
+		},
+		{
+			name:      "TraversalFromIncludeDir",
+			input:     "version: 0.2.0\ninclude: [../../schema/full.yaml]\n",
+			opts:      k8sinit.ParseOptions{IncludeFS: includeFS, IncludeDir: "common"},
+			expectErr: `include path "../../schema/full.yaml" is outside of the include directory`,
+		},
+		{
+			name:      "Absolute",
+			input:     "version: 0.2.0\ninclude: [/etc/passwd]\n",
+			opts:      k8sinit.ParseOptions{IncludeFS: includeFS},
+			expectErr: `include path "/etc/passwd" must be relative to the include directory`,
+		},
+		{
+			name:      "Missing",
+			input:     "version: 0.2.0\ninclude: [missing.yaml]\n",
+			opts:      k8sinit.ParseOptions{IncludeFS: includeFS},
+			expectErr: `failed to read included file "missing.yaml"`,
+		},
+		{
+			name:      "Disabled",
+			input:     "version: 0.2.0\ninclude: [config.yaml]\n",
+			expectErr: "includes are not enabled",
+		},
+		{
+			name:      "OldVersion",
+			input:     "version: 0.1.0\ninclude: [config.yaml]\n",
+			opts:      k8sinit.ParseOptions{IncludeFS: includeFS},
+			expectErr: `field "include" requires config file version 0.2.0 or newer`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			c, err := k8sinit.ParseConfigurationWithOptions([]byte(tc.input), tc.opts)
+			g.Expect(err).To(MatchError(ContainSubstring(tc.expectErr)))
+			g.Expect(c).To(BeNil())
+		})
+	}
+
+	t.Run("IncludeDir", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfigurationWithOptions([]byte("version: 0.2.0\ninclude: [network.yaml]\n"), k8sinit.ParseOptions{IncludeFS: includeFS, IncludeDir: "common"})
+		g.Expect(err).To(BeNil())
+		g.Expect(c.ExtraSANs).To(Equal(&[]string{"10.10.10.10"}))
+	})
+}
+
+func TestParseConfigurationDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"config.d/20-override.yml": {Data: []byte("version: 0.1.0\naddons:\n- name: rbac\n")},
+		"config.d/10-base.yaml":    {Data: []byte("version: 0.1.0\naddons:\n- name: dns\n---\nversion: 0.1.0\nextraSANs: [10.10.10.10]\n")},
+		"config.d/00-empty.yaml":   {Data: []byte("---\n")},
+		"config.d/notes.txt":       {Data: []byte("not a configuration")},
+		"config.d/old/30-old.yaml": {Data: []byte("version: 0.1.0\naddons:\n- name: dns\n  disable: true\n")},
+		"broken.d/10-base.yaml":    {Data: []byte("version: 0.1.0\n")},
+		"broken.d/20-broken.yaml":  {Data: []byte("version: 0.1.0\nextraSANs: {}\n")},
+		"empty.d/notes.txt":        {Data: []byte("not a configuration")},
+	}
+
+	t.Run("Ordering", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfigurationDir(fsys, "config.d")
+		g.Expect(err).To(BeNil())
+		g.Expect(c).To(Equal(k8sinit.MultiPartConfiguration{
+			Parts: []*k8sinit.Configuration{
+				{Version: "0.1.0", Source: "config.d/10-base.yaml part 0", Addons: []k8sinit.AddonConfiguration{{Name: "dns"}}},
+				{Version: "0.1.0", Source: "config.d/10-base.yaml part 1", ExtraSANs: &[]string{"10.10.10.10"}},
+				{Version: "0.1.0", Source: "config.d/20-override.yml part 0", Addons: []k8sinit.AddonConfiguration{{Name: "rbac"}}},
+			},
+			SkippedEmptyParts: 1,
+		}))
+	})
+
+	t.Run("FileError", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.ParseConfigurationDir(fsys, "broken.d")
+		g.Expect(err).To(MatchError(ContainSubstring(`failed to parse config file "broken.d/20-broken.yaml"`)))
+
+		var parseErr *k8sinit.ConfigParseError
+		g.Expect(errors.As(err, &parseErr)).To(BeTrue())
+		g.Expect(parseErr.Part).To(Equal(0))
+	})
+
+	t.Run("NoConfigFiles", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.ParseConfigurationDir(fsys, "empty.d")
+		g.Expect(err).To(MatchError(k8sinit.ErrEmptyConfiguration))
+	})
+
+	t.Run("MissingDir", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.ParseConfigurationDir(fsys, "missing.d")
+		g.Expect(err).To(MatchError(ContainSubstring(`failed to read config directory "missing.d"`)))
+	})
+}
+
+func TestParseScalarArgs(t *testing.T) {
+	b := []byte(`
+version: 0.1.0
+extraKubeletArgs:
+  --max-pods: 110
+  --fail-swap-on: false
+  --kube-api-qps: 50.0
+  --cluster-domain: null
+extraKubeliteEnv:
+  GOMAXPROCS: 4
+`)
+
+	g := NewWithT(t)
+	c, err := k8sinit.ParseConfigurationWithOptions(b, k8sinit.ParseOptions{Strict: true})
+	g.Expect(err).To(BeNil())
+	g.Expect(c.ExtraKubeletArgs).To(Equal(k8sinit.ExtraArgs{
+		"--max-pods":       &[]string{"110"}[0],
+		"--fail-swap-on":   &[]string{"false"}[0],
+		"--kube-api-qps":   &[]string{"50.0"}[0],
+		"--cluster-domain": nil,
+	}))
+	g.Expect(c.ExtraKubeliteEnv).To(Equal(k8sinit.ExtraArgs{"GOMAXPROCS": &[]string{"4"}[0]}))
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		g := NewWithT(t)
+		marshaled, err := c.Marshal()
+		g.Expect(err).To(BeNil())
+
+		parsed, err := k8sinit.ParseConfiguration(marshaled)
+		g.Expect(err).To(BeNil())
+		g.Expect(parsed).To(Equal(c))
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfigurationJSON([]byte(`{"version": "0.1.0", "extraKubeletArgs": {"--max-pods": 110, "--fail-swap-on": false, "--cluster-domain": null}}`))
+		g.Expect(err).To(BeNil())
+		g.Expect(c.ExtraKubeletArgs).To(Equal(k8sinit.ExtraArgs{
+			"--max-pods":       &[]string{"110"}[0],
+			"--fail-swap-on":   &[]string{"false"}[0],
+			"--cluster-domain": nil,
+		}))
+	})
+}
+
+func TestParseListArgs(t *testing.T) {
+	b := []byte(`
+version: 0.2.0
+extraKubeAPIServerArgs:
+  - --runtime-config=api/all=true
+  - --feature-gates=A=true
+  - --feature-gates=B=true
+extraKubeletArgs:
+  --max-pods: "110"
+`)
+
+	g := NewWithT(t)
+	c, err := k8sinit.ParseConfigurationWithOptions(b, k8sinit.ParseOptions{Strict: true})
+	g.Expect(err).To(BeNil())
+	g.Expect(c.ExtraKubeAPIServerArgs).To(Equal(k8sinit.ExtraArgs{
+		"--runtime-config=api/all=true": &[]string{""}[0],
+		"--feature-gates=A=true":        &[]string{""}[0],
+		"--feature-gates=B=true":        &[]string{""}[0],
+	}))
+	g.Expect(c.ExtraKubeletArgs).To(Equal(k8sinit.ExtraArgs{"--max-pods": &[]string{"110"}[0]}))
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		g := NewWithT(t)
+		marshaled, err := c.Marshal()
+		g.Expect(err).To(BeNil())
+
+		parsed, err := k8sinit.ParseConfiguration(marshaled)
+		g.Expect(err).To(BeNil())
+		g.Expect(parsed).To(Equal(c))
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfigurationJSON([]byte(`{"version": "0.2.0", "extraKubeletArgs": ["--feature-gates=A=true"]}`))
+		g.Expect(err).To(BeNil())
+		g.Expect(c.ExtraKubeletArgs).To(Equal(k8sinit.ExtraArgs{"--feature-gates=A=true": &[]string{""}[0]}))
+	})
+
+	t.Run("MissingValue", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfiguration([]byte("version: 0.2.0\nextraKubeletArgs:\n  - --fail-swap-on\n"))
+		g.Expect(err).To(MatchError(ContainSubstring(`argument "--fail-swap-on" must be written as "--flag=value" in the list form`)))
+		g.Expect(c).To(BeNil())
+	})
+}
+
+func TestParseMissingVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		input          string
+		expectMissing  bool
+		expectErrorMsg string
+	}{
+		{name: "no-version", input: "extraKubeletArgs:\n  --max-pods: \"110\"\n", expectMissing: true},
+		{name: "empty", input: "version: \"\"\nextraKubeletArgs:\n  --max-pods: \"110\"\n", expectMissing: true},
+		{name: "whitespace", input: "version: \"  \"\nextraKubeletArgs:\n  --max-pods: \"110\"\n", expectMissing: true},
+		{name: "non-semantic", input: "version: v1\nextraKubeletArgs:\n  --max-pods: \"110\"\n", expectErrorMsg: `could not parse config file version "v1"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			c, err := k8sinit.ParseConfiguration([]byte(tc.input))
+			g.Expect(c).To(BeNil())
+			g.Expect(errors.Is(err, k8sinit.ErrMissingVersion)).To(Equal(tc.expectMissing))
+			if tc.expectMissing {
+				g.Expect(err).To(MatchError("invalid configuration: configuration is missing required field 'version'"))
+			} else {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectErrorMsg)))
+			}
+		})
+	}
+}
+
+func TestSupportedVersions(t *testing.T) {
+	t.Run("CurrentVersion", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(k8sinit.IsVersionSupported(k8sinit.CurrentVersion)).To(BeTrue())
+		g.Expect(k8sinit.SupportedVersions).To(ContainElement(k8sinit.CurrentVersion))
+
+		c, err := k8sinit.ParseConfiguration([]byte(fmt.Sprintf("version: %q\n", k8sinit.CurrentVersion)))
+		g.Expect(err).To(BeNil())
+		g.Expect(c.Version).To(Equal(k8sinit.CurrentVersion))
+	})
+
+	for _, tc := range []struct {
+		version   string
+		supported bool
+	}{
+		{version: "0.1.0", supported: true},
+		{version: "0.1.5", supported: true},
+		{version: "0.2.0", supported: true},
+		{version: "0.0.9"},
+		{version: "0.3.0"},
+		{version: "1.0.0"},
+		{version: "0.1"},
+		{version: ""},
+	} {
+		t.Run(tc.version, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(k8sinit.IsVersionSupported(tc.version)).To(Equal(tc.supported))
+		})
+	}
+
+	t.Run("AllSupported", func(t *testing.T) {
+		g := NewWithT(t)
+		for _, v := range k8sinit.SupportedVersions {
+			g.Expect(k8sinit.IsVersionSupported(v)).To(BeTrue(), v)
+		}
+	})
+}
+
+func TestParseExactVersions(t *testing.T) {
+	opts := k8sinit.ParseOptions{ExactVersions: []string{"0.1.0", "0.2.0"}}
+	for _, tc := range []struct {
+		name           string
+		version        string
+		expectErrorMsg string
+	}{
+		{name: "exact", version: "0.1.0"},
+		{name: "exact-latest", version: "0.2.0"},
+		{name: "build-metadata", version: "0.2.0+build.1"},
+		{name: "in-range-unlisted", version: "0.1.5", expectErrorMsg: "invalid configuration: config file version is 0.1.5 but only versions 0.1.0, 0.2.0 are allowed"},
+		{name: "out-of-range", version: "0.3.0", expectErrorMsg: "invalid configuration: config file version is 0.3.0 but the maximum version supported is 0.2.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			c, err := k8sinit.ParseConfigurationWithOptions([]byte(fmt.Sprintf("version: %s\naddons: [{name: dns}]\n", tc.version)), opts)
+			if tc.expectErrorMsg == "" {
+				g.Expect(err).To(BeNil())
+				g.Expect(c.Version).To(Equal(tc.version))
+			} else {
+				g.Expect(c).To(BeNil())
+				g.Expect(err).To(MatchError(tc.expectErrorMsg))
+			}
+		})
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfigurationWithOptions([]byte("version: 0.1.5\naddons: [{name: dns}]\n"), k8sinit.ParseOptions{})
+		g.Expect(err).To(BeNil())
+		g.Expect(c.Version).To(Equal("0.1.5"))
+	})
+}
+
+func TestParseLenient(t *testing.T) {
+	input := []byte(`---
+version: 0.1.0
+addons:
+  - name: dns
+---
+version: 0.1.0
+extraKubeletArgs: [not, a, map
+---
+version: 0.2.0
+addons:
+  - name: ingress
+`)
+
+	t.Run("Lenient", func(t *testing.T) {
+		g := NewWithT(t)
+		c, partErrors, err := k8sinit.ParseMultiPartConfigurationLenient(input, k8sinit.ParseOptions{})
+		g.Expect(err).To(BeNil())
+		g.Expect(c.Parts).To(HaveLen(2))
+		g.Expect(c.Parts[0].Addons).To(Equal([]k8sinit.AddonConfiguration{{Name: "dns"}}))
+		g.Expect(c.Parts[1].Addons).To(Equal([]k8sinit.AddonConfiguration{{Name: "ingress"}}))
+		g.Expect(c.Parts[1].Source).To(Equal("part 2"))
+		g.Expect(partErrors).To(HaveLen(1))
+		g.Expect(partErrors[0].Part).To(Equal(1))
+		g.Expect(partErrors[0].Error()).To(HavePrefix("part 1: could not parse configuration: "))
+	})
+
+	t.Run("FailFast", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := k8sinit.ParseMultiPartConfiguration(input)
+		var parseErr *k8sinit.ConfigParseError
+		g.Expect(errors.As(err, &parseErr)).To(BeTrue())
+		g.Expect(parseErr.Part).To(Equal(1))
+	})
+
+	t.Run("AllPartsInvalid", func(t *testing.T) {
+		g := NewWithT(t)
+		c, partErrors, err := k8sinit.ParseMultiPartConfigurationLenient([]byte("version: 0.3.0\n---\nversion: [0.1.0\n"), k8sinit.ParseOptions{})
+		g.Expect(err).To(MatchError(k8sinit.ErrEmptyConfiguration))
+		g.Expect(c.Parts).To(BeEmpty())
+		g.Expect(partErrors).To(HaveLen(2))
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		g := NewWithT(t)
+		_, partErrors, err := k8sinit.ParseMultiPartConfigurationLenient(input, k8sinit.ParseOptions{MaxParts: 2})
+		g.Expect(err).To(MatchError("configuration has more than the maximum of 2 parts"))
+		g.Expect(partErrors).To(HaveLen(1))
+	})
+}
+
+func TestParseDuplicateAddons(t *testing.T) {
+	input := []byte(`version: 0.1.0
+addons:
+  - name: dns
+  - name: ingress
+  - name: dns
+    disable: true
+`)
+
+	t.Run("Error", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfiguration(input)
+		g.Expect(c).To(BeNil())
+		g.Expect(err).To(MatchError(`invalid configuration: addon "dns" is listed both to enable (addons[0]) and disable (addons[2])`))
+	})
+
+	t.Run("LastWriterWins", func(t *testing.T) {
+		logger := &recordingLogger{}
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfigurationWithOptions(input, k8sinit.ParseOptions{
+			Logger:     logger,
+			Validation: k8sinit.ValidateOptions{ResolveDuplicateAddons: true},
+		})
+		g.Expect(err).To(BeNil())
+		// the disable entry overrides the enable entry, in the position of the first entry
+		g.Expect(c.Addons).To(Equal([]k8sinit.AddonConfiguration{{Name: "dns", Disable: true}, {Name: "ingress"}}))
+		g.Expect(logger.warnings).To(Equal([]string{`addons[2] "dns" overrides addons[0], since the addon is listed multiple times`}))
+	})
+}
@@ -0,0 +1,58 @@
+package k8sinit
+
+// addonSections are the sections that are only applied with ApplyOptions.OnlyAddons, and not applied with ApplyOptions.SkipAddons.
+var addonSections = map[Section]struct{}{
+	SectionAddonRepositories: {},
+	SectionAddons:            {},
+}
+
+// isStagedOut returns true if a section is not applied because of opts.SkipAddons or opts.OnlyAddons. Hooks are always applied.
+func (s *launcherScope) isStagedOut(section Section) bool {
+	if section == SectionHooks {
+		return false
+	}
+	_, isAddonSection := addonSections[section]
+	return (s.opts.SkipAddons && isAddonSection) || (s.opts.OnlyAddons && !isAddonSection)
+}
+
+// stagedParts returns the configuration parts without the sections that are not applied because of opts.SkipAddons or
+// opts.OnlyAddons. The configuration is returned as-is if neither is set.
+func (s *launcherScope) stagedParts(c MultiPartConfiguration) MultiPartConfiguration {
+	if !s.opts.SkipAddons && !s.opts.OnlyAddons {
+		return c
+	}
+	var sections []Section
+	for _, section := range Sections {
+		if !s.isStagedOut(section) {
+			sections = append(sections, section)
+		}
+	}
+	staged := c
+	staged.Parts = make([]*Configuration, 0, len(c.Parts))
+	for _, part := range c.Parts {
+		if part != nil {
+			part = part.Subset(sections...)
+		}
+		staged.Parts = append(staged.Parts, part)
+	}
+	return staged
+}
+
+// deferredAddons returns the qualified names of the addons configured by any part, in the order they are first listed.
+func deferredAddons(c MultiPartConfiguration) []string {
+	var names []string
+	seen := make(map[string]struct{})
+	for _, part := range c.Parts {
+		if part == nil {
+			continue
+		}
+		for _, addon := range part.Addons {
+			name := addon.QualifiedName()
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
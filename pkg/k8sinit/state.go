@@ -0,0 +1,128 @@
+package k8sinit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// appliedState is the last successfully applied configuration, stored as JSON in ApplyOptions.StatePath.
+type appliedState struct {
+	// PreInit is true if the configuration was applied in pre-init mode, i.e. without addons and service restarts.
+	PreInit bool `json:"preInit"`
+	// Configuration is the merged configuration (see Merge), with the same field names as the configuration file format.
+	Configuration json.RawMessage `json:"configuration"`
+	// ArgFiles are the sha256 digests of the files that extra arguments refer to, by path (see argFileDigests).
+	ArgFiles map[string]string `json:"argFiles,omitempty"`
+}
+
+// loadAppliedState returns the last applied configuration from path, and the digests of the files it referred to. If the
+// file does not exist, is corrupted or was written in a different mode (pre-init or not), nil is returned so that the
+// full configuration is applied.
+func (s *launcherScope) loadAppliedState(path string) (*Configuration, map[string]string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			s.logger.Warnf("failed to read the last applied configuration, applying the full configuration: %v", err)
+		}
+		return nil, nil
+	}
+
+	var state appliedState
+	if err := json.Unmarshal(b, &state); err != nil {
+		s.logger.Warnf("ignoring corrupted last applied configuration %s, applying the full configuration: %v", path, err)
+		return nil, nil
+	}
+	var c Configuration
+	if err := yaml.Unmarshal(state.Configuration, &c); err != nil || c.Version == "" {
+		s.logger.Warnf("ignoring corrupted last applied configuration %s, applying the full configuration: %v", path, err)
+		return nil, nil
+	}
+	if state.PreInit != s.launcher.preInit {
+		s.logger.Infof("The last configuration was applied with pre-init=%v, applying the full configuration", state.PreInit)
+		return nil, nil
+	}
+	return &c, state.ArgFiles
+}
+
+// writeAppliedState stores the merged configuration as the last applied configuration in path, along with the digests
+// of the files it refers to. The file is replaced atomically, and is only readable by the owner since the configuration
+// may contain secrets.
+func (s *launcherScope) writeAppliedState(path string, merged *Configuration, argFiles map[string]string) error {
+	b, err := merged.Marshal()
+	if err != nil {
+		return err
+	}
+	j, err := k8syaml.ToJSON(b)
+	if err != nil {
+		return fmt.Errorf("failed to convert configuration to JSON: %w", err)
+	}
+	b, err = json.Marshal(appliedState{PreInit: s.launcher.preInit, Configuration: j, ArgFiles: argFiles})
+	if err != nil {
+		return fmt.Errorf("failed to marshal applied state: %w", err)
+	}
+
+	return writePrivateFile(path, append(b, '\n'))
+}
+
+// writePrivateFile replaces the file in path atomically with b. The file is only readable by the owner.
+func writePrivateFile(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory of %s: %w", path, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// mergedForState returns the merged configuration to compare with and to store as the last applied configuration, or nil
+// if opts.StatePath is not set. If the configuration cannot be merged, it is applied in full and not stored.
+func (s *launcherScope) mergedForState(c MultiPartConfiguration) *Configuration {
+	if s.opts.StatePath == "" || len(c.Parts) == 0 {
+		return nil
+	}
+	merged, err := c.Merge()
+	if err != nil {
+		s.logger.Warnf("failed to merge configuration, applying the full configuration: %v", err)
+		return nil
+	}
+	return merged
+}
+
+// changedSections returns the sections of the merged configuration that differ from the last applied configuration.
+func changedSections(last, merged *Configuration) []Section {
+	var changed []Section
+	for _, section := range Sections {
+		a, b := last.Subset(section), merged.Subset(section)
+		a.Version, b.Version = "", ""
+		if !a.Equal(b) {
+			changed = append(changed, section)
+		}
+	}
+	return changed
+}
+
+// incrementalParts returns the configuration parts with only the changed sections (see changedSections), so that
+// unchanged sections (e.g. addons) are not acted upon again.
+func incrementalParts(c MultiPartConfiguration, changed []Section) MultiPartConfiguration {
+	incremental := c
+	incremental.Parts = make([]*Configuration, 0, len(c.Parts))
+	for _, part := range c.Parts {
+		if part != nil {
+			part = part.Subset(changed...)
+		}
+		incremental.Parts = append(incremental.Parts, part)
+	}
+	return incremental
+}
@@ -0,0 +1,157 @@
+package k8sinit
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Section is a top-level field of the configuration file format, e.g. "addons". Its value is the name of the field in
+// the configuration file.
+type Section string
+
+const (
+	SectionAddonRepositories               Section = "addonRepositories"
+	SectionAddons                          Section = "addons"
+	SectionExtraKubeletArgs                Section = "extraKubeletArgs"
+	SectionExtraKubeAPIServerArgs          Section = "extraKubeAPIServerArgs"
+	SectionExtraKubeProxyArgs              Section = "extraKubeProxyArgs"
+	SectionExtraKubeControllerManagerArgs  Section = "extraKubeControllerManagerArgs"
+	SectionExtraKubeSchedulerArgs          Section = "extraKubeSchedulerArgs"
+	SectionExtraKubeliteEnv                Section = "extraKubeliteEnv"
+	SectionExtraSANs                       Section = "extraSANs"
+	SectionContainerdRegistryConfigs       Section = "containerdRegistryConfigs"
+	SectionRegistryMirrors                 Section = "registryMirrors"
+	SectionExtraContainerdArgs             Section = "extraContainerdArgs"
+	SectionExtraContainerdEnv              Section = "extraContainerdEnv"
+	SectionContainerRuntime                Section = "containerRuntime"
+	SectionContainerd                      Section = "containerd"
+	SectionExtraDqliteArgs                 Section = "extraDqliteArgs"
+	SectionDatastore                       Section = "datastore"
+	SectionExtraDqliteEnv                  Section = "extraDqliteEnv"
+	SectionExtraMicroK8sClusterAgentArgs   Section = "extraMicroK8sClusterAgentArgs"
+	SectionExtraMicroK8sClusterAgentEnv    Section = "extraMicroK8sClusterAgentEnv"
+	SectionExtraMicroK8sAPIServerProxyArgs Section = "extraMicroK8sAPIServerProxyArgs"
+	SectionExtraMicroK8sAPIServerProxyEnv  Section = "extraMicroK8sAPIServerProxyEnv"
+	SectionExtraEtcdArgs                   Section = "extraEtcdArgs"
+	SectionExtraEtcdEnv                    Section = "extraEtcdEnv"
+	SectionExtraFlanneldArgs               Section = "extraFlanneldArgs"
+	SectionExtraFlanneldEnv                Section = "extraFlanneldEnv"
+	SectionExtraConfigFiles                Section = "extraConfigFiles"
+	SectionPersistentClusterToken          Section = "persistentClusterToken"
+	SectionRestartServices                 Section = "restartServices"
+	SectionJoin                            Section = "join"
+	SectionNodeLabels                      Section = "nodeLabels"
+	SectionNodeTaints                      Section = "nodeTaints"
+	SectionAuditPolicy                     Section = "auditPolicy"
+	SectionKubeletConfig                   Section = "kubeletConfig"
+	SectionFeatureGates                    Section = "featureGates"
+	SectionAdmissionPlugins                Section = "admissionPlugins"
+	SectionEncryption                      Section = "encryption"
+	SectionOIDC                            Section = "oidc"
+	SectionCNI                             Section = "cni"
+	SectionHooks                           Section = "hooks"
+	SectionExtraCNIEnv                     Section = "extraCNIEnv"
+	SectionExtraFIPSEnv                    Section = "extraFIPSEnv"
+)
+
+// Sections are all sections of the configuration file format, in the order of the Configuration struct. The version,
+// roles and annotations are not sections, since they are kept by every subset. Includes are resolved while parsing, so
+// they are not a section either.
+// NOTE: this needs to be updated when new fields are added to the Configuration struct.
+var Sections = []Section{
+	SectionAddonRepositories, SectionAddons, SectionExtraKubeletArgs, SectionExtraKubeAPIServerArgs, SectionExtraKubeProxyArgs,
+	SectionExtraKubeControllerManagerArgs, SectionExtraKubeSchedulerArgs, SectionExtraKubeliteEnv, SectionExtraSANs,
+	SectionContainerdRegistryConfigs, SectionRegistryMirrors, SectionExtraContainerdArgs, SectionExtraContainerdEnv, SectionContainerRuntime,
+	SectionContainerd, SectionExtraDqliteArgs, SectionDatastore, SectionExtraDqliteEnv, SectionExtraMicroK8sClusterAgentArgs,
+	SectionExtraMicroK8sClusterAgentEnv, SectionExtraMicroK8sAPIServerProxyArgs, SectionExtraMicroK8sAPIServerProxyEnv,
+	SectionExtraEtcdArgs, SectionExtraEtcdEnv, SectionExtraFlanneldArgs, SectionExtraFlanneldEnv, SectionExtraConfigFiles,
+	SectionPersistentClusterToken, SectionRestartServices, SectionJoin, SectionNodeLabels, SectionNodeTaints,
+	SectionAuditPolicy, SectionKubeletConfig, SectionFeatureGates, SectionAdmissionPlugins, SectionEncryption, SectionOIDC, SectionCNI, SectionHooks, SectionExtraCNIEnv,
+	SectionExtraFIPSEnv,
+}
+
+// sectionFieldIndex maps each section to the index of its field in the Configuration struct, by YAML field name.
+var sectionFieldIndex = func() map[Section]int {
+	t := reflect.TypeOf(Configuration{})
+	fields := make(map[string]int, t.NumField())
+	for idx := 0; idx < t.NumField(); idx++ {
+		name, _, _ := strings.Cut(t.Field(idx).Tag.Get("yaml"), ",")
+		fields[name] = idx
+	}
+	index := make(map[Section]int, len(Sections))
+	for _, section := range Sections {
+		idx, ok := fields[string(section)]
+		if !ok {
+			panic(fmt.Sprintf("section %q is not a field of the Configuration struct", section))
+		}
+		index[section] = idx
+	}
+	return index
+}()
+
+// Subset returns a copy of the configuration that only contains the given sections, along with the version, roles,
+// annotations and source of the configuration. This allows applying a configuration in stages, e.g. the SANs first and the addons later.
+// Unknown sections are ignored. The configuration itself is not changed.
+func (c *Configuration) Subset(sections ...Section) *Configuration {
+	if c == nil {
+		return nil
+	}
+	clone := c.Clone()
+	subset := &Configuration{Version: clone.Version, Roles: clone.Roles, Annotations: clone.Annotations, Source: clone.Source}
+	src, dst := reflect.ValueOf(clone).Elem(), reflect.ValueOf(subset).Elem()
+	for _, section := range sections {
+		if idx, ok := sectionFieldIndex[section]; ok {
+			dst.Field(idx).Set(src.Field(idx))
+		}
+	}
+	return subset
+}
+
+// IsEmpty returns true if the configuration does not set any section, so that applying it would not change the node.
+// The version, roles, annotations and includes are not sections, so they are ignored. A section is empty if its value is
+// nil, an empty list or map (or a pointer to one, e.g. ExtraSANs), or a struct of empty values. A null value that has a
+// meaning of its own (e.g. AuditPolicy) is not empty. A nil configuration is empty.
+func (c *Configuration) IsEmpty() bool {
+	if c == nil {
+		return true
+	}
+	for _, section := range Sections {
+		if c.hasSection(section) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasSection returns true if the configuration sets the given section.
+func (c *Configuration) hasSection(section Section) bool {
+	idx, ok := sectionFieldIndex[section]
+	return ok && !isEmptyValue(reflect.ValueOf(c).Elem().Field(idx))
+}
+
+// isEmptyValue returns true if v is nil, an empty list or map or a pointer to one, a zero scalar, or a struct whose fields
+// are all empty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	case reflect.Ptr:
+		if v.IsNil() {
+			return true
+		}
+		if kind := v.Elem().Kind(); kind == reflect.Map || kind == reflect.Slice {
+			return v.Elem().Len() == 0
+		}
+		return false
+	case reflect.Struct:
+		for idx := 0; idx < v.NumField(); idx++ {
+			if !isEmptyValue(v.Field(idx)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return v.IsZero()
+	}
+}
@@ -0,0 +1,188 @@
+package k8sinit_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSubset(t *testing.T) {
+	b, err := testdata.ReadFile("testdata/schema/full.yaml")
+	if err != nil {
+		t.Fatalf("failed to read testdata: %v", err)
+	}
+
+	t.Run("Addons", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfiguration(b)
+		g.Expect(err).To(BeNil())
+
+		subset := c.Subset(k8sinit.SectionAddons)
+		g.Expect(subset).To(Equal(&k8sinit.Configuration{Version: c.Version, Source: c.Source, Addons: c.Addons}))
+		g.Expect(subset.Validate()).To(Succeed())
+	})
+
+	t.Run("MultipleSections", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfiguration(b)
+		g.Expect(err).To(BeNil())
+
+		subset := c.Subset(k8sinit.SectionExtraSANs, k8sinit.SectionExtraKubeletArgs)
+		g.Expect(subset).To(Equal(&k8sinit.Configuration{
+			Version:          c.Version,
+			Source:           c.Source,
+			ExtraSANs:        c.ExtraSANs,
+			ExtraKubeletArgs: c.ExtraKubeletArgs,
+		}))
+		g.Expect(subset.Validate()).To(Succeed())
+		g.Expect(subset.Addons).To(BeNil())
+		g.Expect(subset.PersistentClusterToken).To(BeEmpty())
+	})
+
+	t.Run("AllSections", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfiguration(b)
+		g.Expect(err).To(BeNil())
+		g.Expect(c.Subset(k8sinit.Sections...)).To(Equal(c))
+	})
+
+	t.Run("NoSections", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfiguration(b)
+		g.Expect(err).To(BeNil())
+
+		subset := c.Subset()
+		g.Expect(subset).To(Equal(&k8sinit.Configuration{Version: c.Version, Source: c.Source}))
+		g.Expect(subset.Validate()).To(Succeed())
+	})
+
+	t.Run("Independent", func(t *testing.T) {
+		g := NewWithT(t)
+		c, err := k8sinit.ParseConfiguration(b)
+		g.Expect(err).To(BeNil())
+		original := c.Clone()
+
+		subset := c.Subset(k8sinit.SectionExtraSANs, k8sinit.SectionExtraKubeletArgs)
+		(*subset.ExtraSANs)[0] = "10.0.0.1"
+		subset.ExtraKubeletArgs["--max-pods"] = &[]string{"200"}[0]
+		g.Expect(c).To(Equal(original))
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		g := NewWithT(t)
+		var c *k8sinit.Configuration
+		g.Expect(c.Subset(k8sinit.SectionAddons)).To(BeNil())
+	})
+
+	t.Run("CoversAllFields", func(t *testing.T) {
+		g := NewWithT(t)
+		sections := make(map[k8sinit.Section]struct{}, len(k8sinit.Sections))
+		for _, section := range k8sinit.Sections {
+			sections[section] = struct{}{}
+		}
+
+		ty := reflect.TypeOf(k8sinit.Configuration{})
+		for idx := 0; idx < ty.NumField(); idx++ {
+			name, _, _ := strings.Cut(ty.Field(idx).Tag.Get("yaml"), ",")
+			if name == "-" || name == "version" || name == "roles" || name == "include" || name == "annotations" {
+				continue
+			}
+			g.Expect(sections).To(HaveKey(k8sinit.Section(name)), "field %q has no section", name)
+		}
+	})
+}
+
+// sampleValue returns a non-empty value of type t.
+func sampleValue(t reflect.Type) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Ptr:
+		v.Set(reflect.New(t.Elem()))
+		if kind := t.Elem().Kind(); kind == reflect.Slice || kind == reflect.Map {
+			v.Elem().Set(sampleValue(t.Elem()))
+		}
+	case reflect.Slice:
+		v.Set(reflect.Append(v, sampleValue(t.Elem())))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+		v.SetMapIndex(sampleValue(t.Key()), sampleValue(t.Elem()))
+	case reflect.Struct:
+		v.Field(0).Set(sampleValue(t.Field(0).Type))
+	case reflect.String:
+		v.SetString("value")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	}
+	return v
+}
+
+func TestIsEmpty(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		c           *k8sinit.Configuration
+		expectEmpty bool
+	}{
+		{name: "Nil", expectEmpty: true},
+		{name: "Zero", c: &k8sinit.Configuration{}, expectEmpty: true},
+		{name: "VersionOnly", c: &k8sinit.Configuration{Version: "0.2.0"}, expectEmpty: true},
+		{
+			name:        "NotSections",
+			c:           &k8sinit.Configuration{Version: "0.2.0", Roles: []k8sinit.NodeRole{k8sinit.NodeRoleWorker}, Annotations: map[string]string{"owner": "team-a"}, Source: "part 0"},
+			expectEmpty: true,
+		},
+		{
+			name:        "EmptyListsAndMaps",
+			c:           &k8sinit.Configuration{Addons: []k8sinit.AddonConfiguration{}, ExtraKubeletArgs: k8sinit.ExtraArgs{}, Hooks: k8sinit.HooksConfiguration{PreApply: []string{}}},
+			expectEmpty: true,
+		},
+		{name: "EmptySANs", c: &k8sinit.Configuration{ExtraSANs: &[]string{}}, expectEmpty: true},
+		{name: "SANs", c: &k8sinit.Configuration{ExtraSANs: &[]string{"10.0.0.1"}}},
+		{name: "NullAuditPolicy", c: &k8sinit.Configuration{AuditPolicy: &k8sinit.AuditPolicyConfiguration{}}},
+		{name: "NullArg", c: &k8sinit.Configuration{ExtraKubeletArgs: k8sinit.ExtraArgs{"--max-pods": nil}}},
+		{name: "JoinWorker", c: &k8sinit.Configuration{Join: k8sinit.JoinConfiguration{Worker: true}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(tc.c.IsEmpty()).To(Equal(tc.expectEmpty))
+		})
+	}
+
+	ty := reflect.TypeOf(k8sinit.Configuration{})
+	fields := make(map[k8sinit.Section]reflect.StructField, ty.NumField())
+	for idx := 0; idx < ty.NumField(); idx++ {
+		name, _, _ := strings.Cut(ty.Field(idx).Tag.Get("yaml"), ",")
+		fields[k8sinit.Section(name)] = ty.Field(idx)
+	}
+	for _, section := range k8sinit.Sections {
+		field, ok := fields[section]
+		if !ok {
+			t.Fatalf("section %q is not a field of the Configuration struct", section)
+		}
+
+		// structs are checked for each of their fields, e.g. "join.worker"
+		values := map[string]reflect.Value{string(section): sampleValue(field.Type)}
+		if field.Type.Kind() == reflect.Struct {
+			values = make(map[string]reflect.Value, field.Type.NumField())
+			for idx := 0; idx < field.Type.NumField(); idx++ {
+				v := reflect.New(field.Type).Elem()
+				v.Field(idx).Set(sampleValue(field.Type.Field(idx).Type))
+				values[string(section)+"."+field.Type.Field(idx).Name] = v
+			}
+		}
+		for name, value := range values {
+			t.Run(name, func(t *testing.T) {
+				c := &k8sinit.Configuration{Version: "0.2.0"}
+				reflect.ValueOf(c).Elem().FieldByIndex(field.Index).Set(value)
+
+				g := NewWithT(t)
+				g.Expect(c.IsEmpty()).To(BeFalse())
+			})
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package k8sinit
+
+import "strings"
+
+// derivedArgsSections are the sections that are applied as arguments of a service (see withNodeArgs, withAuditPolicy,
+// withEncryptionArgs, withOIDCArgs, withKubeletConfigArgs, withFeatureGates and withAdmissionPlugins), by the arguments
+// file of the service.
+var derivedArgsSections = map[string][]Section{
+	"kubelet":                 {SectionNodeLabels, SectionNodeTaints, SectionKubeletConfig, SectionFeatureGates},
+	"kube-apiserver":          {SectionAuditPolicy, SectionEncryption, SectionOIDC, SectionFeatureGates, SectionAdmissionPlugins},
+	"kube-controller-manager": {SectionFeatureGates},
+	"kube-scheduler":          {SectionFeatureGates},
+	"kube-proxy":              {SectionFeatureGates},
+}
+
+// preInitSkippedSections are the sections that are not applied in pre-init mode.
+var preInitSkippedSections = map[Section]struct{}{
+	SectionAddonRepositories: {},
+	SectionAddons:            {},
+	SectionJoin:              {},
+}
+
+// presentSections returns the sections that are set in any part of the configuration.
+func presentSections(c MultiPartConfiguration) map[Section]struct{} {
+	present := make(map[Section]struct{})
+	for _, part := range c.Parts {
+		if part == nil {
+			continue
+		}
+		for _, section := range Sections {
+			if part.hasSection(section) {
+				present[section] = struct{}{}
+			}
+		}
+	}
+	return present
+}
+
+// trackActions marks the sections as applied if any actions were recorded since the apply result had since actions.
+func (s *launcherScope) trackActions(since int, sections ...Section) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.result.Actions) == since {
+		return
+	}
+	for _, section := range sections {
+		s.appliedSections[section] = struct{}{}
+	}
+}
+
+// actionCount returns the number of actions recorded so far.
+func (s *launcherScope) actionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.result.Actions)
+}
+
+// summarizeSections sets the section summary of the apply result, and logs it. present are the sections of the
+// configuration, and selected are the sections of the parts that apply to the role of the local node.
+func (s *launcherScope) summarizeSections(present, selected map[Section]struct{}) {
+	summary := &SectionSummary{}
+	for _, section := range Sections {
+		if _, ok := present[section]; !ok {
+			continue
+		}
+		summary.Present = append(summary.Present, section)
+		_, isSelected := selected[section]
+		_, isPreInitSkipped := preInitSkippedSections[section]
+		_, isApplied := s.appliedSections[section]
+		switch {
+		case !isSelected, s.launcher.preInit && isPreInitSkipped, s.isStagedOut(section):
+			summary.Skipped = append(summary.Skipped, section)
+		case isApplied:
+			summary.Applied = append(summary.Applied, section)
+		default:
+			summary.Unchanged = append(summary.Unchanged, section)
+		}
+	}
+	s.result.Sections = summary
+	s.logger.Infof("Configuration sections: present=%v applied=%v unchanged=%v skipped=%v", summary.Present, summary.Applied, summary.Unchanged, summary.Skipped)
+}
+
+// argsSections returns the sections of the configuration that are applied to the arguments file of a service field: the
+// section of the field itself (e.g. "extraKubeletArgs" or "containerd"), and the sections derived from it (see
+// derivedArgsSections) if they are set.
+func (c *Configuration) argsSections(field serviceArgsField) []Section {
+	name, _, _ := strings.Cut(field.name, ".")
+	sections := []Section{Section(name)}
+	for _, section := range derivedArgsSections[field.configFile] {
+		if c.hasSection(section) {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
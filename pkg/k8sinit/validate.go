@@ -0,0 +1,673 @@
+package k8sinit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// fieldVersions is a registry of configuration fields that were introduced after the minimum config file version.
+// Configurations using any of these fields must declare at least the version that introduced the field.
+var fieldVersions = []struct {
+	field   string
+	version *version.Version
+	isSet   func(c *Configuration) bool
+}{
+	{field: "include", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return len(c.Include) > 0
+	}},
+	{field: "extraArgs[" + ResetArgsKey + "]", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		for _, field := range c.serviceArgsFields() {
+			if _, ok := (*field.args)[ResetArgsKey]; ok {
+				return true
+			}
+		}
+		return false
+	}},
+	{field: "extraArgs[--flag=value]", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		for _, field := range c.serviceArgsFields() {
+			for key := range *field.args {
+				if isVerbatimArg(key) {
+					return true
+				}
+			}
+		}
+		return false
+	}},
+	{field: "containerd", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return len(c.Containerd.ExtraArgs) > 0 || c.Containerd.ConfigToml != ""
+	}},
+	{field: "datastore", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return len(c.Datastore.ExtraArgs) > 0 || c.Datastore.AllowUnsafe
+	}},
+	{field: "cni", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return c.CNI.Config != "" || c.CNI.Calico != nil || c.CNI.Flannel != nil
+	}},
+	{field: "hooks", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		h := c.Hooks
+		return len(h.PreApply) > 0 || len(h.PostApply) > 0 || h.Timeout != "" || h.FailurePolicy != ""
+	}},
+	{field: "annotations", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return len(c.Annotations) > 0
+	}},
+	{field: "registryMirrors", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return len(c.RegistryMirrors) > 0
+	}},
+	{field: "roles", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return len(c.Roles) > 0
+	}},
+	{field: "nodeLabels", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return len(c.NodeLabels) > 0
+	}},
+	{field: "nodeTaints", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return len(c.NodeTaints) > 0
+	}},
+	{field: "extraSANs[" + extraSANInterfacePrefix + "...]", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		if c.ExtraSANs == nil {
+			return false
+		}
+		for _, entry := range *c.ExtraSANs {
+			if strings.HasPrefix(strings.TrimPrefix(entry, extraSANRemovePrefix), extraSANInterfacePrefix) {
+				return true
+			}
+		}
+		return false
+	}},
+	{field: "auditPolicy", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return c.AuditPolicy != nil
+	}},
+	{field: "kubeletConfig", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return c.KubeletConfig != nil
+	}},
+	{field: "featureGates", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return c.hasFeatureGates()
+	}},
+	{field: "admissionPlugins", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return c.hasAdmissionPlugins()
+	}},
+	{field: "encryption", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return c.Encryption != nil
+	}},
+	{field: "oidc", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return c.OIDC != nil
+	}},
+	{field: "restartServices", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return c.RestartServices != ""
+	}},
+	{field: "containerRuntime", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return c.ContainerRuntime != ""
+	}},
+	{field: "addons[].repository", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return anyAddon(c, func(a AddonConfiguration) bool { return a.Repository != "" })
+	}},
+	{field: "addons[].timeout", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return anyAddon(c, func(a AddonConfiguration) bool { return a.Timeout != "" })
+	}},
+	{field: "addons[].failurePolicy", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return anyAddon(c, func(a AddonConfiguration) bool { return a.FailurePolicy != "" })
+	}},
+	{field: "addons[].priority", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		return anyAddon(c, func(a AddonConfiguration) bool { return a.Priority != 0 })
+	}},
+	{field: "addonRepositories[].disable", version: version.MustParseSemantic("0.2.0"), isSet: func(c *Configuration) bool {
+		for _, repo := range c.AddonRepositories {
+			if repo.Disable {
+				return true
+			}
+		}
+		return false
+	}},
+}
+
+// anyAddon returns true if f is true for any of the configured addons.
+func anyAddon(c *Configuration, f func(AddonConfiguration) bool) bool {
+	for _, addon := range c.Addons {
+		if f(addon) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidationError aggregates all problems found while validating a Configuration.
+type ValidationError struct {
+	// Errors is the list of problems found during validation.
+	Errors []error
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the list of validation errors.
+func (e *ValidationError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is reports whether any of the validation errors matches target. Unlike Unwrap, this is also used by errors.Is before
+// Go 1.20.
+func (e *ValidationError) Is(target error) bool {
+	return isAnyError(e.Errors, target)
+}
+
+// As finds the first validation error that matches target. Unlike Unwrap, this is also used by errors.As before Go 1.20.
+func (e *ValidationError) As(target interface{}) bool {
+	return asAnyError(e.Errors, target)
+}
+
+// isAnyError reports whether any of errs matches target, see errors.Is.
+func isAnyError(errs []error, target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// asAnyError finds the first of errs that matches target, and if so, sets target to that error, see errors.As.
+func asAnyError(errs []error, target interface{}) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the configuration for semantic errors.
+// Validate is called by ParseConfiguration, and should also be called for configurations that are constructed programmatically.
+// All problems found are returned as a *ValidationError. Problems that do not prevent applying the configuration, e.g.
+// extra arguments managed by an enabled addon (see AddonConflictWarnings), are reported as warnings when parsing instead.
+func (c *Configuration) Validate() error {
+	return c.ValidateWithOptions(ValidateOptions{})
+}
+
+// ValidateWithOptions checks the configuration for semantic errors. See Validate for details.
+func (c *Configuration) ValidateWithOptions(opts ValidateOptions) error {
+	var errs []error
+
+	// without a version, no other fields can be interpreted
+	if strings.TrimSpace(c.Version) == "" {
+		return &ValidationError{Errors: []error{ErrMissingVersion}}
+	}
+	if v, err := validateVersion(c.Version); err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, validateFieldVersions(c, v)...)
+	}
+
+	if c.ExtraSANs != nil {
+		errs = append(errs, validateExtraSANs(*c.ExtraSANs)...)
+	}
+
+	knownAddons := opts.KnownAddons
+	if knownAddons == nil {
+		knownAddons = DefaultKnownAddons
+	}
+	errs = append(errs, validateAddons(c.Addons, knownAddons)...)
+	if !opts.ResolveDuplicateAddons {
+		errs = append(errs, validateDuplicateAddons(c.Addons)...)
+	}
+	errs = append(errs, validateAddonArgumentLimits(c.Addons, opts)...)
+	errs = append(errs, validateAddonRepositories(c.AddonRepositories)...)
+
+	errs = append(errs, validateAmbiguousArgs(c)...)
+	errs = append(errs, validateRoles(c)...)
+
+	for _, field := range c.serviceArgsFields() {
+		if value, ok := (*field.args)[ResetArgsKey]; ok && value != nil {
+			errs = append(errs, fmt.Errorf("%s[%s] must be null to reset all arguments", field.name, ResetArgsKey))
+		}
+	}
+
+	errs = append(errs, validateArgKeys(c)...)
+	errs = append(errs, validateExtraConfigFiles(c)...)
+
+	if !c.Datastore.AllowUnsafe {
+		errs = append(errs, validateUnsafeDatastoreArgs(c.Datastore.ExtraArgs)...)
+	}
+
+	errs = append(errs, validateCNI(c.CNI)...)
+	errs = append(errs, validateHooks(c.Hooks)...)
+	errs = append(errs, validateNodeLabelsAndTaints(c)...)
+	errs = append(errs, validateAuditPolicy(c)...)
+	errs = append(errs, validateKubeletConfig(c)...)
+	errs = append(errs, validateEncryption(c)...)
+	errs = append(errs, validateOIDC(c)...)
+	errs = append(errs, validateFeatureGates(c)...)
+	errs = append(errs, validateAdmissionPlugins(c)...)
+	errs = append(errs, validateRegistryMirrors(c)...)
+
+	switch c.RestartServices {
+	case "", RestartPolicyAlways, RestartPolicyOnChange, RestartPolicyNever:
+	default:
+		errs = append(errs, fmt.Errorf("restartServices %q must be one of %q, %q or %q", c.RestartServices, RestartPolicyAlways, RestartPolicyOnChange, RestartPolicyNever))
+	}
+
+	if err := validateContainerRuntime(c.ContainerRuntime); err != nil {
+		errs = append(errs, err)
+	}
+
+	if v := c.Containerd.ConfigToml; v != "" {
+		if _, err := toml.Decode(v, &map[string]interface{}{}); err != nil {
+			errs = append(errs, fmt.Errorf("containerd configToml is not valid TOML: %w", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// validateVersion checks that the configuration version is within the supported range, and returns the parsed version.
+func validateVersion(configVersion string) (*version.Version, error) {
+	v, err := version.ParseSemantic(configVersion)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("could not parse config file version %q: %w", configVersion, err)
+	case maximumConfigFileVersionSupported.LessThan(v):
+		return nil, fmt.Errorf("config file version is %v but the maximum version supported is %v", configVersion, maximumConfigFileVersionSupported)
+	case v.LessThan(minimumConfigFileVersionRequired):
+		return nil, fmt.Errorf("config file version is %v but the minimum version required is %v", configVersion, minimumConfigFileVersionRequired)
+	}
+	return v, nil
+}
+
+// validateExactVersion checks that the configuration version is one of the exact versions allowed.
+// Versions are compared semantically, e.g. "0.2.0+build.1" is the same version as "0.2.0".
+func validateExactVersion(configVersion string, exactVersions []string) error {
+	v, err := version.ParseSemantic(configVersion)
+	if err != nil {
+		return fmt.Errorf("could not parse config file version %q: %w", configVersion, err)
+	}
+	for _, exact := range exactVersions {
+		cmp, err := v.Compare(exact)
+		if err != nil {
+			return fmt.Errorf("could not parse allowed config file version %q: %w", exact, err)
+		}
+		if cmp == 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("config file version is %v but only versions %s are allowed", configVersion, strings.Join(exactVersions, ", "))
+}
+
+// validateFieldVersions checks that the configuration does not use any fields newer than its declared version.
+func validateFieldVersions(c *Configuration, v *version.Version) []error {
+	var errs []error
+	for _, entry := range fieldVersions {
+		if entry.isSet(c) && v.LessThan(entry.version) {
+			errs = append(errs, fmt.Errorf("field %q requires config file version %v or newer, but version is %v", entry.field, entry.version, c.Version))
+		}
+	}
+	return errs
+}
+
+// validateHooks checks that hook commands are not empty, and that the hooks timeout and failure policy are valid.
+func validateHooks(hooks HooksConfiguration) []error {
+	var errs []error
+	for _, list := range []struct {
+		name     string
+		commands []string
+	}{{"hooks.preApply", hooks.PreApply}, {"hooks.postApply", hooks.PostApply}} {
+		for idx, command := range list.commands {
+			if strings.TrimSpace(command) == "" {
+				errs = append(errs, fmt.Errorf("%s[%d] must not be empty", list.name, idx))
+			}
+		}
+	}
+	if hooks.Timeout != "" {
+		if timeout, err := time.ParseDuration(hooks.Timeout); err != nil || timeout <= 0 {
+			errs = append(errs, fmt.Errorf("hooks timeout %q is not a valid positive duration", hooks.Timeout))
+		}
+	}
+	switch hooks.FailurePolicy {
+	case "", AddonFailurePolicyAbort, AddonFailurePolicyContinue:
+	default:
+		errs = append(errs, fmt.Errorf("hooks failurePolicy %q must be one of %q or %q", hooks.FailurePolicy, AddonFailurePolicyAbort, AddonFailurePolicyContinue))
+	}
+	return errs
+}
+
+// validateExtraSANs checks that each ExtraSANs entry is a valid IP address, a valid (optionally wildcard) RFC 1123 DNS name, or
+// an interface entry (e.g. "interface:eth0:ipv6"). Removal markers (e.g. "-10.0.0.5") are validated against the SAN they remove.
+func validateExtraSANs(sans []string) []error {
+	var errs []error
+	for idx, entry := range sans {
+		san := strings.ToLower(strings.TrimPrefix(entry, extraSANRemovePrefix))
+		if net.ParseIP(san) != nil {
+			continue
+		}
+		if _, _, ok, err := parseInterfaceSAN(san); ok {
+			if err != nil {
+				errs = append(errs, fmt.Errorf("extraSANs[%d] %q is not a valid interface SAN: %w", idx, entry, err))
+			}
+			continue
+		}
+		if len(validation.IsDNS1123Subdomain(san)) == 0 || len(validation.IsWildcardDNS1123Subdomain(san)) == 0 {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("extraSANs[%d] %q is not a valid IP address or DNS name", idx, entry))
+	}
+	return errs
+}
+
+// validateAddonRepositories checks that addon repositories have a name, and that added repositories have an absolute URL
+// or a local path (e.g. "/snap/microk8s/current/addons/core").
+func validateAddonRepositories(repos []AddonRepositoryConfiguration) []error {
+	var errs []error
+	for idx, repo := range repos {
+		if repo.Name == "" {
+			errs = append(errs, fmt.Errorf("addonRepositories[%d] name must not be empty", idx))
+		}
+		switch {
+		case repo.URL == "" && repo.Disable:
+		case repo.URL == "":
+			errs = append(errs, fmt.Errorf("addonRepositories[%d] url must not be empty", idx))
+		case strings.HasPrefix(repo.URL, "/"):
+		default:
+			if u, err := url.Parse(repo.URL); err != nil || u.Scheme == "" || (u.Host == "" && u.Scheme != "file") {
+				errs = append(errs, fmt.Errorf("addonRepositories[%d] url %q must be an absolute URL or path, e.g. \"https://github.com/canonical/microk8s-community-addons\"", idx, repo.URL))
+			}
+		}
+	}
+	return errs
+}
+
+// validateAddons checks that the addons list is well-formed, and that all addons are known.
+func validateAddons(addons []AddonConfiguration, knownAddons []string) []error {
+	known := make(map[string]struct{}, len(knownAddons))
+	for _, name := range knownAddons {
+		known[name] = struct{}{}
+	}
+
+	var errs []error
+	for idx, addon := range addons {
+		repository, name := addon.Repository, addon.Name
+		if parts := strings.SplitN(addon.Name, "/", 2); len(parts) == 2 {
+			if repository != "" {
+				errs = append(errs, fmt.Errorf("addons[%d] name %q must not be qualified when repository %q is set", idx, addon.Name, repository))
+				continue
+			}
+			if parts[0] == "" {
+				errs = append(errs, fmt.Errorf("addons[%d] name %q has an empty repository", idx, addon.Name))
+				continue
+			}
+			repository, name = parts[0], parts[1]
+		}
+		if addon.Timeout != "" {
+			if timeout, err := time.ParseDuration(addon.Timeout); err != nil || timeout <= 0 {
+				errs = append(errs, fmt.Errorf("addons[%d] timeout %q is not a valid positive duration", idx, addon.Timeout))
+			}
+		}
+		for _, arg := range addon.Arguments {
+			if !isAddonArgumentTemplate(arg) {
+				continue
+			}
+			if _, err := resolveAddonArgument(arg, NodeInfo{}); err != nil {
+				errs = append(errs, fmt.Errorf("addons[%d] argument %q: %w", idx, arg, err))
+			}
+		}
+		switch addon.FailurePolicy {
+		case "", AddonFailurePolicyAbort, AddonFailurePolicyContinue:
+		default:
+			errs = append(errs, fmt.Errorf("addons[%d] failurePolicy %q must be one of %q or %q", idx, addon.FailurePolicy, AddonFailurePolicyAbort, AddonFailurePolicyContinue))
+		}
+		_, isKnown := known[name]
+		switch {
+		case isKnown:
+		case name == "":
+			errs = append(errs, fmt.Errorf("addons[%d] is missing a name", idx))
+		case strings.ContainsAny(name, " \t\n/"):
+			errs = append(errs, fmt.Errorf("addons[%d] name %q must not contain whitespace or slashes", idx, addon.Name))
+		case repository != "" && repository != "core" && repository != "community":
+			// addons from other repositories are not known in advance
+		default:
+			if suggestion := closestMatch(name, knownAddons, 2); suggestion != "" {
+				errs = append(errs, fmt.Errorf("addons[%d] %q is not a known addon (did you mean %q?)", idx, addon.Name, suggestion))
+			} else {
+				errs = append(errs, fmt.Errorf("addons[%d] %q is not a known addon", idx, addon.Name))
+			}
+		}
+	}
+	return errs
+}
+
+// validateDuplicateAddons checks that each addon (by qualified name) is listed at most once. Addons that are listed both
+// to enable and disable are reported with a specific error, since this is usually a copy-paste mistake.
+func validateDuplicateAddons(addons []AddonConfiguration) []error {
+	var errs []error
+	first := make(map[string]int, len(addons))
+	for idx, addon := range addons {
+		name := addon.QualifiedName()
+		if firstIdx, ok := first[name]; ok {
+			if addon.Disable != addons[firstIdx].Disable {
+				enableIdx, disableIdx := firstIdx, idx
+				if !addon.Disable {
+					enableIdx, disableIdx = idx, firstIdx
+				}
+				errs = append(errs, fmt.Errorf("addon %q is listed both to enable (addons[%d]) and disable (addons[%d])", name, enableIdx, disableIdx))
+				continue
+			}
+			errs = append(errs, fmt.Errorf("addons[%d] %q is already listed as addons[%d], each addon may only be listed once", idx, name, firstIdx))
+			continue
+		}
+		first[name] = idx
+	}
+	return errs
+}
+
+// resolveDuplicateAddons resolves addons that are listed multiple times, so that the last entry of each addon is used
+// (last-writer-wins). The resolved entry keeps the position of the first entry, same as when merging configuration parts.
+// A warning is returned for each duplicate entry.
+func resolveDuplicateAddons(addons []AddonConfiguration) ([]AddonConfiguration, []string) {
+	if len(validateDuplicateAddons(addons)) == 0 {
+		return addons, nil
+	}
+	var warnings []string
+	first := make(map[string]int, len(addons))
+	for idx, addon := range addons {
+		name := addon.QualifiedName()
+		if firstIdx, ok := first[name]; ok {
+			warnings = append(warnings, fmt.Sprintf("addons[%d] %q overrides addons[%d], since the addon is listed multiple times", idx, name, firstIdx))
+		} else {
+			first[name] = idx
+		}
+	}
+	return mergeAddons(nil, addons), warnings
+}
+
+// closestMatch returns the candidate with the smallest edit distance to s, if it is at most maxDistance.
+// closestMatch returns an empty string if no candidate is close enough.
+func closestMatch(s string, candidates []string, maxDistance int) string {
+	var match string
+	for _, candidate := range candidates {
+		if d := editDistance(s, candidate); d <= maxDistance {
+			match, maxDistance = candidate, d-1
+		}
+	}
+	return match
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// minInt returns the smallest of the given values.
+func minInt(v int, values ...int) int {
+	for _, value := range values {
+		if value < v {
+			v = value
+		}
+	}
+	return v
+}
+
+// validateAddonArgumentLimits checks that the number and total size of the arguments of each addon are within the limits.
+func validateAddonArgumentLimits(addons []AddonConfiguration, opts ValidateOptions) []error {
+	maxArgs := opts.MaxAddonArguments
+	if maxArgs == 0 {
+		maxArgs = DefaultMaxAddonArguments
+	}
+	maxSize := opts.MaxAddonArgumentsSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxAddonArgumentsSize
+	}
+
+	var errs []error
+	for idx, addon := range addons {
+		if len(addon.Arguments) > maxArgs {
+			errs = append(errs, fmt.Errorf("addons[%d] %q has %d arguments, but the maximum is %d", idx, addon.QualifiedName(), len(addon.Arguments), maxArgs))
+		}
+		var size int
+		for _, arg := range addon.Arguments {
+			size += len(arg)
+		}
+		if size > maxSize {
+			errs = append(errs, fmt.Errorf("addons[%d] %q arguments are %d bytes, but the maximum is %d bytes", idx, addon.QualifiedName(), size, maxSize))
+		}
+	}
+	return errs
+}
+
+// normalizeArgKey returns the canonical form of an extra argument key, which is the flag name with two leading dashes,
+// e.g. "--max-pods" for "max-pods" or "-max-pods". Single-letter flags may also be written with a single leading dash, e.g. "-l".
+func normalizeArgKey(key string) string {
+	name := strings.TrimLeft(key, "-")
+	if len(name) == 1 && key == "-"+name {
+		return key
+	}
+	return "--" + name
+}
+
+// validateArgKeys checks that all extra arguments keys are in canonical form (see normalizeArgKey), and that arguments
+// of the list form (see ExtraArgs) do not have a value. Environment variables are not flags, and may not use the list form.
+func validateArgKeys(c *Configuration) []error {
+	var errs []error
+	for _, field := range c.serviceArgsFields() {
+		for _, key := range util.SortedKeys(*field.args) {
+			if key == ResetArgsKey {
+				continue
+			}
+			if field.isEnv() {
+				if isVerbatimArg(key) {
+					errs = append(errs, fmt.Errorf("%s[%s] is not an environment variable name, the list form is only supported for arguments", field.name, key))
+				}
+				continue
+			}
+			flag := argFlag(key)
+			if normalized := normalizeArgKey(flag); flag != normalized {
+				errs = append(errs, fmt.Errorf("%s[%s] must be written as %q", field.name, key, normalized+strings.TrimPrefix(key, flag)))
+			} else if flag == "--" || flag == "-" {
+				errs = append(errs, fmt.Errorf("%s has an empty argument name", field.name))
+			}
+			if value := (*field.args)[key]; isVerbatimArg(key) && value != nil && *value != "" {
+				errs = append(errs, fmt.Errorf("%s[%s] is a whole argument, its value must be empty or null", field.name, key))
+			}
+		}
+	}
+	return errs
+}
+
+// unsafeDatastoreArgs are k8s-dqlite arguments that are dangerous to change on a running datastore.
+var unsafeDatastoreArgs = map[string]struct{}{
+	"--storage-dir": {},
+	"--listen":      {},
+	"--enable-tls":  {},
+}
+
+// validateUnsafeDatastoreArgs rejects datastore arguments that are dangerous to change on a running datastore.
+func validateUnsafeDatastoreArgs(args map[string]*string) []error {
+	var errs []error
+	for _, key := range util.SortedKeys(args) {
+		if _, unsafe := unsafeDatastoreArgs[argFlag(key)]; unsafe {
+			errs = append(errs, fmt.Errorf("datastore.extraArgs[%s] is unsafe to change on a running datastore (set datastore.allowUnsafe to allow it)", key))
+		}
+	}
+	return errs
+}
+
+// validateAmbiguousArgs checks that extra arguments maps which configure the same service arguments file do not set the same key to different values.
+func validateAmbiguousArgs(c *Configuration) []error {
+	var errs []error
+	for _, pair := range []struct {
+		nameA, nameB string
+		argsA, argsB map[string]*string
+	}{
+		{nameA: "extraContainerdArgs", nameB: "containerd.extraArgs", argsA: c.ExtraContainerdArgs, argsB: c.Containerd.ExtraArgs},
+		{nameA: "extraDqliteArgs", nameB: "datastore.extraArgs", argsA: c.ExtraDqliteArgs, argsB: c.Datastore.ExtraArgs},
+	} {
+		for _, key := range util.SortedKeys(pair.argsA) {
+			valA := pair.argsA[key]
+			valB, ok := pair.argsB[key]
+			if !ok {
+				continue
+			}
+			if valA == nil && valB == nil || valA != nil && valB != nil && *valA == *valB {
+				continue
+			}
+			errs = append(errs, fmt.Errorf("argument %q is set to conflicting values in %s and %s", key, pair.nameA, pair.nameB))
+		}
+	}
+	return errs
+}
+
+// checkConfigFileName checks that the name of an extra config file is a plain file name in $SNAP_DATA/args, and not one
+// of the files that are managed otherwise, i.e. the arguments files of services and the state of applied configurations.
+func checkConfigFileName(file string) error {
+	if strings.ContainsAny(file, "/\\") || file == "." || file == ".." {
+		return fmt.Errorf("file name %q must not contain any slashes (possible path-traversal prevented)", file)
+	}
+	if file == configurationHashFile || file == appliedArgsFile {
+		return fmt.Errorf("file name %q is reserved for the state of applied configurations", file)
+	}
+	for _, field := range (&Configuration{}).serviceArgsFields() {
+		if file == field.configFile {
+			return fmt.Errorf("file name %q is reserved for the arguments of a service, use %s instead", file, field.name)
+		}
+	}
+	return nil
+}
+
+// validateExtraConfigFiles checks the names of extra config files, see checkConfigFileName.
+func validateExtraConfigFiles(c *Configuration) []error {
+	var errs []error
+	for _, file := range util.SortedKeys(c.ExtraConfigFiles) {
+		if err := checkConfigFileName(file); err != nil {
+			errs = append(errs, fmt.Errorf("extraConfigFiles[%s]: %w", file, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,675 @@
+package k8sinit_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		config       k8sinit.Configuration
+		expectErrors []string
+	}{
+		{
+			name:   "valid",
+			config: k8sinit.Configuration{Version: "0.1.0", Addons: []k8sinit.AddonConfiguration{{Name: "dns"}}},
+		},
+		{
+			name:         "missing-version",
+			config:       k8sinit.Configuration{Addons: []k8sinit.AddonConfiguration{{Name: "dns", Timeout: "5m"}}},
+			expectErrors: []string{`configuration is missing required field 'version'`},
+		},
+		{
+			name:         "whitespace-version",
+			config:       k8sinit.Configuration{Version: "  "},
+			expectErrors: []string{`configuration is missing required field 'version'`},
+		},
+		{
+			name:         "non-semantic-version",
+			config:       k8sinit.Configuration{Version: "v1"},
+			expectErrors: []string{`could not parse config file version "v1"`},
+		},
+		{
+			name:         "unsupported-version",
+			config:       k8sinit.Configuration{Version: "0.3.0"},
+			expectErrors: []string{"maximum version supported is 0.2.0"},
+		},
+		{
+			name: "ambiguous-containerd-args",
+			config: k8sinit.Configuration{
+				Version:             "0.2.0",
+				ExtraContainerdArgs: map[string]*string{"--log-level": &[]string{"debug"}[0], "--state": nil},
+				Containerd: k8sinit.ContainerdConfiguration{
+					ExtraArgs: map[string]*string{"--log-level": &[]string{"info"}[0], "--state": nil},
+				},
+			},
+			expectErrors: []string{`argument "--log-level" is set to conflicting values in extraContainerdArgs and containerd.extraArgs`},
+		},
+		{
+			name:   "container-runtime",
+			config: k8sinit.Configuration{Version: "0.2.0", ContainerRuntime: "containerd"},
+		},
+		{
+			name:         "container-runtime-unknown",
+			config:       k8sinit.Configuration{Version: "0.2.0", ContainerRuntime: "docker"},
+			expectErrors: []string{`containerRuntime "docker" is not supported (supported runtimes are containerd)`},
+		},
+		{
+			name: "addon-repositories",
+			config: k8sinit.Configuration{Version: "0.2.0", AddonRepositories: []k8sinit.AddonRepositoryConfiguration{
+				{Name: "community", URL: "https://github.com/canonical/microk8s-community-addons", Reference: "1.28"},
+				{Name: "core", URL: "/snap/microk8s/current/addons/core"},
+				{Name: "private", URL: "ssh://git@git.example.com/addons.git"},
+				{Name: "old", Disable: true},
+			}},
+		},
+		{
+			name: "addon-repositories-invalid",
+			config: k8sinit.Configuration{Version: "0.2.0", AddonRepositories: []k8sinit.AddonRepositoryConfiguration{
+				{URL: "https://github.com/canonical/microk8s-community-addons"},
+				{Name: "relative", URL: "addons/custom"},
+				{Name: "no-url"},
+				{Name: "invalid", URL: "https://exa mple.com"},
+			}},
+			expectErrors: []string{
+				`addonRepositories[0] name must not be empty`,
+				`addonRepositories[1] url "addons/custom" must be an absolute URL or path, e.g. "https://github.com/canonical/microk8s-community-addons"`,
+				`addonRepositories[2] url must not be empty`,
+				`addonRepositories[3] url "https://exa mple.com" must be an absolute URL or path, e.g. "https://github.com/canonical/microk8s-community-addons"`,
+			},
+		},
+		{
+			name:         "addon-repositories-disable-version",
+			config:       k8sinit.Configuration{Version: "0.1.0", AddonRepositories: []k8sinit.AddonRepositoryConfiguration{{Name: "old", Disable: true}}},
+			expectErrors: []string{`field "addonRepositories[].disable" requires config file version 0.2.0 or newer, but version is 0.1.0`},
+		},
+		{
+			name: "node-labels-and-taints",
+			config: k8sinit.Configuration{
+				Version:    "0.2.0",
+				NodeLabels: map[string]string{"node-role.kubernetes.io/worker": "", "zone": "a"},
+				NodeTaints: []string{"dedicated=gpu:NoSchedule", "maintenance:PreferNoSchedule"},
+			},
+		},
+		{
+			name: "node-labels-and-taints-invalid",
+			config: k8sinit.Configuration{
+				Version:          "0.2.0",
+				NodeLabels:       map[string]string{"zone": "not valid"},
+				NodeTaints:       []string{"dedicated=gpu", "dedicated=gpu:Sometimes"},
+				ExtraKubeletArgs: map[string]*string{"--node-labels=zone=b": &[]string{""}[0]},
+			},
+			expectErrors: []string{
+				`nodeLabels[zone] value "not valid" is not valid`,
+				`nodeTaints[0] "dedicated=gpu" is not valid: must be in the form "key[=value]:effect"`,
+				`nodeTaints[1] "dedicated=gpu:Sometimes" is not valid: effect "Sometimes" must be one of NoSchedule, PreferNoSchedule, NoExecute`,
+				`nodeLabels cannot be used together with extraKubeletArgs[--node-labels=zone=b]`,
+			},
+		},
+		{
+			name: "audit-policy",
+			config: k8sinit.Configuration{Version: "0.2.0", AuditPolicy: &k8sinit.AuditPolicyConfiguration{
+				Policy:  "apiVersion: audit.k8s.io/v1\nkind: Policy\nrules:\n- level: Metadata\n",
+				LogPath: "/var/log/audit.log",
+			}},
+		},
+		{
+			name: "audit-policy-invalid",
+			config: k8sinit.Configuration{
+				Version:                "0.2.0",
+				AuditPolicy:            &k8sinit.AuditPolicyConfiguration{Policy: "apiVersion: v1\nkind: ConfigMap\nrules:\n- level: Everything\n", LogPath: "audit.log"},
+				ExtraKubeAPIServerArgs: map[string]*string{"--audit-log-path": &[]string{"/var/log/audit.log"}[0]},
+			},
+			expectErrors: []string{
+				`auditPolicy policy apiVersion "v1" must be "audit.k8s.io/v1"`,
+				`auditPolicy policy kind "ConfigMap" must be "Policy"`,
+				`auditPolicy policy rules[0] level "Everything" must be one of None, Metadata, Request, RequestResponse`,
+				`auditPolicy logPath "audit.log" must be an absolute path or "-"`,
+				`auditPolicy cannot be used together with extraKubeAPIServerArgs[--audit-log-path], since both set the kube-apiserver "--audit-log-path" argument`,
+			},
+		},
+		{
+			name:         "audit-policy-not-yaml",
+			config:       k8sinit.Configuration{Version: "0.2.0", AuditPolicy: &k8sinit.AuditPolicyConfiguration{Policy: "{"}},
+			expectErrors: []string{`auditPolicy policy is not a valid YAML document`},
+		},
+		{
+			name:         "audit-policy-version",
+			config:       k8sinit.Configuration{Version: "0.1.0", AuditPolicy: &k8sinit.AuditPolicyConfiguration{}},
+			expectErrors: []string{`field "auditPolicy" requires config file version 0.2.0`},
+		},
+		{
+			name: "encryption",
+			config: k8sinit.Configuration{Version: "0.2.0", Encryption: &k8sinit.EncryptionConfiguration{Providers: []k8sinit.EncryptionProviderConfiguration{
+				{Type: "aescbc", Keys: []k8sinit.EncryptionKeyConfiguration{{Name: "key1", Secret: "MDEyMzQ1Njc4OWFiY2RlZg=="}}},
+				{Type: "kms", Name: "vault", Endpoint: "unix:///var/run/kms.sock", Timeout: "3s"},
+				{Type: "identity"},
+			}}},
+		},
+		{
+			name: "encryption-invalid-keys",
+			config: k8sinit.Configuration{Version: "0.2.0", Encryption: &k8sinit.EncryptionConfiguration{Providers: []k8sinit.EncryptionProviderConfiguration{
+				{Type: "aescbc", Keys: []k8sinit.EncryptionKeyConfiguration{{Name: "key1", Secret: "not base64!"}, {Name: "key1", Secret: "c2hvcnQ="}}},
+				{Type: "secretbox"},
+				{Type: "kms", Endpoint: "/var/run/kms.sock"},
+				{Type: "identity", Keys: []k8sinit.EncryptionKeyConfiguration{{Name: "key1", Secret: "c2hvcnQ="}}},
+				{Type: "aesgcm"},
+			}}},
+			expectErrors: []string{
+				`encryption.providers[0].keys[0] secret is not valid base64`,
+				`encryption.providers[0].keys[1] name "key1" is used by multiple keys`,
+				`encryption.providers[0].keys[1] secret is 5 bytes, but the "aescbc" provider requires keys of 16, 24 or 32 bytes`,
+				`encryption.providers[1] must have at least one key for the "secretbox" provider`,
+				`encryption.providers[2] name is required for the "kms" provider`,
+				`encryption.providers[2] endpoint "/var/run/kms.sock" must be a unix socket`,
+				`encryption.providers[3] keys are not supported by the "identity" provider`,
+				`encryption.providers[4] type "aesgcm" must be one of "aescbc", "secretbox", "kms" or "identity"`,
+			},
+		},
+		{
+			name: "encryption-no-providers",
+			config: k8sinit.Configuration{
+				Version:                "0.2.0",
+				Encryption:             &k8sinit.EncryptionConfiguration{},
+				ExtraKubeAPIServerArgs: map[string]*string{"--encryption-provider-config": &[]string{"/etc/encryption.yaml"}[0]},
+			},
+			expectErrors: []string{
+				`encryption must have at least one provider`,
+				`encryption cannot be used together with extraKubeAPIServerArgs[--encryption-provider-config], since both set the kube-apiserver "--encryption-provider-config" argument`,
+			},
+		},
+		{
+			name: "oidc",
+			config: k8sinit.Configuration{Version: "0.2.0", OIDC: &k8sinit.OIDCConfiguration{
+				IssuerURL: "https://accounts.example.com", ClientID: "kubernetes", UsernameClaim: "email", GroupsClaim: "groups", CAFile: "/etc/ssl/certs/oidc-ca.pem",
+			}},
+		},
+		{
+			name:         "oidc-missing-client-id",
+			config:       k8sinit.Configuration{Version: "0.2.0", OIDC: &k8sinit.OIDCConfiguration{IssuerURL: "https://accounts.example.com"}},
+			expectErrors: []string{`oidc.clientID is required`},
+		},
+		{
+			name:         "oidc-not-https",
+			config:       k8sinit.Configuration{Version: "0.2.0", OIDC: &k8sinit.OIDCConfiguration{IssuerURL: "http://accounts.example.com", ClientID: "kubernetes"}},
+			expectErrors: []string{`oidc.issuerURL "http://accounts.example.com" must be an https URL`},
+		},
+		{
+			name: "oidc-invalid",
+			config: k8sinit.Configuration{
+				Version:                "0.2.0",
+				OIDC:                   &k8sinit.OIDCConfiguration{ClientID: "kubernetes", CAFile: "oidc-ca.pem", CA: "not a certificate"},
+				ExtraKubeAPIServerArgs: map[string]*string{"--oidc-groups-claim": &[]string{"groups"}[0], "--max-requests-inflight": &[]string{"800"}[0]},
+			},
+			expectErrors: []string{
+				`oidc.issuerURL is required`,
+				`oidc.caFile and oidc.ca cannot be used together`,
+				`oidc cannot be used together with extraKubeAPIServerArgs[--oidc-groups-claim], since both set the kube-apiserver "--oidc-groups-claim" argument`,
+			},
+		},
+		{
+			name:         "oidc-invalid-ca",
+			config:       k8sinit.Configuration{Version: "0.2.0", OIDC: &k8sinit.OIDCConfiguration{IssuerURL: "https://accounts.example.com", ClientID: "kubernetes", CA: "not a certificate"}},
+			expectErrors: []string{`oidc.ca must be a PEM-encoded certificate`},
+		},
+		{
+			name:         "oidc-version",
+			config:       k8sinit.Configuration{Version: "0.1.0", OIDC: &k8sinit.OIDCConfiguration{IssuerURL: "https://accounts.example.com", ClientID: "kubernetes"}},
+			expectErrors: []string{`field "oidc" requires config file version 0.2.0`},
+		},
+		{
+			name:         "node-labels-version",
+			config:       k8sinit.Configuration{Version: "0.1.0", NodeLabels: map[string]string{"zone": "a"}, NodeTaints: []string{"a:NoSchedule"}},
+			expectErrors: []string{`field "nodeLabels" requires config file version 0.2.0`, `field "nodeTaints" requires config file version 0.2.0`},
+		},
+		{
+			name: "hooks",
+			config: k8sinit.Configuration{Version: "0.2.0", Hooks: k8sinit.HooksConfiguration{
+				PreApply: []string{"/usr/local/bin/snapshot"}, PostApply: []string{"echo done"}, Timeout: "1m", FailurePolicy: k8sinit.AddonFailurePolicyContinue,
+			}},
+		},
+		{
+			name: "hooks-invalid",
+			config: k8sinit.Configuration{Version: "0.2.0", Hooks: k8sinit.HooksConfiguration{
+				PreApply: []string{"echo ok", " "}, Timeout: "soon", FailurePolicy: "retry",
+			}},
+			expectErrors: []string{
+				`hooks.preApply[1] must not be empty`,
+				`hooks timeout "soon" is not a valid positive duration`,
+				`hooks failurePolicy "retry" must be one of "abort" or "continue"`,
+			},
+		},
+		{
+			name:         "hooks-version",
+			config:       k8sinit.Configuration{Version: "0.1.0", Hooks: k8sinit.HooksConfiguration{PreApply: []string{"true"}}},
+			expectErrors: []string{`field "hooks" requires config file version 0.2.0 or newer, but version is 0.1.0`},
+		},
+		{
+			name:         "container-runtime-too-old",
+			config:       k8sinit.Configuration{Version: "0.1.0", ContainerRuntime: "containerd"},
+			expectErrors: []string{`field "containerRuntime" requires config file version 0.2.0 or newer`},
+		},
+		{
+			name: "datastore",
+			config: k8sinit.Configuration{
+				Version:   "0.2.0",
+				Datastore: k8sinit.DatastoreConfiguration{ExtraArgs: map[string]*string{"--datastore-max-idle-connections": &[]string{"5"}[0]}},
+			},
+		},
+		{
+			name: "datastore-unsafe",
+			config: k8sinit.Configuration{
+				Version:   "0.2.0",
+				Datastore: k8sinit.DatastoreConfiguration{ExtraArgs: map[string]*string{"--storage-dir": &[]string{"/data"}[0], "--listen": nil}},
+			},
+			expectErrors: []string{
+				"datastore.extraArgs[--listen] is unsafe to change on a running datastore",
+				"datastore.extraArgs[--storage-dir] is unsafe to change on a running datastore",
+			},
+		},
+		{
+			name: "datastore-allow-unsafe",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				Datastore: k8sinit.DatastoreConfiguration{
+					ExtraArgs:   map[string]*string{"--storage-dir": &[]string{"/data"}[0]},
+					AllowUnsafe: true,
+				},
+			},
+		},
+		{
+			name: "cni-inline",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				CNI:     k8sinit.CNIConfiguration{Config: "apiVersion: v1\nkind: ConfigMap\n---\napiVersion: v1\nkind: ServiceAccount\n"},
+			},
+		},
+		{
+			name: "cni-calico-and-inline",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				CNI: k8sinit.CNIConfiguration{
+					Config: "apiVersion: v1\nkind: ConfigMap\n",
+					Calico: &k8sinit.CalicoConfiguration{MTU: 1400},
+				},
+			},
+			expectErrors: []string{"only one of cni.config, cni.calico may be set"},
+		},
+		{
+			name: "cni-invalid",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				CNI:     k8sinit.CNIConfiguration{Calico: &k8sinit.CalicoConfiguration{PodCIDR: "10.1.0.0", MTU: -1}},
+			},
+			expectErrors: []string{
+				`cni.calico.podCIDR "10.1.0.0" is not a valid CIDR`,
+				"cni.calico.mtu -1 must not be negative",
+			},
+		},
+		{
+			name:         "restart-services-invalid",
+			config:       k8sinit.Configuration{Version: "0.2.0", RestartServices: "sometimes"},
+			expectErrors: []string{`restartServices "sometimes" must be one of "always", "onChange" or "never"`},
+		},
+		{
+			name: "arg-keys",
+			config: k8sinit.Configuration{
+				Version: "0.1.0",
+				ExtraKubeletArgs: map[string]*string{
+					"--max-pods":   &[]string{"110"}[0],
+					"node-labels":  &[]string{"zone=a"}[0],
+					"-cluster-dns": &[]string{"10.152.183.10"}[0],
+					"----v":        &[]string{"4"}[0],
+					"--":           &[]string{"test"}[0],
+				},
+				ExtraContainerdArgs: map[string]*string{"-l": &[]string{"debug"}[0], "--v": nil},
+				ExtraKubeliteEnv:    map[string]*string{"HTTP_PROXY": &[]string{"http://squid.internal:3128"}[0]},
+			},
+			expectErrors: []string{
+				"extraKubeletArgs has an empty argument name",
+				`extraKubeletArgs[----v] must be written as "--v"`,
+				`extraKubeletArgs[-cluster-dns] must be written as "--cluster-dns"`,
+				`extraKubeletArgs[node-labels] must be written as "--node-labels"`,
+			},
+		},
+		{
+			name: "reset-args",
+			config: k8sinit.Configuration{
+				Version:          "0.2.0",
+				ExtraKubeletArgs: map[string]*string{k8sinit.ResetArgsKey: nil, "--max-pods": &[]string{"200"}[0]},
+				ExtraEtcdArgs:    map[string]*string{k8sinit.ResetArgsKey: &[]string{"true"}[0]},
+			},
+			expectErrors: []string{"extraEtcdArgs[*] must be null to reset all arguments"},
+		},
+		{
+			name: "reset-args-version",
+			config: k8sinit.Configuration{
+				Version:          "0.1.0",
+				ExtraKubeletArgs: map[string]*string{k8sinit.ResetArgsKey: nil},
+			},
+			expectErrors: []string{`field "extraArgs[*]" requires config file version 0.2.0 or newer`},
+		},
+		{
+			name: "list-args",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				ExtraKubeletArgs: map[string]*string{
+					"--feature-gates=A=true": &[]string{""}[0],
+					"feature-gates=B=true":   &[]string{""}[0],
+					"--node-labels=a=b":      &[]string{"c"}[0],
+					"--node-ip=10.0.0.1":     nil,
+				},
+				ExtraKubeliteEnv: map[string]*string{"GOFIPS=1": &[]string{""}[0]},
+				Datastore:        k8sinit.DatastoreConfiguration{ExtraArgs: map[string]*string{"--storage-dir=/var/data": &[]string{""}[0]}},
+			},
+			expectErrors: []string{
+				`extraKubeletArgs[--node-labels=a=b] is a whole argument, its value must be empty or null`,
+				`extraKubeletArgs[feature-gates=B=true] must be written as "--feature-gates=B=true"`,
+				`extraKubeliteEnv[GOFIPS=1] is not an environment variable name, the list form is only supported for arguments`,
+				`datastore.extraArgs[--storage-dir=/var/data] is unsafe to change on a running datastore`,
+			},
+		},
+		{
+			name: "list-args-version",
+			config: k8sinit.Configuration{
+				Version:          "0.1.0",
+				ExtraKubeletArgs: map[string]*string{"--feature-gates=A=true": &[]string{""}[0]},
+			},
+			expectErrors: []string{`field "extraArgs[--flag=value]" requires config file version 0.2.0 or newer`},
+		},
+		{
+			name: "field-version",
+			config: k8sinit.Configuration{
+				Version:    "0.2.0",
+				Containerd: k8sinit.ContainerdConfiguration{ConfigToml: "version = 2"},
+			},
+		},
+		{
+			name: "field-version-too-old",
+			config: k8sinit.Configuration{
+				Version:    "0.1.0",
+				Containerd: k8sinit.ContainerdConfiguration{ConfigToml: "version = 2"},
+			},
+			expectErrors: []string{`field "containerd" requires config file version 0.2.0 or newer, but version is 0.1.0`},
+		},
+		{
+			name: "multiple",
+			config: k8sinit.Configuration{
+				Version:   "0.0.1",
+				ExtraSANs: &[]string{"10.0.0.1", "https://node"},
+				Addons:    []k8sinit.AddonConfiguration{{Name: ""}, {Name: "dns"}, {Name: "my addon"}},
+				Containerd: k8sinit.ContainerdConfiguration{
+					ConfigToml: "[invalid",
+				},
+			},
+			expectErrors: []string{
+				"minimum version required is 0.1.0",
+				`extraSANs[1] "https://node"`,
+				"addons[0] is missing a name",
+				`addons[2] name "my addon" must not contain whitespace`,
+				"containerd configToml is not valid TOML",
+			},
+		},
+		{
+			name: "unknown-addon",
+			config: k8sinit.Configuration{
+				Version: "0.1.0",
+				Addons:  []k8sinit.AddonConfiguration{{Name: "dsn"}, {Name: "ingres"}, {Name: "not-an-addon"}},
+			},
+			expectErrors: []string{
+				`addons[0] "dsn" is not a known addon (did you mean "dns"?)`,
+				`addons[1] "ingres" is not a known addon (did you mean "ingress"?)`,
+				`addons[2] "not-an-addon" is not a known addon`,
+			},
+		},
+		{
+			name: "addon-argument-templates",
+			config: k8sinit.Configuration{
+				Version: "0.1.0",
+				Addons: []k8sinit.AddonConfiguration{
+					{Name: "ingress", Arguments: []string{"--default-ssl-certificate={{.NodeName}}/tls", "{{.NodeIP}}"}},
+					{Name: "metallb", Arguments: []string{"{{.NodeZone}}"}},
+					{Name: "dns", Arguments: []string{"{{.NodeIP"}},
+				},
+			},
+			expectErrors: []string{
+				`addons[1] argument "{{.NodeZone}}": unknown template variable ".NodeZone" (known variables are {{.NodeName}}, {{.NodeIP}})`,
+				`addons[2] argument "{{.NodeIP": invalid template`,
+			},
+		},
+		{
+			name: "qualified-addons",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				Addons: []k8sinit.AddonConfiguration{
+					{Name: "core/dns"},
+					{Name: "community/istio"},
+					{Name: "linkerd", Repository: "community"},
+					{Name: "my-addon", Repository: "private"},
+					{Name: "private/other-addon"},
+				},
+			},
+		},
+		{
+			name: "duplicate-addons",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				Addons: []k8sinit.AddonConfiguration{
+					{Name: "dns"},
+					{Name: "ingress"},
+					{Name: "dns", Disable: true},
+					{Name: "community/istio"},
+					{Name: "istio", Repository: "community"},
+				},
+			},
+			expectErrors: []string{
+				`addon "dns" is listed both to enable (addons[0]) and disable (addons[2])`,
+				`addons[4] "community/istio" is already listed as addons[3], each addon may only be listed once`,
+			},
+		},
+		{
+			name: "enable-and-disable-addon",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				Addons: []k8sinit.AddonConfiguration{
+					{Name: "ingress", Disable: true},
+					{Name: "dns"},
+					{Name: "ingress"},
+				},
+			},
+			expectErrors: []string{
+				`addon "ingress" is listed both to enable (addons[2]) and disable (addons[0])`,
+			},
+		},
+		{
+			name: "enable-addon-once",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				Addons:  []k8sinit.AddonConfiguration{{Name: "ingress"}},
+			},
+		},
+		{
+			name: "disable-addon-once",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				Addons:  []k8sinit.AddonConfiguration{{Name: "ingress", Disable: true}},
+			},
+		},
+		{
+			name: "invalid-qualified-addons",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				Addons: []k8sinit.AddonConfiguration{
+					{Name: "/dns"},
+					{Name: "core/dns", Repository: "core"},
+					{Name: "core/"},
+					{Name: "core/dsn"},
+				},
+			},
+			expectErrors: []string{
+				`addons[0] name "/dns" has an empty repository`,
+				`addons[1] name "core/dns" must not be qualified when repository "core" is set`,
+				"addons[2] is missing a name",
+				`addons[3] "core/dsn" is not a known addon (did you mean "dns"?)`,
+			},
+		},
+		{
+			name: "addon-timeout-and-failure-policy",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				Addons: []k8sinit.AddonConfiguration{
+					{Name: "dns", Timeout: "5m", FailurePolicy: k8sinit.AddonFailurePolicyContinue},
+					{Name: "ingress", Timeout: "forever"},
+					{Name: "registry", Timeout: "-1s", FailurePolicy: "retry"},
+				},
+			},
+			expectErrors: []string{
+				`addons[1] timeout "forever" is not a valid positive duration`,
+				`addons[2] timeout "-1s" is not a valid positive duration`,
+				`addons[2] failurePolicy "retry" must be one of "abort" or "continue"`,
+			},
+		},
+		{
+			name: "extra-config-files",
+			config: k8sinit.Configuration{
+				Version: "0.2.0",
+				ExtraConfigFiles: map[string]string{
+					"flannel-network-mgr-config":  `{"Network": "10.1.0.0/16"}`,
+					"../../etc/cron.d/x":          "* * * * * root id",
+					"..":                          "",
+					`..\x`:                        "",
+					"kube-apiserver":              "--authorization-mode=AlwaysAllow",
+					"launch-configuration.sha256": "",
+				},
+			},
+			expectErrors: []string{
+				`extraConfigFiles[..]: file name ".." must not contain any slashes (possible path-traversal prevented)`,
+				`extraConfigFiles[../../etc/cron.d/x]: file name "../../etc/cron.d/x" must not contain any slashes`,
+				`extraConfigFiles[..\x]: file name "..\\x" must not contain any slashes`,
+				`extraConfigFiles[kube-apiserver]: file name "kube-apiserver" is reserved for the arguments of a service, use extraKubeAPIServerArgs instead`,
+				`extraConfigFiles[launch-configuration.sha256]: file name "launch-configuration.sha256" is reserved for the state of applied configurations`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			err := tc.config.Validate()
+			if len(tc.expectErrors) == 0 {
+				g.Expect(err).To(BeNil())
+				return
+			}
+
+			var validationErr *k8sinit.ValidationError
+			g.Expect(errors.As(err, &validationErr)).To(BeTrue())
+			g.Expect(validationErr.Errors).To(HaveLen(len(tc.expectErrors)))
+			for idx, expectErr := range tc.expectErrors {
+				g.Expect(validationErr.Errors[idx]).To(MatchError(ContainSubstring(expectErr)))
+			}
+		})
+	}
+}
+
+func TestValidateKnownAddons(t *testing.T) {
+	c := k8sinit.Configuration{
+		Version: "0.1.0",
+		Addons:  []k8sinit.AddonConfiguration{{Name: "my-addon"}, {Name: "my-adon"}},
+	}
+
+	g := NewWithT(t)
+	err := c.ValidateWithOptions(k8sinit.ValidateOptions{KnownAddons: []string{"my-addon", "other-addon"}})
+	g.Expect(err).To(MatchError(ContainSubstring(`addons[1] "my-adon" is not a known addon (did you mean "my-addon"?)`)))
+	g.Expect(err).NotTo(MatchError(ContainSubstring("addons[0]")))
+}
+
+func TestValidateAddonArgumentLimits(t *testing.T) {
+	opts := k8sinit.ValidateOptions{MaxAddonArguments: 3, MaxAddonArgumentsSize: 12}
+	for _, tc := range []struct {
+		name      string
+		args      []string
+		expectErr string
+	}{
+		{name: "below", args: []string{"--a", "--b"}},
+		{name: "at", args: []string{"--a=1", "--b", "--c"}},
+		{name: "at-size", args: []string{"--a=1234567", "-"}},
+		{name: "above", args: []string{"--a", "--b", "--c", "--d"}, expectErr: `addons[0] "ingress" has 4 arguments, but the maximum is 3`},
+		{name: "above-size", args: []string{"--a=12345678", "-"}, expectErr: `addons[0] "ingress" arguments are 13 bytes, but the maximum is 12 bytes`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := k8sinit.Configuration{
+				Version: "0.1.0",
+				Addons:  []k8sinit.AddonConfiguration{{Name: "ingress", Arguments: tc.args}},
+			}
+
+			g := NewWithT(t)
+			err := c.ValidateWithOptions(opts)
+			if tc.expectErr == "" {
+				g.Expect(err).To(BeNil())
+			} else {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectErr)))
+			}
+		})
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		c := k8sinit.Configuration{
+			Version: "0.1.0",
+			Addons: []k8sinit.AddonConfiguration{
+				{Name: "dns", Arguments: make([]string, k8sinit.DefaultMaxAddonArguments)},
+				{Name: "community/istio", Arguments: make([]string, k8sinit.DefaultMaxAddonArguments+1)},
+				{Name: "ingress", Arguments: []string{strings.Repeat("a", k8sinit.DefaultMaxAddonArgumentsSize+1)}},
+			},
+		}
+
+		g := NewWithT(t)
+		err := c.Validate()
+		g.Expect(err).To(MatchError(ContainSubstring(`addons[1] "community/istio" has 65 arguments, but the maximum is 64`)))
+		g.Expect(err).To(MatchError(ContainSubstring(`addons[2] "ingress" arguments are 65537 bytes, but the maximum is 65536 bytes`)))
+		g.Expect(err).NotTo(MatchError(ContainSubstring("addons[0]")))
+	})
+}
+
+func TestErrorsMatchContainedErrors(t *testing.T) {
+	errOther, errTarget := errors.New("other error"), errors.New("target error")
+	for _, tc := range []struct {
+		name string
+		err  interface {
+			error
+			Is(error) bool
+			As(interface{}) bool
+		}
+	}{
+		{name: "ValidationError", err: &k8sinit.ValidationError{Errors: []error{errOther, errTarget}}},
+		{name: "AddonsError", err: &k8sinit.AddonsError{Errors: []error{errOther, errTarget}}},
+		{name: "RollbackError", err: &k8sinit.RollbackError{Err: errOther, RollbackErr: errTarget}},
+		{name: "RemoteApplyError", err: &k8sinit.RemoteApplyError{Errors: []error{errOther, fmt.Errorf("node-1: %w", errTarget)}}},
+		{name: "PreflightError", err: &k8sinit.PreflightError{Errors: []error{errOther, errTarget}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			// the methods are called directly, since errors.Is and errors.As only use Unwrap() []error since Go 1.20
+			g.Expect(tc.err.Is(errTarget)).To(BeTrue())
+			g.Expect(tc.err.Is(errOther)).To(BeTrue())
+			g.Expect(tc.err.Is(errors.New("target error"))).To(BeFalse())
+
+			var validationErr *k8sinit.ValidationError
+			g.Expect(tc.err.As(&validationErr)).To(BeFalse())
+		})
+	}
+
+	t.Run("As", func(t *testing.T) {
+		g := NewWithT(t)
+		validationErr := &k8sinit.ValidationError{Errors: []error{errTarget}}
+		err := &k8sinit.ValidationError{Errors: []error{errOther, fmt.Errorf("part 1: %w", validationErr)}}
+
+		var target *k8sinit.ValidationError
+		g.Expect(err.As(&target)).To(BeTrue())
+		g.Expect(target).To(BeIdenticalTo(validationErr))
+	})
+}
@@ -31,6 +31,8 @@ type Snap interface {
 	ReadCNIYaml() (string, error)
 	// WriteCNIYaml updates the CNI manifest yaml.
 	WriteCNIYaml([]byte) error
+	// RemoveCNIYaml removes the CNI manifest yaml. Removing a missing manifest is not an error.
+	RemoveCNIYaml() error
 	// ApplyCNI applies the current CNI manifest in the MicroK8s cluster.
 	ApplyCNI(ctx context.Context) error
 
@@ -52,6 +54,8 @@ type Snap interface {
 	HasKubeliteLock() bool
 	// HasDqliteLock returns true if this MicroK8s instance is running dqlite.
 	HasDqliteLock() bool
+	// HasClusteredLock returns true if this MicroK8s instance has joined a cluster as a worker-only node.
+	HasClusteredLock() bool
 	// HasNoCertsReissueLock returns true if the lock file to prevent reissue of the CA certificates is present in this MicroK8s instance.
 	HasNoCertsReissueLock() bool
 	// CreateNoCertsReissueLock creates the lock file to prevent reissue of CA certificates in this MicroK8s instance.
@@ -61,6 +65,9 @@ type Snap interface {
 	ReadServiceArguments(serviceName string) (string, error)
 	// WriteServiceArguments updates the arguments file a particular service.
 	WriteServiceArguments(serviceName string, b []byte) error
+	// RemoveServiceArguments removes the arguments file of a particular service.
+	// Removing a missing arguments file is not an error.
+	RemoveServiceArguments(serviceName string) error
 
 	// ConsumeClusterToken returns true if token is a valid token for authenticating join requests.
 	// Tokens with a TTL may be consumed multiple times until they expire. One-time tokens may only be consumed once.
@@ -97,12 +104,20 @@ type Snap interface {
 	// WriteCSRConfig updates the csr.conf.template file on the local node.
 	WriteCSRConfig(csrConf []byte) error
 
+	// ReadContainerdRegistryConfig reads the hosts.toml registry configuration for containerd of a registry (e.g. "docker.io").
+	// A missing configuration is reported with an error wrapping os.ErrNotExist.
+	ReadContainerdRegistryConfig(registry string) ([]byte, error)
 	// UpdateContainerdRegistryConfigs writes hosts.toml registry configurations for containerd.
 	// Accepts a map where key is the registry (e.g. "docker.io") and the value is the contents of the hosts.toml file.
 	UpdateContainerdRegistryConfigs(configs map[string][]byte) error
+	// RemoveContainerdRegistryConfig removes the hosts.toml registry configuration for containerd of a registry (e.g. "docker.io").
+	// Removing a missing configuration is not an error.
+	RemoveContainerdRegistryConfig(registry string) error
 
 	// AddAddonsRepository configures an addons repository on the local node, similar to running the 'microk8s addons repo add' command.
 	AddAddonsRepository(ctx context.Context, name, url, reference string, force bool) error
+	// RemoveAddonsRepository removes an addons repository from the local node, similar to running the 'microk8s addons repo remove' command.
+	RemoveAddonsRepository(ctx context.Context, name string) error
 
 	// JoinCluster joins the local node to an existing MicroK8s cluster as a control-plane or worker node.
 	JoinCluster(ctx context.Context, url string, worker bool) error
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"strings"
 
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
@@ -51,6 +52,7 @@ type Snap struct {
 
 	KubeliteLock                       bool
 	DqliteLock                         bool
+	ClusteredLock                      bool
 	NoCertsReissueLock                 bool
 	CreateNoCertsReissueLockCalledWith []struct{}
 
@@ -135,6 +137,12 @@ func (s *Snap) WriteCNIYaml(b []byte) error {
 	return nil
 }
 
+// RemoveCNIYaml is a mock implementation for the snap.Snap interface.
+func (s *Snap) RemoveCNIYaml() error {
+	s.CNIYaml = ""
+	return nil
+}
+
 // ApplyCNI is a mock implementation for the snap.Snap interface.
 func (s *Snap) ApplyCNI(_ context.Context) error {
 	s.ApplyCNICalled = append(s.ApplyCNICalled, struct{}{})
@@ -182,6 +190,11 @@ func (s *Snap) HasDqliteLock() bool {
 	return s.DqliteLock
 }
 
+// HasClusteredLock is a mock implementation for the snap.Snap interface.
+func (s *Snap) HasClusteredLock() bool {
+	return s.ClusteredLock
+}
+
 // HasNoCertsReissueLock is a mock implementation for the snap.Snap interface.
 func (s *Snap) HasNoCertsReissueLock() bool {
 	return s.NoCertsReissueLock
@@ -212,6 +225,12 @@ func (s *Snap) WriteServiceArguments(service string, b []byte) error {
 	return nil
 }
 
+// RemoveServiceArguments is a mock implementation for the snap.Snap interface.
+func (s *Snap) RemoveServiceArguments(service string) error {
+	delete(s.ServiceArguments, service)
+	return nil
+}
+
 func contains(list []string, item string) bool {
 	for _, i := range list {
 		if item == i {
@@ -309,6 +328,21 @@ func (s *Snap) WriteCSRConfig(b []byte) error {
 	return nil
 }
 
+// ReadContainerdRegistryConfig is a mock implementation for the snap.Snap interface.
+func (s *Snap) ReadContainerdRegistryConfig(registry string) ([]byte, error) {
+	hostsToml, ok := s.ContainerdRegistryConfigs[registry]
+	if !ok {
+		return nil, fmt.Errorf("registry %s is not configured: %w", registry, os.ErrNotExist)
+	}
+	return []byte(hostsToml), nil
+}
+
+// RemoveContainerdRegistryConfig is a mock implementation for the snap.Snap interface.
+func (s *Snap) RemoveContainerdRegistryConfig(registry string) error {
+	delete(s.ContainerdRegistryConfigs, registry)
+	return nil
+}
+
 // UpdateContainerdRegistryConfigs is a mock implementation for the snap.Snap interface.
 func (s *Snap) UpdateContainerdRegistryConfigs(cfgs map[string][]byte) error {
 	if s.ContainerdRegistryConfigs == nil {
@@ -334,6 +368,12 @@ func (s *Snap) AddAddonsRepository(ctx context.Context, name, url, reference str
 	return nil
 }
 
+// RemoveAddonsRepository is a mock implementation for the snap.Snap interface.
+func (s *Snap) RemoveAddonsRepository(ctx context.Context, name string) error {
+	delete(s.AddonRepositories, name)
+	return nil
+}
+
 // JoinCluster is a mock implementation for the snap.Snap interface.
 func (s *Snap) JoinCluster(ctx context.Context, url string, worker bool) error {
 	s.JoinClusterCalledWith = append(s.JoinClusterCalledWith, JoinClusterCall{url, worker})
@@ -3,6 +3,7 @@ package snap
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -159,6 +160,13 @@ func (s *snap) WriteCNIYaml(cniManifest []byte) error {
 	return os.WriteFile(s.snapDataPath("args", "cni-network", "cni.yaml"), []byte(cniManifest), 0660)
 }
 
+func (s *snap) RemoveCNIYaml() error {
+	if err := os.Remove(s.snapDataPath("args", "cni-network", "cni.yaml")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove cni manifest: %w", err)
+	}
+	return nil
+}
+
 func (s *snap) ApplyCNI(ctx context.Context) error {
 	var err error
 	for i := 0; i < s.applyCNIRetries; i++ {
@@ -202,6 +210,10 @@ func (s *snap) HasDqliteLock() bool {
 	return util.FileExists(s.snapDataPath("var", "lock", "ha-cluster"))
 }
 
+func (s *snap) HasClusteredLock() bool {
+	return util.FileExists(s.snapDataPath("var", "lock", "clustered.lock"))
+}
+
 func (s *snap) HasNoCertsReissueLock() bool {
 	return util.FileExists(s.snapDataPath("var", "lock", "no-cert-reissue"))
 }
@@ -219,6 +231,13 @@ func (s *snap) WriteServiceArguments(serviceName string, arguments []byte) error
 	return os.WriteFile(s.snapDataPath("args", serviceName), arguments, 0660)
 }
 
+func (s *snap) RemoveServiceArguments(serviceName string) error {
+	if err := os.Remove(s.snapDataPath("args", serviceName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove arguments of service %s: %w", serviceName, err)
+	}
+	return nil
+}
+
 func (s *snap) ConsumeClusterToken(token string) bool {
 	s.clusterTokensMu.Lock()
 	defer s.clusterTokensMu.Unlock()
@@ -351,21 +370,38 @@ func (s *snap) WriteCSRConfig(csrConf []byte) error {
 	return os.WriteFile(s.snapDataPath("certs", "csr.conf.template"), csrConf, 0660)
 }
 
-func (s *snap) UpdateContainerdRegistryConfigs(configs map[string][]byte) error {
+// containerdRegistryConfigDir returns the directory of the hosts.toml registry configuration of a registry.
+func (s *snap) containerdRegistryConfigDir(registry string) (string, error) {
 	relativeHostsDir := s.snapDataPath("args", "certs.d")
 	hostsDir, err := filepath.Abs(relativeHostsDir)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute directory for registry configurations: %w", err)
+		return "", fmt.Errorf("failed to get absolute directory for registry configurations: %w", err)
+	}
+
+	relativeDir := filepath.Join(hostsDir, registry)
+	dir, err := filepath.Abs(relativeDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute directory for registry %s: %w", registry, err)
+	}
+	if !strings.HasPrefix(dir, hostsDir) {
+		return "", fmt.Errorf("invalid registry name, possible path-traversal prevented")
 	}
+	return dir, nil
+}
 
+func (s *snap) ReadContainerdRegistryConfig(registry string) ([]byte, error) {
+	dir, err := s.containerdRegistryConfigDir(registry)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(dir, "hosts.toml"))
+}
+
+func (s *snap) UpdateContainerdRegistryConfigs(configs map[string][]byte) error {
 	for registry, hostsToml := range configs {
-		relativeDir := filepath.Join(hostsDir, registry)
-		dir, err := filepath.Abs(relativeDir)
+		dir, err := s.containerdRegistryConfigDir(registry)
 		if err != nil {
-			return fmt.Errorf("failed to get absolute directory for registry %s: %w", registry, err)
-		}
-		if !strings.HasPrefix(dir, hostsDir) {
-			return fmt.Errorf("invalid registry name, possible path-traversal prevented")
+			return err
 		}
 
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -379,6 +415,17 @@ func (s *snap) UpdateContainerdRegistryConfigs(configs map[string][]byte) error
 	return nil
 }
 
+func (s *snap) RemoveContainerdRegistryConfig(registry string) error {
+	dir, err := s.containerdRegistryConfigDir(registry)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, "hosts.toml")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove hosts.toml for registry %s: %w", registry, err)
+	}
+	return nil
+}
+
 func (s *snap) AddAddonsRepository(ctx context.Context, name, url, reference string, force bool) error {
 	cmd := []string{filepath.Join(s.snapPath("microk8s-addons.wrapper")), "repo", "add", name, url}
 	if reference != "" {
@@ -393,6 +440,14 @@ func (s *snap) AddAddonsRepository(ctx context.Context, name, url, reference str
 	return nil
 }
 
+func (s *snap) RemoveAddonsRepository(ctx context.Context, name string) error {
+	cmd := []string{filepath.Join(s.snapPath("microk8s-addons.wrapper")), "repo", "remove", name}
+	if err := s.runCommand(ctx, cmd...); err != nil {
+		return fmt.Errorf("failed to execute addons repo remove command: %w", err)
+	}
+	return nil
+}
+
 func (s *snap) JoinCluster(ctx context.Context, url string, worker bool) error {
 	cmd := []string{filepath.Join(s.snapPath("microk8s-join.wrapper")), url}
 	if worker {
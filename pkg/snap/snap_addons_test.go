@@ -49,4 +49,18 @@ func TestAddons(t *testing.T) {
 			t.Fatalf("Expected commands %#v, but received %#v", expectedCommands, runner.CalledWithCommand)
 		}
 	})
+
+	t.Run("RemoveRepository", func(t *testing.T) {
+		runner := &utiltest.MockRunner{}
+		s := snap.NewSnap("testdata", "testdata", snap.WithCommandRunner(runner.Run))
+
+		s.RemoveAddonsRepository(context.Background(), "community")
+
+		expectedCommands := []string{
+			"testdata/microk8s-addons.wrapper repo remove community",
+		}
+		if !reflect.DeepEqual(expectedCommands, runner.CalledWithCommand) {
+			t.Fatalf("Expected commands %#v, but received %#v", expectedCommands, runner.CalledWithCommand)
+		}
+	})
 }
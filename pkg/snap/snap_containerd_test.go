@@ -33,11 +33,32 @@ func TestUpdateContainerdRegistryConfigs(t *testing.T) {
 		g.Expect(b).To(Equal([]byte(`server = "http://quay.mirror:32000"`)))
 	})
 
+	t.Run("Read", func(t *testing.T) {
+		g := NewWithT(t)
+		b, err := s.ReadContainerdRegistryConfig("docker.io")
+		g.Expect(err).To(BeNil())
+		g.Expect(b).To(Equal([]byte(`server = "http://dockerhub.mirror:32000"`)))
+
+		_, err = s.ReadContainerdRegistryConfig("ghcr.io")
+		g.Expect(err).To(MatchError(os.ErrNotExist))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(s.RemoveContainerdRegistryConfig("quay.io")).To(Succeed())
+		g.Expect("testdata/args/certs.d/quay.io/hosts.toml").ToNot(BeAnExistingFile())
+		g.Expect(s.RemoveContainerdRegistryConfig("quay.io")).To(Succeed())
+	})
+
 	t.Run("BadPath", func(t *testing.T) {
 		g := NewWithT(t)
 		err := s.UpdateContainerdRegistryConfigs(map[string][]byte{
 			"../path/traversal": []byte(`server = "http://dockerhub.mirror:32000"`),
 		})
 		g.Expect(err).NotTo(BeNil())
+
+		_, err = s.ReadContainerdRegistryConfig("../path/traversal")
+		g.Expect(err).NotTo(BeNil())
+		g.Expect(s.RemoveContainerdRegistryConfig("../path/traversal")).NotTo(Succeed())
 	})
 }
@@ -92,4 +92,35 @@ func TestFiles(t *testing.T) {
 			}
 		})
 	}
+
+	for _, tc := range []struct {
+		name   string
+		write  func([]byte) error
+		remove func() error
+		file   string
+	}{
+		{name: "RemoveCNI", write: s.WriteCNIYaml, remove: s.RemoveCNIYaml, file: "testdata/args/cni-network/cni.yaml"},
+		{
+			name:   "RemoveServiceArguments",
+			write:  func(b []byte) error { return s.WriteServiceArguments("kubelet", b) },
+			remove: func() error { return s.RemoveServiceArguments("kubelet") },
+			file:   "testdata/args/kubelet",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.write([]byte("contents")); err != nil {
+				t.Fatalf("expected error to be nil, but it was %q instead", err)
+			}
+			if err := tc.remove(); err != nil {
+				t.Fatalf("expected error to be nil, but it was %q instead", err)
+			}
+			if _, err := os.Stat(tc.file); !os.IsNotExist(err) {
+				t.Fatalf("expected %q to be removed, but stat returned %v", tc.file, err)
+			}
+			// removing a missing file is not an error
+			if err := tc.remove(); err != nil {
+				t.Fatalf("expected error to be nil, but it was %q instead", err)
+			}
+		})
+	}
 }
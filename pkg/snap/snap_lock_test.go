@@ -21,6 +21,7 @@ func TestLock(t *testing.T) {
 	}{
 		{name: "kubelite", file: "lite.lock", hasLock: s.HasKubeliteLock},
 		{name: "dqlite", file: "ha-cluster", hasLock: s.HasDqliteLock},
+		{name: "clustered", file: "clustered.lock", hasLock: s.HasClusteredLock},
 		{name: "cert-reissue", file: "no-cert-reissue", hasLock: s.HasNoCertsReissueLock},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
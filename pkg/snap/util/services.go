@@ -4,15 +4,98 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 	"github.com/canonical/microk8s-cluster-agent/pkg/util"
 )
 
+const (
+	// ManagedArgsHeader is the comment written at the top of arguments files updated by launch configurations, see
+	// UpdateManagedServiceArguments.
+	ManagedArgsHeader = "# Managed by the MicroK8s cluster agent, changes outside of the manual arguments section may be overwritten."
+	// ManualArgsBegin starts the manual section of an arguments file. Lines of the manual section are kept as-is when
+	// the arguments file is updated, and are written after the arguments managed by the cluster agent, so that they
+	// take precedence.
+	ManualArgsBegin = "# BEGIN MANUAL ARGUMENTS"
+	// ManualArgsEnd ends the manual section of an arguments file.
+	ManualArgsEnd = "# END MANUAL ARGUMENTS"
+)
+
+// listValuedArgs are arguments whose value is a comma separated list of entries, where the order of the entries does
+// not change the meaning of the argument.
+var listValuedArgs = map[string]struct{}{
+	"--feature-gates":             {},
+	"--enable-admission-plugins":  {},
+	"--disable-admission-plugins": {},
+	"--node-labels":               {},
+	"--register-with-taints":      {},
+}
+
+// normalizeArgumentValue returns the canonical form of an argument value, which is only used to compare values. Surrounding
+// whitespace and quotes are removed, and the entries of list-valued arguments (see listValuedArgs) are sorted.
+func normalizeArgumentValue(key string, value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = strings.TrimSpace(value[1 : len(value)-1])
+	}
+	if _, ok := listValuedArgs[key]; ok {
+		entries := strings.Split(value, ",")
+		for idx, entry := range entries {
+			entries[idx] = strings.TrimSpace(entry)
+		}
+		sort.Strings(entries)
+		value = strings.Join(entries, ",")
+	}
+	return value
+}
+
+// splitServiceArguments splits the non-empty lines of an arguments file into the managed lines and the lines of the
+// manual section (see ManualArgsBegin), without the header and the delimiters. A manual section without an end
+// delimiter extends to the end of the file. If managed is false, all non-empty lines are returned as-is.
+func splitServiceArguments(arguments string, managed bool) (lines []string, manual []string) {
+	inManual := false
+	for _, line := range strings.Split(arguments, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case !managed:
+			lines = append(lines, line)
+		case line == ManagedArgsHeader:
+		case line == ManualArgsBegin:
+			inManual = true
+		case line == ManualArgsEnd:
+			inManual = false
+		case inManual:
+			manual = append(manual, line)
+		default:
+			lines = append(lines, line)
+		}
+	}
+	return lines, manual
+}
+
+// joinServiceArguments returns the contents of an arguments file with the given lines. If managed is true, the lines
+// follow the header (see ManagedArgsHeader), and are followed by the manual section if it is not empty.
+func joinServiceArguments(lines []string, manual []string, managed bool) []byte {
+	if !managed {
+		return []byte(strings.Join(lines, "\n") + "\n")
+	}
+	lines = append([]string{ManagedArgsHeader}, lines...)
+	if len(manual) > 0 {
+		lines = append(lines, ManualArgsBegin)
+		lines = append(lines, manual...)
+		lines = append(lines, ManualArgsEnd)
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
 // GetServiceArgument retrieves the value of a specific argument from the $SNAP_DATA/args/$service file.
 // The argument name should include preceding dashes (e.g. "--secure-port").
 // If any errors occur, or the argument is not present, an empty string is returned.
+// The first occurrence of the argument is returned, so that arguments managed by the cluster agent are returned even if
+// they are overridden in the manual section (see ManualArgsBegin).
 func GetServiceArgument(s snap.Snap, serviceName string, argument string) string {
 	arguments, err := s.ReadServiceArguments(serviceName)
 	if err != nil {
@@ -35,9 +118,24 @@ func GetServiceArgument(s snap.Snap, serviceName string, argument string) string
 // UpdateServiceArguments updates the arguments file for a service.
 // UpdateServiceArguments is a no-op if updateList and delete are empty.
 // updateList is a map of key-value pairs. It will replace the argument with the new value (or just append).
+// If an updated argument appears multiple times, only the first occurrence is kept.
 // delete is a list of arguments to remove completely. The argument is removed if present.
+// Values that only differ in surrounding whitespace or quotes, or in the order of the entries of a list-valued argument
+// (e.g. "--feature-gates"), are not considered changed, but the updated value is still written as-is.
 // Returns a boolean whether any of the arguments were changed, as well as any errors that may have occured.
 func UpdateServiceArguments(s snap.Snap, serviceName string, updateList []map[string]string, delete []string) (bool, error) {
+	return updateServiceArguments(s, serviceName, updateList, delete, false)
+}
+
+// UpdateManagedServiceArguments updates the arguments file for a service like UpdateServiceArguments, and marks it as
+// managed by the cluster agent (see ManagedArgsHeader). Lines of the manual section (see ManualArgsBegin) are not
+// updated, and are kept after the updated arguments. It is used to apply launch configurations.
+func UpdateManagedServiceArguments(s snap.Snap, serviceName string, updateList []map[string]string, delete []string) (bool, error) {
+	return updateServiceArguments(s, serviceName, updateList, delete, true)
+}
+
+// updateServiceArguments implements UpdateServiceArguments and UpdateManagedServiceArguments.
+func updateServiceArguments(s snap.Snap, serviceName string, updateList []map[string]string, delete []string, managed bool) (bool, error) {
 	if updateList == nil {
 		updateList = []map[string]string{}
 	}
@@ -68,20 +166,21 @@ func UpdateServiceArguments(s snap.Snap, serviceName string, updateList []map[st
 	}
 
 	changed := false
-	existingArguments := make(map[string]struct{}, len(arguments))
-	newArguments := make([]string, 0, len(arguments))
-	for _, line := range strings.Split(arguments, "\n") {
-		line = strings.TrimSpace(line)
-		// ignore empty lines
-		if line == "" {
-			continue
-		}
+	lines, manual := splitServiceArguments(arguments, managed)
+	existingArguments := make(map[string]struct{}, len(lines))
+	newArguments := make([]string, 0, len(lines))
+	for _, line := range lines {
 		key, oldValue := util.ParseArgumentLine(line)
+		_, seen := existingArguments[key]
 		existingArguments[key] = struct{}{}
-		if newValue, ok := updateMap[key]; ok {
+		if newValue, ok := updateMap[key]; ok && seen {
+			// argument appears multiple times, only keep the first (updated) one
+			changed = true
+			continue
+		} else if ok {
 			// update argument with new value
 			newArguments = append(newArguments, fmt.Sprintf("%s=%s", key, newValue))
-			if oldValue != newValue {
+			if normalizeArgumentValue(key, oldValue) != normalizeArgumentValue(key, newValue) {
 				changed = true
 			}
 		} else if _, ok := deleteMap[key]; ok {
@@ -94,15 +193,96 @@ func UpdateServiceArguments(s snap.Snap, serviceName string, updateList []map[st
 		}
 	}
 
-	for key, value := range updateMap {
+	// new arguments are appended in sorted order, so that the arguments file is the same for the same updates
+	for _, key := range util.SortedKeys(updateMap) {
 		if _, argExists := existingArguments[key]; !argExists {
 			changed = true
-			newArguments = append(newArguments, fmt.Sprintf("%s=%s", key, value))
+			newArguments = append(newArguments, fmt.Sprintf("%s=%s", key, updateMap[key]))
 		}
 	}
 
-	if err := s.WriteServiceArguments(serviceName, []byte(strings.Join(newArguments, "\n")+"\n")); err != nil {
+	if err := s.WriteServiceArguments(serviceName, joinServiceArguments(newArguments, manual, managed)); err != nil {
 		return false, fmt.Errorf("failed to update arguments for service %s: %q", serviceName, err)
 	}
 	return changed, nil
 }
+
+// UpdateServiceArgumentLines updates the arguments file for a service with whole argument lines (e.g. "--feature-gates=A=true").
+// Unlike UpdateServiceArguments, lines are not matched by argument name, so that an argument may appear multiple times.
+// add is a list of lines to append, if not already present. remove is a list of lines to remove, if present.
+// The arguments file is only written if it changed. Returns a boolean whether any lines were changed.
+func UpdateServiceArgumentLines(s snap.Snap, serviceName string, add []string, remove []string) (bool, error) {
+	return updateServiceArgumentLines(s, serviceName, add, remove, false)
+}
+
+// UpdateManagedServiceArgumentLines updates the arguments file for a service like UpdateServiceArgumentLines, and marks
+// it as managed by the cluster agent (see UpdateManagedServiceArguments). Lines of the manual section are not removed.
+func UpdateManagedServiceArgumentLines(s snap.Snap, serviceName string, add []string, remove []string) (bool, error) {
+	return updateServiceArgumentLines(s, serviceName, add, remove, true)
+}
+
+// updateServiceArgumentLines implements UpdateServiceArgumentLines and UpdateManagedServiceArgumentLines.
+func updateServiceArgumentLines(s snap.Snap, serviceName string, add []string, remove []string, managed bool) (bool, error) {
+	if len(add) == 0 && len(remove) == 0 {
+		return false, nil
+	}
+
+	removeMap := make(map[string]struct{}, len(remove))
+	for _, line := range remove {
+		removeMap[line] = struct{}{}
+	}
+
+	arguments, err := s.ReadServiceArguments(serviceName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("failed to read arguments of service %s: %w", serviceName, err)
+	}
+
+	changed := false
+	lines, manual := splitServiceArguments(arguments, managed)
+	existingLines := make(map[string]struct{})
+	newLines := make([]string, 0, len(lines)+len(add))
+	for _, line := range lines {
+		if _, ok := removeMap[line]; ok {
+			changed = true
+			continue
+		}
+		existingLines[line] = struct{}{}
+		newLines = append(newLines, line)
+	}
+	for _, line := range add {
+		if _, ok := existingLines[line]; !ok {
+			changed = true
+			existingLines[line] = struct{}{}
+			newLines = append(newLines, line)
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+	if err := s.WriteServiceArguments(serviceName, joinServiceArguments(newLines, manual, managed)); err != nil {
+		return false, fmt.Errorf("failed to update arguments for service %s: %w", serviceName, err)
+	}
+	return true, nil
+}
+
+// StripManualArguments returns the contents of an arguments file without comment lines (e.g. ManagedArgsHeader) and
+// without the manual section (see ManualArgsBegin), which only apply to the local node. Other lines are kept as-is.
+// It is used to send the arguments of the local node to joining nodes.
+func StripManualArguments(arguments string) string {
+	lines := strings.Split(arguments, "\n")
+	kept := make([]string, 0, len(lines))
+	inManual := false
+	for _, line := range lines {
+		switch trimmed := strings.TrimSpace(line); {
+		case trimmed == ManualArgsBegin:
+			inManual = true
+		case trimmed == ManualArgsEnd:
+			inManual = false
+		case inManual || strings.HasPrefix(trimmed, "#"):
+		default:
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
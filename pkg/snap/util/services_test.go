@@ -3,6 +3,7 @@ package snaputil_test
 import (
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
@@ -82,11 +83,13 @@ func TestUpdateServiceArguments(t *testing.T) {
 --with-space value2
 `
 	for _, tc := range []struct {
-		name           string
-		update         []map[string]string
-		delete         []string
-		expectedValues map[string]string
-		expectedChange bool
+		name              string
+		initialArguments  string
+		update            []map[string]string
+		delete            []string
+		expectedValues    map[string]string
+		expectedArguments string
+		expectedChange    bool
 	}{
 		{
 			name:   "no-change",
@@ -107,6 +110,42 @@ func TestUpdateServiceArguments(t *testing.T) {
 			},
 			expectedChange: false,
 		},
+		{
+			name:   "no-change-quoted",
+			update: []map[string]string{{"--key": `"value"`}, {"--other": " other-value "}},
+			expectedValues: map[string]string{
+				"--key":   `"value"`,
+				"--other": " other-value",
+			},
+			expectedChange: false,
+		},
+		{
+			name:             "no-change-list-order",
+			update:           []map[string]string{{"--feature-gates": "B=false,A=true"}},
+			initialArguments: "--feature-gates=A=true, B=false\n",
+			expectedValues: map[string]string{
+				"--feature-gates": "B=false,A=true",
+			},
+			expectedChange: false,
+		},
+		{
+			name:             "list-entry-changed",
+			update:           []map[string]string{{"--feature-gates": "B=true,A=true"}},
+			initialArguments: "--feature-gates=A=true,B=false\n",
+			expectedValues: map[string]string{
+				"--feature-gates": "B=true,A=true",
+			},
+			expectedChange: true,
+		},
+		{
+			name:             "unsorted-list-order",
+			update:           []map[string]string{{"--authorization-mode": "RBAC,Node"}},
+			initialArguments: "--authorization-mode=Node,RBAC\n",
+			expectedValues: map[string]string{
+				"--authorization-mode": "RBAC,Node",
+			},
+			expectedChange: true,
+		},
 		{
 			name:   "simple-update",
 			update: []map[string]string{{"--key": "new-value"}},
@@ -162,13 +201,38 @@ func TestUpdateServiceArguments(t *testing.T) {
 			},
 			expectedChange: true,
 		},
+		{
+			name:   "update-repeated",
+			update: []map[string]string{{"--opt": "new-value"}},
+			initialArguments: `
+--opt=a
+--key=value
+--opt=b
+`,
+			expectedValues: map[string]string{
+				"--opt": "new-value",
+				"--key": "value",
+			},
+			expectedArguments: "--opt=new-value\n--key=value\n",
+			expectedChange:    true,
+		},
+		{
+			name:              "append-sorted",
+			update:            []map[string]string{{"--zzz": "1", "--aaa": "2", "--mmm": "3"}},
+			expectedArguments: "--key=value\n--other=other-value\n--with-space value2\n--aaa=2\n--mmm=3\n--zzz=1\n",
+			expectedChange:    true,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			g := NewWithT(t)
 
+			arguments := initialArguments
+			if tc.initialArguments != "" {
+				arguments = tc.initialArguments
+			}
 			s := &mock.Snap{
 				ServiceArguments: map[string]string{
-					"service": initialArguments,
+					"service": arguments,
 				},
 			}
 
@@ -179,6 +243,9 @@ func TestUpdateServiceArguments(t *testing.T) {
 			for key, expectedValue := range tc.expectedValues {
 				g.Expect(snaputil.GetServiceArgument(s, "service", key)).To(Equal(expectedValue))
 			}
+			if tc.expectedArguments != "" {
+				g.Expect(s.ServiceArguments["service"]).To(Equal(tc.expectedArguments))
+			}
 
 			t.Run("Reapply", func(t *testing.T) {
 				g := NewWithT(t)
@@ -189,3 +256,163 @@ func TestUpdateServiceArguments(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateServiceArgumentLines(t *testing.T) {
+	initialArguments := `
+--feature-gates=A=true
+--key=value
+`
+	for _, tc := range []struct {
+		name           string
+		add            []string
+		remove         []string
+		expectedArgs   string
+		expectedChange bool
+	}{
+		{
+			name:           "add-repeated",
+			add:            []string{"--feature-gates=B=true", "--feature-gates=C=true"},
+			expectedArgs:   "--feature-gates=A=true\n--key=value\n--feature-gates=B=true\n--feature-gates=C=true\n",
+			expectedChange: true,
+		},
+		{
+			name:           "no-change",
+			add:            []string{"--feature-gates=A=true"},
+			remove:         []string{"--feature-gates=B=true"},
+			expectedArgs:   initialArguments,
+			expectedChange: false,
+		},
+		{
+			name:           "remove",
+			remove:         []string{"--feature-gates=A=true"},
+			expectedArgs:   "--key=value\n",
+			expectedChange: true,
+		},
+		{
+			name:           "remove-does-not-match-name",
+			remove:         []string{"--key"},
+			expectedArgs:   initialArguments,
+			expectedChange: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			s := &mock.Snap{
+				ServiceArguments: map[string]string{
+					"service": initialArguments,
+				},
+			}
+
+			changed, err := snaputil.UpdateServiceArgumentLines(s, "service", tc.add, tc.remove)
+			g.Expect(err).To(BeNil())
+			g.Expect(changed).To(Equal(tc.expectedChange))
+			g.Expect(s.ServiceArguments["service"]).To(Equal(tc.expectedArgs))
+
+			t.Run("Reapply", func(t *testing.T) {
+				g := NewWithT(t)
+				changed, err := snaputil.UpdateServiceArgumentLines(s, "service", tc.add, tc.remove)
+				g.Expect(err).To(BeNil())
+				g.Expect(changed).To(BeFalse())
+			})
+		})
+	}
+}
+
+func TestManualServiceArguments(t *testing.T) {
+	initialArguments := `
+--key=value
+--other=other-value
+# BEGIN MANUAL ARGUMENTS
+--manual=manual-value
+--key=manual-override
+# END MANUAL ARGUMENTS
+`
+	expectedArguments := snaputil.ManagedArgsHeader + `
+--key=new-value
+--new=value
+# BEGIN MANUAL ARGUMENTS
+--manual=manual-value
+--key=manual-override
+# END MANUAL ARGUMENTS
+`
+
+	t.Run("UpdateManagedServiceArguments", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{ServiceArguments: map[string]string{"service": initialArguments}}
+
+		changed, err := snaputil.UpdateManagedServiceArguments(s, "service", []map[string]string{{"--key": "new-value", "--new": "value"}}, []string{"--other", "--manual"})
+		g.Expect(err).To(BeNil())
+		g.Expect(changed).To(BeTrue())
+		g.Expect(s.ServiceArguments["service"]).To(Equal(expectedArguments))
+
+		t.Run("Reapply", func(t *testing.T) {
+			g := NewWithT(t)
+			changed, err := snaputil.UpdateManagedServiceArguments(s, "service", []map[string]string{{"--key": "other-value"}}, nil)
+			g.Expect(err).To(BeNil())
+			g.Expect(changed).To(BeTrue())
+			g.Expect(s.ServiceArguments["service"]).To(Equal(strings.Replace(expectedArguments, "--key=new-value", "--key=other-value", 1)))
+		})
+	})
+
+	t.Run("UpdateManagedServiceArgumentLines", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{ServiceArguments: map[string]string{"service": initialArguments}}
+
+		changed, err := snaputil.UpdateManagedServiceArgumentLines(s, "service", []string{"--new=value"}, []string{"--other=other-value", "--manual=manual-value"})
+		g.Expect(err).To(BeNil())
+		g.Expect(changed).To(BeTrue())
+		g.Expect(s.ServiceArguments["service"]).To(Equal(snaputil.ManagedArgsHeader + `
+--key=value
+--new=value
+# BEGIN MANUAL ARGUMENTS
+--manual=manual-value
+--key=manual-override
+# END MANUAL ARGUMENTS
+`))
+	})
+
+	t.Run("Unterminated", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{ServiceArguments: map[string]string{"service": "--key=value\n# BEGIN MANUAL ARGUMENTS\n--manual=manual-value\n"}}
+
+		_, err := snaputil.UpdateManagedServiceArguments(s, "service", []map[string]string{{"--new": "value"}}, nil)
+		g.Expect(err).To(BeNil())
+		g.Expect(s.ServiceArguments["service"]).To(Equal(snaputil.ManagedArgsHeader + "\n--key=value\n--new=value\n# BEGIN MANUAL ARGUMENTS\n--manual=manual-value\n# END MANUAL ARGUMENTS\n"))
+	})
+}
+
+func TestUnmanagedServiceArguments(t *testing.T) {
+	g := NewWithT(t)
+	s := &mock.Snap{ServiceArguments: map[string]string{"service": "--key=value\n"}}
+
+	// arguments files updated outside of launch configurations are not marked as managed
+	changed, err := snaputil.UpdateServiceArguments(s, "service", []map[string]string{{"--new": "value"}}, nil)
+	g.Expect(err).To(BeNil())
+	g.Expect(changed).To(BeTrue())
+	changed, err = snaputil.UpdateServiceArgumentLines(s, "service", []string{"--feature-gates=A=true"}, nil)
+	g.Expect(err).To(BeNil())
+	g.Expect(changed).To(BeTrue())
+	g.Expect(s.ServiceArguments["service"]).To(Equal("--key=value\n--new=value\n--feature-gates=A=true\n"))
+}
+
+func TestStripManualArguments(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		arguments string
+		expected  string
+	}{
+		{name: "Unmanaged", arguments: "--key=value\n\n--other=value\n", expected: "--key=value\n\n--other=value\n"},
+		{
+			name:      "Managed",
+			arguments: snaputil.ManagedArgsHeader + "\n--key=value\n# BEGIN MANUAL ARGUMENTS\n--manual=value\n# END MANUAL ARGUMENTS\n--other=value\n",
+			expected:  "--key=value\n--other=value\n",
+		},
+		{name: "Unterminated", arguments: "--key=value\n# BEGIN MANUAL ARGUMENTS\n--manual=value\n", expected: "--key=value"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(snaputil.StripManualArguments(tc.arguments)).To(Equal(tc.expected))
+		})
+	}
+}
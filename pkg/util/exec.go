@@ -3,22 +3,56 @@ package util
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
 )
 
+// maxErrorOutput is the maximum number of bytes of the stderr output of a failed command that are included in its error.
+const maxErrorOutput = 4096
+
 // RunCommand executes a command with a given context.
 // RunCommand returns nil if the command completes successfully and the exit code is 0.
 func RunCommand(ctx context.Context, command ...string) error {
+	return RunCommandWithOutput(ctx, os.Stdout, os.Stderr, command...)
+}
+
+// RunCommandWithOutput executes a command with a given context, writing its output to stdout and stderr.
+// RunCommandWithOutput returns nil if the command completes successfully and the exit code is 0. Otherwise, the error
+// includes the end of the stderr output of the command, e.g. so that the cause of the failure can be matched.
+func RunCommandWithOutput(ctx context.Context, stdout io.Writer, stderr io.Writer, command ...string) error {
 	var args []string
 	if len(command) > 1 {
 		args = command[1:]
 	}
+	output := &tailWriter{max: maxErrorOutput}
 	cmd := exec.CommandContext(ctx, command[0], args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = stdout
+	cmd.Stderr = output
+	if stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderr, output)
+	}
 	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(string(output.b)); msg != "" {
+			return fmt.Errorf("command %v failed with exit code %d: %w: %s", command, cmd.ProcessState.ExitCode(), err, msg)
+		}
 		return fmt.Errorf("command %v failed with exit code %d: %w", command, cmd.ProcessState.ExitCode(), err)
 	}
 	return nil
 }
+
+// tailWriter keeps the last max bytes written to it.
+type tailWriter struct {
+	max int
+	b   []byte
+}
+
+// Write implements io.Writer.
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	if len(w.b) > w.max {
+		w.b = w.b[len(w.b)-w.max:]
+	}
+	return len(p), nil
+}
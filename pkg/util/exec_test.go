@@ -1,6 +1,7 @@
 package util_test
 
 import (
+	"bytes"
 	"context"
 	"testing"
 
@@ -22,6 +23,29 @@ func TestExec(t *testing.T) {
 		}
 	})
 
+	t.Run("Output", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		err := util.RunCommandWithOutput(context.Background(), &stdout, &stderr, "/bin/bash", "-c", "echo out; echo err >&2")
+		if err != nil {
+			t.Fatalf("Expected no errors, but received %q", err)
+		}
+		if stdout.String() != "out\n" || stderr.String() != "err\n" {
+			t.Fatalf("Expected stdout %q and stderr %q, but received %q and %q", "out\n", "err\n", stdout.String(), stderr.String())
+		}
+	})
+
+	t.Run("FailureOutput", func(t *testing.T) {
+		var stderr bytes.Buffer
+		err := util.RunCommandWithOutput(context.Background(), nil, &stderr, "/bin/bash", "-c", "echo 'connection refused' >&2; exit 2")
+		expectErr := "command [/bin/bash -c echo 'connection refused' >&2; exit 2] failed with exit code 2: exit status 2: connection refused"
+		if err == nil || err.Error() != expectErr {
+			t.Fatalf("Expected error %q, but received %v", expectErr, err)
+		}
+		if stderr.String() != "connection refused\n" {
+			t.Fatalf("Expected stderr %q, but received %q", "connection refused\n", stderr.String())
+		}
+	})
+
 	t.Run("Cancel", func(t *testing.T) {
 		ch := make(chan struct{}, 1)
 		ctx, cancel := context.WithCancel(context.Background())
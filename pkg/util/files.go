@@ -41,7 +41,7 @@ func ParseArgumentLine(line string) (key string, value string) {
 	line = strings.TrimSpace(line)
 
 	// parse "--argument value" and "--argument=value" variants
-	if parts := strings.Split(line, "="); len(parts) >= 2 {
+	if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
 		key = parts[0]
 		value = parts[1]
 	} else if parts := strings.Split(line, " "); len(parts) >= 2 {
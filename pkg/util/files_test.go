@@ -55,6 +55,7 @@ func TestParseArgumentLine(t *testing.T) {
 		{line: "--key    ", key: "--key", value: ""},
 		{line: "--key=", key: "--key", value: ""},
 		{line: "--key=    ", key: "--key", value: ""},
+		{line: "--key=a=true,b=false", key: "--key", value: "a=true,b=false"},
 	} {
 		t.Run(tc.line, func(t *testing.T) {
 			key, value := util.ParseArgumentLine(tc.line)
@@ -0,0 +1,15 @@
+package util
+
+import "sort"
+
+// SortedKeys returns the keys of a map in sorted order.
+// Use SortedKeys to iterate over maps whose entries are serialized, written to files or hashed, since the iteration
+// order of Go maps is random.
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,20 @@
+package util_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+func TestSortedKeys(t *testing.T) {
+	if keys := util.SortedKeys(map[string]string{"b": "2", "c": "3", "a": "1"}); !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+		t.Fatalf("Expected keys [a b c] but they were %v", keys)
+	}
+	if keys := util.SortedKeys(map[string]*string{"--v": nil, "--max-pods": nil}); !reflect.DeepEqual(keys, []string{"--max-pods", "--v"}) {
+		t.Fatalf("Expected keys [--max-pods --v] but they were %v", keys)
+	}
+	if keys := util.SortedKeys[bool](nil); len(keys) != 0 {
+		t.Fatalf("Expected no keys but they were %v", keys)
+	}
+}